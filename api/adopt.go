@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+)
+
+// Adopt records a service instance that was provisioned by some
+// other platform in the store, so that future bind, unbind and
+// deprovision operations know its service and plan.  If the service
+// is instances_retrievable, the broker is asked about the instance
+// first, and its idea of the plan wins out over the caller's.
+//
+// Adopt returns the service and plan IDs that were recorded.
+func (c *Client) Adopt(store *Store, cat *Catalog, id, service, plan string) (string, string, error) {
+	if id == "" {
+		return "", "", fmt.Errorf("instance ID is required for adoption")
+	}
+	if _, _, err := store.GetInstanceDetails(c.URL, id); err == nil {
+		return "", "", fmt.Errorf("service instance '%s' is already known", id)
+	}
+
+	service, plan, err := cat.FindPlan(service, plan)
+	if err != nil {
+		return "", "", err
+	}
+
+	if retrievable, _ := cat.Retrievable(service); retrievable {
+		inst, err := c.GetInstance(id)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to verify service instance '%s': %s", id, err)
+		}
+		if inst.ServiceID != "" && inst.ServiceID != service {
+			return "", "", fmt.Errorf("service instance '%s' belongs to service %s, not %s", id, inst.ServiceID, service)
+		}
+		if inst.PlanID != "" {
+			plan = inst.PlanID
+		}
+	}
+
+	store.AddInstance(c.URL, id, service, plan)
+	return service, plan, nil
+}
+
+// AdoptBinding records a service binding that was created by some
+// other platform in the store, under an instance that the store
+// already knows about.  If the service is bindings_retrievable,
+// the credentials are fetched from the broker, and any that were
+// passed in by the caller are ignored.
+//
+// AdoptBinding returns the credentials that were recorded.
+func (c *Client) AdoptBinding(store *Store, cat *Catalog, id, bid string, creds map[string]interface{}) (map[string]interface{}, error) {
+	if bid == "" {
+		return nil, fmt.Errorf("binding ID is required for adoption")
+	}
+	if _, _, _, err := store.GetBindingDetails(c.URL, bid); err == nil {
+		return nil, fmt.Errorf("service binding '%s' is already known", bid)
+	}
+
+	service, _, err := store.GetInstanceDetails(c.URL, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s; adopt it first", err)
+	}
+
+	var b *Binding
+	if _, retrievable := cat.Retrievable(service); retrievable {
+		b, err = c.GetBinding(id, bid)
+		if err != nil {
+			return nil, fmt.Errorf("unable to verify service binding '%s': %s", bid, err)
+		}
+		creds = b.Credentials
+	}
+
+	store.AddBinding(c.URL, id, bid, creds)
+	if b != nil {
+		store.SetBindingExtras(c.URL, id, bid, b.SyslogDrainURL, b.VolumeMounts)
+	}
+	return creds, nil
+}
@@ -0,0 +1,126 @@
+package api_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestAdopt(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+
+	// the broker's idea of the plan wins out
+	store := &api.Store{}
+	service, plan, err := c.Adopt(store, cat, "i-1", "db", "large")
+	if err != nil {
+		t.Fatalf("unable to adopt i-1: %s", err)
+	}
+	if service != "db" || plan != "db-small" {
+		t.Errorf("expected i-1 to be adopted as db/db-small, not %s/%s", service, plan)
+	}
+	if _, plan, _ := store.GetInstanceDetails(c.URL, "i-1"); plan != "db-small" {
+		t.Errorf("expected i-1 to be recorded as db-small, not %s", plan)
+	}
+
+	if _, _, err := c.Adopt(store, cat, "i-1", "db", "small"); err == nil {
+		t.Errorf("adopting i-1 twice should fail")
+	}
+	if _, _, err := c.Adopt(store, cat, "i-2", "db", "small"); err == nil {
+		t.Errorf("adopting an instance the broker doesn't have should fail")
+	}
+	if _, _, err := c.Adopt(store, cat, "i-3", "db", "huge"); err == nil {
+		t.Errorf("adopting an instance of an unknown plan should fail")
+	}
+
+	// bindings come with the broker's credentials
+	bound, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"})
+	if err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	creds, err := c.AdoptBinding(store, cat, "i-1", "b-1", map[string]interface{}{"password": "guessed"})
+	if err != nil {
+		t.Fatalf("unable to adopt b-1: %s", err)
+	}
+	if creds["password"] != bound.Credentials["password"] {
+		t.Errorf("expected b-1 to be adopted with the broker's credentials, not %v", creds)
+	}
+	if _, err := c.AdoptBinding(store, cat, "i-1", "b-1", nil); err == nil {
+		t.Errorf("adopting b-1 twice should fail")
+	}
+	if _, err := c.AdoptBinding(store, cat, "i-9", "b-9", nil); err == nil || !strings.Contains(err.Error(), "adopt it first") {
+		t.Errorf("adopting a binding of an unknown instance should fail, and say to adopt the instance (got %v)", err)
+	}
+
+	b.Expect(osbtest.Provision).Times(1)
+	b.Expect(osbtest.Bind).Times(1)
+}
+
+func TestAdoptNotRetrievable(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("db").Retrievable(false, false).Plan("small")
+	c := b.Client()
+
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+
+	// with nothing to check against, the caller is taken at their word
+	store := &api.Store{}
+	if _, _, err := c.Adopt(store, cat, "i-1", "db", "small"); err != nil {
+		t.Fatalf("unable to adopt i-1: %s", err)
+	}
+	creds, err := c.AdoptBinding(store, cat, "i-1", "b-1", map[string]interface{}{"password": "given"})
+	if err != nil {
+		t.Fatalf("unable to adopt b-1: %s", err)
+	}
+	if creds["password"] != "given" {
+		t.Errorf("expected b-1 to be adopted with the given credentials, not %v", creds)
+	}
+
+	b.Expect(osbtest.FetchInstance).Never()
+	b.Expect(osbtest.FetchBinding).Never()
+}
+
+func TestForget(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	store := &api.Store{}
+	store.AddInstance(c.URL, "i-1", "db", "db-small")
+	store.AddBinding(c.URL, "i-1", "b-1", nil)
+	store.AddBinding(c.URL, "i-1", "b-2", nil)
+
+	if kind, err := store.Forget(c.URL, "b-1"); err != nil || kind != "binding" {
+		t.Errorf("expected to forget binding b-1, but got %s (%v)", kind, err)
+	}
+	if _, _, _, err := store.GetBindingDetails(c.URL, "b-2"); err != nil {
+		t.Errorf("forgetting b-1 should leave b-2 alone")
+	}
+	if kind, err := store.Forget(c.URL, "i-1"); err != nil || kind != "instance" {
+		t.Errorf("expected to forget instance i-1, but got %s (%v)", kind, err)
+	}
+	if _, _, _, err := store.GetBindingDetails(c.URL, "b-2"); err == nil {
+		t.Errorf("forgetting i-1 should forget its bindings, too")
+	}
+	if _, err := store.Forget(c.URL, "i-1"); err == nil {
+		t.Errorf("forgetting i-1 twice should fail")
+	}
+
+	// forgetting is a purely local affair
+	if n := len(b.Requests()); n != 0 {
+		t.Errorf("forgetting should not contact the broker, but it made %d requests", n)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Aliases are local, human-friendly names for instances and bindings,
+// so that people don't have to copy UUIDs around.  An alias has to be
+// unique amongst all of the instances and bindings of a broker, and
+// can't be the same as the ID of some other instance or binding.
+
+// CheckAlias makes sure that name is free to be used as an alias for
+// the instance or binding with the given ID (which may be empty, for
+// records that don't exist yet).
+func (s *Store) CheckAlias(url, id, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	url = strings.TrimSuffix(url, "/")
+	for _, broker := range s.Data {
+		if strings.TrimSuffix(broker.Broker, "/") != url {
+			continue
+		}
+		for _, instance := range broker.Instances {
+			if instance.ID != id && (instance.ID == name || instance.Name == name) {
+				return fmt.Errorf("alias '%s' is already in use by service instance '%s'", name, instance.ID)
+			}
+			for _, binding := range instance.Bindings {
+				if binding.ID != id && (binding.ID == name || binding.Name == name) {
+					return fmt.Errorf("alias '%s' is already in use by service instance binding '%s'", name, binding.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) NameInstance(url, id, name string) error {
+	if err := s.CheckAlias(url, id, name); err != nil {
+		return err
+	}
+	inst := s.findInstance(url, id)
+	if inst == nil {
+		return fmt.Errorf("service instance '%s' not found", id)
+	}
+	inst.Name = name
+	return nil
+}
+
+func (s *Store) NameBinding(url, id, bid, name string) error {
+	if err := s.CheckAlias(url, bid, name); err != nil {
+		return err
+	}
+	b := s.findBinding(url, id, bid)
+	if b == nil {
+		return fmt.Errorf("service instance binding '%s' not found", bid)
+	}
+	b.Name = name
+	return nil
+}
+
+// Resolve turns an alias into the ID of the instance or binding it
+// names.  IDs (and anything else that isn't a known alias) are given
+// back as-is, so callers can resolve everything the user gives them.
+// If url is empty, every broker in the store is searched.
+func (s *Store) Resolve(url, ref string) string {
+	url = strings.TrimSuffix(url, "/")
+
+	found := ""
+	for _, broker := range s.Data {
+		if url != "" && strings.TrimSuffix(broker.Broker, "/") != url {
+			continue
+		}
+		for _, instance := range broker.Instances {
+			if instance.ID == ref {
+				return ref
+			}
+			if instance.Name == ref && found == "" {
+				found = instance.ID
+			}
+			for _, binding := range instance.Bindings {
+				if binding.ID == ref {
+					return ref
+				}
+				if binding.Name == ref && found == "" {
+					found = binding.ID
+				}
+			}
+		}
+	}
+
+	if found != "" {
+		return found
+	}
+	return ref
+}
+
+// Alias returns the alias of an instance or binding, if it has one.
+func (s *Store) Alias(url, id string) string {
+	url = strings.TrimSuffix(url, "/")
+	for _, broker := range s.Data {
+		if strings.TrimSuffix(broker.Broker, "/") != url {
+			continue
+		}
+		for _, instance := range broker.Instances {
+			if instance.ID == id {
+				return instance.Name
+			}
+			for _, binding := range instance.Bindings {
+				if binding.ID == id {
+					return binding.Name
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestAliases(t *testing.T) {
+	url := "http://broker"
+	store := &api.Store{}
+	store.AddInstance(url, "i-1", "db", "db-small")
+	store.AddInstance(url, "i-2", "db", "db-small")
+	store.AddBinding(url, "i-1", "b-1", nil)
+	store.AddInstance("http://other", "i-3", "db", "db-small")
+
+	if err := store.NameInstance(url, "i-1", "my-pg"); err != nil {
+		t.Fatalf("unable to name i-1: %s", err)
+	}
+	if err := store.NameBinding(url, "i-1", "b-1", "app1"); err != nil {
+		t.Fatalf("unable to name b-1: %s", err)
+	}
+
+	// aliases are unique, and can't shadow IDs
+	for _, name := range []string{"my-pg", "app1", "i-1", "b-1"} {
+		if err := store.NameInstance(url, "i-2", name); err == nil {
+			t.Errorf("naming i-2 '%s' should fail, since it's taken", name)
+		}
+	}
+	if err := store.NameInstance(url, "i-1", "my-pg"); err != nil {
+		t.Errorf("renaming i-1 to its own alias should be fine, but got %s", err)
+	}
+	if err := store.NameInstance("http://other", "i-3", "my-pg"); err != nil {
+		t.Errorf("aliases only have to be unique per broker, but got %s", err)
+	}
+	if err := store.NameInstance(url, "i-9", "nine"); err == nil {
+		t.Errorf("naming an unknown instance should fail")
+	}
+
+	tests := []struct {
+		url, ref, expect string
+	}{
+		{url, "my-pg", "i-1"},
+		{url, "app1", "b-1"},
+		{url, "i-2", "i-2"},
+		{url, "unknown", "unknown"},
+		{"http://other", "my-pg", "i-3"},
+		{"", "app1", "b-1"},
+	}
+	for _, test := range tests {
+		if got := store.Resolve(test.url, test.ref); got != test.expect {
+			t.Errorf("resolving '%s' on '%s': expected %s, got %s", test.ref, test.url, test.expect, got)
+		}
+	}
+
+	if store.Alias(url, "i-1") != "my-pg" || store.Alias(url, "b-1") != "app1" || store.Alias(url, "i-2") != "" {
+		t.Errorf("expected aliases my-pg, app1 and none, got '%s', '%s' and '%s'",
+			store.Alias(url, "i-1"), store.Alias(url, "b-1"), store.Alias(url, "i-2"))
+	}
+}
+
+func TestProfileContext(t *testing.T) {
+	if ctx, err := api.ProfileContext("", nil); ctx != nil || err != nil {
+		t.Errorf("no profile should mean no context, got %v (%v)", ctx, err)
+	}
+	if _, err := api.ProfileContext("openshift", nil); err == nil {
+		t.Errorf("an unknown profile should fail")
+	}
+
+	fields := map[string]interface{}{"instance_name": "my-pg", "binding_name": "app1"}
+	cf, err := api.ProfileContext("cf", fields)
+	if err != nil {
+		t.Fatalf("unable to build a cloudfoundry context: %s", err)
+	}
+	if cf["platform"] != "cloudfoundry" || cf["instance_name"] != "my-pg" || cf["binding_name"] != "app1" {
+		t.Errorf("expected a cloudfoundry context, with names, got %v", cf)
+	}
+	if cf["organization_guid"] == nil || cf["space_guid"] == nil {
+		t.Errorf("expected the cloudfoundry context to have the org and space it requires, got %v", cf)
+	}
+
+	k8s, err := api.ProfileContext("k8s", fields)
+	if err != nil {
+		t.Fatalf("unable to build a kubernetes context: %s", err)
+	}
+	if k8s["platform"] != "kubernetes" || k8s["instance_name"] != "my-pg" || k8s["namespace"] != "default" || k8s["clusterid"] == nil {
+		t.Errorf("expected a kubernetes context in the default namespace, with a name, got %v", k8s)
+	}
+	if _, ok := k8s["binding_name"]; ok {
+		t.Errorf("kubernetes contexts don't have binding names, but got %v", k8s)
+	}
+
+	// the names make it to the broker
+	b, c := newBroker(t)
+	defer b.Close()
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small", Context: cf}); err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	b.Expect(osbtest.Provision).
+		Field("context.platform", "cloudfoundry").
+		Field("context.instance_name", "my-pg").
+		Times(1)
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Job is a single lifecycle operation to be run as part of a Batch.
+// Ref is not used for anything; it is handed back in the JobResult,
+// so that callers can tell which of their jobs it belongs to.
+type Job struct {
+	Ref        string                 `json:"ref,omitempty"`
+	Verb       string                 `json:"verb"`
+	InstanceID string                 `json:"instance_id,omitempty"`
+	BindingID  string                 `json:"binding_id,omitempty"`
+	ServiceID  string                 `json:"service_id,omitempty"`
+	PlanID     string                 `json:"plan_id,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Context    map[string]interface{} `json:"context,omitempty"`
+
+	seq int // the order Run got the job in
+}
+
+type JobResult struct {
+	Job
+
+	Status    string    `json:"status"`
+	Operation string    `json:"operation,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Attempts  int       `json:"attempts"`
+	Started   time.Time `json:"started"`
+	Duration  int64     `json:"duration_ms"`
+	Async     int64     `json:"async_ms,omitempty"`
+
+	// what the broker gave back when binding, or provisioning
+	Binding      *Binding `json:"-"`
+	DashboardURL string   `json:"dashboard_url,omitempty"`
+}
+
+// Succeeded returns true if the job did what it was asked to do.
+func (r JobResult) Succeeded() bool {
+	return r.Error == "" && r.Status != "skipped"
+}
+
+type BatchSummary struct {
+	Total     int            `json:"total"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Skipped   int            `json:"skipped"`
+	Retries   int            `json:"retries"`
+	Verbs     map[string]int `json:"verbs"`
+	Duration  int64          `json:"duration_ms"`
+}
+
+// A Batch runs lifecycle jobs against a broker, at most Parallel
+// at a time, waiting up to MaxWait for each asynchronous operation
+// to finish (polling every Interval).  Jobs that the broker refuses
+// with a ConcurrencyError are retried up to Retries times, backing
+// off exponentially from Backoff.
+//
+// Jobs against the same instance are run in the order they were
+// given, so that a batch can provision an instance and then bind
+// to it.  If the instance fails to provision, the rest of its jobs
+// are skipped.
+type Batch struct {
+	Client *Client
+
+	Parallel int
+	MaxWait  time.Duration
+	Interval time.Duration
+	Retries  int
+	Backoff  time.Duration
+}
+
+const maxBackoff = 30 * time.Second
+
+// Run reads jobs until the channel is closed, and calls done (never
+// concurrently) with the result of each one as it finishes.
+func (b *Batch) Run(jobs <-chan Job, done func(JobResult)) BatchSummary {
+	parallel := b.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	summary := BatchSummary{Verbs: make(map[string]int)}
+	start := time.Now()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	last := make(map[string]chan bool)
+
+	seq := 0
+	for job := range jobs {
+		job.seq = seq
+		seq++
+		if job.Verb == "provision" && job.InstanceID == "" {
+			job.InstanceID = RandomID()
+		}
+		if job.Verb == "bind" && job.BindingID == "" {
+			job.BindingID = RandomID()
+		}
+
+		before := last[job.InstanceID]
+		after := make(chan bool, 1)
+		if job.InstanceID != "" {
+			last[job.InstanceID] = after
+		}
+
+		wg.Add(1)
+		go func(job Job, before <-chan bool, after chan<- bool) {
+			defer wg.Done()
+
+			// wait for the instance's earlier jobs before taking up a
+			// slot, so that jobs for other instances can use it in the
+			// meantime.
+			var r JobResult
+			healthy := true
+			if before != nil {
+				healthy = <-before
+			}
+			if healthy {
+				slots <- struct{}{}
+				r = b.run(job)
+				<-slots
+			} else {
+				r = JobResult{Job: job, Status: "skipped", Started: time.Now(), Error: "the instance could not be provisioned"}
+			}
+			after <- healthy && !(job.Verb == "provision" && !r.Succeeded())
+
+			lock.Lock()
+			defer lock.Unlock()
+			summary.Total++
+			summary.Retries += r.Attempts - 1
+			switch {
+			case r.Status == "skipped":
+				summary.Skipped++
+			case r.Error != "":
+				summary.Failed++
+			default:
+				summary.Succeeded++
+				summary.Verbs[job.Verb]++
+			}
+			if done != nil {
+				done(r)
+			}
+		}(job, before, after)
+	}
+
+	wg.Wait()
+	summary.Duration = int64(time.Since(start) / time.Millisecond)
+	return summary
+}
+
+// RunAll is a convenience wrapper around Run, for callers who have
+// all of their jobs up front.  Results are given in the same order
+// as the jobs.
+func (b *Batch) RunAll(jobs []Job) ([]JobResult, BatchSummary) {
+	ch := make(chan Job)
+	go func() {
+		for _, job := range jobs {
+			ch <- job
+		}
+		close(ch)
+	}()
+
+	results := make([]JobResult, len(jobs))
+	summary := b.Run(ch, func(r JobResult) {
+		results[r.seq] = r
+	})
+	return results, summary
+}
+
+func (b *Batch) run(job Job) (r JobResult) {
+	r = JobResult{Job: job, Started: time.Now()}
+	defer func() {
+		r.Duration = int64(time.Since(r.Started) / time.Millisecond)
+	}()
+
+	backoff := b.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for {
+		r.Attempts++
+		err := b.submit(&r)
+		if err == nil {
+			break
+		}
+		if !IsConcurrencyError(err) || r.Attempts > b.Retries {
+			r.Status = "failed"
+			r.Error = err.Error()
+			return r
+		}
+
+		// back off, with a bit of jitter so that all the jobs
+		// that collided don't just collide again
+		time.Sleep(backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)))
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	if !strings.HasSuffix(r.Status, "ing") {
+		return r
+	}
+
+	start := time.Now()
+	op, err := b.Client.WaitFor(LastOperationSpec{
+		InstanceID: job.InstanceID,
+		BindingID:  job.BindingID,
+		ServiceID:  job.ServiceID,
+		PlanID:     job.PlanID,
+		Operation:  r.Operation,
+	}, b.Interval, b.MaxWait)
+	r.Async = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		r.Status = "failed"
+		r.Error = err.Error()
+		return r
+	}
+
+	switch {
+	case op.State == Succeeded, op.State == Gone && (job.Verb == "unbind" || job.Verb == "deprovision"):
+		switch job.Verb {
+		case "provision":
+			r.Status = "provisioned"
+		case "update":
+			r.Status = "updated"
+		case "bind":
+			r.Status = "bound"
+			if binding, err := b.Client.GetBinding(job.InstanceID, job.BindingID); err == nil {
+				r.Binding = binding
+			} else {
+				r.Error = fmt.Sprintf("unable to retrieve binding: %s", err)
+			}
+		case "unbind":
+			r.Status = "unbound"
+		case "deprovision":
+			r.Status = "deprovisioned"
+		}
+
+	default:
+		r.Status = "failed"
+		r.Error = fmt.Sprintf("%s %s", job.Verb, op.State)
+		if op.Description != "" {
+			r.Error += ": " + op.Description
+		}
+	}
+	return r
+}
+
+func (b *Batch) submit(r *JobResult) error {
+	c := b.Client
+	job := r.Job
+
+	switch job.Verb {
+	case "provision":
+		stat, err := c.Provision(job.InstanceID, ProvisionSpec{
+			ServiceID:  job.ServiceID,
+			PlanID:     job.PlanID,
+			Context:    job.Context,
+			Parameters: job.Parameters,
+		})
+		if err != nil {
+			return err
+		}
+		r.Status, r.Operation, r.DashboardURL = stat.Status, stat.Operation, stat.DashboardURL
+
+	case "update":
+		spec := UpdateSpec{
+			InstanceID: job.InstanceID,
+			ServiceID:  job.ServiceID,
+			PlanID:     job.PlanID,
+			Context:    job.Context,
+			Parameters: job.Parameters,
+		}
+		stat, err := c.Update(spec)
+		if err != nil {
+			return err
+		}
+		r.Status, r.Operation, r.DashboardURL = stat.Status, stat.Operation, stat.DashboardURL
+
+	case "bind":
+		stat, err := c.Bind(BindSpec{
+			InstanceID: job.InstanceID,
+			BindingID:  job.BindingID,
+			ServiceID:  job.ServiceID,
+			PlanID:     job.PlanID,
+			Context:    job.Context,
+			Parameters: job.Parameters,
+		})
+		if err != nil {
+			return err
+		}
+		r.Status, r.Operation = stat.Status, stat.Operation
+		r.Binding = &Binding{
+			Credentials:     stat.Credentials,
+			SyslogDrainURL:  stat.SyslogDrainURL,
+			RouteServiceURL: stat.RouteServiceURL,
+			VolumeMounts:    stat.VolumeMounts,
+		}
+
+	case "unbind":
+		stat, err := c.Unbind(UnbindSpec{
+			InstanceID: job.InstanceID,
+			BindingID:  job.BindingID,
+			ServiceID:  job.ServiceID,
+			PlanID:     job.PlanID,
+		})
+		if err != nil {
+			return err
+		}
+		r.Status, r.Operation = stat.Status, stat.Operation
+
+	case "deprovision":
+		stat, err := c.Deprovision(DeprovisionSpec{
+			InstanceID: job.InstanceID,
+			ServiceID:  job.ServiceID,
+			PlanID:     job.PlanID,
+		})
+		if err != nil {
+			return err
+		}
+		r.Status, r.Operation = stat.Status, stat.Operation
+
+	default:
+		return fmt.Errorf("unrecognized job verb '%s' (try provision, update, bind, unbind, or deprovision)", job.Verb)
+	}
+	return nil
+}
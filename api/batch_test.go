@@ -0,0 +1,169 @@
+package api_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func newBatch(c *api.Client) *api.Batch {
+	return &api.Batch{
+		Client:   c,
+		Parallel: 4,
+		MaxWait:  5 * time.Second,
+		Interval: 20 * time.Millisecond,
+		Retries:  2,
+		Backoff:  10 * time.Millisecond,
+	}
+}
+
+func TestBatchOrdering(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	results, summary := newBatch(c).RunAll([]api.Job{
+		{Verb: "provision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-large"},
+		{Verb: "bind", InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-large"},
+		{Verb: "unbind", InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-large"},
+		{Verb: "deprovision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-large"},
+		{Verb: "provision", InstanceID: "i-2", ServiceID: "db", PlanID: "db-small"},
+	})
+
+	want := []string{"provisioned", "bound", "unbound", "deprovisioned", "provisioned"}
+	for i, r := range results {
+		if r.Status != want[i] || r.Error != "" {
+			t.Errorf("job #%d (%s) should have %s, but it %s: %s", i+1, r.Verb, want[i], r.Status, r.Error)
+		}
+	}
+	if summary.Total != 5 || summary.Succeeded != 5 {
+		t.Errorf("expected 5 of 5 jobs to succeed, but %d of %d did", summary.Succeeded, summary.Total)
+	}
+	if results[1].Binding == nil || results[1].Binding.Credentials == nil {
+		t.Errorf("the asynchronous bind should have fetched the binding's credentials")
+	}
+
+	// everything for i-1 has to have happened in the order given,
+	// each one only once the one before it had finished.
+	var got []string
+	for _, r := range b.Requests() {
+		if strings.HasPrefix(r.Path, "/v2/service_instances/i-1") && r.Endpoint != osbtest.LastOperation && r.Endpoint != osbtest.BindingLastOperation && r.Endpoint != osbtest.FetchBinding {
+			got = append(got, r.Endpoint)
+		}
+	}
+	order := []string{osbtest.Provision, osbtest.Bind, osbtest.Unbind, osbtest.Deprovision}
+	if strings.Join(got, ", ") != strings.Join(order, ", ") {
+		t.Errorf("expected requests for i-1 in the order\n  %s\nbut got\n  %s", strings.Join(order, "\n  "), strings.Join(got, "\n  "))
+	}
+}
+
+func TestBatchRefs(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// refs are the caller's business; they don't have to be unique,
+	// and they can look like a job's position
+	results, _ := newBatch(c).RunAll([]api.Job{
+		{Ref: "1", Verb: "provision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-small"},
+		{Verb: "provision", InstanceID: "i-2", ServiceID: "db", PlanID: "db-small"},
+		{Ref: "x", Verb: "provision", InstanceID: "i-3", ServiceID: "db", PlanID: "db-small"},
+		{Ref: "x", Verb: "provision", InstanceID: "i-4", ServiceID: "db", PlanID: "db-small"},
+	})
+	for i, r := range results {
+		if want := fmt.Sprintf("i-%d", i+1); r.InstanceID != want {
+			t.Errorf("result #%d should be for %s, but it is for '%s'", i+1, want, r.InstanceID)
+		}
+	}
+	if results[0].Ref != "1" || results[1].Ref != "" {
+		t.Errorf("the refs should have been handed back as given, but got '%s' and '%s'", results[0].Ref, results[1].Ref)
+	}
+}
+
+func TestBatchSkipsAfterFailedProvision(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Provision).Status(500).Error("", "no capacity").Once()
+
+	results, summary := newBatch(c).RunAll([]api.Job{
+		{Verb: "provision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-small"},
+		{Verb: "bind", InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"},
+	})
+	if results[0].Succeeded() || !strings.Contains(results[0].Error, "no capacity") {
+		t.Errorf("the provision should have failed with 'no capacity', but got %s: %s", results[0].Status, results[0].Error)
+	}
+	if results[1].Status != "skipped" {
+		t.Errorf("the bind should have been skipped, but it %s", results[1].Status)
+	}
+	if summary.Failed != 1 || summary.Skipped != 1 {
+		t.Errorf("expected 1 failed and 1 skipped job, but got %d and %d", summary.Failed, summary.Skipped)
+	}
+	b.Expect(osbtest.Bind).Never()
+}
+
+func TestBatchRetriesConcurrencyErrors(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Provision).Status(422).Error("ConcurrencyError", "busy").Times(2)
+
+	results, summary := newBatch(c).RunAll([]api.Job{
+		{Verb: "provision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-small"},
+	})
+	if !results[0].Succeeded() {
+		t.Fatalf("the provision should have succeeded on its third attempt, but got %s", results[0].Error)
+	}
+	if results[0].Attempts != 3 || summary.Retries != 2 {
+		t.Errorf("expected 3 attempts (2 retries), but got %d (%d)", results[0].Attempts, summary.Retries)
+	}
+	b.Expect(osbtest.Provision).Times(3)
+}
+
+func TestBatchGivesUpOnConcurrencyErrors(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Provision).Status(422).Error("ConcurrencyError", "busy")
+
+	results, _ := newBatch(c).RunAll([]api.Job{
+		{Verb: "provision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-small"},
+	})
+	if results[0].Succeeded() || results[0].Attempts != 3 {
+		t.Errorf("the provision should have failed after 3 attempts, but got %s after %d", results[0].Status, results[0].Attempts)
+	}
+
+	b.Reset()
+	b.On(osbtest.Provision).Status(422).Error("", "bad request")
+	results, _ = newBatch(c).RunAll([]api.Job{
+		{Verb: "provision", InstanceID: "i-1", ServiceID: "db", PlanID: "db-small"},
+	})
+	if results[0].Attempts != 1 {
+		t.Errorf("only ConcurrencyErrors should be retried, but the provision was attempted %d times", results[0].Attempts)
+	}
+}
+
+func TestBatchParallel(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Provision).Delay(100 * time.Millisecond)
+
+	jobs := make([]api.Job, 8)
+	for i := range jobs {
+		jobs[i] = api.Job{Verb: "provision", ServiceID: "db", PlanID: "db-small"}
+	}
+	start := time.Now()
+	results, summary := newBatch(c).RunAll(jobs)
+	took := time.Since(start)
+
+	if summary.Succeeded != len(jobs) {
+		t.Errorf("expected all %d jobs to succeed, but %d did", len(jobs), summary.Succeeded)
+	}
+	if took < 200*time.Millisecond || took > 700*time.Millisecond {
+		t.Errorf("8 jobs of 100ms, 4 at a time, should take about 200ms, but took %s", took)
+	}
+	for _, r := range results {
+		if r.InstanceID == "" {
+			t.Errorf("job %s should have been given an instance ID", r.Ref)
+		}
+	}
+}
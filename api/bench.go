@@ -0,0 +1,487 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The bench workloads are the cycles that osb bench repeats, over
+// and over, for as long as it is told to.
+const (
+	// Fetch the catalog.
+	BenchCatalog = "catalog"
+
+	// Provision a new instance, and then deprovision it.
+	BenchProvision = "provision"
+
+	// Bind a (single, shared) instance, and then unbind it.
+	BenchBind = "bind"
+)
+
+var BenchWorkloads = []string{BenchCatalog, BenchProvision, BenchBind}
+
+type BenchSpec struct {
+	Workload   string
+	ServiceID  string
+	PlanID     string
+	Parameters map[string]interface{}
+
+	// How long to keep starting new cycles, how many to have going
+	// at once, and (if not zero) how many to start every second.
+	Duration time.Duration
+	Parallel int
+	Rate     float64
+
+	Interval time.Duration
+	MaxWait  time.Duration
+
+	// Closing Stop ends the run early; cycles that have already
+	// started are allowed to finish, and everything is cleaned up.
+	Stop <-chan struct{}
+}
+
+// BenchEndpoint summarizes the requests made to one endpoint.  Rows
+// with names like `provision (done)` measure from the first request
+// until the last operation finished, for asynchronous operations.
+type BenchEndpoint struct {
+	Name       string  `json:"name"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	Throughput float64 `json:"throughput"`
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+}
+
+type BenchReport struct {
+	Broker      string           `json:"broker"`
+	Workload    string           `json:"workload"`
+	ServiceID   string           `json:"service_id,omitempty"`
+	PlanID      string           `json:"plan_id,omitempty"`
+	Parallel    int              `json:"parallel"`
+	Rate        float64          `json:"rate,omitempty"`
+	Cycles      int              `json:"cycles"`
+	Endpoints   []*BenchEndpoint `json:"endpoints"`
+	Interrupted bool             `json:"interrupted"`
+	Leftovers   []string         `json:"leftovers,omitempty"`
+	Warnings    []string         `json:"warnings,omitempty"`
+	Elapsed     int64            `json:"elapsed_ms"`
+	Took        int64            `json:"took_ms"`
+}
+
+type benchSeries struct {
+	took   []time.Duration
+	errors int
+}
+
+type bench struct {
+	c    *Client
+	spec BenchSpec
+
+	lock     sync.Mutex
+	report   *BenchReport
+	series   map[string]*benchSeries
+	order    []string
+	instance string            // for bind cycles
+	live     map[string]string // id => path, of everything not yet cleaned up
+}
+
+// Bench repeats a workload against the broker, for as long as the
+// spec says (or until it is stopped), and reports how long each sort
+// of request took.  Whatever it provisions or binds is cleaned up
+// before it returns, even if it is stopped early; anything that
+// can't be cleaned up is listed in the report's Leftovers.
+func (c *Client) Bench(cat *Catalog, spec BenchSpec) (*BenchReport, error) {
+	start := time.Now()
+	if spec.Parallel <= 0 {
+		spec.Parallel = 10
+	}
+	if spec.Interval == 0 {
+		spec.Interval = time.Second
+	}
+
+	b := &bench{
+		c:      c,
+		spec:   spec,
+		series: make(map[string]*benchSeries),
+		live:   make(map[string]string),
+		report: &BenchReport{
+			Broker:    c.URL,
+			Workload:  spec.Workload,
+			Parallel:  spec.Parallel,
+			Rate:      spec.Rate,
+			Endpoints: make([]*BenchEndpoint, 0),
+		},
+	}
+
+	var cycle func()
+	switch spec.Workload {
+	case BenchCatalog:
+		b.order = []string{"catalog"}
+		cycle = b.catalog
+
+	case BenchProvision:
+		if err := b.plan(cat, false); err != nil {
+			return nil, err
+		}
+		b.order = []string{"provision", "provision (done)", "deprovision", "deprovision (done)", "last_operation"}
+		cycle = b.provision
+
+	case BenchBind:
+		if err := b.plan(cat, true); err != nil {
+			return nil, err
+		}
+		b.order = []string{"bind", "bind (done)", "unbind", "unbind (done)", "last_operation"}
+		cycle = b.bind
+
+		// every bind cycle shares one instance, which isn't part
+		// of the benchmark, so none of this gets measured.
+		b.instance = RandomID()
+		b.track(b.instance, b.instancePath(b.instance))
+		if b.op("", "PUT", b.instancePath(b.instance), provisionBody(spec.ServiceID, spec.PlanID, spec.Parameters)) != "ok" {
+			b.cleanup()
+			return nil, fmt.Errorf("unable to provision a service instance to bind")
+		}
+
+	default:
+		return nil, fmt.Errorf("unrecognized workload '%s'", spec.Workload)
+	}
+
+	b.run(cycle)
+	b.cleanup()
+
+	b.summarize(time.Since(start))
+	b.report.Took = int64(time.Since(start) / time.Millisecond)
+	return b.report, nil
+}
+
+// plan checks that the spec's service / plan exists (and is bindable,
+// if it needs to be).
+func (b *bench) plan(cat *Catalog, bindable bool) error {
+	b.report.ServiceID = b.spec.ServiceID
+	b.report.PlanID = b.spec.PlanID
+	for _, s := range cat.Services {
+		if s.ID != b.spec.ServiceID {
+			continue
+		}
+		for _, p := range s.Plans {
+			if p.ID != b.spec.PlanID {
+				continue
+			}
+			ok := s.Bindable
+			if p.MaybeBindable != nil {
+				ok = *p.MaybeBindable
+			}
+			if bindable && !ok {
+				return fmt.Errorf("the plan is not bindable")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no such service / plan: %s / %s", b.spec.ServiceID, b.spec.PlanID)
+}
+
+// run starts cycles until the time is up (or we are stopped), either
+// as fast as Parallel workers can get through them, or at Rate cycles
+// per second (which Parallel still caps), and waits for them all to
+// finish.
+func (b *bench) run(cycle func()) {
+	start := time.Now()
+	next := make(chan bool)
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.spec.Parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range next {
+				cycle()
+				b.lock.Lock()
+				b.report.Cycles++
+				b.lock.Unlock()
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if b.spec.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / b.spec.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	done := time.After(b.spec.Duration)
+
+	for running := true; running; {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-done:
+				running = false
+				continue
+			case <-b.spec.Stop:
+				b.report.Interrupted = true
+				running = false
+				continue
+			}
+		}
+
+		select {
+		case next <- true:
+		case <-done:
+			running = false
+		case <-b.spec.Stop:
+			b.report.Interrupted = true
+			running = false
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	b.report.Elapsed = int64(time.Since(start) / time.Millisecond)
+}
+
+func (b *bench) instancePath(id string) string {
+	return "/v2/service_instances/" + id
+}
+
+func (b *bench) bindingPath(id string) string {
+	return "/v2/service_instances/" + b.instance + "/service_bindings/" + id
+}
+
+func (b *bench) track(id, path string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.live[id] = path
+}
+
+func (b *bench) untrack(id string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.live, id)
+}
+
+func (b *bench) warn(f string, args ...interface{}) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.report.Warnings = append(b.report.Warnings, fmt.Sprintf(f, args...))
+}
+
+// record adds one measurement to the named series; unnamed requests
+// (setup and cleanup) aren't measured.
+func (b *bench) record(name string, took time.Duration, ok bool) {
+	if name == "" {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	s, found := b.series[name]
+	if !found {
+		s = &benchSeries{}
+		b.series[name] = s
+	}
+	s.took = append(s.took, took)
+	if !ok {
+		s.errors++
+	}
+}
+
+// op makes a request (PUT or DELETE) for an instance or binding, and
+// waits for it to finish if the broker accepts it, measuring both.
+// It returns `ok` if it worked, `rejected` if the broker said no (so
+// there is nothing to clean up), or `unknown`.
+func (b *bench) op(name, method, path string, in interface{}) string {
+	deleting := method == "DELETE"
+	q := async(true)
+	if deleting {
+		q = query(b.spec.ServiceID, b.spec.PlanID, true)
+	}
+
+	start := time.Now()
+	x, err := b.c.exchange(method, path+q, in)
+	if err != nil {
+		b.record(name, time.Since(start), false)
+		return "unknown"
+	}
+
+	switch {
+	case x.StatusCode == 202:
+		b.record(name, time.Since(start), true)
+		state := b.wait(name, path, x)
+		ok := state == Succeeded || (deleting && state == Gone)
+		if name != "" {
+			b.record(name+" (done)", time.Since(start), ok)
+		}
+		if ok {
+			return "ok"
+		}
+		return "unknown"
+
+	case x.StatusCode/100 == 2, deleting && x.StatusCode == 410:
+		b.record(name, time.Since(start), true)
+		return "ok"
+
+	case x.StatusCode/100 == 4 && x.StatusCode != 408 && x.StatusCode != 422:
+		b.record(name, time.Since(start), false)
+		return "rejected"
+
+	default:
+		b.record(name, time.Since(start), false)
+		return "unknown"
+	}
+}
+
+// wait polls the last operation of the instance (or binding) at path,
+// until it finishes, measuring each poll.
+func (b *bench) wait(name, path string, x *Exchange) string {
+	path += "/last_operation" + query(b.spec.ServiceID, b.spec.PlanID, false)
+	if op, _ := x.Object()["operation"].(string); op != "" {
+		path += "&operation=" + url.QueryEscape(op)
+	}
+	if name != "" {
+		name = "last_operation"
+	}
+
+	deadline := time.Now().Add(b.spec.MaxWait)
+	for {
+		time.Sleep(b.spec.Interval)
+		start := time.Now()
+		x, err := b.c.exchange("GET", path, nil)
+		if err != nil {
+			b.record(name, time.Since(start), false)
+			return Failed
+		}
+		b.record(name, time.Since(start), x.StatusCode == 200 || x.StatusCode == 410)
+
+		state, _ := x.Object()["state"].(string)
+		switch {
+		case x.StatusCode == 410:
+			return Gone
+		case x.StatusCode != 200:
+			return Failed
+		case state != InProgress:
+			return state
+		case b.spec.MaxWait > 0 && time.Now().After(deadline):
+			return InProgress
+		}
+	}
+}
+
+func (b *bench) catalog() {
+	start := time.Now()
+	x, err := b.c.exchange("GET", "/v2/catalog", nil)
+	b.record("catalog", time.Since(start), err == nil && x.StatusCode == 200)
+}
+
+func (b *bench) provision() {
+	id := RandomID()
+	path := b.instancePath(id)
+
+	b.track(id, path)
+	if b.op("provision", "PUT", path, provisionBody(b.spec.ServiceID, b.spec.PlanID, b.spec.Parameters)) == "rejected" {
+		b.untrack(id)
+		return
+	}
+	if b.op("deprovision", "DELETE", path, nil) == "ok" {
+		b.untrack(id)
+	}
+}
+
+func (b *bench) bind() {
+	id := RandomID()
+	path := b.bindingPath(id)
+
+	b.track(id, path)
+	if b.op("bind", "PUT", path, map[string]interface{}{
+		"service_id": b.spec.ServiceID,
+		"plan_id":    b.spec.PlanID,
+		"parameters": b.spec.Parameters,
+	}) == "rejected" {
+		b.untrack(id)
+		return
+	}
+	if b.op("unbind", "DELETE", path, nil) == "ok" {
+		b.untrack(id)
+	}
+}
+
+// cleanup deletes anything that the cycles didn't manage to, bindings
+// first and then instances, retrying for a while if the broker is
+// still busy with them.
+func (b *bench) cleanup() {
+	ids := make([]string, 0, len(b.live))
+	for id := range b.live {
+		if id != b.instance {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if _, ok := b.live[b.instance]; ok {
+		ids = append(ids, b.instance)
+	}
+
+	for _, id := range ids {
+		path := b.live[id]
+		for attempt := 0; attempt < 10; attempt++ {
+			if b.op("", "DELETE", path, nil) == "ok" {
+				b.untrack(id)
+				break
+			}
+			time.Sleep(b.spec.Interval)
+		}
+		if _, ok := b.live[id]; ok {
+			b.warn("unable to clean up %s", path)
+			b.report.Leftovers = append(b.report.Leftovers, path)
+		}
+	}
+}
+
+// summarize works out the throughput, error rate and latencies of
+// each endpoint.  Throughput is per second of the benchmark itself,
+// not counting setup or cleanup.
+func (b *bench) summarize(took time.Duration) {
+	elapsed := float64(b.report.Elapsed) / 1000
+	if elapsed <= 0 {
+		elapsed = took.Seconds()
+	}
+
+	for _, name := range b.order {
+		s, ok := b.series[name]
+		if !ok {
+			continue
+		}
+
+		sort.Slice(s.took, func(i, j int) bool { return s.took[i] < s.took[j] })
+		n := len(s.took)
+		b.report.Endpoints = append(b.report.Endpoints, &BenchEndpoint{
+			Name:       name,
+			Requests:   n,
+			Errors:     s.errors,
+			ErrorRate:  float64(s.errors) / float64(n),
+			Throughput: float64(n) / elapsed,
+			P50:        percentile(s.took, 50),
+			P90:        percentile(s.took, 90),
+			P99:        percentile(s.took, 99),
+			Max:        percentile(s.took, 100),
+		})
+	}
+}
+
+// percentile returns the p-th percentile (by nearest rank) of a sorted
+// list of durations, in milliseconds.
+func percentile(l []time.Duration, p float64) float64 {
+	if len(l) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(l)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return float64(l[i]) / float64(time.Millisecond)
+}
@@ -0,0 +1,150 @@
+package api_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func endpoints(report *api.BenchReport) map[string]*api.BenchEndpoint {
+	m := make(map[string]*api.BenchEndpoint)
+	for _, e := range report.Endpoints {
+		m[e.Name] = e
+	}
+	return m
+}
+
+func bench(t *testing.T, c *api.Client, spec api.BenchSpec) *api.BenchReport {
+	t.Helper()
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+	if spec.Duration == 0 {
+		spec.Duration = 200 * time.Millisecond
+	}
+	spec.Parallel = 2
+	spec.Interval = 20 * time.Millisecond
+	spec.MaxWait = 5 * time.Second
+
+	report, err := c.Bench(cat, spec)
+	if err != nil {
+		t.Fatalf("unable to bench %s: %s", spec.Workload, err)
+	}
+	return report
+}
+
+func TestBench(t *testing.T) {
+	tests := []struct {
+		workload string
+		plan     string
+		names    string
+	}{
+		{api.BenchCatalog, "", "catalog"},
+		{api.BenchProvision, "db-small", "provision deprovision"},
+		{api.BenchProvision, "db-large", "provision provision (done) deprovision deprovision (done) last_operation"},
+		{api.BenchBind, "db-small", "bind unbind"},
+		{api.BenchBind, "db-large", "bind bind (done) unbind unbind (done) last_operation"},
+	}
+	for _, test := range tests {
+		b, c := newBroker(t)
+		report := bench(t, c, api.BenchSpec{Workload: test.workload, ServiceID: "db", PlanID: test.plan})
+
+		var names []string
+		for _, e := range report.Endpoints {
+			names = append(names, e.Name)
+			if e.Requests == 0 || e.Errors != 0 || e.P50 > e.P99 || e.P99 > e.Max {
+				t.Errorf("%s %s: unexpected %s summary %+v", test.workload, test.plan, e.Name, e)
+			}
+		}
+		if got := strings.Join(names, " "); got != test.names {
+			t.Errorf("%s %s: expected endpoints [%s], got [%s]", test.workload, test.plan, test.names, got)
+		}
+		if report.Cycles == 0 || report.Interrupted {
+			t.Errorf("%s %s: expected some uninterrupted cycles, got %d", test.workload, test.plan, report.Cycles)
+		}
+		if len(report.Leftovers) != 0 || len(b.Mock.State().Instances) != 0 {
+			t.Errorf("%s %s: expected everything to be cleaned up, but %v were left over", test.workload, test.plan, report.Leftovers)
+		}
+		b.Close()
+	}
+}
+
+func TestBenchSpec(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("db").Plan("small").
+		Service("static").NotBindable().Plan("only")
+	c := b.Client()
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+
+	for _, spec := range []api.BenchSpec{
+		{Workload: "stress"},
+		{Workload: api.BenchProvision, ServiceID: "db", PlanID: "db-huge"},
+		{Workload: api.BenchBind, ServiceID: "static", PlanID: "static-only"},
+	} {
+		if _, err := c.Bench(cat, spec); err == nil {
+			t.Errorf("benching %s of %s/%s should fail", spec.Workload, spec.ServiceID, spec.PlanID)
+		}
+	}
+	if n := len(b.Requests()); n != 1 {
+		t.Errorf("bad specs should not be benched, but %d requests were made", n)
+	}
+}
+
+func TestBenchStop(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	stop := make(chan struct{})
+	close(stop)
+	report := bench(t, c, api.BenchSpec{Workload: api.BenchBind, ServiceID: "db", PlanID: "db-small", Duration: time.Minute, Stop: stop})
+	if !report.Interrupted {
+		t.Errorf("a stopped bench should say it was interrupted")
+	}
+	if len(b.Mock.State().Instances) != 0 {
+		t.Errorf("a stopped bench should still clean up after itself")
+	}
+}
+
+func TestBenchErrors(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// rejected provisions have nothing to clean up
+	b.On(osbtest.Provision).Status(400).Error("", "out of quota")
+	report := bench(t, c, api.BenchSpec{Workload: api.BenchProvision, ServiceID: "db", PlanID: "db-small"})
+	e := endpoints(report)["provision"]
+	if e == nil || e.Errors != e.Requests || e.ErrorRate != 1 {
+		t.Errorf("expected every provision to fail, got %+v", e)
+	}
+	if len(report.Leftovers) != 0 {
+		t.Errorf("rejected provisions should not be left over, but got %v", report.Leftovers)
+	}
+	b.Expect(osbtest.Deprovision).Never()
+}
+
+func TestBenchLeftovers(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// each leftover is retried before being given up on, so only
+	// start a few cycles
+	b.On(osbtest.Deprovision).Status(500).Error("", "stuck")
+	report := bench(t, c, api.BenchSpec{Workload: api.BenchProvision, ServiceID: "db", PlanID: "db-small", Rate: 20})
+	if e := endpoints(report)["deprovision"]; e == nil || e.Errors == 0 {
+		t.Errorf("expected failed deprovisions, got %+v", e)
+	}
+	if n := len(b.Mock.State().Instances); len(report.Leftovers) != n || n == 0 {
+		t.Errorf("expected all %d stuck instances to be left over, but got %v", n, report.Leftovers)
+	}
+	if len(report.Warnings) != len(report.Leftovers) {
+		t.Errorf("expected a warning for each leftover, got %v", report.Warnings)
+	}
+}
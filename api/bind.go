@@ -2,6 +2,8 @@ package api
 
 import (
 	"fmt"
+	"net/http"
+	"time"
 )
 
 type BindSpec struct {
@@ -14,6 +16,17 @@ type BindSpec struct {
 	Parameters map[string]interface{} `json:"parameters"`
 }
 
+type VolumeMount struct {
+	Driver       string `json:"driver"        yaml:"driver"`
+	ContainerDir string `json:"container_dir" yaml:"container_dir"`
+	Mode         string `json:"mode"          yaml:"mode"`
+	DeviceType   string `json:"device_type"   yaml:"device_type"`
+	Device       struct {
+		VolumeID    string                 `json:"volume_id"    yaml:"volume_id"`
+		MountConfig map[string]interface{} `json:"mount_config" yaml:"mount_config,omitempty"`
+	} `json:"device" yaml:"device"`
+}
+
 type BindStatus struct {
 	InstanceID string `json:"-"`
 	BindingID  string `json:"-"`
@@ -23,28 +36,36 @@ type BindStatus struct {
 	Credentials     map[string]interface{} `json:"credentials"`
 	SyslogDrainURL  string                 `json:"syslog_drain_url"`
 	RouteServiceURL string                 `json:"route_service_url"`
-	VolumeMounts    []struct {
-		Driver       string `json:"driver"`
-		ContainerDir string `json:"container_dir"`
-		Mode         string `json:"mode"`
-		DeviceType   string `json:"device_type"`
-		Device       struct {
-			VolumeID    string                 `json:"volume_id"`
-			MountConfig map[string]interface{} `json:"mount_config"`
-		} `json:"device"`
-	} `json:"volume_mounts"`
+	VolumeMounts    []VolumeMount          `json:"volume_mounts"`
 }
 
-func (c *Client) Bind(spec BindSpec) (*BindStatus, error) {
+func (c *Client) Bind(spec BindSpec) (out *BindStatus, err error) {
 	if spec.InstanceID == "" {
 		return nil, fmt.Errorf("instance ID is required for binding")
 	}
 
 	if spec.BindingID == "" {
-		spec.BindingID = randomID()
+		spec.BindingID = RandomID()
 	}
 
-	res, err := c.put("/v2/service_instances/"+spec.InstanceID+"/service_bindings/"+spec.BindingID, spec)
+	var res *http.Response
+	defer func(start time.Time) {
+		e := JournalEntry{
+			Verb:       "bind",
+			InstanceID: spec.InstanceID,
+			BindingID:  spec.BindingID,
+			ServiceID:  spec.ServiceID,
+			PlanID:     spec.PlanID,
+			Request:    spec,
+		}
+		if out != nil {
+			e.Status = out.Status
+			e.Operation = out.Operation
+		}
+		c.record(e, start, res, out, err)
+	}(time.Now())
+
+	res, err = c.put(c.async("/v2/service_instances/"+spec.InstanceID+"/service_bindings/"+spec.BindingID), spec)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type CatalogCache struct {
+	Catalogs map[string]*Catalog `json:"catalogs"`
+}
+
+func CatalogCachePath(store string) string {
+	if store == "" {
+		store = DefaultStorePath
+	}
+	return store + ".catalogs"
+}
+
+func ReadCatalogCache(path string) (*CatalogCache, error) {
+	cache := &CatalogCache{Catalogs: make(map[string]*Catalog)}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, cache); err != nil {
+		return nil, err
+	}
+	if cache.Catalogs == nil {
+		cache.Catalogs = make(map[string]*Catalog)
+	}
+	return cache, nil
+}
+
+func (cc *CatalogCache) Write(path string) error {
+	b, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+func (cc *CatalogCache) Get(url string) *Catalog {
+	if cc == nil {
+		return nil
+	}
+	return cc.Catalogs[strings.TrimSuffix(url, "/")]
+}
+
+func (cc *CatalogCache) Set(url string, cat *Catalog) {
+	cc.Catalogs[strings.TrimSuffix(url, "/")] = cat
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A Cassette is a recording of the HTTP exchanges between a Client
+// and a broker, which can be played back later, with no broker (or
+// network) involved.  That makes it possible to reproduce exactly
+// what a flaky broker did, and to test against it, from Go:
+//
+//	cassette, err := api.LoadCassette("testdata/flaky.yml")
+//	...
+//	c := &api.Client{URL: "http://broker", Cassette: cassette}
+//
+// Authorization headers and binding credentials are redacted from
+// recordings, so cassettes can be shared without leaking secrets.
+type Cassette struct {
+	Path         string         `yaml:"-"`
+	Interactions []*Interaction `yaml:"interactions"`
+
+	// Recording cassettes record every exchange, and save themselves
+	// after each one.  Otherwise, requests are answered from the
+	// recorded responses.
+	Recording bool `yaml:"-"`
+
+	// Which parts of requests have to be the same as the recording
+	// for a recorded response to be played back: any of "method",
+	// "host", "path", "query" and "body".  Bodies are compared as
+	// JSON, if they are JSON.  Defaults to method and path.
+	Match []string `yaml:"-"`
+
+	// Strict cassettes play back each response once, in order, and
+	// fail any request that doesn't match the next one.  Otherwise,
+	// the first matching response that hasn't been played back yet is
+	// used, or the last one that has, if they all have.
+	Strict bool `yaml:"-"`
+
+	lock   sync.Mutex
+	played []bool
+	last   int
+}
+
+type Interaction struct {
+	Request  CassetteRequest   `yaml:"request"`
+	Response *CassetteResponse `yaml:"response,omitempty"`
+	Error    string            `yaml:"error,omitempty"`
+	Recorded time.Time         `yaml:"recorded"`
+	Took     int64             `yaml:"took_ms"`
+}
+
+type CassetteRequest struct {
+	Method  string      `yaml:"method"`
+	Host    string      `yaml:"host"`
+	Path    string      `yaml:"path"`
+	Query   string      `yaml:"query,omitempty"`
+	Headers http.Header `yaml:"headers,omitempty"`
+	Body    string      `yaml:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	Status  int         `yaml:"status"`
+	Headers http.Header `yaml:"headers,omitempty"`
+	Body    string      `yaml:"body,omitempty"`
+}
+
+const Redacted = "REDACTED"
+
+var CassetteMatches = []string{"method", "host", "path", "query", "body"}
+
+// RecordCassette starts recording to a cassette file.  If the file
+// already exists, new exchanges are added to the end of it.
+func RecordCassette(path string) (*Cassette, error) {
+	k := &Cassette{Path: path}
+	if _, err := os.Stat(path); err == nil {
+		if k, err = LoadCassette(path); err != nil {
+			return nil, err
+		}
+	}
+	k.Recording = true
+	return k, k.Save()
+}
+
+// LoadCassette reads a cassette file, for playing back.
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Cassette{Path: path}
+	if err := yaml.Unmarshal(b, k); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	k.played = make([]bool, len(k.Interactions))
+	k.last = -1
+	return k, nil
+}
+
+func (k *Cassette) Save() error {
+	if k.Interactions == nil {
+		k.Interactions = make([]*Interaction, 0)
+	}
+	b, err := yaml.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(k.Path, b, 0600)
+}
+
+// Unplayed returns the recorded exchanges that have not been played
+// back (yet).
+func (k *Cassette) Unplayed() []*Interaction {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	l := make([]*Interaction, 0)
+	for i, x := range k.Interactions {
+		if !k.played[i] {
+			l = append(l, x)
+		}
+	}
+	return l
+}
+
+// Do sends a request, recording the exchange, or answers it from the
+// recording, depending on what sort of cassette it is.
+func (k *Cassette) Do(ua *http.Client, req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	if !k.Recording {
+		return k.play(req, body)
+	}
+	return k.record(ua, req, body)
+}
+
+func (k *Cassette) record(ua *http.Client, req *http.Request, body []byte) (*http.Response, error) {
+	x := &Interaction{
+		Request: CassetteRequest{
+			Method:  req.Method,
+			Host:    req.URL.Host,
+			Path:    req.URL.Path,
+			Query:   req.URL.RawQuery,
+			Headers: redactHeaders(req.Header),
+			Body:    redactBody(body),
+		},
+		Recorded: time.Now(),
+	}
+
+	res, err := ua.Do(req)
+	x.Took = int64(time.Since(x.Recorded) / time.Millisecond)
+	if err != nil {
+		x.Error = err.Error()
+	} else {
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		res.Body = ioutil.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return res, err
+		}
+		x.Response = &CassetteResponse{
+			Status:  res.StatusCode,
+			Headers: redactHeaders(res.Header),
+			Body:    redactBody(b),
+		}
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.Interactions = append(k.Interactions, x)
+	if err := k.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to save cassette %s: %s\n", k.Path, err)
+	}
+	return res, err
+}
+
+func (k *Cassette) play(req *http.Request, body []byte) (*http.Response, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	found := -1
+	if k.Strict {
+		next := k.last + 1
+		if next >= len(k.Interactions) {
+			return nil, fmt.Errorf("cassette %s: no more recorded exchanges to play back for %s %s", k.Path, req.Method, req.URL.Path)
+		}
+		if !k.matches(k.Interactions[next].Request, req, body) {
+			r := k.Interactions[next].Request
+			return nil, fmt.Errorf("cassette %s: expected %s %s (exchange #%d), but got %s %s", k.Path, r.Method, r.Path, next+1, req.Method, req.URL.Path)
+		}
+		found = next
+
+	} else {
+		for i, x := range k.Interactions {
+			if k.matches(x.Request, req, body) {
+				if !k.played[i] {
+					found = i
+					break
+				}
+				if found < 0 || i == k.last {
+					found = i
+				}
+			}
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("cassette %s: no recorded exchange matches %s %s", k.Path, req.Method, req.URL.Path)
+		}
+	}
+
+	k.played[found] = true
+	k.last = found
+	x := k.Interactions[found]
+	if x.Error != "" || x.Response == nil {
+		return nil, fmt.Errorf("%s (played back from cassette %s)", x.Error, k.Path)
+	}
+
+	headers := x.Response.Headers
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", x.Response.Status, http.StatusText(x.Response.Status)),
+		StatusCode:    x.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        CopyHeader(headers),
+		Body:          ioutil.NopCloser(strings.NewReader(x.Response.Body)),
+		ContentLength: int64(len(x.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (k *Cassette) matches(r CassetteRequest, req *http.Request, body []byte) bool {
+	match := k.Match
+	if len(match) == 0 {
+		match = []string{"method", "path"}
+	}
+
+	for _, m := range match {
+		switch m {
+		case "method":
+			if r.Method != req.Method {
+				return false
+			}
+		case "host":
+			if r.Host != req.URL.Host {
+				return false
+			}
+		case "path":
+			if r.Path != req.URL.Path {
+				return false
+			}
+		case "query":
+			q, _ := url.ParseQuery(r.Query)
+			if !reflect.DeepEqual(q, req.URL.Query()) && (len(q) > 0 || len(req.URL.Query()) > 0) {
+				return false
+			}
+		case "body":
+			if !sameBody(r.Body, redactBody(body)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sameBody(a, b string) bool {
+	var x, y interface{}
+	if json.Unmarshal([]byte(a), &x) != nil || json.Unmarshal([]byte(b), &y) != nil {
+		return a == b
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func redactHeaders(h http.Header) http.Header {
+	h = CopyHeader(h)
+	for _, k := range []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"} {
+		if h.Get(k) != "" {
+			h.Set(k, Redacted)
+		}
+	}
+	return h
+}
+
+// redactBody blanks out every value in the `credentials` of a JSON
+// body, leaving the keys, so that it's still clear what was there.
+func redactBody(b []byte) string {
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) != nil {
+		return string(b)
+	}
+	creds, ok := m["credentials"].(map[string]interface{})
+	if !ok {
+		return string(b)
+	}
+
+	for k := range creds {
+		creds[k] = Redacted
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return string(b)
+	}
+	return string(out)
+}
@@ -0,0 +1,157 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+// record provisions and binds against a fake broker, recording it all
+// to a cassette, and returns the path to that cassette.
+func record(t *testing.T, dir string) string {
+	b, c := newBroker(t)
+	defer b.Close()
+	path := filepath.Join(dir, "cassette.yml")
+
+	k, err := api.RecordCassette(path)
+	if err != nil {
+		t.Fatalf("unable to start recording: %s", err)
+	}
+	c.Cassette = k
+
+	if _, err := c.GetCatalog(); err != nil {
+		t.Fatalf("unable to fetch the catalog: %s", err)
+	}
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	if _, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	return path
+}
+
+// replay loads a cassette, for a client that can only talk to it.
+func replay(t *testing.T, path string, strict bool) *api.Client {
+	k, err := api.LoadCassette(path)
+	if err != nil {
+		t.Fatalf("unable to load the cassette: %s", err)
+	}
+	k.Strict = strict
+	return &api.Client{URL: "http://broker.invalid", Cassette: k, AcceptsIncomplete: true}
+}
+
+func TestCassetteRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := ioutil.ReadFile(record(t, dir))
+	if err != nil {
+		t.Fatalf("unable to read the cassette: %s", err)
+	}
+	s := string(b)
+	for _, want := range []string{"/v2/catalog", "/v2/service_instances/i-1", "/v2/service_instances/i-1/service_bindings/b-1"} {
+		if !strings.Contains(s, "path: "+want+"\n") {
+			t.Errorf("the cassette should have recorded a request for %s", want)
+		}
+	}
+	if strings.Contains(s, "Basic ") {
+		t.Errorf("the cassette shouldn't have any Authorization headers in it")
+	}
+	if strings.Contains(s, "db.mock") || !strings.Contains(s, api.Redacted) {
+		t.Errorf("the cassette should have the binding credentials redacted")
+	}
+}
+
+func TestCassetteStrictReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := record(t, dir)
+
+	c := replay(t, path, true)
+	if _, err := c.GetCatalog(); err != nil {
+		t.Fatalf("unable to replay the catalog: %s", err)
+	}
+	stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"})
+	if err != nil {
+		t.Fatalf("unable to replay the provision: %s", err)
+	}
+	if stat.Status != "provisioned" {
+		t.Errorf("expected the replayed provision to have provisioned, but it %s", stat.Status)
+	}
+	if _, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to replay the bind: %s", err)
+	}
+	if n := len(c.Cassette.Unplayed()); n != 0 {
+		t.Errorf("every exchange should have been played back, but %d weren't", n)
+	}
+	if _, err := c.GetCatalog(); err == nil || !strings.Contains(err.Error(), "no more recorded exchanges") {
+		t.Errorf("a strict cassette should only play each exchange once, but got %v", err)
+	}
+
+	// out of order
+	c = replay(t, path, true)
+	_, err = c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"})
+	if err == nil || !strings.Contains(err.Error(), "expected GET /v2/catalog") {
+		t.Errorf("a strict cassette should refuse requests out of order, but got %v", err)
+	}
+}
+
+func TestCassetteLenientReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := replay(t, record(t, dir), false)
+	if _, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to replay the bind: %s", err)
+	}
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to replay the provision: %s", err)
+	}
+	if n := len(c.Cassette.Unplayed()); n != 1 {
+		t.Errorf("only the catalog should be left to play back, but %d exchanges are", n)
+	}
+
+	// once played, the last response is played again
+	for i := 0; i < 2; i++ {
+		stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"})
+		if err != nil || stat.Status != "provisioned" {
+			t.Errorf("a lenient cassette should replay the provision again, but got %v (%v)", stat, err)
+		}
+	}
+
+	if _, err := c.Provision("i-2", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err == nil {
+		t.Errorf("nothing was recorded for i-2, so its provision should have failed")
+	}
+}
+
+func TestCassetteMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := record(t, dir)
+
+	c := replay(t, path, false)
+	c.Cassette.Match = []string{"method", "path", "body"}
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Errorf("the same provision should match the recording, but got %s", err)
+	}
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-large"}); err == nil {
+		t.Errorf("a provision with a different body shouldn't match the recording")
+	}
+}
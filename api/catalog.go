@@ -4,6 +4,13 @@ import (
 	"fmt"
 )
 
+// MaintenanceInfo describes the version of whatever a plan deploys,
+// so that platforms can tell when instances need to be upgraded.
+type MaintenanceInfo struct {
+	Version     string `json:"version"                yaml:"version"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
 type Catalog struct {
 	Services []struct {
 		ID          string `json:"id"`
@@ -35,7 +42,9 @@ type Catalog struct {
 			Free      bool  `json:"-"`
 
 			MaybeBindable *bool `json:"bindable"`
-			Bindable      bool  `json:"bindable"`
+			Bindable      bool  `json:"-"`
+
+			MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
 
 			Metadata interface{} `json:"metadata,omitempty"`
 
@@ -104,3 +113,64 @@ func (cat Catalog) FindPlan(service, plan string) (string, string, error) {
 
 	return "", "", fmt.Errorf("no such service / plan: %s / %s", service, plan)
 }
+
+func (cat Catalog) Names(service, plan string) (string, string) {
+	for _, s := range cat.Services {
+		if s.ID == service {
+			for _, p := range s.Plans {
+				if p.ID == plan {
+					return s.Name, p.Name
+				}
+			}
+			return s.Name, plan
+		}
+	}
+
+	return service, plan
+}
+
+func (cat Catalog) Retrievable(service string) (bool, bool) {
+	for _, s := range cat.Services {
+		if s.ID == service {
+			return s.InstancesRetrievable, s.BindingsRetrievable
+		}
+	}
+	return false, false
+}
+
+// PlanUpdateable returns true if instances of the given service can
+// be moved from one plan to another.
+func (cat Catalog) PlanUpdateable(service string) bool {
+	for _, s := range cat.Services {
+		if s.ID == service {
+			return s.PlanUpdateable
+		}
+	}
+	return false
+}
+
+func (cat Catalog) Tags(service string) []string {
+	for _, s := range cat.Services {
+		if s.ID == service {
+			return s.Tags
+		}
+	}
+	return nil
+}
+
+// BindingType works out the servicebinding.io `type` and `provider`
+// for bindings of the given service.  The type is the first of the
+// service's tags (which tend to be generic, like "postgresql"), and
+// the provider is the service's own name.  Services without tags
+// use their name as the type, and have no provider.
+func (cat Catalog) BindingType(service string) (string, string) {
+	for _, s := range cat.Services {
+		if s.ID == service {
+			if len(s.Tags) > 0 && s.Tags[0] != s.Name {
+				return s.Tags[0], s.Name
+			}
+			return s.Name, ""
+		}
+	}
+	return service, ""
+}
@@ -10,6 +10,7 @@ import (
 	"net/http/httputil"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	fmt "github.com/jhunt/go-ansi"
@@ -29,10 +30,24 @@ type Client struct {
 
 	APIVersion string
 
-	ua *http.Client
+	// AcceptsIncomplete tells the broker that we are willing and
+	// able to poll for the outcome of asynchronous operations.
+	AcceptsIncomplete bool
+
+	Journal *Journal
+
+	// If set, every exchange with the broker is recorded to (or
+	// played back from) the cassette.
+	Cassette *Cassette
+
+	ua   *http.Client
+	lock sync.Mutex
 }
 
 func (c *Client) init() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	if c.APIVersion == "" {
 		c.APIVersion = DefaultAPIVersion
 	}
@@ -54,9 +69,20 @@ func (c *Client) init() {
 
 func (c *Client) do(req *http.Request) (*http.Response, error) {
 	c.init()
+	c.prepare(req)
+	return c.send(req)
+}
+
+// prepare sets the headers that every request to the broker needs.
+func (c *Client) prepare(req *http.Request) {
 	req.Header.Set("X-Broker-API-Version", c.APIVersion)
 	req.SetBasicAuth(c.Username, c.Password)
+	if req.Header.Get(RequestIdentityHeader) == "" {
+		req.Header.Set(RequestIdentityHeader, RandomID())
+	}
+}
 
+func (c *Client) send(req *http.Request) (*http.Response, error) {
 	if c.Trace {
 		b, err := httputil.DumpRequest(req, true)
 		if err != nil {
@@ -65,7 +91,7 @@ func (c *Client) do(req *http.Request) (*http.Response, error) {
 			fmt.Fprintf(os.Stderr, "@M{%s}\n\n", string(b))
 		}
 
-		res, err := c.ua.Do(req)
+		res, err := c.roundTrip(req)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "@Y{request failed:} @R{%s}\n", err)
 		}
@@ -90,6 +116,13 @@ func (c *Client) do(req *http.Request) (*http.Response, error) {
 		return res, err
 	}
 
+	return c.roundTrip(req)
+}
+
+func (c *Client) roundTrip(req *http.Request) (*http.Response, error) {
+	if c.Cassette != nil {
+		return c.Cassette.Do(c.ua, req)
+	}
 	return c.ua.Do(req)
 }
 
@@ -130,6 +163,32 @@ func (c *Client) put(path string, in interface{}) (res *http.Response, err error
 	return c.do(req)
 }
 
+func (c *Client) patch(path string, in interface{}) (res *http.Response, err error) {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("PATCH", c.url(path), bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(req)
+}
+
+// async adds `accepts_incomplete=true` to the query string of a
+// lifecycle request path, if the client accepts incomplete ops.
+func (c *Client) async(path string) string {
+	if !c.AcceptsIncomplete {
+		return path
+	}
+	if strings.Contains(path, "?") {
+		return path + "&accepts_incomplete=true"
+	}
+	return path + "?accepts_incomplete=true"
+}
+
 func (c *Client) del(path string) (res *http.Response, err error) {
 	req, err := http.NewRequest("DELETE", c.url(path), nil)
 	if err != nil {
@@ -0,0 +1,187 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func newBroker(t *testing.T) (*osbtest.Broker, *api.Client) {
+	b := osbtest.New(t)
+	b.Service("db").
+		Plan("small").
+		Plan("large").Async(200 * time.Millisecond).AsyncBindings()
+
+	c := b.Client()
+	c.AcceptsIncomplete = true
+	return b, c
+}
+
+func TestProvision(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	stat, err := c.Provision("i-1", api.ProvisionSpec{
+		ServiceID:  "db",
+		PlanID:     "db-small",
+		Parameters: map[string]interface{}{"size": "10G"},
+	})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	if stat.Status != "provisioned" || stat.InstanceID != "i-1" {
+		t.Errorf("expected i-1 to be provisioned, but got %s / %s", stat.InstanceID, stat.Status)
+	}
+
+	b.Expect(osbtest.Provision).
+		Header("X-Broker-API-Version", api.DefaultAPIVersion).
+		Query("accepts_incomplete", "true").
+		Field("service_id", "db").
+		Field("plan_id", "db-small").
+		Field("parameters.size", "10G").
+		Times(1)
+}
+
+func TestProvisionError(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Provision).Status(400).Error("", "bad size").Once()
+
+	_, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"})
+	if err == nil {
+		t.Fatalf("provision should have failed")
+	}
+	if api.IsConcurrencyError(err) {
+		t.Errorf("a 400 Bad Request is not a ConcurrencyError")
+	}
+}
+
+func TestProvisionAsync(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	if stat.Status != "provisioning" || stat.Operation == "" {
+		t.Fatalf("expected an asynchronous provision, but got %s (operation '%s')", stat.Status, stat.Operation)
+	}
+
+	op, err := c.WaitFor(api.LastOperationSpec{
+		InstanceID: "i-1",
+		ServiceID:  "db",
+		PlanID:     "db-large",
+		Operation:  stat.Operation,
+	}, 50*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to wait for the provision: %s", err)
+	}
+	if op.State != api.Succeeded {
+		t.Errorf("expected the provision to succeed, but it %s", op.State)
+	}
+
+	b.Expect(osbtest.LastOperation).Query("operation", stat.Operation)
+}
+
+func TestWaitFor(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	spec := api.LastOperationSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-large"}
+
+	b.On(osbtest.LastOperation).Status(200).JSON(map[string]string{"state": "in progress"}).Times(2)
+	b.On(osbtest.LastOperation).Status(200).JSON(map[string]string{"state": "failed", "description": "no disk"}).Once()
+	op, err := c.WaitFor(spec, 10*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to wait: %s", err)
+	}
+	if op.State != api.Failed || op.Description != "no disk" {
+		t.Errorf("expected the operation to fail with 'no disk', but got %s '%s'", op.State, op.Description)
+	}
+	b.Expect(osbtest.LastOperation).Times(3)
+	b.Verify()
+
+	b.On(osbtest.LastOperation).Status(410).JSON(map[string]string{})
+	if op, err = c.WaitFor(spec, 10*time.Millisecond, 5*time.Second); err != nil || op.State != api.Gone {
+		t.Errorf("a 410 from last_operation should mean the instance is gone, but got %v (%v)", op, err)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.LastOperation).Status(200).JSON(map[string]string{"state": "in progress"})
+
+	op, err := c.WaitFor(api.LastOperationSpec{InstanceID: "i-1"}, 10*time.Millisecond, 50*time.Millisecond)
+	if err == nil {
+		t.Fatalf("WaitFor should have timed out")
+	}
+	if op == nil || op.State != api.InProgress {
+		t.Errorf("WaitFor should hand back the last state it saw when it times out, but got %v", op)
+	}
+}
+
+func TestBind(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+
+	stat, err := c.Bind(api.BindSpec{
+		InstanceID: "i-1",
+		BindingID:  "b-1",
+		ServiceID:  "db",
+		PlanID:     "db-small",
+	})
+	if err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	if stat.Status != "bound" {
+		t.Errorf("expected the binding to be bound, but it is %s", stat.Status)
+	}
+	if stat.Credentials["hostname"] != "db.mock" {
+		t.Errorf("expected credentials for db.mock, but got %v", stat.Credentials)
+	}
+
+	binding, err := c.GetBinding("i-1", "b-1")
+	if err != nil {
+		t.Fatalf("unable to fetch the binding: %s", err)
+	}
+	if binding.Credentials["username"] != stat.Credentials["username"] {
+		t.Errorf("fetched credentials %v don't match the ones from the bind, %v", binding.Credentials, stat.Credentials)
+	}
+
+	b.Expect(osbtest.Bind).Field("service_id", "db").Field("plan_id", "db-small").Times(1)
+}
+
+func TestBindAsync(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	last := api.LastOperationSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-large", Operation: stat.Operation}
+	if _, err := c.WaitFor(last, 50*time.Millisecond, 5*time.Second); err != nil {
+		t.Fatalf("unable to wait for the provision: %s", err)
+	}
+
+	bind, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	if bind.Status != "binding" {
+		t.Fatalf("expected an asynchronous bind, but got %s", bind.Status)
+	}
+	last.BindingID, last.Operation = "b-1", bind.Operation
+	op, err := c.WaitFor(last, 50*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to wait for the bind: %s", err)
+	}
+	if op.State != api.Succeeded {
+		t.Errorf("expected the bind to succeed, but it %s", op.State)
+	}
+}
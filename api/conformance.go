@@ -0,0 +1,921 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestSkipped = "skipped"
+)
+
+// An Exchange is a single request / response round-trip with the
+// broker, as it was seen on the wire, so that test reports can show
+// exactly what the broker said.
+type Exchange struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Request    string `json:"request,omitempty"`
+	StatusCode int    `json:"status"`
+	Response   string `json:"response,omitempty"`
+	Took       int64  `json:"took_ms"`
+
+	body interface{}
+	json bool
+}
+
+func (x *Exchange) String() string {
+	return fmt.Sprintf("%s %s => HTTP %d %s", x.Method, x.Path, x.StatusCode, truncate(x.Response, 200))
+}
+
+// Object returns the response body, if it was a JSON object.
+func (x *Exchange) Object() map[string]interface{} {
+	m, _ := x.body.(map[string]interface{})
+	return m
+}
+
+// exchange sends a request to the broker, and reads (and tries to
+// parse) whatever comes back.  Errors are only returned for things
+// like network failures; any HTTP response at all is an Exchange.
+func (c *Client) exchange(method, path string, in interface{}) (*Exchange, error) {
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	return c.rawExchange(method, path, body, nil)
+}
+
+// rawExchange sends exactly the given body, and lets the caller mess
+// with the request headers (i.e. to break authentication) just before
+// the request goes out.
+func (c *Client) rawExchange(method, path string, body []byte, tweak func(*http.Request)) (*Exchange, error) {
+	req, err := http.NewRequest(method, c.url(path), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Exchange{
+		Method:  req.Method,
+		Path:    req.URL.RequestURI(),
+		Request: string(body),
+	}
+
+	c.init()
+	c.prepare(req)
+	if tweak != nil {
+		tweak(req)
+	}
+
+	start := time.Now()
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	x.Took = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+
+	x.StatusCode = res.StatusCode
+	x.Response = string(b)
+	x.json = json.Unmarshal(b, &x.body) == nil
+	return x, nil
+}
+
+type TestStep struct {
+	Name      string      `json:"name"`
+	Result    string      `json:"result"`
+	Reason    string      `json:"reason,omitempty"`
+	Failures  []string    `json:"failures,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+	Exchanges []*Exchange `json:"exchanges,omitempty"`
+	Observed  *Exchange   `json:"observed,omitempty"`
+	Took      int64       `json:"took_ms"`
+
+	start time.Time
+}
+
+func newStep(name string) *TestStep {
+	return &TestStep{
+		Name:      name,
+		Result:    TestPassed,
+		Failures:  make([]string, 0),
+		Warnings:  make([]string, 0),
+		Exchanges: make([]*Exchange, 0),
+		start:     time.Now(),
+	}
+}
+
+func (s *TestStep) fail(f string, args ...interface{}) {
+	s.Result = TestFailed
+	s.Failures = append(s.Failures, fmt.Sprintf(f, args...))
+}
+
+func (s *TestStep) warn(f string, args ...interface{}) {
+	s.Warnings = append(s.Warnings, fmt.Sprintf(f, args...))
+}
+
+func (s *TestStep) skip(f string, args ...interface{}) {
+	s.Result = TestSkipped
+	s.Reason = fmt.Sprintf(f, args...)
+}
+
+func (s *TestStep) done() *TestStep {
+	s.Took = int64(time.Since(s.start) / time.Millisecond)
+	return s
+}
+
+func (s *TestStep) Failed() bool {
+	return s.Result == TestFailed
+}
+
+// send makes a request as part of this step, and fails the step
+// if the broker couldn't be reached at all.
+func (s *TestStep) send(c *Client, method, path string, in interface{}) *Exchange {
+	x, err := c.exchange(method, path, in)
+	if err != nil {
+		s.fail("%s %s failed: %s", method, path, err)
+		return nil
+	}
+	s.Exchanges = append(s.Exchanges, x)
+	return x
+}
+
+// expect fails the step unless the response has one of the given
+// HTTP status codes.
+func (s *TestStep) expect(x *Exchange, codes ...int) bool {
+	for _, code := range codes {
+		if x.StatusCode == code {
+			return true
+		}
+	}
+
+	l := make([]string, len(codes))
+	for i, code := range codes {
+		l[i] = fmt.Sprintf("%d", code)
+	}
+	s.fail("expected HTTP %s from %s %s, but got HTTP %d", strings.Join(l, " or "), x.Method, x.Path, x.StatusCode)
+	return false
+}
+
+// object fails the step unless the response body is a JSON object.
+// The spec requires `{}` for responses that have nothing to say.
+func (s *TestStep) object(x *Exchange) map[string]interface{} {
+	if !x.json {
+		s.fail("response to %s %s is not valid JSON: %q", x.Method, x.Path, truncate(x.Response, 80))
+		return nil
+	}
+	m, ok := x.body.(map[string]interface{})
+	if !ok {
+		s.fail("response to %s %s is not a JSON object", x.Method, x.Path)
+		return nil
+	}
+	return m
+}
+
+// field checks the type of an optional field in a response body.
+func (s *TestStep) field(m map[string]interface{}, name, kind string, required bool) {
+	v, ok := m[name]
+	if !ok || v == nil {
+		if required {
+			s.fail("required field `%s` is missing", name)
+		}
+		return
+	}
+
+	good := false
+	switch kind {
+	case "string":
+		_, good = v.(string)
+	case "boolean":
+		_, good = v.(bool)
+	case "object":
+		_, good = v.(map[string]interface{})
+	case "array":
+		_, good = v.([]interface{})
+	}
+	if !good {
+		s.fail("field `%s` should be a%s %s, not %s", name, article(kind), kind, jsonType(v))
+	}
+}
+
+func article(kind string) string {
+	if strings.IndexAny(kind[:1], "aeiou") == 0 {
+		return "n"
+	}
+	return ""
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	}
+	return "null"
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
+
+type TestCase struct {
+	Service    string      `json:"service"`
+	Plan       string      `json:"plan"`
+	ServiceID  string      `json:"service_id"`
+	PlanID     string      `json:"plan_id"`
+	InstanceID string      `json:"instance_id"`
+	BindingID  string      `json:"binding_id,omitempty"`
+	Result     string      `json:"result"`
+	Steps      []*TestStep `json:"steps"`
+	Took       int64       `json:"took_ms"`
+}
+
+func (t *TestCase) Name() string {
+	return t.Service + "/" + t.Plan
+}
+
+type ConformanceReport struct {
+	Broker   string      `json:"broker"`
+	Result   string      `json:"result"`
+	Catalog  *TestStep   `json:"catalog"`
+	Cases    []*TestCase `json:"cases"`
+	Negative *TestCase   `json:"negative,omitempty"`
+	Passed   int         `json:"passed"`
+	Failed   int         `json:"failed"`
+	Skipped  int         `json:"skipped"`
+	Took     int64       `json:"took_ms"`
+}
+
+func (r *ConformanceReport) count(s *TestStep) {
+	switch s.Result {
+	case TestPassed:
+		r.Passed++
+	case TestFailed:
+		r.Failed++
+		r.Result = TestFailed
+	case TestSkipped:
+		r.Skipped++
+	}
+}
+
+// A ConformanceSpec says what to test.  Plans are given as either
+// `service` (for all of a service's plans) or `service/plan`, by
+// name or ID; with no Plans, every plan in the catalog is tested.
+// Parameters are sent with every provision.  Each asynchronous
+// operation is polled every Interval, for up to MaxWait.
+//
+// Suite picks between the `lifecycle` tests, the `negative` tests
+// (requests that brokers must reject), or (if empty) both.
+type ConformanceSpec struct {
+	Suite      string
+	Plans      []string
+	Parameters map[string]interface{}
+	Interval   time.Duration
+	MaxWait    time.Duration
+}
+
+// Conform runs the whole service broker lifecycle, against each of
+// the selected service plans in turn, checking every response the
+// broker gives against what the OSB spec says it should be.  Test
+// instances and bindings are cleaned up, even if something fails.
+func (c *Client) Conform(spec ConformanceSpec) (*ConformanceReport, error) {
+	start := time.Now()
+	report := &ConformanceReport{
+		Broker: strings.TrimSuffix(c.URL, "/"),
+		Result: TestPassed,
+		Cases:  make([]*TestCase, 0),
+	}
+	if spec.Interval <= 0 {
+		spec.Interval = 2 * time.Second
+	}
+	switch spec.Suite {
+	case "", "lifecycle", "negative":
+	default:
+		return nil, fmt.Errorf("unrecognized test suite '%s' (try lifecycle, or negative)", spec.Suite)
+	}
+
+	step := newStep("catalog")
+	cat := c.checkCatalog(step)
+	report.Catalog = step.done()
+	report.count(step)
+	if cat == nil {
+		report.Took = int64(time.Since(start) / time.Millisecond)
+		return report, nil
+	}
+
+	selected, err := selectPlans(cat, spec.Plans)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Suite != "negative" {
+		for _, sp := range selected {
+			t := c.conformPlan(cat, sp[0], sp[1], spec)
+			for _, s := range t.Steps {
+				report.count(s)
+			}
+			report.Cases = append(report.Cases, t)
+		}
+	}
+	if spec.Suite != "lifecycle" && len(selected) > 0 {
+		t := c.negative(cat, selected, spec)
+		for _, s := range t.Steps {
+			report.count(s)
+		}
+		report.Negative = t
+	}
+
+	report.Took = int64(time.Since(start) / time.Millisecond)
+	return report, nil
+}
+
+func selectPlans(cat *Catalog, want []string) ([][2]int, error) {
+	l := make([][2]int, 0)
+	if len(want) == 0 {
+		for i, s := range cat.Services {
+			for j := range s.Plans {
+				l = append(l, [2]int{i, j})
+			}
+		}
+		return l, nil
+	}
+
+	for _, w := range want {
+		ws := strings.SplitN(w, "/", 2)
+		found := false
+		for i, s := range cat.Services {
+			if s.ID != ws[0] && s.Name != ws[0] {
+				continue
+			}
+			for j, p := range s.Plans {
+				if len(ws) == 1 || p.ID == ws[1] || p.Name == ws[1] {
+					l = append(l, [2]int{i, j})
+					found = true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no such service / plan '%s' in the catalog", w)
+		}
+	}
+	return l, nil
+}
+
+var cliFriendly = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func (c *Client) checkCatalog(s *TestStep) *Catalog {
+	x := s.send(c, "GET", "/v2/catalog", nil)
+	if x == nil || !s.expect(x, 200) {
+		return nil
+	}
+	m := s.object(x)
+	if m == nil {
+		return nil
+	}
+
+	var cat Catalog
+	if err := json.Unmarshal([]byte(x.Response), &cat); err != nil {
+		s.fail("unable to parse catalog: %s", err)
+		return nil
+	}
+
+	services, ok := m["services"].([]interface{})
+	if !ok {
+		s.fail("required field `services` is missing, or is not an array")
+		return nil
+	}
+	if len(services) == 0 {
+		s.warn("the catalog has no services in it")
+	}
+
+	ids := make(map[string]string)
+	names := make(map[string]bool)
+	for i, v := range services {
+		svc, ok := v.(map[string]interface{})
+		if !ok {
+			s.fail("services[%d] is not an object", i)
+			continue
+		}
+		id, _ := svc["id"].(string)
+		name, _ := svc["name"].(string)
+		where := fmt.Sprintf("service '%s'", name)
+		if name == "" {
+			where = fmt.Sprintf("services[%d]", i)
+		}
+
+		for _, f := range []string{"id", "name", "description"} {
+			if v, _ := svc[f].(string); v == "" {
+				s.fail("%s: required field `%s` is missing or empty", where, f)
+			}
+		}
+		if _, ok := svc["bindable"].(bool); !ok {
+			s.fail("%s: required field `bindable` is missing, or is not a boolean", where)
+		}
+		if name != "" && !cliFriendly.MatchString(name) {
+			s.warn("%s: name is not CLI-friendly", where)
+		}
+		if id != "" {
+			if other, dup := ids[id]; dup {
+				s.fail("%s: id '%s' is already used by %s", where, id, other)
+			}
+			ids[id] = where
+		}
+		if name != "" {
+			if names[name] {
+				s.fail("%s: name is not unique", where)
+			}
+			names[name] = true
+		}
+
+		plans, ok := svc["plans"].([]interface{})
+		if !ok || len(plans) == 0 {
+			s.fail("%s: required field `plans` is missing, or empty", where)
+			continue
+		}
+		planNames := make(map[string]bool)
+		for j, v := range plans {
+			plan, ok := v.(map[string]interface{})
+			if !ok {
+				s.fail("%s: plans[%d] is not an object", where, j)
+				continue
+			}
+			pid, _ := plan["id"].(string)
+			pname, _ := plan["name"].(string)
+			pwhere := fmt.Sprintf("%s, plan '%s'", where, pname)
+			if pname == "" {
+				pwhere = fmt.Sprintf("%s, plans[%d]", where, j)
+			}
+
+			for _, f := range []string{"id", "name", "description"} {
+				if v, _ := plan[f].(string); v == "" {
+					s.fail("%s: required field `%s` is missing or empty", pwhere, f)
+				}
+			}
+			if v, ok := plan["bindable"]; ok {
+				if _, ok := v.(bool); !ok {
+					s.fail("%s: field `bindable` should be a boolean", pwhere)
+				}
+			}
+			if v, ok := plan["free"]; ok {
+				if _, ok := v.(bool); !ok {
+					s.fail("%s: field `free` should be a boolean", pwhere)
+				}
+			}
+			if pname != "" && !cliFriendly.MatchString(pname) {
+				s.warn("%s: name is not CLI-friendly", pwhere)
+			}
+			if pid != "" {
+				if other, dup := ids[pid]; dup {
+					s.fail("%s: id '%s' is already used by %s", pwhere, pid, other)
+				}
+				ids[pid] = pwhere
+			}
+			if pname != "" {
+				if planNames[pname] {
+					s.fail("%s: name is not unique within the service", pwhere)
+				}
+				planNames[pname] = true
+			}
+		}
+	}
+
+	return &cat
+}
+
+// A conformance run tracks everything we've made on the broker, so
+// that we know what needs to be cleaned up.
+type conformance struct {
+	c    *Client
+	spec ConformanceSpec
+	t    *TestCase
+
+	async    bool
+	instance bool
+	binding  bool
+	broken   bool
+}
+
+func (c *Client) conformPlan(cat *Catalog, i, j int, spec ConformanceSpec) *TestCase {
+	start := time.Now()
+	svc := cat.Services[i]
+	plan := svc.Plans[j]
+
+	t := &TestCase{
+		Service:    svc.Name,
+		Plan:       plan.Name,
+		ServiceID:  svc.ID,
+		PlanID:     plan.ID,
+		InstanceID: RandomID(),
+		Result:     TestPassed,
+		Steps:      make([]*TestStep, 0),
+	}
+	r := &conformance{c: c, spec: spec, t: t}
+
+	bindable := svc.Bindable
+	if plan.MaybeBindable != nil {
+		bindable = *plan.MaybeBindable
+	}
+	other := ""
+	if svc.PlanUpdateable {
+		for _, p := range svc.Plans {
+			if p.ID != plan.ID {
+				other = p.ID
+				break
+			}
+		}
+	}
+
+	r.step("provision (sync)", r.provisionSync)
+	r.step("provision (async)", func(s *TestStep) {
+		if !r.async {
+			s.skip("the broker provisioned synchronously")
+			return
+		}
+		r.provisionAsync(s)
+	})
+	r.step("fetch instance", func(s *TestStep) {
+		if !svc.InstancesRetrievable {
+			s.skip("the service is not instances_retrievable")
+			return
+		}
+		r.fetchInstance(s)
+	})
+	r.step("update", func(s *TestStep) {
+		if other == "" {
+			s.skip("the service is not plan_updateable, or has no other plans")
+			return
+		}
+		r.update(s, other)
+	})
+	r.step("bind", func(s *TestStep) {
+		if !bindable {
+			s.skip("the plan is not bindable")
+			return
+		}
+		t.BindingID = RandomID()
+		r.bind(s, svc.BindingsRetrievable)
+	})
+	r.step("fetch binding", func(s *TestStep) {
+		if !r.binding {
+			s.skip("there is no binding to fetch")
+			return
+		}
+		if !svc.BindingsRetrievable {
+			s.skip("the service is not bindings_retrievable")
+			return
+		}
+		r.fetchBinding(s)
+	})
+
+	// from here on out, we're cleaning up, so we carry on
+	// regardless of what went wrong before.
+	r.cleanup("unbind", func(s *TestStep) {
+		if !r.binding {
+			s.skip("there is no binding to unbind")
+			return
+		}
+		r.unbind(s)
+	})
+	r.cleanup("deprovision", func(s *TestStep) {
+		if !r.instance {
+			s.skip("there is no instance to deprovision")
+			return
+		}
+		r.deprovision(s)
+	})
+
+	t.Took = int64(time.Since(start) / time.Millisecond)
+	return t
+}
+
+// step runs one step of the lifecycle, unless an earlier step has
+// already failed, in which case there's no point.
+func (r *conformance) step(name string, fn func(*TestStep)) {
+	if r.broken {
+		s := newStep(name)
+		s.skip("an earlier step failed")
+		r.t.Steps = append(r.t.Steps, s.done())
+		return
+	}
+	r.cleanup(name, fn)
+}
+
+// cleanup runs one step of the lifecycle, whether or not any earlier
+// steps have failed, so that we don't leave anything behind.
+func (r *conformance) cleanup(name string, fn func(*TestStep)) {
+	s := newStep(name)
+	fn(s)
+	r.t.Steps = append(r.t.Steps, s.done())
+
+	if s.Failed() {
+		r.broken = true
+		r.t.Result = TestFailed
+	}
+}
+
+func (r *conformance) instancePath(extra string) string {
+	return "/v2/service_instances/" + r.t.InstanceID + extra
+}
+
+func (r *conformance) bindingPath(extra string) string {
+	return r.instancePath("/service_bindings/" + r.t.BindingID + extra)
+}
+
+func (r *conformance) query(async bool) string {
+	return query(r.t.ServiceID, r.t.PlanID, async)
+}
+
+func (r *conformance) provisionBody() map[string]interface{} {
+	body := provisionBody(r.t.ServiceID, r.t.PlanID, r.spec.Parameters)
+	body["context"].(map[string]interface{})["instance_name"] = "osb-test-" + r.t.InstanceID[:8]
+	return body
+}
+
+// provisionSync tries to provision without accepts_incomplete, which
+// brokers either have to honor, or refuse with an AsyncRequired error.
+func (r *conformance) provisionSync(s *TestStep) {
+	x := s.send(r.c, "PUT", r.instancePath(""), r.provisionBody())
+	if x == nil {
+		return
+	}
+
+	switch x.StatusCode {
+	case 201:
+		r.instance = true
+		if m := s.object(x); m != nil {
+			s.field(m, "dashboard_url", "string", false)
+		}
+
+	case 422:
+		if m := s.object(x); m != nil {
+			if m["error"] != "AsyncRequired" {
+				s.fail("a 422 response to a synchronous provision should have the error code `AsyncRequired`, not %v", m["error"])
+				return
+			}
+		}
+		r.async = true
+
+	case 202:
+		r.instance = true
+		s.fail("the broker went asynchronous, even though `accepts_incomplete` was not set")
+		if m := s.object(x); m != nil {
+			r.wait(s, r.instancePath("/last_operation"), m, false)
+		}
+
+	default:
+		s.expect(x, 201, 422)
+		if x.StatusCode == 200 {
+			r.instance = true
+		}
+	}
+}
+
+func (r *conformance) provisionAsync(s *TestStep) {
+	x := s.send(r.c, "PUT", r.instancePath("?accepts_incomplete=true"), r.provisionBody())
+	if x == nil {
+		return
+	}
+	if x.StatusCode/100 == 2 {
+		r.instance = true
+	}
+	if !s.expect(x, 201, 202) {
+		return
+	}
+
+	m := s.object(x)
+	if m == nil {
+		return
+	}
+	s.field(m, "dashboard_url", "string", false)
+	if x.StatusCode == 202 {
+		r.wait(s, r.instancePath("/last_operation"), m, false)
+	}
+}
+
+// wait polls a last_operation endpoint, checking each response, until
+// the operation is finished.  A 410 Gone is only acceptable if we
+// are waiting for something to be deleted.
+func (r *conformance) wait(s *TestStep, path string, accepted map[string]interface{}, deleting bool) {
+	s.field(accepted, "operation", "string", false)
+	q := url.Values{}
+	q.Set("service_id", r.t.ServiceID)
+	q.Set("plan_id", r.t.PlanID)
+	if op, ok := accepted["operation"].(string); ok && op != "" {
+		if len(op) > 10000 {
+			s.fail("`operation` is longer than 10,000 characters")
+		}
+		q.Set("operation", op)
+	}
+	path += "?" + q.Encode()
+
+	deadline := time.Now().Add(r.spec.MaxWait)
+	first := len(s.Exchanges)
+	for {
+		x, err := r.c.exchange("GET", path, nil)
+		if err != nil {
+			s.fail("GET %s failed: %s", path, err)
+			return
+		}
+		// keep the first and the last polls, not all of them
+		if len(s.Exchanges) <= first+1 {
+			s.Exchanges = append(s.Exchanges, x)
+		} else {
+			s.Exchanges[len(s.Exchanges)-1] = x
+		}
+
+		if x.StatusCode == 410 {
+			if !deleting {
+				s.fail("last_operation returned 410 Gone, but nothing is being deleted")
+			}
+			return
+		}
+		if !s.expect(x, 200) {
+			return
+		}
+		m := s.object(x)
+		if m == nil {
+			return
+		}
+		s.field(m, "description", "string", false)
+
+		switch m["state"] {
+		case InProgress:
+		case Succeeded:
+			return
+		case Failed:
+			s.fail("the operation failed: %v", m["description"])
+			return
+		default:
+			s.fail("`state` should be one of \"in progress\", \"succeeded\" or \"failed\", not %v", m["state"])
+			return
+		}
+
+		if r.spec.MaxWait > 0 && time.Now().After(deadline) {
+			s.fail("the operation was still in progress after %s", r.spec.MaxWait)
+			return
+		}
+		time.Sleep(r.spec.Interval)
+	}
+}
+
+func (r *conformance) fetchInstance(s *TestStep) {
+	x := s.send(r.c, "GET", r.instancePath(""), nil)
+	if x == nil || !s.expect(x, 200) {
+		return
+	}
+	m := s.object(x)
+	if m == nil {
+		return
+	}
+	s.field(m, "service_id", "string", false)
+	s.field(m, "plan_id", "string", false)
+	s.field(m, "dashboard_url", "string", false)
+	s.field(m, "parameters", "object", false)
+	if v, ok := m["service_id"].(string); ok && v != r.t.ServiceID {
+		s.fail("`service_id` should be '%s', not '%s'", r.t.ServiceID, v)
+	}
+	if v, ok := m["plan_id"].(string); ok && v != r.t.PlanID {
+		s.fail("`plan_id` should be '%s', not '%s'", r.t.PlanID, v)
+	}
+}
+
+func (r *conformance) update(s *TestStep, plan string) {
+	x := s.send(r.c, "PATCH", r.instancePath("?accepts_incomplete=true"), map[string]interface{}{
+		"service_id": r.t.ServiceID,
+		"plan_id":    plan,
+		"previous_values": map[string]interface{}{
+			"service_id": r.t.ServiceID,
+			"plan_id":    r.t.PlanID,
+		},
+	})
+	if x == nil || !s.expect(x, 200, 202) {
+		return
+	}
+	m := s.object(x)
+	if m == nil {
+		return
+	}
+	s.field(m, "dashboard_url", "string", false)
+	if x.StatusCode == 202 {
+		r.wait(s, r.instancePath("/last_operation"), m, false)
+	}
+	if !s.Failed() {
+		r.t.PlanID = plan
+	}
+}
+
+func (r *conformance) bind(s *TestStep, retrievable bool) {
+	app := RandomID()
+	x := s.send(r.c, "PUT", r.bindingPath("?accepts_incomplete=true"), map[string]interface{}{
+		"service_id": r.t.ServiceID,
+		"plan_id":    r.t.PlanID,
+		"app_guid":   app,
+		"bind_resource": map[string]interface{}{
+			"app_guid": app,
+		},
+		"context": map[string]interface{}{
+			"platform": "cloudfoundry",
+		},
+	})
+	if x == nil {
+		return
+	}
+	if x.StatusCode/100 == 2 {
+		r.binding = true
+	}
+	if !s.expect(x, 201, 202) {
+		return
+	}
+	m := s.object(x)
+	if m == nil {
+		return
+	}
+
+	if x.StatusCode == 202 {
+		if !retrievable {
+			s.fail("the broker bound asynchronously, but the service is not bindings_retrievable, so the credentials can never be fetched")
+		}
+		r.wait(s, r.bindingPath("/last_operation"), m, false)
+		return
+	}
+	r.checkBinding(s, m)
+}
+
+func (r *conformance) checkBinding(s *TestStep, m map[string]interface{}) {
+	s.field(m, "credentials", "object", false)
+	s.field(m, "syslog_drain_url", "string", false)
+	s.field(m, "route_service_url", "string", false)
+	s.field(m, "volume_mounts", "array", false)
+}
+
+func (r *conformance) fetchBinding(s *TestStep) {
+	x := s.send(r.c, "GET", r.bindingPath(""), nil)
+	if x == nil || !s.expect(x, 200) {
+		return
+	}
+	if m := s.object(x); m != nil {
+		r.checkBinding(s, m)
+	}
+}
+
+func (r *conformance) unbind(s *TestStep) {
+	x := s.send(r.c, "DELETE", r.bindingPath(r.query(true)), nil)
+	if x == nil || !s.expect(x, 200, 202) {
+		return
+	}
+	m := s.object(x)
+	if m == nil {
+		return
+	}
+	if x.StatusCode == 202 {
+		r.wait(s, r.bindingPath("/last_operation"), m, true)
+	}
+	if !s.Failed() {
+		r.binding = false
+	}
+}
+
+func (r *conformance) deprovision(s *TestStep) {
+	x := s.send(r.c, "DELETE", r.instancePath(r.query(true)), nil)
+	if x == nil || !s.expect(x, 200, 202) {
+		return
+	}
+	m := s.object(x)
+	if m == nil {
+		return
+	}
+	if x.StatusCode == 202 {
+		r.wait(s, r.instancePath("/last_operation"), m, true)
+	}
+	if !s.Failed() {
+		r.instance = false
+	}
+}
@@ -0,0 +1,99 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func stepResults(t *api.TestCase) map[string]string {
+	m := make(map[string]string)
+	for _, s := range t.Steps {
+		m[s.Name] = s.Result
+	}
+	return m
+}
+
+func TestConform(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	report, err := c.Conform(api.ConformanceSpec{
+		Suite:    "lifecycle",
+		Interval: 20 * time.Millisecond,
+		MaxWait:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to run conformance tests: %s", err)
+	}
+	if report.Result != api.TestPassed || report.Failed != 0 {
+		t.Errorf("expected the mock broker to pass, but %d steps failed", report.Failed)
+	}
+	if report.Catalog.Result != api.TestPassed {
+		t.Errorf("expected the catalog to pass, but it %s: %v", report.Catalog.Result, report.Catalog.Failures)
+	}
+	if len(report.Cases) != 2 || report.Negative != nil {
+		t.Fatalf("expected 2 lifecycle cases (and no negative tests), got %d", len(report.Cases))
+	}
+
+	small, large := stepResults(report.Cases[0]), stepResults(report.Cases[1])
+	if small["provision (sync)"] != api.TestPassed || small["provision (async)"] != api.TestSkipped {
+		t.Errorf("expected db/small to provision synchronously, got %v", small)
+	}
+	if large["provision (async)"] != api.TestPassed || large["update"] != api.TestPassed || large["bind"] != api.TestPassed {
+		t.Errorf("expected db/large to provision asynchronously, and be updated and bound, got %v", large)
+	}
+
+	// nothing is left behind
+	if n := len(b.Mock.State().Instances); n != 0 {
+		t.Errorf("expected the tests to clean up after themselves, but %d instances are left", n)
+	}
+}
+
+func TestConformFailure(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// the broker forgets to say what it bound
+	b.On(osbtest.FetchBinding).Status(200).JSON(map[string]interface{}{"credentials": "sekrit"})
+
+	report, err := c.Conform(api.ConformanceSpec{
+		Suite:    "lifecycle",
+		Plans:    []string{"db/small"},
+		Interval: 20 * time.Millisecond,
+		MaxWait:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to run conformance tests: %s", err)
+	}
+	if report.Result != api.TestFailed || len(report.Cases) != 1 {
+		t.Fatalf("expected db/small to fail, got %s with %d cases", report.Result, len(report.Cases))
+	}
+
+	got := stepResults(report.Cases[0])
+	if got["fetch binding"] != api.TestFailed {
+		t.Errorf("expected fetch binding to fail, got %v", got)
+	}
+	// but cleanup happens regardless
+	if got["unbind"] != api.TestPassed || got["deprovision"] != api.TestPassed {
+		t.Errorf("expected the binding and instance to be cleaned up, got %v", got)
+	}
+	if n := len(b.Mock.State().Instances); n != 0 {
+		t.Errorf("expected the tests to clean up after themselves, but %d instances are left", n)
+	}
+}
+
+func TestConformSpec(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	if _, err := c.Conform(api.ConformanceSpec{Suite: "everything"}); err == nil {
+		t.Errorf("an unknown suite should fail")
+	}
+	if _, err := c.Conform(api.ConformanceSpec{Plans: []string{"db/huge"}}); err == nil {
+		t.Errorf("an unknown plan should fail")
+	}
+	b.Expect(osbtest.Provision).Never()
+}
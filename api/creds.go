@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Flatten collapses a nested map of credentials, as returned by a bind
+// operation, into a single-level map of strings, joining the keys of
+// nested maps and the indices of lists together with `sep`.  Scalar
+// values are stringified; numbers and booleans as they would appear in
+// JSON, and nulls as the empty string.
+func Flatten(creds map[string]interface{}, sep string) map[string]string {
+	flat := make(map[string]string)
+	for k, v := range creds {
+		flatten(flat, k, v, sep)
+	}
+	return flat
+}
+
+func flatten(flat map[string]string, prefix string, v interface{}, sep string) {
+	switch v.(type) {
+	case map[string]interface{}:
+		for k, sub := range v.(map[string]interface{}) {
+			flatten(flat, prefix+sep+k, sub, sep)
+		}
+
+	case map[interface{}]interface{}:
+		for k, sub := range v.(map[interface{}]interface{}) {
+			flatten(flat, fmt.Sprintf("%s%s%v", prefix, sep, k), sub, sep)
+		}
+
+	case []interface{}:
+		for i, sub := range v.([]interface{}) {
+			flatten(flat, fmt.Sprintf("%s%s%d", prefix, sep, i), sub, sep)
+		}
+
+	case string:
+		flat[prefix] = v.(string)
+
+	case nil:
+		flat[prefix] = ""
+
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			flat[prefix] = fmt.Sprintf("%v", v)
+		} else {
+			flat[prefix] = string(b)
+		}
+	}
+}
+
+var notEnvSafe = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// EnvName turns a flattened credential key into something that can
+// be used as the name of an environment variable, by upper-casing it
+// and replacing everything that isn't a letter, digit or underscore.
+func EnvName(key string) string {
+	name := notEnvSafe.ReplaceAllString(strings.ToUpper(key), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
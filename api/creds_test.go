@@ -0,0 +1,61 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+func TestFlatten(t *testing.T) {
+	flat := api.Flatten(map[string]interface{}{
+		"uri":  "postgres://db.example.com",
+		"port": 5432.0,
+		"tls":  true,
+		"note": nil,
+		"db": map[string]interface{}{
+			"host": "db.example.com",
+			"replicas": []interface{}{
+				"r1.example.com",
+				map[interface{}]interface{}{"host": "r2.example.com"},
+			},
+		},
+	}, "_")
+
+	expect := map[string]string{
+		"uri":                "postgres://db.example.com",
+		"port":               "5432",
+		"tls":                "true",
+		"note":               "",
+		"db_host":            "db.example.com",
+		"db_replicas_0":      "r1.example.com",
+		"db_replicas_1_host": "r2.example.com",
+	}
+	for k, v := range expect {
+		if flat[k] != v {
+			t.Errorf("expected %s to flatten to '%s', got '%s'", k, v, flat[k])
+		}
+	}
+	if len(flat) != len(expect) {
+		t.Errorf("expected %d flattened keys, got %d: %v", len(expect), len(flat), flat)
+	}
+
+	if flat := api.Flatten(map[string]interface{}{"db": map[string]interface{}{"host": "h"}}, "."); flat["db.host"] != "h" {
+		t.Errorf("expected flattening with '.' to give db.host, got %v", flat)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"uri":             "URI",
+		"db_host":         "DB_HOST",
+		"db.host":         "DB_HOST",
+		"my-service/port": "MY_SERVICE_PORT",
+		"0_replica":       "_0_REPLICA",
+		"":                "",
+	}
+	for key, expect := range tests {
+		if got := api.EnvName(key); got != expect {
+			t.Errorf("EnvName(%s): expected %s, got %s", key, expect, got)
+		}
+	}
+}
@@ -2,12 +2,14 @@ package api
 
 import (
 	"fmt"
+	"net/http"
+	"time"
 )
 
 type DeprovisionSpec struct {
 	InstanceID string
-	ServiceID string
-	PlanID string
+	ServiceID  string
+	PlanID     string
 }
 
 type DeprovisionStatus struct {
@@ -16,7 +18,7 @@ type DeprovisionStatus struct {
 	Operation string `json:"operation"`
 }
 
-func (c *Client) Deprovision(spec DeprovisionSpec) (*DeprovisionStatus, error) {
+func (c *Client) Deprovision(spec DeprovisionSpec) (out *DeprovisionStatus, err error) {
 	if spec.InstanceID == "" {
 		return nil, fmt.Errorf("instance ID is required for deprovisioning")
 	}
@@ -28,7 +30,22 @@ func (c *Client) Deprovision(spec DeprovisionSpec) (*DeprovisionStatus, error) {
 		spec.PlanID = "oops-unknown-plan-id"
 	}
 
-	res, err := c.del("/v2/service_instances/" + spec.InstanceID+"?service_id="+spec.ServiceID+"&plan_id="+spec.PlanID)
+	var res *http.Response
+	defer func(start time.Time) {
+		e := JournalEntry{
+			Verb:       "deprovision",
+			InstanceID: spec.InstanceID,
+			ServiceID:  spec.ServiceID,
+			PlanID:     spec.PlanID,
+		}
+		if out != nil {
+			e.Status = out.Status
+			e.Operation = out.Operation
+		}
+		c.record(e, start, res, out, err)
+	}(time.Now())
+
+	res, err = c.del(c.async("/v2/service_instances/" + spec.InstanceID + "?service_id=" + spec.ServiceID + "&plan_id=" + spec.PlanID))
 	if err != nil {
 		return nil, err
 	}
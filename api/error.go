@@ -7,22 +7,59 @@ import (
 
 type Error struct {
 	HTTP        string
+	StatusCode  int    `json:"-"`
+	ErrorCode   string `json:"error"`
 	Description string `json:"description"`
 }
 
 func (e Error) Error() string {
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("%s: %s (HTTP %s)", e.ErrorCode, e.Description, e.HTTP)
+	}
 	return fmt.Sprintf("%s (HTTP %s)", e.Description, e.HTTP)
 }
 
 func (c *Client) err(res *http.Response) error {
 	var e Error
 	if err := c.parse(res, &e); err != nil {
-		return err
+		// not every broker sends a JSON body with its errors
+		e = Error{}
 	}
 	e.HTTP = res.Status
+	e.StatusCode = res.StatusCode
 	if e.Description == "" {
 		e.Description = "an unknown error has occurred"
 	}
 
 	return e
 }
+
+// IsGone returns true if the given error is an API error that
+// indicates the instance or binding no longer exists, either
+// via a 404 Not Found or a 410 Gone.
+func IsGone(err error) bool {
+	if e, ok := err.(Error); ok {
+		return e.StatusCode == 404 || e.StatusCode == 410
+	}
+	return false
+}
+
+// IsConcurrencyError returns true if the broker refused a request
+// because another operation on the same instance is in progress.
+// The request can be retried, once that other operation finishes.
+func IsConcurrencyError(err error) bool {
+	if e, ok := err.(Error); ok {
+		return e.StatusCode == 422 && e.ErrorCode == "ConcurrencyError"
+	}
+	return false
+}
+
+// IsAsyncRequired returns true if the broker refused a request
+// because it can only be satisfied asynchronously, and the client
+// did not send `accepts_incomplete=true`.
+func IsAsyncRequired(err error) bool {
+	if e, ok := err.(Error); ok {
+		return e.StatusCode == 422 && e.ErrorCode == "AsyncRequired"
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+)
+
+type Instance struct {
+	InstanceID string `json:"-"`
+
+	ServiceID    string                 `json:"service_id"`
+	PlanID       string                 `json:"plan_id"`
+	DashboardURL string                 `json:"dashboard_url"`
+	Parameters   map[string]interface{} `json:"parameters"`
+
+	MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+}
+
+type Binding struct {
+	InstanceID string `json:"-"`
+	BindingID  string `json:"-"`
+
+	Credentials     map[string]interface{} `json:"credentials"`
+	SyslogDrainURL  string                 `json:"syslog_drain_url"`
+	RouteServiceURL string                 `json:"route_service_url"`
+	VolumeMounts    []VolumeMount          `json:"volume_mounts"`
+	Parameters      map[string]interface{} `json:"parameters"`
+}
+
+func (c *Client) GetInstance(id string) (*Instance, error) {
+	if id == "" {
+		return nil, fmt.Errorf("instance ID is required for fetching")
+	}
+
+	res, err := c.get("/v2/service_instances/" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != 200 {
+		return nil, c.err(res)
+	}
+
+	var inst Instance
+	inst.InstanceID = id
+	return &inst, c.parse(res, &inst)
+}
+
+func (c *Client) GetBinding(id, bid string) (*Binding, error) {
+	if id == "" {
+		return nil, fmt.Errorf("instance ID is required for fetching")
+	}
+	if bid == "" {
+		return nil, fmt.Errorf("binding ID is required for fetching")
+	}
+
+	res, err := c.get("/v2/service_instances/" + id + "/service_bindings/" + bid)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != 200 {
+		return nil, c.err(res)
+	}
+
+	var b Binding
+	b.InstanceID = id
+	b.BindingID = bid
+	return &b, c.parse(res, &b)
+}
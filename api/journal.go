@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+const RequestIdentityHeader = "X-Broker-API-Request-Identity"
+
+type JournalEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Target    string    `json:"target"`
+	Verb      string    `json:"verb"`
+	User      string    `json:"user,omitempty"`
+
+	InstanceID string `json:"instance_id"`
+	BindingID  string `json:"binding_id,omitempty"`
+	ServiceID  string `json:"service_id,omitempty"`
+	PlanID     string `json:"plan_id,omitempty"`
+
+	StatusCode      int    `json:"status_code,omitempty"`
+	Status          string `json:"status"`
+	Operation       string `json:"operation,omitempty"`
+	RequestIdentity string `json:"request_identity,omitempty"`
+	Duration        int64  `json:"duration_ms"`
+	Error           string `json:"error,omitempty"`
+
+	Request  interface{} `json:"request,omitempty"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+// A Journal is an append-only log of every lifecycle operation
+// (provision, bind, etc.) that a Client performs, stored as one
+// JSON object per line.  Credentials are never written to it.
+type Journal struct {
+	Path string
+	User string
+
+	lock sync.Mutex
+}
+
+func JournalPath(store string) string {
+	if store == "" {
+		store = DefaultStorePath
+	}
+	return store + ".journal"
+}
+
+func (j *Journal) Append(e JournalEntry) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if e.User == "" {
+		e.User = j.User
+	}
+	if e.User == "" {
+		e.User = os.Getenv("USER")
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	ee := make([]JournalEntry, 0)
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		ee = append(ee, e)
+	}
+	return ee, s.Err()
+}
+
+// record appends an entry to the client's journal, if it has one.
+// Failing to journal an operation is not allowed to fail the
+// operation itself, so errors are only ever warned about.
+func (c *Client) record(e JournalEntry, start time.Time, res *http.Response, out interface{}, err error) {
+	if c.Journal == nil {
+		return
+	}
+
+	e.Timestamp = start.UTC()
+	e.Target = strings.TrimSuffix(c.URL, "/")
+	e.Duration = int64(time.Since(start) / time.Millisecond)
+	if res != nil {
+		e.StatusCode = res.StatusCode
+		if res.Request != nil {
+			e.RequestIdentity = res.Request.Header.Get(RequestIdentityHeader)
+		}
+	}
+	e.Request = redact(e.Request)
+	if err != nil {
+		e.Status = "failed"
+		e.Error = err.Error()
+	} else {
+		e.Response = redact(out)
+	}
+
+	if err := c.Journal.Append(e); err != nil {
+		fmt.Fprintf(os.Stderr, "@Y{unable to journal %s operation:} @R{%s}\n", e.Verb, err)
+	}
+}
+
+var sensitive = regexp.MustCompile(`(?i)pass|secret|token|key|cred`)
+
+// redact round-trips a request or response through JSON, and then
+// replaces every credential, and anything else that looks like it
+// might be secret, with the string "REDACTED".
+func redact(x interface{}) interface{} {
+	if x == nil {
+		return nil
+	}
+	b, err := json.Marshal(x)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	return redactValue(v, false)
+}
+
+func redactValue(v interface{}, all bool) interface{} {
+	switch v.(type) {
+	case map[string]interface{}:
+		m := v.(map[string]interface{})
+		for k, sub := range m {
+			m[k] = redactValue(sub, all || k == "credentials" || sensitive.MatchString(k))
+		}
+		return m
+
+	case []interface{}:
+		l := v.([]interface{})
+		for i := range l {
+			l[i] = redactValue(l[i], all)
+		}
+		return l
+
+	case nil:
+		return nil
+	}
+
+	if all {
+		return "REDACTED"
+	}
+	return v
+}
@@ -0,0 +1,92 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestJournal(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	dir, err := ioutil.TempDir("", "osb-journal")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal")
+	c.Journal = &api.Journal{Path: path, User: "tester"}
+
+	_, err = c.Provision("i-1", api.ProvisionSpec{
+		ServiceID: "db",
+		PlanID:    "db-small",
+		Parameters: map[string]interface{}{
+			"size":           "10G",
+			"admin_password": "hunter2",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	bound, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"})
+	if err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	b.On(osbtest.Deprovision).Status(500).Error("", "out of disk").Once()
+	if _, err := c.Deprovision(api.DeprovisionSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-small"}); err == nil {
+		t.Fatalf("deprovision should have failed")
+	}
+
+	ee, err := api.ReadJournal(path)
+	if err != nil {
+		t.Fatalf("unable to read journal: %s", err)
+	}
+	if len(ee) != 3 {
+		t.Fatalf("expected 3 journal entries, got %d", len(ee))
+	}
+
+	for i, verb := range []string{"provision", "bind", "deprovision"} {
+		e := ee[i]
+		if e.Verb != verb || e.InstanceID != "i-1" || e.User != "tester" || e.Target != c.URL {
+			t.Errorf("entry #%d should be a %s of i-1 on %s by tester, got %+v", i+1, verb, c.URL, e)
+		}
+		if e.RequestIdentity == "" {
+			t.Errorf("entry #%d should have the request identity it was sent with", i+1)
+		}
+	}
+	if ee[1].BindingID != "b-1" {
+		t.Errorf("the bind entry should be for b-1, not %s", ee[1].BindingID)
+	}
+	if ee[2].Status != "failed" || ee[2].StatusCode != 500 || !strings.Contains(ee[2].Error, "out of disk") {
+		t.Errorf("the deprovision entry should have failed with a 500 (out of disk), got %+v", ee[2])
+	}
+
+	params := ee[0].Request.(map[string]interface{})["parameters"].(map[string]interface{})
+	if params["size"] != "10G" || params["admin_password"] != "REDACTED" {
+		t.Errorf("parameters should be journaled with secrets redacted, got %v", params)
+	}
+
+	// credentials never make it to disk
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read journal: %s", err)
+	}
+	for _, secret := range []string{"hunter2", bound.Credentials["password"].(string), bound.Credentials["uri"].(string)} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("the journal should not contain the secret '%s'", secret)
+		}
+	}
+}
+
+func TestReadJournalMissing(t *testing.T) {
+	ee, err := api.ReadJournal("/nonexistent/journal")
+	if err != nil || len(ee) != 0 {
+		t.Errorf("a missing journal should be empty, got %d entries (%v)", len(ee), err)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPath looks up a value in a decoded JSON document, by a (small)
+// subset of JSONPath: a leading `$`, `.key` and `['key']` for object
+// keys, and `[n]` for array elements, as in:
+//
+//	$.credentials.hosts[0]
+//	$['credentials']['port']
+//
+// The leading `$` is optional.
+func JSONPath(doc interface{}, path string) (interface{}, error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	v := doc
+	for p != "" {
+		var key string
+		index := -1
+
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+			n := strings.IndexAny(p, ".[")
+			if n < 0 {
+				n = len(p)
+			}
+			key, p = p[:n], p[n:]
+			if key == "" {
+				return nil, fmt.Errorf("%s: empty key", path)
+			}
+
+		case strings.HasPrefix(p, "['"):
+			n := strings.Index(p, "']")
+			if n < 0 {
+				return nil, fmt.Errorf("%s: unterminated ['...']", path)
+			}
+			key, p = p[2:n], p[n+2:]
+
+		case p[0] == '[':
+			n := strings.Index(p, "]")
+			if n < 0 {
+				return nil, fmt.Errorf("%s: unterminated [...]", path)
+			}
+			i, err := strconv.Atoi(p[1:n])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("%s: bad array index [%s]", path, p[1:n])
+			}
+			index, p = i, p[n+1:]
+
+		default:
+			return nil, fmt.Errorf("%s: expected . or [ at '%s'", path, p)
+		}
+
+		if index >= 0 {
+			l, ok := v.([]interface{})
+			if !ok || index >= len(l) {
+				return nil, fmt.Errorf("%s: no such element [%d]", path, index)
+			}
+			v = l[index]
+			continue
+		}
+
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: no such key '%s'", path, key)
+		}
+		if v, ok = m[key]; !ok {
+			return nil, fmt.Errorf("%s: no such key '%s'", path, key)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,63 @@
+package api_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+func TestJSONPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{
+	  "credentials": {
+	    "port": 5432,
+	    "hosts": ["a.db", "b.db"],
+	    "odd.key": {"x": true}
+	  },
+	  "tags": [{"name": "sql"}]
+	}`), &doc)
+
+	tests := []struct {
+		path string
+		want interface{}
+	}{
+		{"$", doc},
+		{"", doc},
+		{"$.credentials.port", float64(5432)},
+		{".credentials.port", float64(5432)},
+		{"$['credentials']['port']", float64(5432)},
+		{"$.credentials.hosts[1]", "b.db"},
+		{"$.credentials['odd.key'].x", true},
+		{"$.tags[0].name", "sql"},
+		{" $.tags ", []interface{}{map[string]interface{}{"name": "sql"}}},
+	}
+	for _, test := range tests {
+		got, err := api.JSONPath(doc, test.path)
+		if err != nil {
+			t.Errorf("JSONPath(%q) failed: %s", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("JSONPath(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+
+	for _, path := range []string{
+		"$.nope",
+		"$.credentials.hosts[2]",
+		"$.credentials.hosts[x]",
+		"$.credentials.port.more",
+		"$.credentials[0]",
+		"$.tags.name",
+		"$..port",
+		"$['credentials'",
+		"$[0",
+		"credentials",
+	} {
+		if got, err := api.JSONPath(doc, path); err == nil {
+			t.Errorf("JSONPath(%q) should have failed, but got %v", path, got)
+		}
+	}
+}
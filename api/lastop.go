@@ -0,0 +1,94 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	InProgress = "in progress"
+	Succeeded  = "succeeded"
+	Failed     = "failed"
+	Gone       = "gone"
+)
+
+type LastOperationSpec struct {
+	InstanceID string
+	BindingID  string
+	ServiceID  string
+	PlanID     string
+	Operation  string
+}
+
+type LastOperation struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+}
+
+func (c *Client) LastOperation(spec LastOperationSpec) (*LastOperation, error) {
+	if spec.InstanceID == "" {
+		return nil, fmt.Errorf("instance ID is required for polling the last operation")
+	}
+
+	path := "/v2/service_instances/" + spec.InstanceID
+	if spec.BindingID != "" {
+		path += "/service_bindings/" + spec.BindingID
+	}
+	path += "/last_operation"
+
+	q := url.Values{}
+	if spec.ServiceID != "" {
+		q.Set("service_id", spec.ServiceID)
+	}
+	if spec.PlanID != "" {
+		q.Set("plan_id", spec.PlanID)
+	}
+	if spec.Operation != "" {
+		q.Set("operation", spec.Operation)
+	}
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	res, err := c.get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var op LastOperation
+	switch res.StatusCode {
+	case 200:
+		return &op, c.parse(res, &op)
+
+	case 410:
+		res.Body.Close()
+		op.State = Gone
+		return &op, nil
+	}
+
+	return nil, c.err(res)
+}
+
+// WaitFor polls the last_operation endpoint until the operation is
+// no longer in progress, or until the timeout (if non-zero) elapses.
+func (c *Client) WaitFor(spec LastOperationSpec, interval, timeout time.Duration) (*LastOperation, error) {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		op, err := c.LastOperation(spec)
+		if err != nil {
+			return nil, err
+		}
+		if op.State != InProgress {
+			return op, nil
+		}
+		if timeout > 0 && time.Now().After(deadline) {
+			return op, fmt.Errorf("timed out waiting for the last operation on %s to finish", spec.InstanceID)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The negative tests send requests that a broker has to reject, or
+// at least has to handle carefully, and check that it does.  Most of
+// them don't need anything to exist on the broker; those that do
+// provision their own instances, and clean up after themselves.
+
+type negative struct {
+	c    *Client
+	spec ConformanceSpec
+	t    *TestCase
+}
+
+func (c *Client) negative(cat *Catalog, selected [][2]int, spec ConformanceSpec) *TestCase {
+	start := time.Now()
+	svc := cat.Services[selected[0][0]]
+	plan := svc.Plans[selected[0][1]]
+
+	t := &TestCase{
+		Service:   svc.Name,
+		Plan:      plan.Name,
+		ServiceID: svc.ID,
+		PlanID:    plan.ID,
+		Result:    TestPassed,
+		Steps:     make([]*TestStep, 0),
+	}
+	n := &negative{c: c, spec: spec, t: t}
+
+	n.step("missing API version", func(s *TestStep) {
+		x := s.observe(c.rawExchange("GET", "/v2/catalog", nil, func(req *http.Request) {
+			req.Header.Del("X-Broker-API-Version")
+		}))
+		if x != nil {
+			s.expect(x, 412)
+		}
+	})
+	n.step("wrong API version", func(s *TestStep) {
+		x := s.observe(c.rawExchange("GET", "/v2/catalog", nil, func(req *http.Request) {
+			req.Header.Set("X-Broker-API-Version", "1.0")
+		}))
+		if x != nil {
+			s.expect(x, 412)
+		}
+	})
+	n.step("bad credentials", func(s *TestStep) {
+		x := s.observe(c.rawExchange("GET", "/v2/catalog", nil, func(req *http.Request) {
+			req.SetBasicAuth(c.Username, c.Password+"-but-wrong")
+		}))
+		if x != nil {
+			s.expect(x, 401)
+		}
+	})
+	n.step("missing credentials", func(s *TestStep) {
+		x := s.observe(c.rawExchange("GET", "/v2/catalog", nil, func(req *http.Request) {
+			req.Header.Del("Authorization")
+		}))
+		if x != nil {
+			s.expect(x, 401)
+		}
+	})
+
+	n.step("unknown service_id", func(s *TestStep) {
+		n.rejectProvision(s, RandomID(), plan.ID)
+	})
+	n.step("unknown plan_id", func(s *TestStep) {
+		n.rejectProvision(s, svc.ID, RandomID())
+	})
+	n.step("malformed JSON", func(s *TestStep) {
+		id := RandomID()
+		body := `{"service_id":"` + svc.ID + `","plan_id":"` + plan.ID + `","organization_guid":`
+		x := s.observe(c.rawExchange("PUT", "/v2/service_instances/"+id+"?accepts_incomplete=true", []byte(body), func(req *http.Request) {
+			req.Header.Set("Content-Type", "application/json")
+		}))
+		if x == nil {
+			return
+		}
+		if x.StatusCode/100 == 2 {
+			n.cleanupAfter(s, x, id, svc.ID, plan.ID)
+		}
+		s.expect(x, 400)
+	})
+
+	n.step("duplicate provision", func(s *TestStep) {
+		id := RandomID()
+		if !n.provision(s, id, svc.ID, plan.ID) {
+			return
+		}
+		defer n.cleanup(s, id, svc.ID, plan.ID)
+
+		// same ID, different organization and space (and a different
+		// plan, if there is one), which is never the same instance.
+		other := plan.ID
+		for _, p := range svc.Plans {
+			if p.ID != plan.ID {
+				other = p.ID
+				break
+			}
+		}
+		x := s.observe(c.exchange("PUT", "/v2/service_instances/"+id+"?accepts_incomplete=true", provisionBody(svc.ID, other, spec.Parameters)))
+		if x != nil && s.expect(x, 409) {
+			return
+		}
+		if x != nil && x.StatusCode == 202 {
+			n.wait(id, svc.ID, other, x)
+		}
+	})
+
+	n.step("deprovision unknown ID", func(s *TestStep) {
+		x := s.observe(c.exchange("DELETE", "/v2/service_instances/"+RandomID()+query(svc.ID, plan.ID, true), nil))
+		if x != nil {
+			s.expect(x, 410)
+		}
+	})
+
+	n.step("bind non-bindable plan", func(s *TestStep) {
+		serviceID, planID := "", ""
+		for _, sp := range selected {
+			svc := cat.Services[sp[0]]
+			plan := svc.Plans[sp[1]]
+			bindable := svc.Bindable
+			if plan.MaybeBindable != nil {
+				bindable = *plan.MaybeBindable
+			}
+			if !bindable {
+				serviceID, planID = svc.ID, plan.ID
+				break
+			}
+		}
+		if planID == "" {
+			s.skip("there are no non-bindable plans to test with")
+			return
+		}
+
+		id, bid := RandomID(), RandomID()
+		if !n.provision(s, id, serviceID, planID) {
+			return
+		}
+		defer n.cleanup(s, id, serviceID, planID)
+
+		path := "/v2/service_instances/" + id + "/service_bindings/" + bid
+		x := s.observe(c.exchange("PUT", path+"?accepts_incomplete=true", map[string]interface{}{
+			"service_id": serviceID,
+			"plan_id":    planID,
+		}))
+		if x == nil {
+			return
+		}
+		if x.StatusCode/100 == 2 {
+			s.fail("the broker bound a service instance of a non-bindable plan")
+			s.send(c, "DELETE", path+query(serviceID, planID, true), nil)
+			return
+		}
+		if x.StatusCode/100 != 4 {
+			s.fail("expected an HTTP 4xx error from %s %s, but got HTTP %d", x.Method, x.Path, x.StatusCode)
+		}
+	})
+
+	n.step("async required", func(s *TestStep) {
+		// only plans that can't be provisioned synchronously have to
+		// refuse, so we keep trying until one of them does.
+		for _, sp := range selected {
+			svc := cat.Services[sp[0]]
+			plan := svc.Plans[sp[1]]
+
+			id := RandomID()
+			x := s.observe(c.exchange("PUT", "/v2/service_instances/"+id, provisionBody(svc.ID, plan.ID, spec.Parameters)))
+			if x == nil {
+				return
+			}
+
+			switch x.StatusCode {
+			case 200, 201:
+				n.cleanup(s, id, svc.ID, plan.ID)
+				continue
+
+			case 202:
+				s.fail("the broker went asynchronous, even though `accepts_incomplete` was not set")
+				n.cleanupAfter(s, x, id, svc.ID, plan.ID)
+
+			case 422:
+				if m := s.object(x); m != nil && m["error"] != "AsyncRequired" {
+					s.fail("a 422 response to a synchronous provision should have the error code `AsyncRequired`, not %v", m["error"])
+				}
+
+			default:
+				s.expect(x, 422)
+			}
+			return
+		}
+		s.skip("all of the plans can be provisioned synchronously")
+	})
+
+	t.Took = int64(time.Since(start) / time.Millisecond)
+	return t
+}
+
+// Negative tests are independent of one another, so they all run,
+// no matter what fails.
+func (n *negative) step(name string, fn func(*TestStep)) {
+	s := newStep(name)
+	fn(s)
+	n.t.Steps = append(n.t.Steps, s.done())
+	if s.Failed() {
+		n.t.Result = TestFailed
+	}
+}
+
+// observe records the response that the test is actually about.
+func (s *TestStep) observe(x *Exchange, err error) *Exchange {
+	if err != nil {
+		s.fail("request failed: %s", err)
+		return nil
+	}
+	s.Exchanges = append(s.Exchanges, x)
+	s.Observed = x
+	return x
+}
+
+// rejectProvision tries to provision something that doesn't exist
+// in the catalog, which the broker has to refuse.
+func (n *negative) rejectProvision(s *TestStep, service, plan string) {
+	id := RandomID()
+	x := s.observe(n.c.exchange("PUT", "/v2/service_instances/"+id+"?accepts_incomplete=true", provisionBody(service, plan, n.spec.Parameters)))
+	if x == nil {
+		return
+	}
+	if x.StatusCode/100 == 2 {
+		n.cleanupAfter(s, x, id, service, plan)
+	}
+	s.expect(x, 400)
+}
+
+// provision sets up an instance for a test to use, waiting for it
+// to be ready.  If that fails, so does the test.
+func (n *negative) provision(s *TestStep, id, service, plan string) bool {
+	x := s.send(n.c, "PUT", "/v2/service_instances/"+id+"?accepts_incomplete=true", provisionBody(service, plan, n.spec.Parameters))
+	if x == nil {
+		return false
+	}
+
+	switch x.StatusCode {
+	case 200, 201:
+		return true
+
+	case 202:
+		if last, err := n.wait(id, service, plan, x); err != nil || last.State != Succeeded {
+			s.fail("unable to provision a service instance to test with")
+			n.cleanup(s, id, service, plan)
+			return false
+		}
+		return true
+	}
+
+	s.fail("unable to provision a service instance to test with (HTTP %d)", x.StatusCode)
+	return false
+}
+
+// cleanup deprovisions an instance that a test made (on purpose, or
+// not), and waits for it to go away.  Problems cleaning up are only
+// warnings; they don't change the outcome of the test.
+func (n *negative) cleanup(s *TestStep, id, service, plan string) {
+	x, err := n.c.exchange("DELETE", "/v2/service_instances/"+id+query(service, plan, true), nil)
+	if err != nil {
+		s.warn("unable to clean up service instance %s: %s", id, err)
+		return
+	}
+	s.Exchanges = append(s.Exchanges, x)
+
+	switch x.StatusCode {
+	case 200, 410:
+	case 202:
+		last, err := n.wait(id, service, plan, x)
+		if err != nil {
+			s.warn("unable to clean up service instance %s: %s", id, err)
+		} else if last.State != Succeeded && last.State != Gone {
+			s.warn("unable to clean up service instance %s: deprovision %s", id, last.State)
+		}
+	default:
+		s.warn("unable to clean up service instance %s: HTTP %d %s", id, x.StatusCode, strings.TrimSpace(x.Response))
+	}
+}
+
+// cleanupAfter cleans up an instance that the broker should never
+// have made, once it has finished making it.
+func (n *negative) cleanupAfter(s *TestStep, x *Exchange, id, service, plan string) {
+	if x.StatusCode == 202 {
+		n.wait(id, service, plan, x)
+	}
+	n.cleanup(s, id, service, plan)
+}
+
+// wait waits for the asynchronous operation that the broker accepted
+// (in x) to finish.
+func (n *negative) wait(id, service, plan string, x *Exchange) (*LastOperation, error) {
+	op, _ := x.Object()["operation"].(string)
+	return n.c.WaitFor(LastOperationSpec{
+		InstanceID: id,
+		ServiceID:  service,
+		PlanID:     plan,
+		Operation:  op,
+	}, n.spec.Interval, n.spec.MaxWait)
+}
+
+func query(service, plan string, async bool) string {
+	q := url.Values{}
+	q.Set("service_id", service)
+	q.Set("plan_id", plan)
+	if async {
+		q.Set("accepts_incomplete", "true")
+	}
+	return "?" + q.Encode()
+}
+
+func provisionBody(service, plan string, params map[string]interface{}) map[string]interface{} {
+	org, space := RandomID(), RandomID()
+	return map[string]interface{}{
+		"service_id":        service,
+		"plan_id":           plan,
+		"organization_guid": org,
+		"space_guid":        space,
+		"context": map[string]interface{}{
+			"platform":          "cloudfoundry",
+			"organization_guid": org,
+			"space_guid":        space,
+		},
+		"parameters": params,
+	}
+}
@@ -0,0 +1,85 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestNegative(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("db").
+		Plan("small").
+		Plan("large").Async(100 * time.Millisecond).
+		Service("static").NotBindable().
+		Plan("only")
+	c := b.Client()
+
+	report, err := c.Conform(api.ConformanceSpec{
+		Suite:    "negative",
+		Interval: 20 * time.Millisecond,
+		MaxWait:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to run negative tests: %s", err)
+	}
+	if len(report.Cases) != 0 || report.Negative == nil {
+		t.Fatalf("expected only the negative tests to run")
+	}
+	got := stepResults(report.Negative)
+	for _, name := range []string{
+		"missing API version", "wrong API version", "bad credentials", "missing credentials",
+		"unknown service_id", "unknown plan_id", "malformed JSON", "duplicate provision",
+		"deprovision unknown ID", "bind non-bindable plan", "async required",
+	} {
+		if got[name] != api.TestPassed {
+			t.Errorf("expected the mock broker to pass '%s', but it %s", name, got[name])
+		}
+	}
+	if n := len(b.Mock.State().Instances); n != 0 {
+		t.Errorf("expected the tests to clean up after themselves, but %d instances are left", n)
+	}
+}
+
+func TestNegativeFailures(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// a broker that provisions anything it's asked to
+	b.On(osbtest.Provision).Status(201).JSON(map[string]interface{}{})
+
+	report, err := c.Conform(api.ConformanceSpec{
+		Suite:    "negative",
+		Plans:    []string{"db/small"},
+		Interval: 20 * time.Millisecond,
+		MaxWait:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to run negative tests: %s", err)
+	}
+	if report.Result != api.TestFailed {
+		t.Errorf("expected the negative tests to fail")
+	}
+	got := stepResults(report.Negative)
+	for name, expect := range map[string]string{
+		"unknown service_id":     api.TestFailed,
+		"unknown plan_id":        api.TestFailed,
+		"malformed JSON":         api.TestFailed,
+		"duplicate provision":    api.TestFailed,
+		"bad credentials":        api.TestPassed,
+		"bind non-bindable plan": api.TestSkipped,
+		"async required":         api.TestSkipped,
+	} {
+		if got[name] != expect {
+			t.Errorf("expected '%s' to be %s, but it was %s", name, expect, got[name])
+		}
+	}
+	for _, s := range report.Negative.Steps {
+		if s.Result == api.TestFailed && s.Observed == nil {
+			t.Errorf("failed step '%s' should show the exchange it was about", s.Name)
+		}
+	}
+}
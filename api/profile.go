@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/pborman/uuid"
+)
+
+// profileFields lists the context fields (besides `platform`) that we
+// know how to fill in, for each of the platform profiles.
+var profileFields = map[string][]string{
+	"cloudfoundry": {"organization_guid", "space_guid", "instance_name", "binding_name"},
+	"kubernetes":   {"namespace", "clusterid", "instance_name"},
+}
+
+// profileDefaults are used for the context fields that the OSB spec
+// requires of each platform, when we aren't given them.  They are the
+// same every time, so that everything we create looks like it lives in
+// the same org and space (or namespace, of the same cluster).
+var profileDefaults = map[string]string{
+	"organization_guid": platformID("organization"),
+	"space_guid":        platformID("space"),
+	"namespace":         "default",
+	"clusterid":         platformID("cluster"),
+}
+
+func platformID(what string) string {
+	return uuid.NewSHA1(uuid.NameSpace_URL, []byte("https://github.com/jhunt/osb#"+what)).String()
+}
+
+var profileAliases = map[string]string{
+	"cf":  "cloudfoundry",
+	"k8s": "kubernetes",
+}
+
+// ProfileContext builds the `context` object to send along with a
+// request, for the given platform profile.  Only the fields that the
+// profile supports are kept; the rest are quietly dropped.  Required
+// fields that aren't given (like a Cloud Foundry `space_guid`) are
+// filled in from profileDefaults.  With no profile, there is no
+// context at all.
+func ProfileContext(profile string, fields map[string]interface{}) (map[string]interface{}, error) {
+	if profile == "" {
+		return nil, nil
+	}
+	if p, ok := profileAliases[profile]; ok {
+		profile = p
+	}
+
+	supported, ok := profileFields[profile]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized platform profile '%s' (try cloudfoundry, or kubernetes)", profile)
+	}
+
+	ctx := map[string]interface{}{"platform": profile}
+	for _, k := range supported {
+		if v, ok := fields[k]; ok && v != "" {
+			ctx[k] = v
+		} else if v, ok := profileDefaults[k]; ok {
+			ctx[k] = v
+		}
+	}
+	return ctx, nil
+}
@@ -1,5 +1,10 @@
 package api
 
+import (
+	"net/http"
+	"time"
+)
+
 type ProvisionSpec struct {
 	ServiceID string `json:"service_id"`
 	PlanID    string `json:"plan_id"`
@@ -9,6 +14,8 @@ type ProvisionSpec struct {
 	SpaceGUID        string                 `json:"space_guid"`
 
 	Parameters map[string]interface{} `json:"parameters"`
+
+	MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
 }
 
 type ProvisionStatus struct {
@@ -19,11 +26,28 @@ type ProvisionStatus struct {
 	Operation    string `json:"operation"`
 }
 
-func (c *Client) Provision(id string, spec ProvisionSpec) (*ProvisionStatus, error) {
+func (c *Client) Provision(id string, spec ProvisionSpec) (out *ProvisionStatus, err error) {
 	if id == "" {
-		id = randomID()
+		id = RandomID()
 	}
-	res, err := c.put("/v2/service_instances/"+id, spec)
+
+	var res *http.Response
+	defer func(start time.Time) {
+		e := JournalEntry{
+			Verb:       "provision",
+			InstanceID: id,
+			ServiceID:  spec.ServiceID,
+			PlanID:     spec.PlanID,
+			Request:    spec,
+		}
+		if out != nil {
+			e.Status = out.Status
+			e.Operation = out.Operation
+		}
+		c.record(e, start, res, out, err)
+	}(time.Now())
+
+	res, err = c.put(c.async("/v2/service_instances/"+id), spec)
 	if err != nil {
 		return nil, err
 	}
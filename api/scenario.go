@@ -0,0 +1,790 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A Scenario is a script of requests to send to a broker, in order,
+// with assertions about what the broker says back.  Values can be
+// captured from responses, into variables, for use in later steps:
+//
+//	name: bind and check the port
+//	steps:
+//	  - provision: { service: db, plan: small }
+//	  - wait: {}
+//	  - bind: {}
+//	    expect:
+//	      status: 201
+//	      json: { $.credentials.port: 5432 }
+//	    capture:
+//	      username: $.credentials.username
+//	  - request:
+//	      method: GET
+//	      path:   /v2/service_instances/${instance}
+//	    expect: { within: 2s }
+//	  - deprovision: {}
+//	    always: true
+//
+// Once a step fails, the rest are skipped, except those marked as
+// `always`, which are for cleaning up.
+type Scenario struct {
+	Name  string            `yaml:"name"`
+	Vars  map[string]string `yaml:"vars"`
+	Steps []*ScenarioStep   `yaml:"steps"`
+}
+
+// A ScenarioStep does one of the things in ScenarioActions.
+type ScenarioStep struct {
+	Name   string `yaml:"name"`
+	Always bool   `yaml:"always"`
+
+	Catalog     *ScenarioCall    `yaml:"catalog"`
+	Provision   *ScenarioCall    `yaml:"provision"`
+	Update      *ScenarioCall    `yaml:"update"`
+	Deprovision *ScenarioCall    `yaml:"deprovision"`
+	Bind        *ScenarioCall    `yaml:"bind"`
+	Unbind      *ScenarioCall    `yaml:"unbind"`
+	Wait        *ScenarioCall    `yaml:"wait"`
+	Request     *ScenarioRequest `yaml:"request"`
+
+	Expect  ScenarioExpect    `yaml:"expect"`
+	Capture map[string]string `yaml:"capture"`
+
+	Action string `yaml:"-"`
+}
+
+var ScenarioActions = []string{"catalog", "provision", "update", "deprovision", "bind", "unbind", "wait", "request"}
+
+// A ScenarioCall is an OSB lifecycle request.  Anything left out is
+// filled in from earlier steps: the instance (and binding) that was
+// provisioned (or bound) last, the service and plan it was made
+// with, and the asynchronous operation that was started last.
+// Services and plans can be given by name, or ID.
+type ScenarioCall struct {
+	Service    string                 `yaml:"service"`
+	Plan       string                 `yaml:"plan"`
+	Instance   string                 `yaml:"instance"`
+	Binding    string                 `yaml:"binding"`
+	Parameters map[string]interface{} `yaml:"parameters"`
+	Context    map[string]interface{} `yaml:"context"`
+
+	// Don't send `accepts_incomplete=true`.
+	Sync bool `yaml:"sync"`
+
+	// For `wait` only.
+	Operation string `yaml:"operation"`
+	MaxWait   string `yaml:"max_wait"`
+}
+
+// A ScenarioRequest is an arbitrary HTTP request.  The body is sent
+// as-is if it is a string, and as JSON otherwise.  Headers are set
+// after the usual ones, so they can be overridden.
+type ScenarioRequest struct {
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Headers map[string]string `yaml:"headers"`
+	Body    interface{}       `yaml:"body"`
+}
+
+// A ScenarioExpect is what a step expects of the broker.  Without
+// a Status, any 2xx response will do (as will a 410 Gone, for an
+// unbind or deprovision).  OSBStatus is what osb says
+// happened, i.e. `provisioned`, `already existed`, `binding`, or (for
+// `wait`) the state the operation ended up in.  Error is the OSB
+// error code, like `ConcurrencyError`.  JSON maps JSONPaths into the
+// response to the values they should have.  Within is the longest
+// the step may take, as a duration, like `500ms` or `2s`.
+type ScenarioExpect struct {
+	Status    intList                `yaml:"status"`
+	OSBStatus stringList             `yaml:"osb_status"`
+	Error     string                 `yaml:"error"`
+	JSON      map[string]interface{} `yaml:"json"`
+	Within    string                 `yaml:"within"`
+}
+
+type intList []int
+
+func (l *intList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var one int
+	if err := unmarshal(&one); err == nil {
+		*l = intList{one}
+		return nil
+	}
+	return unmarshal((*[]int)(l))
+}
+
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var one string
+	if err := unmarshal(&one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+	return unmarshal((*[]string)(l))
+}
+
+func (s *ScenarioStep) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ScenarioStep
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	var keys map[string]interface{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	n := 0
+	for _, a := range ScenarioActions {
+		if _, ok := keys[a]; ok {
+			s.Action = a
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("each step needs exactly one of %s", strings.Join(ScenarioActions, ", "))
+	}
+
+	// `catalog:` and `wait:` on their own are fine, but come through
+	// as nil, since there's nothing in them.
+	if s.call() == nil {
+		if s.Action == "request" {
+			s.Request = &ScenarioRequest{}
+		} else {
+			*s.slot() = &ScenarioCall{}
+		}
+	}
+	return nil
+}
+
+func (s *ScenarioStep) slot() **ScenarioCall {
+	switch s.Action {
+	case "catalog":
+		return &s.Catalog
+	case "provision":
+		return &s.Provision
+	case "update":
+		return &s.Update
+	case "deprovision":
+		return &s.Deprovision
+	case "bind":
+		return &s.Bind
+	case "unbind":
+		return &s.Unbind
+	case "wait":
+		return &s.Wait
+	}
+	return nil
+}
+
+func (s *ScenarioStep) call() interface{} {
+	if s.Action == "request" {
+		if s.Request == nil {
+			return nil
+		}
+		return s.Request
+	}
+	if c := *s.slot(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func ReadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	if err := yaml.UnmarshalStrict(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(s.Steps) == 0 {
+		return nil, fmt.Errorf("%s: there are no steps in the scenario", path)
+	}
+	if s.Name == "" {
+		s.Name = path
+	}
+	for i, step := range s.Steps {
+		if step.Expect.Within != "" {
+			if _, err := time.ParseDuration(step.Expect.Within); err != nil {
+				return nil, fmt.Errorf("%s: steps[%d]: bad `within` duration '%s'", path, i, step.Expect.Within)
+			}
+		}
+		if step.Wait != nil && step.Wait.MaxWait != "" {
+			if _, err := time.ParseDuration(step.Wait.MaxWait); err != nil {
+				return nil, fmt.Errorf("%s: steps[%d]: bad `max_wait` duration '%s'", path, i, step.Wait.MaxWait)
+			}
+		}
+	}
+	return &s, nil
+}
+
+type ScenarioReport struct {
+	Name    string            `json:"name"`
+	Broker  string            `json:"broker"`
+	Result  string            `json:"result"`
+	Steps   []*TestStep       `json:"steps"`
+	Vars    map[string]string `json:"vars"`
+	Passed  int               `json:"passed"`
+	Failed  int               `json:"failed"`
+	Skipped int               `json:"skipped"`
+	Took    int64             `json:"took_ms"`
+}
+
+// ScenarioSpec says how to run a scenario.  Vars are set before the
+// scenario starts, overriding the `vars` of the scenario itself.
+// Asynchronous operations are polled every Interval, for up to
+// MaxWait, unless the `wait` step says otherwise.
+type ScenarioSpec struct {
+	Vars     map[string]string
+	Interval time.Duration
+	MaxWait  time.Duration
+}
+
+type scenario struct {
+	c    *Client
+	spec ScenarioSpec
+	vars map[string]string
+
+	catalog *Catalog
+	plans   map[string][2]string // service and plan IDs, by instance
+
+	// the last asynchronous operation the broker started
+	pending struct {
+		verb      string
+		instance  string
+		binding   string
+		operation string
+	}
+}
+
+// RunScenario runs every step of the scenario, in order, and reports
+// on how each went.
+func (c *Client) RunScenario(sc *Scenario, spec ScenarioSpec) *ScenarioReport {
+	start := time.Now()
+	if spec.Interval == 0 {
+		spec.Interval = 2 * time.Second
+	}
+
+	r := &scenario{
+		c:     c,
+		spec:  spec,
+		vars:  make(map[string]string),
+		plans: make(map[string][2]string),
+	}
+	for k, v := range sc.Vars {
+		r.vars[k] = v
+	}
+	for k, v := range spec.Vars {
+		r.vars[k] = v
+	}
+
+	report := &ScenarioReport{
+		Name:   sc.Name,
+		Broker: c.URL,
+		Result: TestPassed,
+		Steps:  make([]*TestStep, 0),
+	}
+
+	failed := false
+	for i, step := range sc.Steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("%d. %s", i+1, step.Action)
+		}
+		s := newStep(name)
+
+		if failed && !step.Always {
+			s.skip("an earlier step failed")
+		} else {
+			r.run(s, step)
+		}
+		report.Steps = append(report.Steps, s.done())
+
+		switch s.Result {
+		case TestPassed:
+			report.Passed++
+		case TestFailed:
+			report.Failed++
+			report.Result = TestFailed
+			failed = true
+		case TestSkipped:
+			report.Skipped++
+		}
+	}
+
+	report.Vars = r.vars
+	report.Took = int64(time.Since(start) / time.Millisecond)
+	return report
+}
+
+var scenarioVar = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// expand replaces ${name} with the value of the variable, in the
+// given string, or in every string inside the given value.
+func (r *scenario) expand(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case string:
+		var missing []string
+		s := scenarioVar.ReplaceAllStringFunc(v.(string), func(m string) string {
+			name := strings.TrimSpace(m[2 : len(m)-1])
+			value, ok := r.vars[name]
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("variable '%s' is not set", missing[0])
+		}
+		return s, nil
+
+	case map[interface{}]interface{}, map[string]interface{}:
+		m := make(map[string]interface{})
+		for k, sub := range JSONable(v).(map[string]interface{}) {
+			x, err := r.expand(sub)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = x
+		}
+		return m, nil
+
+	case []interface{}:
+		l := make([]interface{}, len(v.([]interface{})))
+		for i, sub := range v.([]interface{}) {
+			x, err := r.expand(sub)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = x
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+func (r *scenario) str(s string) (string, error) {
+	v, err := r.expand(s)
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+func (r *scenario) object(m map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	v, err := r.expand(m)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+// osbStatus is what osb says happened, for each verb, by HTTP status
+// (see Provision, Bind, et al.)
+var osbStatus = map[string]map[int]string{
+	"provision":   {200: "already existed", 201: "provisioned", 202: "provisioning"},
+	"update":      {200: "updated", 202: "updating"},
+	"deprovision": {200: "deprovisioned", 202: "deprovisioning", 410: "deprovisioned"},
+	"bind":        {200: "already bound", 201: "bound", 202: "binding"},
+	"unbind":      {200: "already unbound", 201: "unbound", 202: "unbinding", 410: "already unbound"},
+}
+
+func (r *scenario) run(s *TestStep, step *ScenarioStep) {
+	var x *Exchange
+	var status string
+	var err error
+
+	switch step.Action {
+	case "catalog":
+		x = s.send(r.c, "GET", "/v2/catalog", nil)
+		if x != nil && x.StatusCode == 200 {
+			var cat Catalog
+			if json.Unmarshal([]byte(x.Response), &cat) == nil {
+				r.catalog = &cat
+			}
+		}
+	case "provision", "update", "deprovision", "bind", "unbind":
+		x, err = r.lifecycle(s, step.Action, *step.slot())
+	case "wait":
+		x, status, err = r.wait(s, step.Wait)
+	case "request":
+		x, err = r.request(s, step.Request)
+	}
+	if err != nil {
+		s.fail("%s", err)
+		return
+	}
+	if x == nil {
+		return
+	}
+	s.Observed = x
+	if status == "" {
+		if l, ok := osbStatus[step.Action]; ok {
+			if status, ok = l[x.StatusCode]; !ok {
+				status = "failed"
+			}
+		}
+	}
+
+	r.check(s, step, x, status)
+	if s.Failed() {
+		return
+	}
+	for name, path := range step.Capture {
+		v, err := JSONPath(x.body, path)
+		if err != nil {
+			s.fail("unable to capture `%s`: %s", name, err)
+			continue
+		}
+		if str, ok := v.(string); ok {
+			r.vars[name] = str
+		} else {
+			b, _ := json.Marshal(v)
+			r.vars[name] = string(b)
+		}
+	}
+}
+
+// gone returns true if a response says that what was to be deleted is
+// already gone, which is as good as deleting it.
+func gone(action string, status int) bool {
+	return status == 410 && (action == "deprovision" || action == "unbind")
+}
+
+func (r *scenario) check(s *TestStep, step *ScenarioStep, x *Exchange, status string) {
+	e := step.Expect
+
+	if len(e.Status) > 0 {
+		s.expect(x, e.Status...)
+	} else if step.Action != "wait" && x.StatusCode/100 != 2 && !gone(step.Action, x.StatusCode) {
+		s.fail("expected a 2xx response from %s %s, but got HTTP %d", x.Method, x.Path, x.StatusCode)
+	}
+
+	want := e.OSBStatus
+	if len(want) == 0 && step.Action == "wait" {
+		want = stringList{Succeeded}
+		if r.pending.verb == "deprovision" || r.pending.verb == "unbind" {
+			want = append(want, Gone)
+		}
+	}
+	if len(want) > 0 {
+		ok := false
+		for _, w := range want {
+			ok = ok || w == status
+		}
+		if !ok {
+			s.fail("expected osb status %s, but got '%s'", strings.Join(want, " or "), status)
+		}
+	}
+
+	if e.Error != "" {
+		if got, _ := x.Object()["error"].(string); got != e.Error {
+			s.fail("expected error `%s`, but got `%s`", e.Error, got)
+		}
+	}
+
+	paths := make([]string, 0, len(e.JSON))
+	for path := range e.JSON {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		want, err := r.expand(JSONable(e.JSON[path]))
+		if err != nil {
+			s.fail("%s", err)
+			continue
+		}
+		// compare as JSON, so that 5432 and 5432.0 are the same.
+		b, _ := json.Marshal(want)
+		json.Unmarshal(b, &want)
+
+		got, err := JSONPath(x.body, path)
+		if err != nil {
+			s.fail("%s", err)
+		} else if !reflect.DeepEqual(got, want) {
+			g, _ := json.Marshal(got)
+			s.fail("expected %s to be %s, but it is %s", path, b, g)
+		}
+	}
+
+	if e.Within != "" {
+		d, _ := time.ParseDuration(e.Within)
+		if took := time.Since(s.start); took > d {
+			s.fail("expected the step to take no more than %s, but it took %s", d, took.Round(time.Millisecond))
+		}
+	}
+}
+
+// resolve finds the service and plan IDs for a lifecycle request,
+// from the step itself (by name or ID), or from how the instance was
+// provisioned.
+func (r *scenario) resolve(instance, service, plan string) (string, string, error) {
+	known := r.plans[instance]
+	if service == "" {
+		service = known[0]
+	}
+	if plan == "" {
+		plan = known[1]
+	}
+	if service == "" || plan == "" {
+		return "", "", fmt.Errorf("the service and plan of instance '%s' are not known; give them in the step", instance)
+	}
+	if service == known[0] && plan == known[1] {
+		return service, plan, nil
+	}
+
+	if r.catalog == nil {
+		cat, err := r.c.GetCatalog()
+		if err != nil {
+			return "", "", err
+		}
+		r.catalog = cat
+	}
+	return r.catalog.FindPlan(service, plan)
+}
+
+func (r *scenario) lifecycle(s *TestStep, verb string, call *ScenarioCall) (*Exchange, error) {
+	var err error
+	in := *call
+	for _, f := range []*string{&in.Service, &in.Plan, &in.Instance, &in.Binding} {
+		if *f, err = r.str(*f); err != nil {
+			return nil, err
+		}
+	}
+	if in.Parameters, err = r.object(in.Parameters); err != nil {
+		return nil, err
+	}
+	if in.Context, err = r.object(in.Context); err != nil {
+		return nil, err
+	}
+
+	if in.Instance == "" {
+		if verb == "provision" {
+			in.Instance = RandomID()
+		} else if in.Instance = r.vars["instance"]; in.Instance == "" {
+			return nil, fmt.Errorf("no instance to %s; provision one first, or give its ID", verb)
+		}
+	}
+	if in.Binding == "" && (verb == "bind" || verb == "unbind") {
+		if verb == "bind" {
+			in.Binding = RandomID()
+		} else if in.Binding = r.vars["binding"]; in.Binding == "" {
+			return nil, fmt.Errorf("no binding to unbind; bind one first, or give its ID")
+		}
+	}
+
+	service, plan := in.Service, in.Plan
+	if verb == "update" && plan == "" {
+		plan = r.plans[in.Instance][1]
+	}
+	service, plan, err = r.resolve(in.Instance, service, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/v2/service_instances/" + in.Instance
+	if in.Binding != "" {
+		path += "/service_bindings/" + in.Binding
+	}
+
+	var x *Exchange
+	switch verb {
+	case "provision":
+		body := provisionBody(service, plan, in.Parameters)
+		if in.Context != nil {
+			body["context"] = in.Context
+		}
+		x = s.send(r.c, "PUT", path+async(!in.Sync), body)
+
+	case "update":
+		body := map[string]interface{}{
+			"service_id": service,
+			"plan_id":    plan,
+			"parameters": in.Parameters,
+		}
+		if in.Context != nil {
+			body["context"] = in.Context
+		}
+		x = s.send(r.c, "PATCH", path+async(!in.Sync), body)
+
+	case "bind":
+		body := map[string]interface{}{
+			"service_id": service,
+			"plan_id":    plan,
+			"parameters": in.Parameters,
+		}
+		if in.Context != nil {
+			body["context"] = in.Context
+		}
+		x = s.send(r.c, "PUT", path+async(!in.Sync), body)
+
+	case "deprovision", "unbind":
+		x = s.send(r.c, "DELETE", path+query(service, plan, !in.Sync), nil)
+	}
+	if x == nil {
+		return nil, nil
+	}
+
+	if x.StatusCode/100 == 2 {
+		switch verb {
+		case "provision", "update":
+			r.plans[in.Instance] = [2]string{service, plan}
+		}
+		r.vars["instance"] = in.Instance
+		if in.Binding != "" {
+			r.vars["binding"] = in.Binding
+		}
+	}
+	if x.StatusCode == 202 {
+		r.pending.verb = verb
+		r.pending.instance = in.Instance
+		r.pending.binding = in.Binding
+		r.pending.operation, _ = x.Object()["operation"].(string)
+		r.vars["operation"] = r.pending.operation
+	}
+	return x, nil
+}
+
+func async(yes bool) string {
+	if yes {
+		return "?accepts_incomplete=true"
+	}
+	return ""
+}
+
+// wait polls the last operation until it is finished, returning the
+// last poll, and the state the operation ended up in.
+func (r *scenario) wait(s *TestStep, call *ScenarioCall) (*Exchange, string, error) {
+	var err error
+	in := *call
+	for _, f := range []*string{&in.Service, &in.Plan, &in.Instance, &in.Binding, &in.Operation} {
+		if *f, err = r.str(*f); err != nil {
+			return nil, "", err
+		}
+	}
+
+	pending := in.Instance == "" && in.Binding == ""
+	if in.Instance == "" {
+		in.Instance = r.pending.instance
+	}
+	if in.Instance == "" {
+		return nil, "", fmt.Errorf("there is nothing to wait for")
+	}
+	if pending {
+		in.Binding = r.pending.binding
+		if in.Operation == "" {
+			in.Operation = r.pending.operation
+		}
+	}
+
+	service, plan, err := r.resolve(in.Instance, in.Service, in.Plan)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path := "/v2/service_instances/" + in.Instance
+	if in.Binding != "" {
+		path += "/service_bindings/" + in.Binding
+	}
+	path += "/last_operation" + query(service, plan, false)
+	if in.Operation != "" {
+		path += "&operation=" + url.QueryEscape(in.Operation)
+	}
+
+	wait := r.spec.MaxWait
+	if in.MaxWait != "" {
+		wait, _ = time.ParseDuration(in.MaxWait)
+	}
+	deadline := time.Now().Add(wait)
+
+	polls := 0
+	for {
+		x, err := r.c.exchange("GET", path, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		// keep the first and last polls, not everything in between.
+		if polls++; polls > 2 {
+			s.Exchanges = s.Exchanges[:len(s.Exchanges)-1]
+		}
+		s.Exchanges = append(s.Exchanges, x)
+
+		state, _ := x.Object()["state"].(string)
+		switch {
+		case x.StatusCode == 410:
+			return x, Gone, nil
+		case x.StatusCode != 200:
+			return x, "failed", nil
+		case state != InProgress:
+			return x, state, nil
+		case wait > 0 && time.Now().After(deadline):
+			return x, state, fmt.Errorf("timed out after %s, waiting for the operation to finish", wait)
+		}
+		time.Sleep(r.spec.Interval)
+	}
+}
+
+func (r *scenario) request(s *TestStep, in *ScenarioRequest) (*Exchange, error) {
+	method := strings.ToUpper(in.Method)
+	if method == "" {
+		method = "GET"
+	}
+	path, err := r.str(in.Path)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("request steps need a path")
+	}
+
+	var body []byte
+	if in.Body != nil {
+		v, err := r.expand(JSONable(in.Body))
+		if err != nil {
+			return nil, err
+		}
+		if str, ok := v.(string); ok {
+			body = []byte(str)
+		} else if body, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make(map[string]string)
+	for k, v := range in.Headers {
+		if headers[k], err = r.str(v); err != nil {
+			return nil, err
+		}
+	}
+
+	x, err := r.c.rawExchange(method, path, body, func(req *http.Request) {
+		if body != nil && req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Exchanges = append(s.Exchanges, x)
+	return x, nil
+}
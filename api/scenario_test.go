@@ -0,0 +1,159 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func runScenario(t *testing.T, c *api.Client, yaml string, vars map[string]string) *api.ScenarioReport {
+	dir, err := ioutil.TempDir("", "osb-scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scenario.yml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := api.ReadScenario(path)
+	if err != nil {
+		t.Fatalf("unable to read the scenario: %s", err)
+	}
+	return c.RunScenario(sc, api.ScenarioSpec{
+		Vars:     vars,
+		Interval: 20 * time.Millisecond,
+		MaxWait:  5 * time.Second,
+	})
+}
+
+func results(r *api.ScenarioReport) string {
+	l := make([]string, len(r.Steps))
+	for i, s := range r.Steps {
+		l[i] = s.Name + ": " + s.Result
+		for _, f := range s.Failures {
+			l[i] += " (" + f + ")"
+		}
+	}
+	return strings.Join(l, "\n  ")
+}
+
+func TestScenario(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	r := runScenario(t, c, `---
+name: lifecycle
+vars:
+  plan: small
+steps:
+  - catalog: {}
+  - provision: { service: db, plan: "${plan}" }
+    expect: { osb_status: provisioning }
+  - wait: {}
+  - bind: {}
+    expect: { status: 202, osb_status: binding }
+  - wait: {}
+  - name: fetch the binding
+    request:
+      method: GET
+      path:   /v2/service_instances/${instance}/service_bindings/${binding}
+    expect:
+      status: 200
+      json:
+        $.credentials.port: 5432
+        $.credentials.hostname: db.mock
+      within: 2s
+    capture:
+      username: $.credentials.username
+  - name: fetch it again
+    request:
+      method: GET
+      path:   /v2/service_instances/${instance}/service_bindings/${binding}
+    expect:
+      json: { $.credentials.username: "${username}" }
+  - unbind: {}
+  - deprovision: {}
+  - wait: {}
+  - deprovision: {}
+    always: true
+`, map[string]string{"plan": "large"})
+
+	if r.Result != api.TestPassed {
+		t.Errorf("the scenario should have passed:\n  %s", results(r))
+	}
+	if r.Passed != 11 || r.Failed != 0 || r.Skipped != 0 {
+		t.Errorf("expected 11 passed steps, but got %d passed, %d failed, %d skipped", r.Passed, r.Failed, r.Skipped)
+	}
+	if !strings.HasPrefix(r.Vars["username"], "u") {
+		t.Errorf("the username should have been captured from the bind, but got '%s'", r.Vars["username"])
+	}
+
+	b.Expect(osbtest.Provision).Field("plan_id", "db-large").Times(1)
+	b.Expect(osbtest.FetchBinding).Times(2)
+	b.Expect(osbtest.Deprovision).Times(2)
+}
+
+func TestScenarioFailures(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Bind).Status(500).Error("", "out of users").Once()
+
+	r := runScenario(t, c, `---
+steps:
+  - provision: { service: db, plan: small }
+    expect:
+      json: { $.dashboard_url: "http://nope" }
+  - bind: {}
+  - unbind: {}
+  - deprovision: {}
+    always: true
+`, nil)
+
+	if r.Result != api.TestFailed {
+		t.Errorf("the scenario should have failed")
+	}
+	want := []string{api.TestFailed, api.TestSkipped, api.TestSkipped, api.TestPassed}
+	for i, s := range r.Steps {
+		if s.Result != want[i] {
+			t.Errorf("step #%d should have %s:\n  %s", i+1, want[i], results(r))
+			break
+		}
+	}
+	b.Expect(osbtest.Bind).Never()
+	b.Expect(osbtest.Unbind).Never()
+	b.Expect(osbtest.Deprovision).Times(1)
+}
+
+func TestScenarioExpectations(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Provision).Status(422).Error("ConcurrencyError", "busy").Once()
+
+	r := runScenario(t, c, `---
+steps:
+  - provision: { service: db, plan: small }
+    expect: { status: 422, error: ConcurrencyError }
+  - provision: { service: db, plan: small }
+    expect: { status: 201, osb_status: provisioned }
+  - update: { plan: large }
+    expect: { status: 202, osb_status: updating }
+  - wait: {}
+    expect: { osb_status: succeeded }
+  - deprovision: { instance: not-there, service: db, plan: small }
+  - unbind: { binding: not-there, service: db, plan: small }
+  - provision: { service: db, plan: small }
+    expect: { status: 200 }
+`, nil)
+
+	if r.Passed != 6 || r.Failed != 1 {
+		t.Errorf("expected 6 steps to pass, and the last one to fail:\n  %s", results(r))
+	}
+}
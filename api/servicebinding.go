@@ -0,0 +1,30 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteServiceBinding projects a binding into a directory, per the
+// servicebinding.io specification: one file per credential (flattened
+// with `sep`), plus the required `type` entry, and an optional
+// `provider` entry.
+func WriteServiceBinding(dir, typ, provider string, creds map[string]interface{}, sep string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	files := Flatten(creds, sep)
+	files["type"] = typ
+	if provider != "" {
+		files["provider"] = provider
+	}
+
+	for name, value := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(name)), []byte(value), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
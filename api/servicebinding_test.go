@@ -0,0 +1,89 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestWriteServiceBinding(t *testing.T) {
+	root, err := ioutil.TempDir("", "osb-bindings")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "my-db")
+	err = api.WriteServiceBinding(dir, "postgresql", "pg", map[string]interface{}{
+		"host": "db.example.com",
+		"port": 5432.0,
+		"tls":  map[string]interface{}{"ca": "-----BEGIN CERTIFICATE-----"},
+	}, "_")
+	if err != nil {
+		t.Fatalf("unable to write service binding: %s", err)
+	}
+
+	expect := map[string]string{
+		"type":     "postgresql",
+		"provider": "pg",
+		"host":     "db.example.com",
+		"port":     "5432",
+		"tls_ca":   "-----BEGIN CERTIFICATE-----",
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unable to read service binding: %s", err)
+	}
+	if len(files) != len(expect) {
+		t.Errorf("expected %d files in the binding, got %d", len(expect), len(files))
+	}
+	for _, f := range files {
+		if f.Mode().Perm() != 0600 {
+			t.Errorf("%s should only be readable by its owner, but is %s", f.Name(), f.Mode())
+		}
+		b, _ := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if string(b) != expect[f.Name()] {
+			t.Errorf("expected %s to contain '%s', got '%s'", f.Name(), expect[f.Name()], string(b))
+		}
+	}
+
+	// no provider, no provider file
+	dir = filepath.Join(root, "other")
+	if err := api.WriteServiceBinding(dir, "other", "", nil, "_"); err != nil {
+		t.Fatalf("unable to write service binding: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "provider")); !os.IsNotExist(err) {
+		t.Errorf("a binding without a provider should not have a provider file")
+	}
+}
+
+func TestBindingType(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("pg").Tags("postgresql", "sql").Plan("small").
+		Service("redis").Tags("redis").Plan("small").
+		Service("thing").Plan("small")
+
+	cat, err := b.Client().GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+
+	tests := []struct {
+		service, typ, provider string
+	}{
+		{"pg", "postgresql", "pg"},
+		{"redis", "redis", ""},
+		{"thing", "thing", ""},
+		{"unknown", "unknown", ""},
+	}
+	for _, test := range tests {
+		if typ, provider := cat.BindingType(test.service); typ != test.typ || provider != test.provider {
+			t.Errorf("expected %s bindings to be of type %s from '%s', got %s from '%s'", test.service, test.typ, test.provider, typ, provider)
+		}
+	}
+}
@@ -5,20 +5,40 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type binding struct {
 	ID          string                 `yaml:"id"`
+	Name        string                 `yaml:"name,omitempty"`
 	Credentials map[string]interface{} `yaml:"credentials"`
+
+	Labels    map[string]string `yaml:"labels,omitempty"`
+	CreatedAt time.Time         `yaml:"created_at,omitempty"`
+	Operation string            `yaml:"operation,omitempty"`
+	Verb      string            `yaml:"verb,omitempty"`
+
+	Parameters map[string]interface{} `yaml:"parameters,omitempty"`
+
+	SyslogDrainURL string        `yaml:"syslog_drain_url,omitempty"`
+	VolumeMounts   []VolumeMount `yaml:"volume_mounts,omitempty"`
 }
 
 type instance struct {
 	ID        string `yaml:"id"`
+	Name      string `yaml:"name,omitempty"`
 	ServiceID string `yaml:"service_id"`
 	PlanID    string `yaml:"plan_id"`
 
+	Labels    map[string]string `yaml:"labels,omitempty"`
+	CreatedAt time.Time         `yaml:"created_at,omitempty"`
+	Operation string            `yaml:"operation,omitempty"`
+	Verb      string            `yaml:"verb,omitempty"`
+
+	Parameters map[string]interface{} `yaml:"parameters,omitempty"`
+
 	Bindings []binding `yaml:"bindings"`
 }
 
@@ -67,12 +87,22 @@ func (s *Store) Write(path string) error {
 	return ioutil.WriteFile(path, b, 0666)
 }
 
+// AddInstance records a service instance.  If the instance is already
+// in the store (i.e. the broker told us it already existed), its
+// service and plan are updated instead.
 func (s *Store) AddInstance(url, id, service, plan string) {
+	if inst := s.findInstance(url, id); inst != nil {
+		inst.ServiceID = service
+		inst.PlanID = plan
+		return
+	}
+
 	url = strings.TrimSuffix(url, "/")
 	inst := instance{
 		ID:        id,
 		ServiceID: service,
 		PlanID:    plan,
+		CreatedAt: time.Now(),
 	}
 
 	for i, broker := range s.Data {
@@ -103,6 +133,12 @@ func (s *Store) RemoveInstance(url, id string) {
 	}
 }
 
+func (s *Store) LabelInstance(url, id string, labels map[string]string) {
+	if inst := s.findInstance(url, id); inst != nil {
+		inst.Labels = mergeLabels(inst.Labels, labels)
+	}
+}
+
 func (s *Store) GetInstanceDetails(url, id string) (string, string, error) {
 	url = strings.TrimSuffix(url, "/")
 
@@ -119,7 +155,15 @@ func (s *Store) GetInstanceDetails(url, id string) (string, string, error) {
 	return "", "", fmt.Errorf("service instance '%s' not found", id)
 }
 
+// AddBinding records a binding (and its credentials) under its
+// instance.  Bindings that are already in the store just have their
+// credentials updated.
 func (s *Store) AddBinding(url, id, bid string, creds map[string]interface{}) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		b.Credentials = creds
+		return
+	}
+
 	url = strings.TrimSuffix(url, "/")
 
 	for i, broker := range s.Data {
@@ -129,6 +173,7 @@ func (s *Store) AddBinding(url, id, bid string, creds map[string]interface{}) {
 					s.Data[i].Instances[j].Bindings = append(instance.Bindings, binding{
 						ID:          bid,
 						Credentials: creds,
+						CreatedAt:   time.Now(),
 					})
 					return
 				}
@@ -137,6 +182,12 @@ func (s *Store) AddBinding(url, id, bid string, creds map[string]interface{}) {
 	}
 }
 
+func (s *Store) LabelBinding(url, id, bid string, labels map[string]string) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		b.Labels = mergeLabels(b.Labels, labels)
+	}
+}
+
 func (s *Store) GetBindingDetails(url, id string) (string, string, string, error) {
 	url = strings.TrimSuffix(url, "/")
 
@@ -155,6 +206,27 @@ func (s *Store) GetBindingDetails(url, id string) (string, string, string, error
 	return "", "", "", fmt.Errorf("service instance binding '%s' not found", id)
 }
 
+// GetBindingCredentials returns the credentials recorded for the given
+// binding.  If url is empty, the binding is looked for under every
+// broker in the store, and the first match wins.
+func (s *Store) GetBindingCredentials(url, bid string) (map[string]interface{}, error) {
+	url = strings.TrimSuffix(url, "/")
+
+	for _, broker := range s.Data {
+		if url == "" || strings.TrimSuffix(broker.Broker, "/") == url {
+			for _, instance := range broker.Instances {
+				for _, binding := range instance.Bindings {
+					if binding.ID == bid {
+						return binding.Credentials, nil
+					}
+				}
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("service instance binding '%s' not found", bid)
+}
+
 func (s *Store) RemoveBinding(url, id, bid string) {
 	url = strings.TrimSuffix(url, "/")
 
@@ -173,3 +245,132 @@ func (s *Store) RemoveBinding(url, id, bid string) {
 		}
 	}
 }
+
+// Forget removes an instance (and all of its bindings), or a single
+// binding from the store, without contacting the broker at all.
+// It returns the kind of record that was forgotten.
+func (s *Store) Forget(url, id string) (string, error) {
+	if _, _, err := s.GetInstanceDetails(url, id); err == nil {
+		s.RemoveInstance(url, id)
+		return "instance", nil
+	}
+	if inst, _, _, err := s.GetBindingDetails(url, id); err == nil {
+		s.RemoveBinding(url, inst, id)
+		return "binding", nil
+	}
+	return "", fmt.Errorf("'%s' is neither a known service instance nor binding", id)
+}
+
+func (s *Store) findInstance(url, id string) *instance {
+	url = strings.TrimSuffix(url, "/")
+
+	for i, broker := range s.Data {
+		if strings.TrimSuffix(broker.Broker, "/") == url {
+			for j, instance := range broker.Instances {
+				if instance.ID == id {
+					return &s.Data[i].Instances[j]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) findBinding(url, id, bid string) *binding {
+	if inst := s.findInstance(url, id); inst != nil {
+		for k, binding := range inst.Bindings {
+			if binding.ID == bid {
+				return &inst.Bindings[k]
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) SetInstancePlan(url, id, plan string) {
+	if inst := s.findInstance(url, id); inst != nil {
+		inst.PlanID = plan
+	}
+}
+
+// SetInstanceParameters records the parameters last sent to the broker
+// for an instance, so that we can tell later if they have changed.
+func (s *Store) SetInstanceParameters(url, id string, params map[string]interface{}) {
+	if inst := s.findInstance(url, id); inst != nil {
+		inst.Parameters = params
+	}
+}
+
+func (s *Store) GetInstanceParameters(url, id string) map[string]interface{} {
+	if inst := s.findInstance(url, id); inst != nil {
+		return inst.Parameters
+	}
+	return nil
+}
+
+func (s *Store) SetBindingParameters(url, id, bid string, params map[string]interface{}) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		b.Parameters = params
+	}
+}
+
+// SetInstanceOperation records the last thing asked of the broker
+// for an instance (i.e. `provision`, `update` or `deprovision`), and
+// the operation it is (asynchronously) carrying out to do it, if any.
+func (s *Store) SetInstanceOperation(url, id, verb, op string) {
+	if inst := s.findInstance(url, id); inst != nil {
+		inst.Verb = verb
+		inst.Operation = op
+	}
+}
+
+// GetInstanceOperation returns the verb and operation last recorded
+// for an instance, by SetInstanceOperation.
+func (s *Store) GetInstanceOperation(url, id string) (string, string) {
+	if inst := s.findInstance(url, id); inst != nil {
+		return inst.Verb, inst.Operation
+	}
+	return "", ""
+}
+
+func (s *Store) SetBindingCredentials(url, id, bid string, creds map[string]interface{}) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		b.Credentials = creds
+	}
+}
+
+func (s *Store) SetBindingExtras(url, id, bid, drain string, mounts []VolumeMount) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		b.SyslogDrainURL = drain
+		b.VolumeMounts = mounts
+	}
+}
+
+// SetBindingOperation is SetInstanceOperation, for bindings.
+func (s *Store) SetBindingOperation(url, id, bid, verb, op string) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		b.Verb = verb
+		b.Operation = op
+	}
+}
+
+// GetBindingOperation is GetInstanceOperation, for bindings.
+func (s *Store) GetBindingOperation(url, id, bid string) (string, string) {
+	if b := s.findBinding(url, id, bid); b != nil {
+		return b.Verb, b.Operation
+	}
+	return "", ""
+}
+
+func mergeLabels(old, new map[string]string) map[string]string {
+	if len(new) == 0 {
+		return old
+	}
+	if old == nil {
+		old = make(map[string]string)
+	}
+	for k, v := range new {
+		old[k] = v
+	}
+	return old
+}
@@ -0,0 +1,474 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The stress workloads all hit one service instance (or one instance
+// ID) with lots of concurrent requests, which is where brokers tend
+// to fall over: two binds both grabbing the same resource, an update
+// pulling the rug out from under a bind, and so on.
+const (
+	// N concurrent binds, against one instance.
+	StressBinds = "binds"
+
+	// N concurrent provisions and deprovisions, of the same ID.
+	StressProvision = "provision"
+
+	// N concurrent updates and binds, against one instance.
+	StressUpdateBind = "update-bind"
+)
+
+var StressWorkloads = []string{StressBinds, StressProvision, StressUpdateBind}
+
+type StressSpec struct {
+	Workload   string
+	ServiceID  string
+	PlanID     string
+	Parallel   int
+	Parameters map[string]interface{}
+	Interval   time.Duration
+	MaxWait    time.Duration
+}
+
+// StressCount tallies up the responses to one sort of request.
+// Requests that the broker accepted (with a 202) count as OK or
+// AsyncFailed, depending on how the operation turned out.
+type StressCount struct {
+	Requests          int `json:"requests"`
+	OK                int `json:"ok"`
+	AsyncFailed       int `json:"async_failed"`
+	ConcurrencyErrors int `json:"concurrency_errors"`
+	ServerErrors      int `json:"server_errors"`
+	ClientErrors      int `json:"client_errors"`
+	NetworkErrors     int `json:"network_errors"`
+}
+
+func (n *StressCount) add(o StressCount) {
+	n.Requests += o.Requests
+	n.OK += o.OK
+	n.AsyncFailed += o.AsyncFailed
+	n.ConcurrencyErrors += o.ConcurrencyErrors
+	n.ServerErrors += o.ServerErrors
+	n.ClientErrors += o.ClientErrors
+	n.NetworkErrors += o.NetworkErrors
+}
+
+// A StressObject is an instance or binding that was (or might have
+// been) created during a stress run, and what became of it.
+//
+// Expected is what the broker's responses said should be true at the
+// end: `present`, `gone`, or `either` (if they don't say).  Found is
+// what was actually there, according to a GET of the object (if the
+// service allows that), and the response to cleaning it up: also
+// `present` or `gone`, or `unknown`.  Objects are Consistent if they
+// are where they should be, and the GET and the cleanup agree.
+type StressObject struct {
+	Kind       string `json:"kind"`
+	ID         string `json:"id"`
+	Expected   string `json:"expected"`
+	Found      string `json:"found"`
+	Consistent bool   `json:"consistent"`
+	Problem    string `json:"problem,omitempty"`
+}
+
+type StressReport struct {
+	Broker     string                  `json:"broker"`
+	Workload   string                  `json:"workload"`
+	ServiceID  string                  `json:"service_id"`
+	PlanID     string                  `json:"plan_id"`
+	InstanceID string                  `json:"instance_id"`
+	Parallel   int                     `json:"parallel"`
+	Verbs      map[string]*StressCount `json:"verbs"`
+	Total      StressCount             `json:"total"`
+	Objects    []*StressObject         `json:"objects"`
+
+	Inconsistent int      `json:"inconsistent"`
+	Warnings     []string `json:"warnings,omitempty"`
+	Took         int64    `json:"took_ms"`
+}
+
+// Failed returns true if the broker misbehaved: it fell over with a
+// 5xx error, or left something in an inconsistent state.
+func (r *StressReport) Failed() bool {
+	return r.Total.ServerErrors > 0 || r.Inconsistent > 0
+}
+
+type stress struct {
+	c    *Client
+	spec StressSpec
+
+	bindable    bool
+	retrievable [2]bool // instances, bindings
+	plans       []string
+
+	lock   sync.Mutex
+	report *StressReport
+	bound  map[string]string // what each bind said should be there
+}
+
+// Stress runs a stress workload against the broker, and then checks
+// (and cleans up) everything it might have left behind.
+func (c *Client) Stress(cat *Catalog, spec StressSpec) (*StressReport, error) {
+	start := time.Now()
+	if spec.Parallel <= 0 {
+		spec.Parallel = 10
+	}
+	if spec.Interval == 0 {
+		spec.Interval = time.Second
+	}
+
+	r := &stress{
+		c:     c,
+		spec:  spec,
+		bound: make(map[string]string),
+		report: &StressReport{
+			Broker:     c.URL,
+			Workload:   spec.Workload,
+			ServiceID:  spec.ServiceID,
+			PlanID:     spec.PlanID,
+			InstanceID: RandomID(),
+			Parallel:   spec.Parallel,
+			Verbs:      make(map[string]*StressCount),
+			Objects:    make([]*StressObject, 0),
+		},
+	}
+	found := false
+	for _, s := range cat.Services {
+		if s.ID != spec.ServiceID {
+			continue
+		}
+		for _, p := range s.Plans {
+			if p.ID == spec.PlanID {
+				found = true
+				r.bindable = s.Bindable
+				if p.MaybeBindable != nil {
+					r.bindable = *p.MaybeBindable
+				}
+			}
+		}
+		r.retrievable = [2]bool{s.InstancesRetrievable, s.BindingsRetrievable}
+
+		// updates flip between this plan and another, if they can.
+		r.plans = []string{spec.PlanID}
+		if s.PlanUpdateable {
+			for _, p := range s.Plans {
+				if p.ID != spec.PlanID {
+					r.plans = append(r.plans, p.ID)
+					break
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no such service / plan: %s / %s", spec.ServiceID, spec.PlanID)
+	}
+
+	switch spec.Workload {
+	case StressBinds:
+		if !r.bindable {
+			return nil, fmt.Errorf("the plan is not bindable")
+		}
+		if err := r.provision(); err != nil {
+			return nil, err
+		}
+		bindings := r.all(func(i int) string {
+			bid := RandomID()
+			r.bind(bid)
+			return bid
+		})
+		r.verifyBindings(bindings)
+		r.verifyInstance("present", r.plans[:1])
+
+	case StressProvision:
+		r.all(func(i int) string {
+			if i%2 == 0 {
+				r.call("provision", "PUT", r.instancePath(), async(true), provisionBody(spec.ServiceID, spec.PlanID, spec.Parameters))
+			} else {
+				r.call("deprovision", "DELETE", r.instancePath(), query(spec.ServiceID, spec.PlanID, true), nil)
+			}
+			return ""
+		})
+		r.verifyInstance("either", nil)
+
+	case StressUpdateBind:
+		if err := r.provision(); err != nil {
+			return nil, err
+		}
+		updated := make([]string, 0)
+		bindings := r.all(func(i int) string {
+			if i%2 == 1 && r.bindable {
+				bid := RandomID()
+				r.bind(bid)
+				return bid
+			}
+			plan := r.plans[(i/2)%len(r.plans)]
+			if r.call("update", "PATCH", r.instancePath(), async(true), map[string]interface{}{
+				"service_id": spec.ServiceID,
+				"plan_id":    plan,
+				"parameters": map[string]interface{}{"osb-stress": i},
+			}) == "present" {
+				r.lock.Lock()
+				updated = append(updated, plan)
+				r.lock.Unlock()
+			}
+			return ""
+		})
+		r.verifyBindings(bindings)
+		if len(updated) == 0 {
+			updated = r.plans[:1]
+		}
+		r.verifyInstance("present", updated)
+
+	default:
+		return nil, fmt.Errorf("unrecognized workload '%s'", spec.Workload)
+	}
+
+	for _, o := range r.report.Objects {
+		if !o.Consistent {
+			r.report.Inconsistent++
+		}
+	}
+	for _, n := range r.report.Verbs {
+		r.report.Total.add(*n)
+	}
+	r.report.Took = int64(time.Since(start) / time.Millisecond)
+	return r.report, nil
+}
+
+// all runs fn in Parallel goroutines, released all at once, and
+// returns whatever (non-empty) IDs they return.
+func (r *stress) all(fn func(int) string) []string {
+	var wg sync.WaitGroup
+	ready := make(chan bool)
+	ids := make([]string, r.spec.Parallel)
+
+	for i := 0; i < r.spec.Parallel; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-ready
+			ids[i] = fn(i)
+		}(i)
+	}
+	close(ready)
+	wg.Wait()
+
+	l := make([]string, 0)
+	for _, id := range ids {
+		if id != "" {
+			l = append(l, id)
+		}
+	}
+	return l
+}
+
+func (r *stress) instancePath() string {
+	return "/v2/service_instances/" + r.report.InstanceID
+}
+
+func (r *stress) bindingPath(bid string) string {
+	return "/v2/service_instances/" + r.report.InstanceID + "/service_bindings/" + bid
+}
+
+func (r *stress) warn(f string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.Warnings = append(r.report.Warnings, fmt.Sprintf(f, args...))
+}
+
+// call makes a request, waits for it to finish if it's accepted, and
+// counts up the outcome.  It returns what the broker said should be
+// true now: the thing it was asked to make (or change) is `present`,
+// or `gone`, or `either`, if we can't tell.
+func (r *stress) call(verb, method, path, q string, in interface{}) string {
+	var n StressCount
+	outcome := "gone"
+	n.Requests++
+
+	x, err := r.c.exchange(method, path+q, in)
+	switch {
+	case err != nil:
+		n.NetworkErrors++
+		outcome = "either"
+	case x.StatusCode == 202:
+		state := r.wait(verb, path, x)
+		switch {
+		case state == Succeeded, state == Gone && verb == "deprovision":
+			n.OK++
+			outcome = "present"
+		case state == InProgress:
+			n.AsyncFailed++
+			outcome = "either"
+		default:
+			n.AsyncFailed++
+		}
+	case x.StatusCode/100 == 2, verb == "deprovision" && x.StatusCode == 410:
+		n.OK++
+		outcome = "present"
+	case x.StatusCode == 422 && x.Object()["error"] == "ConcurrencyError":
+		n.ConcurrencyErrors++
+	case x.StatusCode/100 == 5:
+		n.ServerErrors++
+	default:
+		n.ClientErrors++
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.report.Verbs[verb] == nil {
+		r.report.Verbs[verb] = &StressCount{}
+	}
+	r.report.Verbs[verb].add(n)
+	return outcome
+}
+
+// wait polls the last operation of the instance (or binding) at
+// path, until it finishes.
+func (r *stress) wait(verb, path string, x *Exchange) string {
+	path += "/last_operation" + query(r.spec.ServiceID, r.spec.PlanID, false)
+	if op, _ := x.Object()["operation"].(string); op != "" {
+		path += "&operation=" + url.QueryEscape(op)
+	}
+
+	deadline := time.Now().Add(r.spec.MaxWait)
+	for {
+		time.Sleep(r.spec.Interval)
+		x, err := r.c.exchange("GET", path, nil)
+		if err != nil {
+			r.warn("unable to poll the last operation of %s: %s", verb, err)
+			return Failed
+		}
+
+		state, _ := x.Object()["state"].(string)
+		switch {
+		case x.StatusCode == 410:
+			return Gone
+		case x.StatusCode != 200:
+			return Failed
+		case state != InProgress:
+			return state
+		case r.spec.MaxWait > 0 && time.Now().After(deadline):
+			r.warn("gave up waiting for %s to finish, after %s", verb, r.spec.MaxWait)
+			return InProgress
+		}
+	}
+}
+
+// provision sets up the instance that the workload hammers on.
+func (r *stress) provision() error {
+	if r.call("provision", "PUT", r.instancePath(), async(true), provisionBody(r.spec.ServiceID, r.spec.PlanID, r.spec.Parameters)) != "present" {
+		r.verifyInstance("either", nil)
+		return fmt.Errorf("unable to provision a service instance to stress")
+	}
+	return nil
+}
+
+func (r *stress) bind(bid string) {
+	outcome := r.call("bind", "PUT", r.bindingPath(bid), async(true), map[string]interface{}{
+		"service_id": r.spec.ServiceID,
+		"plan_id":    r.spec.PlanID,
+		"parameters": r.spec.Parameters,
+	})
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.bound[bid] = outcome
+}
+
+// verifyBindings checks and cleans up every binding that any of the
+// concurrent binds might have made.  Bindings that the broker said
+// it made should be there; the rest should not.
+func (r *stress) verifyBindings(bindings []string) {
+	for _, bid := range bindings {
+		r.verify("binding", bid, r.bound[bid], r.bindingPath(bid), r.retrievable[1], nil)
+	}
+}
+
+func (r *stress) verifyInstance(expect string, plans []string) {
+	r.verify("instance", r.report.InstanceID, expect, r.instancePath(), r.retrievable[0], plans)
+}
+
+// verify checks where an instance or binding is (or isn't), by GETting
+// it (if the service allows that), and then by deleting it, which
+// also cleans it up.  For instances, the plan is also checked against
+// the plans the broker said the instance was updated to.
+func (r *stress) verify(kind, id, expect, path string, retrievable bool, plans []string) {
+	o := &StressObject{Kind: kind, ID: id, Expected: expect, Found: "unknown"}
+	r.report.Objects = append(r.report.Objects, o)
+
+	got := ""
+	if retrievable {
+		x, err := r.c.exchange("GET", path, nil)
+		switch {
+		case err != nil:
+			r.warn("unable to retrieve %s %s: %s", kind, id, err)
+		case x.StatusCode == 200:
+			got = "present"
+			if plan, _ := x.Object()["plan_id"].(string); plans != nil && plan != "" {
+				ok := false
+				for _, p := range plans {
+					ok = ok || p == plan
+				}
+				if !ok {
+					o.Problem = fmt.Sprintf("%s is on plan %s, which it was never updated to", kind, plan)
+				}
+			}
+		case x.StatusCode == 404 || x.StatusCode == 410:
+			got = "gone"
+		default:
+			r.warn("unable to retrieve %s %s: HTTP %d", kind, id, x.StatusCode)
+		}
+	}
+
+	// clean up, retrying if something is still going on.
+	verb := "unbind"
+	if kind == "instance" {
+		verb = "deprovision"
+	}
+	deleted := ""
+	for attempt := 0; attempt < 10 && deleted == ""; attempt++ {
+		x, err := r.c.exchange("DELETE", path+query(r.spec.ServiceID, r.spec.PlanID, true), nil)
+		switch {
+		case err != nil:
+			r.warn("unable to %s %s %s: %s", verb, kind, id, err)
+			deleted = "unknown"
+		case x.StatusCode == 200:
+			deleted = "present"
+		case x.StatusCode == 202:
+			deleted = "present"
+			if state := r.wait(verb, path, x); state != Succeeded && state != Gone {
+				r.warn("unable to %s %s %s: operation %s", verb, kind, id, state)
+			}
+		case x.StatusCode == 410:
+			deleted = "gone"
+		case x.StatusCode == 422:
+			time.Sleep(r.spec.Interval)
+		default:
+			r.warn("unable to %s %s %s: HTTP %d", verb, kind, id, x.StatusCode)
+			deleted = "unknown"
+		}
+	}
+	if deleted == "" {
+		r.warn("unable to %s %s %s: the broker is still busy with it", verb, kind, id)
+		deleted = "unknown"
+	}
+
+	o.Found = deleted
+	if deleted == "unknown" {
+		o.Found = got
+	}
+	switch {
+	case got != "" && deleted != "unknown" && got != deleted:
+		o.Problem = fmt.Sprintf("GET says the %s is %s, but %s says it is %s", kind, got, verb, deleted)
+	case o.Problem == "" && expect != "either" && o.Found != "" && o.Found != expect:
+		o.Problem = fmt.Sprintf("the %s should be %s, but it is %s", kind, expect, o.Found)
+	}
+	if o.Found == "" {
+		o.Found = "unknown"
+	}
+	o.Consistent = o.Problem == ""
+}
@@ -0,0 +1,95 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func stress(t *testing.T, c *api.Client, workload, plan string) *api.StressReport {
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to fetch the catalog: %s", err)
+	}
+	r, err := c.Stress(cat, api.StressSpec{
+		Workload:  workload,
+		ServiceID: "db",
+		PlanID:    plan,
+		Parallel:  5,
+		Interval:  20 * time.Millisecond,
+		MaxWait:   5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to run the %s stress workload: %s", workload, err)
+	}
+	return r
+}
+
+func TestStress(t *testing.T) {
+	for _, workload := range api.StressWorkloads {
+		for _, plan := range []string{"db-small", "db-large"} {
+			b, c := newBroker(t)
+			r := stress(t, c, workload, plan)
+
+			if r.Failed() {
+				t.Errorf("%s on %s: the mock broker shouldn't fail a stress run (%d server errors, %d inconsistent)",
+					workload, plan, r.Total.ServerErrors, r.Inconsistent)
+				for _, o := range r.Objects {
+					if !o.Consistent {
+						t.Logf("  %s %s: expected %s, found %s: %s", o.Kind, o.ID, o.Expected, o.Found, o.Problem)
+					}
+				}
+			}
+			if r.Total.Requests == 0 {
+				t.Errorf("%s on %s: no requests were counted", workload, plan)
+			}
+			if n := len(b.Mock.State().Instances); n != 0 {
+				t.Errorf("%s on %s: the stress run should have cleaned up after itself, but left %d instance(s)", workload, plan, n)
+			}
+			b.Close()
+		}
+	}
+}
+
+func TestStressServerErrors(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.Bind).Status(500).Error("", "oops").Times(2)
+
+	r := stress(t, c, api.StressBinds, "db-small")
+	if !r.Failed() || r.Total.ServerErrors != 2 {
+		t.Errorf("2 server errors should fail the stress run, but got %d (failed: %v)", r.Total.ServerErrors, r.Failed())
+	}
+	if r.Verbs["bind"] == nil || r.Verbs["bind"].ServerErrors != 2 {
+		t.Errorf("the server errors should have been counted against bind")
+	}
+}
+
+func TestStressInconsistency(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// a bind that says it worked, but didn't
+	b.On(osbtest.Bind).Status(201).JSON(map[string]interface{}{
+		"credentials": map[string]string{"username": "ghost"},
+	}).Once()
+
+	r := stress(t, c, api.StressBinds, "db-small")
+	if !r.Failed() || r.Inconsistent == 0 {
+		t.Errorf("a binding that the broker lost should be inconsistent, but %d objects were", r.Inconsistent)
+	}
+}
+
+func TestStressUnknownWorkload(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to fetch the catalog: %s", err)
+	}
+	if _, err := c.Stress(cat, api.StressSpec{Workload: "nope", ServiceID: "db", PlanID: "db-small"}); err == nil {
+		t.Errorf("an unknown workload should be an error")
+	}
+}
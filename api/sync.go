@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	SyncOK      = "ok"
+	SyncMissing = "missing"
+	SyncFailed  = "failed"
+	SyncDrifted = "drifted"
+	SyncChanged = "changed"
+	SyncPending = "pending"
+	SyncUnknown = "unknown"
+)
+
+type SyncFinding struct {
+	Kind       string `json:"kind"`
+	InstanceID string `json:"instance_id"`
+	BindingID  string `json:"binding_id,omitempty"`
+	ServiceID  string `json:"service_id"`
+	PlanID     string `json:"plan_id"`
+
+	State   string `json:"state"`
+	Detail  string `json:"detail,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Partial bool   `json:"partial"`
+
+	exists      bool
+	settled     bool
+	plan        string
+	credentials map[string]interface{}
+}
+
+type SyncReport struct {
+	Broker   string        `json:"broker"`
+	Partial  bool          `json:"partial"`
+	Findings []SyncFinding `json:"findings"`
+}
+
+// Sync checks every instance and binding recorded in the store
+// for the client's broker against what the broker itself reports,
+// using the GET endpoints for retrievable services, and the
+// last_operation endpoints for everything.  The store is not
+// modified; see SyncReport.Apply for that.
+func (c *Client) Sync(store *Store, cat *Catalog) (*SyncReport, error) {
+	url := strings.TrimSuffix(c.URL, "/")
+	report := &SyncReport{
+		Broker:   url,
+		Findings: make([]SyncFinding, 0),
+	}
+
+	for _, broker := range store.Data {
+		if strings.TrimSuffix(broker.Broker, "/") != url {
+			continue
+		}
+
+		for _, instance := range broker.Instances {
+			getInstances, getBindings := cat.Retrievable(instance.ServiceID)
+
+			f := c.syncInstance(instance, getInstances)
+			report.Findings = append(report.Findings, f)
+			report.Partial = report.Partial || f.Partial
+
+			for _, binding := range instance.Bindings {
+				var b SyncFinding
+				if f.State == SyncMissing {
+					b = SyncFinding{
+						State:   SyncMissing,
+						Detail:  "service instance is gone",
+						Action:  "prune",
+						Partial: f.Partial,
+					}
+				} else {
+					b = c.syncBinding(instance, binding, getBindings)
+				}
+				b.Kind = "binding"
+				b.InstanceID = instance.ID
+				b.BindingID = binding.ID
+				b.ServiceID = instance.ServiceID
+				b.PlanID = instance.PlanID
+
+				report.Findings = append(report.Findings, b)
+				report.Partial = report.Partial || b.Partial
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (c *Client) syncInstance(instance instance, retrievable bool) SyncFinding {
+	f := SyncFinding{
+		Kind:       "instance",
+		InstanceID: instance.ID,
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+		State:      SyncOK,
+		Partial:    !retrievable,
+	}
+
+	if retrievable {
+		inst, err := c.GetInstance(instance.ID)
+		switch {
+		case IsGone(err) && instance.Verb == "provision":
+			// brokers 404 instances that are still being provisioned;
+			// the last operation says how that is going.
+
+		case IsGone(err):
+			f.State = SyncMissing
+			f.Action = "prune"
+			return f
+
+		case err != nil:
+			f.State = SyncUnknown
+			f.Detail = err.Error()
+
+		default:
+			f.exists = true
+			if inst.PlanID != "" && inst.PlanID != instance.PlanID {
+				f.State = SyncDrifted
+				f.Detail = fmt.Sprintf("plan is now %s", inst.PlanID)
+				f.Action = "update plan"
+				f.plan = inst.PlanID
+			}
+		}
+	}
+
+	op, err := c.LastOperation(LastOperationSpec{
+		InstanceID: instance.ID,
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+		Operation:  instance.Operation,
+	})
+	if err != nil {
+		if !f.exists && f.State == SyncOK {
+			f.State = SyncUnknown
+			f.Detail = err.Error()
+		}
+		return f
+	}
+	return syncOperation(f, op, instance.Verb, instance.Operation)
+}
+
+func (c *Client) syncBinding(instance instance, binding binding, retrievable bool) SyncFinding {
+	f := SyncFinding{
+		State:   SyncOK,
+		Partial: !retrievable,
+	}
+
+	if retrievable {
+		b, err := c.GetBinding(instance.ID, binding.ID)
+		switch {
+		case IsGone(err) && binding.Verb == "bind":
+			// (likewise for bindings that are still being made)
+
+		case IsGone(err):
+			f.State = SyncMissing
+			f.Action = "prune"
+			return f
+
+		case err != nil:
+			f.State = SyncUnknown
+			f.Detail = err.Error()
+
+		default:
+			f.exists = true
+			if b.Credentials != nil && !sameJSON(b.Credentials, binding.Credentials) {
+				f.State = SyncChanged
+				f.Detail = "credentials have changed"
+				f.Action = "update credentials"
+				f.credentials = b.Credentials
+			}
+		}
+	}
+
+	op, err := c.LastOperation(LastOperationSpec{
+		InstanceID: instance.ID,
+		BindingID:  binding.ID,
+		ServiceID:  instance.ServiceID,
+		PlanID:     instance.PlanID,
+		Operation:  binding.Operation,
+	})
+	if err != nil {
+		if !f.exists && f.State == SyncOK {
+			f.State = SyncUnknown
+			f.Detail = err.Error()
+		}
+		return f
+	}
+	return syncOperation(f, op, binding.Verb, binding.Operation)
+}
+
+// syncOperation folds the outcome of the last operation into a finding.
+// A failed operation only means the instance (or binding) isn't there
+// if the operation was what was supposed to create it; a failed update
+// leaves the instance as it was.  A deprovision (or unbind) that
+// succeeded means it's gone.
+func syncOperation(f SyncFinding, op *LastOperation, verb, operation string) SyncFinding {
+	switch op.State {
+	case Gone:
+		f.State = SyncMissing
+		f.Detail = ""
+		f.Action = "prune"
+
+	case Failed:
+		if f.State == SyncOK || f.State == SyncUnknown {
+			f.State = SyncFailed
+			f.Detail = op.Description
+			if !f.exists && (verb == "provision" || verb == "bind") {
+				f.Action = "prune"
+			}
+		}
+
+	case Succeeded:
+		if verb == "deprovision" || verb == "unbind" {
+			f.State = SyncMissing
+			f.Detail = ""
+			f.Action = "prune"
+			break
+		}
+		f.settled = verb != "" || operation != ""
+
+	case InProgress:
+		if f.State == SyncOK || f.State == SyncUnknown {
+			f.State = SyncPending
+			f.Detail = op.Description
+		}
+	}
+	return f
+}
+
+func sameJSON(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
+
+// Apply updates the store to match the findings of a Sync,
+// pruning records that are gone (or that failed to provision),
+// updating plans and credentials that have changed, and forgetting
+// operations that have finished.  It returns the number of records
+// that were changed.
+func (r *SyncReport) Apply(store *Store) int {
+	n := 0
+	for _, f := range r.Findings {
+		if f.settled && f.Action != "prune" {
+			if f.Kind == "binding" {
+				store.SetBindingOperation(r.Broker, f.InstanceID, f.BindingID, "", "")
+			} else {
+				store.SetInstanceOperation(r.Broker, f.InstanceID, "", "")
+			}
+			if f.Action == "" {
+				n++
+			}
+		}
+
+		switch f.Action {
+		case "prune":
+			if f.Kind == "binding" {
+				store.RemoveBinding(r.Broker, f.InstanceID, f.BindingID)
+			} else {
+				store.RemoveInstance(r.Broker, f.InstanceID)
+			}
+			n++
+
+		case "update plan":
+			store.SetInstancePlan(r.Broker, f.InstanceID, f.plan)
+			n++
+
+		case "update credentials":
+			store.SetBindingCredentials(r.Broker, f.InstanceID, f.BindingID, f.credentials)
+			n++
+		}
+	}
+	return n
+}
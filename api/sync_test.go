@@ -0,0 +1,180 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func states(r *api.SyncReport) map[string]string {
+	m := make(map[string]string)
+	for _, f := range r.Findings {
+		id := f.InstanceID
+		if f.Kind == "binding" {
+			id = f.BindingID
+		}
+		m[id] = f.State
+	}
+	return m
+}
+
+func TestSync(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+
+	store := &api.Store{}
+	for _, id := range []string{"i-1", "i-2"} {
+		if _, err := c.Provision(id, api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+			t.Fatalf("unable to provision %s: %s", id, err)
+		}
+		store.AddInstance(c.URL, id, "db", "db-small")
+	}
+	if _, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	store.AddBinding(c.URL, "i-1", "b-1", map[string]interface{}{"password": "stale"})
+
+	// i-3 was never provisioned on this broker
+	store.AddInstance(c.URL, "i-3", "db", "db-small")
+	store.AddBinding(c.URL, "i-3", "b-3", nil)
+
+	// i-2 was moved to another plan, behind our back
+	b.On("GET /v2/service_instances/i-2").Status(200).JSON(map[string]string{
+		"service_id": "db",
+		"plan_id":    "db-large",
+	})
+
+	report, err := c.Sync(store, cat)
+	if err != nil {
+		t.Fatalf("unable to sync: %s", err)
+	}
+	if report.Partial {
+		t.Errorf("sync of retrievable services should not be partial")
+	}
+
+	expect := map[string]string{
+		"i-1": api.SyncOK,
+		"b-1": api.SyncChanged,
+		"i-2": api.SyncDrifted,
+		"i-3": api.SyncMissing,
+		"b-3": api.SyncMissing,
+	}
+	got := states(report)
+	for id, state := range expect {
+		if got[id] != state {
+			t.Errorf("expected %s to be %s, but it was %s", id, state, got[id])
+		}
+	}
+	if len(got) != len(expect) {
+		t.Errorf("expected %d findings, got %d", len(expect), len(got))
+	}
+
+	if n := report.Apply(store); n != 4 {
+		t.Errorf("expected 4 records to change, but %d did", n)
+	}
+	if _, _, err := store.GetInstanceDetails(c.URL, "i-3"); err == nil {
+		t.Errorf("i-3 should have been pruned from the store")
+	}
+	if _, plan, _ := store.GetInstanceDetails(c.URL, "i-2"); plan != "db-large" {
+		t.Errorf("i-2 should be on db-large now, not %s", plan)
+	}
+	if creds, _ := store.GetBindingCredentials(c.URL, "b-1"); creds["password"] == "stale" {
+		t.Errorf("b-1 should have its new credentials, not %v", creds)
+	}
+
+	// the store was the only thing that changed
+	b.Expect(osbtest.Provision).Times(2)
+	b.Expect(osbtest.Deprovision).Never()
+	b.Expect(osbtest.Update).Never()
+	b.Expect(osbtest.Unbind).Never()
+}
+
+func TestSyncPending(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+
+	store := &api.Store{}
+	stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	store.AddInstance(c.URL, "i-1", "db", "db-large")
+	store.SetInstanceOperation(c.URL, "i-1", "provision", stat.Operation)
+
+	report, err := c.Sync(store, cat)
+	if err != nil {
+		t.Fatalf("unable to sync: %s", err)
+	}
+	if got := states(report)["i-1"]; got != api.SyncPending {
+		t.Errorf("expected i-1 to be pending while it provisions, but it was %s", got)
+	}
+	report.Apply(store)
+	if verb, _ := store.GetInstanceOperation(c.URL, "i-1"); verb != "provision" {
+		t.Errorf("the operation of i-1 should be kept while it is pending")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	report, err = c.Sync(store, cat)
+	if err != nil {
+		t.Fatalf("unable to sync: %s", err)
+	}
+	if got := states(report)["i-1"]; got != api.SyncOK {
+		t.Errorf("expected i-1 to be ok once provisioned, but it was %s", got)
+	}
+	if n := report.Apply(store); n != 1 {
+		t.Errorf("expected the finished operation to be forgotten, but %d records changed", n)
+	}
+	if verb, op := store.GetInstanceOperation(c.URL, "i-1"); verb != "" || op != "" {
+		t.Errorf("the operation of i-1 should be forgotten once it finished, but it is %s/%s", verb, op)
+	}
+}
+
+func TestSyncNotRetrievable(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("db").Retrievable(false, false).Plan("small")
+	c := b.Client()
+
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+
+	store := &api.Store{}
+	for _, id := range []string{"i-1", "i-2"} {
+		if _, err := c.Provision(id, api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+			t.Fatalf("unable to provision %s: %s", id, err)
+		}
+		store.AddInstance(c.URL, id, "db", "db-small")
+	}
+	if _, err := c.Deprovision(api.DeprovisionSpec{InstanceID: "i-2", ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to deprovision: %s", err)
+	}
+
+	report, err := c.Sync(store, cat)
+	if err != nil {
+		t.Fatalf("unable to sync: %s", err)
+	}
+	if !report.Partial {
+		t.Errorf("sync of services that aren't retrievable should be partial")
+	}
+	got := states(report)
+	if got["i-1"] != api.SyncOK || got["i-2"] != api.SyncMissing {
+		t.Errorf("expected i-1 to be ok and i-2 missing, but got %v", got)
+	}
+
+	b.Expect(osbtest.FetchInstance).Never()
+	b.Expect(osbtest.LastOperation).Times(2)
+}
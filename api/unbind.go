@@ -2,6 +2,8 @@ package api
 
 import (
 	"fmt"
+	"net/http"
+	"time"
 )
 
 type UnbindSpec struct {
@@ -22,7 +24,7 @@ type UnbindStatus struct {
 	Operation string `json:"operation"`
 }
 
-func (c *Client) Unbind(spec UnbindSpec) (*UnbindStatus, error) {
+func (c *Client) Unbind(spec UnbindSpec) (out *UnbindStatus, err error) {
 	if spec.InstanceID == "" {
 		return nil, fmt.Errorf("instance ID is required for unbinding")
 	}
@@ -38,7 +40,23 @@ func (c *Client) Unbind(spec UnbindSpec) (*UnbindStatus, error) {
 		spec.PlanID = "oops-unknown-plan-id"
 	}
 
-	res, err := c.del("/v2/service_instances/" + spec.InstanceID + "/service_bindings/" + spec.BindingID+"?service_id="+spec.ServiceID+"&plan_id="+spec.PlanID)
+	var res *http.Response
+	defer func(start time.Time) {
+		e := JournalEntry{
+			Verb:       "unbind",
+			InstanceID: spec.InstanceID,
+			BindingID:  spec.BindingID,
+			ServiceID:  spec.ServiceID,
+			PlanID:     spec.PlanID,
+		}
+		if out != nil {
+			e.Status = out.Status
+			e.Operation = out.Operation
+		}
+		c.record(e, start, res, out, err)
+	}(time.Now())
+
+	res, err = c.del(c.async("/v2/service_instances/" + spec.InstanceID + "/service_bindings/" + spec.BindingID + "?service_id=" + spec.ServiceID + "&plan_id=" + spec.PlanID))
 	if err != nil {
 		return nil, err
 	}
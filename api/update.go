@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type UpdateSpec struct {
+	InstanceID string `json:"-"`
+
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id,omitempty"`
+	Context    map[string]interface{} `json:"context,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+
+	MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+
+	PreviousValues struct {
+		ServiceID       string           `json:"service_id,omitempty"`
+		PlanID          string           `json:"plan_id,omitempty"`
+		MaintenanceInfo *MaintenanceInfo `json:"maintenance_info,omitempty"`
+	} `json:"previous_values"`
+}
+
+type UpdateStatus struct {
+	InstanceID string `json:"-"`
+	Status     string `json:"-"`
+
+	DashboardURL string `json:"dashboard_url"`
+	Operation    string `json:"operation"`
+}
+
+func (c *Client) Update(spec UpdateSpec) (out *UpdateStatus, err error) {
+	if spec.InstanceID == "" {
+		return nil, fmt.Errorf("instance ID is required for updating")
+	}
+
+	var res *http.Response
+	defer func(start time.Time) {
+		e := JournalEntry{
+			Verb:       "update",
+			InstanceID: spec.InstanceID,
+			ServiceID:  spec.ServiceID,
+			PlanID:     spec.PlanID,
+			Request:    spec,
+		}
+		if out != nil {
+			e.Status = out.Status
+			e.Operation = out.Operation
+		}
+		c.record(e, start, res, out, err)
+	}(time.Now())
+
+	res, err = c.patch(c.async("/v2/service_instances/"+spec.InstanceID), spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var status UpdateStatus
+	status.InstanceID = spec.InstanceID
+
+	switch res.StatusCode {
+	case 200:
+		status.Status = "updated"
+		return &status, c.parse(res, &status)
+
+	case 202:
+		status.Status = "updating"
+		return &status, c.parse(res, &status)
+	}
+
+	return nil, c.err(res)
+}
@@ -1,9 +1,70 @@
 package api
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+
 	"github.com/pborman/uuid"
 )
 
-func randomID() string {
+// RandomID returns a new (random) UUID, for instances and bindings
+// that haven't been given an ID of their own.
+func RandomID() string {
 	return uuid.NewRandom().String()
 }
+
+// DerivedID returns a deterministic (version 5) UUID for the given
+// name, in a namespace made from the broker URL and a seed, so that
+// re-running the same provision or bind against the same broker
+// asks for the same instance or binding ID.
+func DerivedID(url, seed, name string) string {
+	ns := uuid.NewSHA1(uuid.NameSpace_URL, []byte(strings.TrimSuffix(url, "/")+"#"+seed))
+	return uuid.NewSHA1(ns, []byte(name)).String()
+}
+
+// CopyHeader returns a copy of an HTTP header that can be changed
+// without changing the original (like http.Header.Clone, which the
+// Go releases we build with don't have yet).
+func CopyHeader(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	c := make(http.Header, len(h))
+	for k, l := range h {
+		c[k] = append([]string(nil), l...)
+	}
+	return c
+}
+
+// JSONable turns the map[interface{}]interface{} values that YAML
+// decodes nested maps into into map[string]interface{} values, all the
+// way down, so that they can be encoded as JSON.
+func JSONable(v interface{}) interface{} {
+	switch v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{})
+		for k, sub := range v.(map[interface{}]interface{}) {
+			m[fmt.Sprintf("%v", k)] = JSONable(sub)
+		}
+		return m
+
+	case map[string]interface{}:
+		if v.(map[string]interface{}) == nil {
+			return v
+		}
+		m := make(map[string]interface{})
+		for k, sub := range v.(map[string]interface{}) {
+			m[k] = JSONable(sub)
+		}
+		return m
+
+	case []interface{}:
+		l := make([]interface{}, len(v.([]interface{})))
+		for i, sub := range v.([]interface{}) {
+			l[i] = JSONable(sub)
+		}
+		return l
+	}
+	return v
+}
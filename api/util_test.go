@@ -0,0 +1,55 @@
+package api_test
+
+import (
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+func TestDerivedID(t *testing.T) {
+	id := api.DerivedID("http://broker", "ci", "my-pg")
+	if len(id) != 36 || id[14] != '5' {
+		t.Errorf("expected a version 5 UUID, got %s", id)
+	}
+	if again := api.DerivedID("http://broker/", "ci", "my-pg"); again != id {
+		t.Errorf("expected the same ID for the same broker, seed and name, got %s and %s", id, again)
+	}
+	for _, other := range []string{
+		api.DerivedID("http://other", "ci", "my-pg"),
+		api.DerivedID("http://broker", "dev", "my-pg"),
+		api.DerivedID("http://broker", "ci", "my-redis"),
+	} {
+		if other == id {
+			t.Errorf("expected a different ID for a different broker, seed or name, but got %s again", id)
+		}
+	}
+}
+
+func TestDerivedIDProvision(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+
+	// re-running the same provision is idempotent
+	store := &api.Store{}
+	id := api.DerivedID(c.URL, "ci", "my-pg")
+	for i, expect := range []string{"provisioned", "already existed"} {
+		stat, err := c.Provision(id, api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"})
+		if err != nil {
+			t.Fatalf("unable to provision (#%d): %s", i+1, err)
+		}
+		if stat.Status != expect {
+			t.Errorf("expected provision #%d to be %s, but it was %s", i+1, expect, stat.Status)
+		}
+		store.AddInstance(c.URL, id, "db", "db-small")
+	}
+
+	if _, err := store.Forget(c.URL, id); err != nil {
+		t.Fatalf("unable to forget %s: %s", id, err)
+	}
+	if _, _, err := store.GetInstanceDetails(c.URL, id); err == nil {
+		t.Errorf("the store should only have recorded %s once", id)
+	}
+	if n := len(b.Mock.State().Instances); n != 1 {
+		t.Errorf("expected the broker to have one instance, but it has %d", n)
+	}
+}
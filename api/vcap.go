@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type VCAPService struct {
+	Name           string                 `json:"name"`
+	InstanceName   string                 `json:"instance_name"`
+	BindingName    *string                `json:"binding_name"`
+	Label          string                 `json:"label"`
+	Plan           string                 `json:"plan"`
+	Tags           []string               `json:"tags"`
+	Provider       *string                `json:"provider"`
+	Credentials    map[string]interface{} `json:"credentials"`
+	SyslogDrainURL *string                `json:"syslog_drain_url"`
+	VolumeMounts   []VCAPVolumeMount      `json:"volume_mounts"`
+}
+
+// VCAPVolumeMount is how Cloud Foundry presents a volume mount to
+// applications; a subset of what the broker hands back at bind time.
+type VCAPVolumeMount struct {
+	ContainerDir string `json:"container_dir"`
+	Mode         string `json:"mode"`
+	DeviceType   string `json:"device_type"`
+}
+
+// VCAPServices is the Cloud Foundry VCAP_SERVICES environment
+// variable, which maps service labels (names) to the list of
+// bindings of instances of that service.
+type VCAPServices map[string][]VCAPService
+
+// VCAP builds a VCAP_SERVICES structure out of the given bindings in
+// the store, or all of the bindings for the broker if none are given.
+// If url is empty, bindings are looked for under every broker.
+//
+// Service and plan names, and service tags, are looked up in the
+// catalog cache, if it has anything for the broker; otherwise the
+// service and plan IDs are used as the label and plan.
+func (s *Store) VCAP(url string, cache *CatalogCache, bids ...string) (VCAPServices, error) {
+	url = strings.TrimSuffix(url, "/")
+	wanted := make(map[string]bool)
+	for _, bid := range bids {
+		wanted[bid] = false
+	}
+
+	vcap := make(VCAPServices)
+	for _, broker := range s.Data {
+		if url != "" && strings.TrimSuffix(broker.Broker, "/") != url {
+			continue
+		}
+		cat := cache.Get(broker.Broker)
+
+		for _, instance := range broker.Instances {
+			label, plan := instance.ServiceID, instance.PlanID
+			tags := []string{}
+			if cat != nil {
+				label, plan = cat.Names(instance.ServiceID, instance.PlanID)
+				if t := cat.Tags(instance.ServiceID); t != nil {
+					tags = t
+				}
+			}
+
+			for _, binding := range instance.Bindings {
+				if seen, ok := wanted[binding.ID]; len(bids) > 0 && (!ok || seen) {
+					continue
+				}
+				wanted[binding.ID] = true
+
+				name := instance.ID
+				if instance.Name != "" {
+					name = instance.Name
+				}
+				svc := VCAPService{
+					Name:         name,
+					InstanceName: name,
+					Label:        label,
+					Plan:         plan,
+					Tags:         tags,
+					Credentials:  binding.Credentials,
+					VolumeMounts: []VCAPVolumeMount{},
+				}
+				if binding.Name != "" {
+					bname := binding.Name
+					svc.Name = bname
+					svc.BindingName = &bname
+				}
+				if svc.Credentials == nil {
+					svc.Credentials = map[string]interface{}{}
+				}
+				if binding.SyslogDrainURL != "" {
+					drain := binding.SyslogDrainURL
+					svc.SyslogDrainURL = &drain
+				}
+				for _, m := range binding.VolumeMounts {
+					svc.VolumeMounts = append(svc.VolumeMounts, VCAPVolumeMount{
+						ContainerDir: m.ContainerDir,
+						Mode:         m.Mode,
+						DeviceType:   m.DeviceType,
+					})
+				}
+
+				vcap[label] = append(vcap[label], svc)
+			}
+		}
+	}
+
+	for _, bid := range bids {
+		if !wanted[bid] {
+			return nil, fmt.Errorf("service instance binding '%s' not found", bid)
+		}
+	}
+	return vcap, nil
+}
+
+// JSON returns the VCAP_SERVICES document, as JSON.
+func (v VCAPServices) JSON() ([]byte, error) {
+	return json.Marshal(v)
+}
@@ -0,0 +1,103 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestVCAP(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("db").Tags("sql", "relational").
+		Plan("small").
+		Service("cache").
+		Plan("tiny")
+	c := b.Client()
+
+	store := &api.Store{}
+	for _, x := range [][4]string{
+		{"i-1", "b-1", "db", "db-small"},
+		{"i-1", "b-2", "db", "db-small"},
+		{"i-2", "b-3", "cache", "cache-tiny"},
+	} {
+		if _, _, err := store.GetInstanceDetails(c.URL, x[0]); err != nil {
+			if _, err := c.Provision(x[0], api.ProvisionSpec{ServiceID: x[2], PlanID: x[3]}); err != nil {
+				t.Fatalf("unable to provision %s: %s", x[0], err)
+			}
+			store.AddInstance(c.URL, x[0], x[2], x[3])
+		}
+		out, err := c.Bind(api.BindSpec{InstanceID: x[0], BindingID: x[1], ServiceID: x[2], PlanID: x[3]})
+		if err != nil {
+			t.Fatalf("unable to bind %s: %s", x[1], err)
+		}
+		store.AddBinding(c.URL, x[0], x[1], out.Credentials)
+	}
+
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+	cache := &api.CatalogCache{Catalogs: make(map[string]*api.Catalog)}
+	cache.Set(c.URL, cat)
+
+	vcap, err := store.VCAP(c.URL, cache)
+	if err != nil {
+		t.Fatalf("unable to build VCAP_SERVICES: %s", err)
+	}
+	if len(vcap["db"]) != 2 || len(vcap["cache"]) != 1 {
+		t.Fatalf("expected 2 db and 1 cache bindings, got %d and %d", len(vcap["db"]), len(vcap["cache"]))
+	}
+	db := vcap["db"][0]
+	if db.Name != "i-1" || db.InstanceName != "i-1" || db.Label != "db" || db.Plan != "small" {
+		t.Errorf("expected i-1 to be a small db, got %+v", db)
+	}
+	if strings.Join(db.Tags, ",") != "sql,relational" {
+		t.Errorf("expected db bindings to be tagged sql and relational, not %v", db.Tags)
+	}
+	if db.Credentials["hostname"] != "db.mock" {
+		t.Errorf("expected the binding's credentials, got %v", db.Credentials)
+	}
+
+	// Cloud Foundry has nulls (and empty lists) where there's nothing
+	raw, err := vcap.JSON()
+	if err != nil {
+		t.Fatalf("unable to marshal VCAP_SERVICES: %s", err)
+	}
+	var back map[string][]map[string]interface{}
+	if err := json.Unmarshal(raw, &back); err != nil {
+		t.Fatalf("VCAP_SERVICES isn't valid JSON: %s", err)
+	}
+	for _, key := range []string{"binding_name", "provider", "syslog_drain_url"} {
+		if v, ok := back["cache"][0][key]; !ok || v != nil {
+			t.Errorf("expected %s to be null, got %v", key, v)
+		}
+	}
+	if l, ok := back["cache"][0]["volume_mounts"].([]interface{}); !ok || len(l) != 0 {
+		t.Errorf("expected volume_mounts to be an empty list, got %v", back["cache"][0]["volume_mounts"])
+	}
+
+	// just the bindings asked for
+	vcap, err = store.VCAP(c.URL, cache, "b-3")
+	if err != nil {
+		t.Fatalf("unable to build VCAP_SERVICES for b-3: %s", err)
+	}
+	if len(vcap) != 1 || len(vcap["cache"]) != 1 {
+		t.Errorf("expected just the b-3 cache binding, got %v", vcap)
+	}
+	if _, err := store.VCAP(c.URL, cache, "b-3", "b-9"); err == nil {
+		t.Errorf("asking for an unknown binding should fail")
+	}
+
+	// without a catalog, there are only IDs
+	vcap, err = store.VCAP("", nil)
+	if err != nil {
+		t.Fatalf("unable to build VCAP_SERVICES without a catalog: %s", err)
+	}
+	if len(vcap["db"]) != 2 || vcap["db"][0].Plan != "db-small" || len(vcap["db"][0].Tags) != 0 {
+		t.Errorf("expected db bindings on plan db-small, without tags, got %+v", vcap["db"])
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jhunt/osb/api"
+)
+
+// An applyManifest describes the instances and bindings that ought
+// to exist, on one or more brokers (targets).  IDs are derived from
+// the names of things (and the seed), unless given explicitly, so
+// that applying the same manifest twice is harmless.
+type applyManifest struct {
+	Seed      string          `yaml:"seed"`
+	Targets   []applyTarget   `yaml:"targets"`
+	Instances []applyInstance `yaml:"instances"`
+}
+
+type applyTarget struct {
+	Name       string `yaml:"name"`
+	URL        string `yaml:"url"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	SkipVerify bool   `yaml:"skip_verify"`
+	Profile    string `yaml:"profile"`
+
+	client *api.Client
+}
+
+type applyInstance struct {
+	Name       string                 `yaml:"name"`
+	ID         string                 `yaml:"id"`
+	Target     string                 `yaml:"target"`
+	Service    string                 `yaml:"service"`
+	Plan       string                 `yaml:"plan"`
+	Parameters map[string]interface{} `yaml:"parameters"`
+	Context    map[string]interface{} `yaml:"context"`
+	Labels     map[string]string      `yaml:"labels"`
+	Bindings   []applyBinding         `yaml:"bindings"`
+}
+
+type applyBinding struct {
+	Name       string                 `yaml:"name"`
+	ID         string                 `yaml:"id"`
+	Parameters map[string]interface{} `yaml:"parameters"`
+	Context    map[string]interface{} `yaml:"context"`
+	Labels     map[string]string      `yaml:"labels"`
+}
+
+// An applyAction is a single step towards converging a broker with
+// the manifest.  Pruning steps are carried out as teardown ops.
+type applyAction struct {
+	Verb      string   `json:"verb"`
+	Target    string   `json:"target"`
+	Instance  string   `json:"instance_id"`
+	Binding   string   `json:"binding_id,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Service   string   `json:"service"`
+	Plan      string   `json:"plan"`
+	ServiceID string   `json:"service_id"`
+	PlanID    string   `json:"plan_id"`
+	Changes   []string `json:"changes,omitempty"`
+	Blocked   string   `json:"blocked,omitempty"`
+
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Took   int64  `json:"duration_ms,omitempty"`
+
+	target   *applyTarget
+	instance *applyInstance
+	binding  *applyBinding
+	prior    *api.UpdateSpec
+}
+
+// readManifest reads an apply manifest, expanding $VARIABLES from
+// the environment first, so that passwords can be kept out of it.
+// If the manifest names no targets, the current --endpoint is used.
+func readManifest(path string) (*applyManifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m applyManifest
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(b))), &m); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if len(m.Targets) == 0 {
+		m.Targets = []applyTarget{{Name: "default", URL: opt.Endpoint}}
+	}
+	targets := make(map[string]bool)
+	for i := range m.Targets {
+		t := &m.Targets[i]
+		if t.Name == "" || t.URL == "" {
+			return nil, fmt.Errorf("%s: target #%d needs both a name and a url", path, i+1)
+		}
+		if targets[t.Name] {
+			return nil, fmt.Errorf("%s: target '%s' is defined more than once", path, t.Name)
+		}
+		targets[t.Name] = true
+
+		t.URL = strings.TrimSuffix(t.URL, "/")
+		if t.Username == "" {
+			t.Username = opt.Username
+		}
+		if t.Password == "" {
+			t.Password = opt.Password
+		}
+		if t.Profile == "" {
+			t.Profile = opt.Profile
+		}
+		t.client = &api.Client{
+			URL:               t.URL,
+			Username:          t.Username,
+			Password:          t.Password,
+			SkipVerify:        t.SkipVerify || opt.SkipVerify,
+			Timeout:           opt.Timeout,
+			Trace:             opt.Trace,
+			Journal:           journal,
+			Cassette:          clients.cassette,
+			AcceptsIncomplete: true,
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range m.Instances {
+		inst := &m.Instances[i]
+		if inst.Name == "" || inst.Service == "" || inst.Plan == "" {
+			return nil, fmt.Errorf("%s: instance #%d needs a name, a service, and a plan", path, i+1)
+		}
+		if inst.Target == "" {
+			if len(m.Targets) > 1 {
+				return nil, fmt.Errorf("%s: instance '%s' needs a target (there is more than one)", path, inst.Name)
+			}
+			inst.Target = m.Targets[0].Name
+		}
+		if !targets[inst.Target] {
+			return nil, fmt.Errorf("%s: instance '%s' refers to unknown target '%s'", path, inst.Name, inst.Target)
+		}
+		inst.Parameters = api.JSONable(inst.Parameters).(map[string]interface{})
+		inst.Context = api.JSONable(inst.Context).(map[string]interface{})
+
+		for j := range inst.Bindings {
+			b := &inst.Bindings[j]
+			if b.Name == "" {
+				return nil, fmt.Errorf("%s: binding #%d of instance '%s' needs a name", path, j+1, inst.Name)
+			}
+			b.Parameters = api.JSONable(b.Parameters).(map[string]interface{})
+			b.Context = api.JSONable(b.Context).(map[string]interface{})
+		}
+
+		for _, name := range append([]string{inst.Name}, bindingNames(inst)...) {
+			if names[inst.Target+" "+name] {
+				return nil, fmt.Errorf("%s: name '%s' is used more than once on target '%s'", path, name, inst.Target)
+			}
+			names[inst.Target+" "+name] = true
+		}
+	}
+
+	return &m, nil
+}
+
+func bindingNames(inst *applyInstance) []string {
+	l := make([]string, len(inst.Bindings))
+	for i, b := range inst.Bindings {
+		l[i] = b.Name
+	}
+	return l
+}
+
+func (m *applyManifest) target(name string) *applyTarget {
+	for i := range m.Targets {
+		if m.Targets[i].Name == name {
+			return &m.Targets[i]
+		}
+	}
+	return nil
+}
+
+// sameParameters compares two sets of parameters as JSON would see
+// them, so that 1 and 1.0 (and nil and {}) are considered the same.
+func sameParameters(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	norm := func(m map[string]interface{}) interface{} {
+		var v interface{}
+		bytes, _ := json.Marshal(api.JSONable(m))
+		json.Unmarshal(bytes, &v)
+		return v
+	}
+	return reflect.DeepEqual(norm(a), norm(b))
+}
+
+// resolveID works out the ID of a named instance (or binding), by
+// looking for its alias in the store first, falling back to the ID
+// derived from the manifest seed.
+func resolveID(store *api.Store, url, seed, id, name string) string {
+	if id != "" {
+		return id
+	}
+	if ref := store.Resolve(url, name); ref != name {
+		return ref
+	}
+	return api.DerivedID(url, seed, name)
+}
+
+// planApply compares the manifest against the store, and works out
+// what needs to be done to converge them.  Pruning is only planned
+// if asked for, and only for the targets named in the manifest.
+func planApply(m *applyManifest, store *api.Store, cache *api.CatalogCache, prune bool) ([]*applyAction, []*teardownOp, error) {
+	actions := make([]*applyAction, 0)
+	binds := make([]*applyAction, 0)
+	wanted := make(map[string]bool)
+
+	catalogs := make(map[string]*api.Catalog)
+	for i := range m.Instances {
+		inst := &m.Instances[i]
+		t := m.target(inst.Target)
+
+		cat, ok := catalogs[t.URL]
+		if !ok {
+			var err error
+			if cat, err = t.client.GetCatalog(); err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", t.Name, err)
+			}
+			cache.Set(t.URL, cat)
+			catalogs[t.URL] = cat
+		}
+
+		serviceID, planID, err := cat.FindPlan(inst.Service, inst.Plan)
+		if err != nil {
+			return nil, nil, fmt.Errorf("instance '%s': %s", inst.Name, err)
+		}
+
+		a := &applyAction{
+			Target:    t.Name,
+			Instance:  resolveID(store, t.URL, m.Seed, inst.ID, inst.Name),
+			Name:      inst.Name,
+			Service:   inst.Service,
+			Plan:      inst.Plan,
+			ServiceID: serviceID,
+			PlanID:    planID,
+			target:    t,
+			instance:  inst,
+		}
+		wanted[t.URL+" "+a.Instance] = true
+
+		haveService, havePlan, err := store.GetInstanceDetails(t.URL, a.Instance)
+		if err != nil {
+			a.Verb = "provision"
+			actions = append(actions, a)
+
+		} else {
+			if haveService != serviceID {
+				s, _ := cat.Names(haveService, havePlan)
+				return nil, nil, fmt.Errorf("instance '%s' is a %s, not a %s; it will have to be deprovisioned first", inst.Name, s, inst.Service)
+			}
+
+			a.prior = &api.UpdateSpec{}
+			a.prior.PreviousValues.ServiceID = haveService
+			a.prior.PreviousValues.PlanID = havePlan
+			if havePlan != planID {
+				_, p := cat.Names(haveService, havePlan)
+				a.Changes = append(a.Changes, fmt.Sprintf("plan: %s -> %s", p, inst.Plan))
+				if !cat.PlanUpdateable(serviceID) {
+					a.Blocked = fmt.Sprintf("%s is not plan_updateable; the instance will have to be deprovisioned first", inst.Service)
+				}
+			}
+			if have := store.GetInstanceParameters(t.URL, a.Instance); !sameParameters(have, inst.Parameters) {
+				a.Changes = append(a.Changes, fmt.Sprintf("parameters: %s -> %s", compactJSON(have), compactJSON(inst.Parameters)))
+			}
+			if len(a.Changes) > 0 {
+				a.Verb = "update"
+				actions = append(actions, a)
+			}
+		}
+
+		for j := range inst.Bindings {
+			b := &inst.Bindings[j]
+			ba := &applyAction{
+				Verb:      "bind",
+				Target:    t.Name,
+				Instance:  a.Instance,
+				Binding:   resolveID(store, t.URL, m.Seed, b.ID, b.Name),
+				Name:      b.Name,
+				Service:   inst.Service,
+				Plan:      inst.Plan,
+				ServiceID: serviceID,
+				PlanID:    planID,
+				target:    t,
+				instance:  inst,
+				binding:   b,
+			}
+			wanted[t.URL+" "+ba.Instance+" "+ba.Binding] = true
+
+			owner, _, _, err := store.GetBindingDetails(t.URL, ba.Binding)
+			if err == nil {
+				if owner != a.Instance {
+					return nil, nil, fmt.Errorf("binding '%s' belongs to instance %s, not '%s'", b.Name, owner, inst.Name)
+				}
+				continue
+			}
+			binds = append(binds, ba)
+		}
+	}
+
+	unbinds := make([]*teardownOp, 0)
+	deprovs := make([]*teardownOp, 0)
+	if prune {
+		urls := make(map[string]bool)
+		for _, t := range m.Targets {
+			urls[t.URL] = true
+		}
+		for _, l := range listings(store, cache, listFilter{}) {
+			if !urls[l.Broker] {
+				continue
+			}
+			op := &teardownOp{
+				Broker:    l.Broker,
+				Instance:  l.Instance,
+				ServiceID: l.ServiceID,
+				PlanID:    l.PlanID,
+			}
+			if l.Kind == "instance" && !wanted[l.Broker+" "+l.Instance] {
+				op.Verb = "deprovision"
+				deprovs = append(deprovs, op)
+			}
+			if l.Kind == "binding" && !wanted[l.Broker+" "+l.Instance+" "+l.Binding] {
+				op.Verb = "unbind"
+				op.Binding = l.Binding
+				unbinds = append(unbinds, op)
+			}
+		}
+	}
+
+	return append(actions, binds...), append(unbinds, deprovs...), nil
+}
+
+func compactJSON(m map[string]interface{}) string {
+	if len(m) == 0 {
+		return "{}"
+	}
+	b, err := json.Marshal(api.JSONable(m))
+	if err != nil {
+		return "?"
+	}
+	return string(b)
+}
+
+// apply carries out a single (non-pruning) action, waiting up to
+// `wait` for it to finish if the broker goes asynchronous on us, and
+// records the outcome in the store.
+func apply(store *api.Store, a *applyAction, wait time.Duration) error {
+	c := a.target.client
+	url := a.target.URL
+	last := api.LastOperationSpec{
+		InstanceID: a.Instance,
+		ServiceID:  a.ServiceID,
+		PlanID:     a.PlanID,
+	}
+
+	switch a.Verb {
+	case "provision":
+		context := a.instance.Context
+		if context == nil {
+			ctx, err := profileContext(a.target.Profile, map[string]interface{}{"instance_name": a.Name})
+			if err != nil {
+				return err
+			}
+			context = ctx
+		}
+		if err := store.CheckAlias(url, a.Instance, a.Name); err != nil {
+			return err
+		}
+
+		stat, err := c.Provision(a.Instance, api.ProvisionSpec{
+			ServiceID:  a.ServiceID,
+			PlanID:     a.PlanID,
+			Context:    context,
+			Parameters: a.instance.Parameters,
+		})
+		if err != nil {
+			return err
+		}
+		a.Status = stat.Status
+		store.AddInstance(url, a.Instance, a.ServiceID, a.PlanID)
+		store.NameInstance(url, a.Instance, a.Name)
+		store.LabelInstance(url, a.Instance, a.instance.Labels)
+		store.SetInstanceParameters(url, a.Instance, a.instance.Parameters)
+		store.SetInstanceOperation(url, a.Instance, "provision", stat.Operation)
+		if stat.Status != "provisioning" {
+			return nil
+		}
+		last.Operation = stat.Operation
+
+	case "update":
+		spec := *a.prior
+		spec.InstanceID = a.Instance
+		spec.ServiceID = a.ServiceID
+		spec.Parameters = a.instance.Parameters
+		spec.Context = a.instance.Context
+		if spec.PreviousValues.PlanID != a.PlanID {
+			spec.PlanID = a.PlanID
+		}
+
+		stat, err := c.Update(spec)
+		if err != nil {
+			return err
+		}
+		a.Status = stat.Status
+		store.SetInstanceOperation(url, a.Instance, "update", stat.Operation)
+		if stat.Status == "updating" {
+			last.Operation = stat.Operation
+			if _, err := waitFor(c, last, wait, "update"); err != nil {
+				return err
+			}
+			a.Status = "updated"
+		}
+		store.SetInstancePlan(url, a.Instance, a.PlanID)
+		store.SetInstanceParameters(url, a.Instance, a.instance.Parameters)
+		store.NameInstance(url, a.Instance, a.Name)
+		store.LabelInstance(url, a.Instance, a.instance.Labels)
+		return nil
+
+	case "bind":
+		context := a.binding.Context
+		if context == nil {
+			ctx, err := profileContext(a.target.Profile, map[string]interface{}{
+				"instance_name": a.instance.Name,
+				"binding_name":  a.Name,
+			})
+			if err != nil {
+				return err
+			}
+			context = ctx
+		}
+		if err := store.CheckAlias(url, a.Binding, a.Name); err != nil {
+			return err
+		}
+
+		stat, err := c.Bind(api.BindSpec{
+			InstanceID: a.Instance,
+			BindingID:  a.Binding,
+			ServiceID:  a.ServiceID,
+			PlanID:     a.PlanID,
+			Context:    context,
+			Parameters: a.binding.Parameters,
+		})
+		if err != nil {
+			return err
+		}
+		a.Status = stat.Status
+		if stat.Status == "binding" {
+			last.BindingID = a.Binding
+			last.Operation = stat.Operation
+			if _, err := waitFor(c, last, wait, "bind"); err != nil {
+				return err
+			}
+			b, err := c.GetBinding(a.Instance, a.Binding)
+			if err != nil {
+				return err
+			}
+			stat.Credentials, stat.SyslogDrainURL, stat.VolumeMounts = b.Credentials, b.SyslogDrainURL, b.VolumeMounts
+			a.Status = "bound"
+		}
+		store.AddBinding(url, a.Instance, a.Binding, stat.Credentials)
+		store.NameBinding(url, a.Instance, a.Binding, a.Name)
+		store.LabelBinding(url, a.Instance, a.Binding, a.binding.Labels)
+		store.SetBindingParameters(url, a.Instance, a.Binding, a.binding.Parameters)
+		store.SetBindingExtras(url, a.Instance, a.Binding, stat.SyslogDrainURL, stat.VolumeMounts)
+		return nil
+
+	default:
+		return fmt.Errorf("unrecognized apply action '%s'", a.Verb)
+	}
+
+	// only asynchronous provisions get this far.  if we gave up
+	// waiting, the instance may yet turn up, so we keep it (and its
+	// operation) in the store, for `osb sync` to sort out later.
+	if state, err := waitFor(c, last, wait, "provision"); err != nil {
+		if state == api.Failed || state == api.Gone {
+			store.RemoveInstance(url, a.Instance)
+		}
+		return err
+	}
+	a.Status = "provisioned"
+	return nil
+}
+
+// waitFor waits for an asynchronous operation to finish, and returns
+// the state it finished in (or was still in, if we gave up waiting),
+// along with an error if it didn't succeed.
+func waitFor(c *api.Client, last api.LastOperationSpec, wait time.Duration, verb string) (string, error) {
+	res, err := c.WaitFor(last, 0, wait)
+	if err != nil {
+		if res != nil {
+			return res.State, err
+		}
+		return "", err
+	}
+	if res.State == api.Succeeded {
+		return res.State, nil
+	}
+	if res.Description != "" {
+		return res.State, fmt.Errorf("%s %s: %s", verb, res.State, res.Description)
+	}
+	return res.State, fmt.Errorf("%s %s", verb, res.State)
+}
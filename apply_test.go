@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func writeManifest(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(filepath.Dir(opt.Data), "manifest.yml")
+	if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatalf("unable to write manifest: %s", err)
+	}
+	return path
+}
+
+func planFor(t *testing.T, src string, store *api.Store, prune bool) ([]*applyAction, []*teardownOp) {
+	t.Helper()
+	m, err := readManifest(writeManifest(t, src))
+	if err != nil {
+		t.Fatalf("unable to read manifest: %s", err)
+	}
+	cache := &api.CatalogCache{Catalogs: make(map[string]*api.Catalog)}
+	actions, ops, err := planApply(m, store, cache, prune)
+	if err != nil {
+		t.Fatalf("unable to plan: %s", err)
+	}
+	return actions, ops
+}
+
+func verbs(actions []*applyAction) string {
+	l := make([]string, len(actions))
+	for i, a := range actions {
+		l[i] = a.Verb + " " + a.Name
+	}
+	return strings.Join(l, ", ")
+}
+
+func TestReadManifest(t *testing.T) {
+	b, _ := newBroker(t)
+	defer b.Close()
+	defer useBroker(t, b)()
+
+	os.Setenv("OSB_TEST_PASSWORD", "from-the-env")
+	defer os.Unsetenv("OSB_TEST_PASSWORD")
+	m, err := readManifest(writeManifest(t, `
+targets:
+  - name: one
+    url: http://one/
+    password: $OSB_TEST_PASSWORD
+instances:
+  - name: my-pg
+    service: db
+    plan: small
+    parameters:
+      nested: { size: 10G }
+`))
+	if err != nil {
+		t.Fatalf("unable to read manifest: %s", err)
+	}
+	tgt := m.target("one")
+	if tgt.URL != "http://one" || tgt.Password != "from-the-env" || tgt.Username != osbtest.Username {
+		t.Errorf("expected target one to have its URL, the password from the environment, and the default username, got %+v", tgt)
+	}
+	if m.Instances[0].Target != "one" {
+		t.Errorf("instances should default to the only target, not '%s'", m.Instances[0].Target)
+	}
+	if _, ok := m.Instances[0].Parameters["nested"].(map[string]interface{}); !ok {
+		t.Errorf("nested parameters should be JSON-able, but are %T", m.Instances[0].Parameters["nested"])
+	}
+
+	// without targets, there's the --endpoint
+	m, err = readManifest(writeManifest(t, "instances: [{name: my-pg, service: db, plan: small}]\n"))
+	if err != nil {
+		t.Fatalf("unable to read manifest: %s", err)
+	}
+	if len(m.Targets) != 1 || m.Targets[0].URL != b.URL() {
+		t.Errorf("expected the --endpoint to be the only target, got %+v", m.Targets)
+	}
+
+	for _, bad := range []string{
+		"instances: [{name: my-pg, service: db}]",
+		"instances: [{name: my-pg, service: db, plan: small, target: other}]",
+		"instances: [{name: my-pg, service: db, plan: small, bindings: [{}]}]",
+		"instances: [{name: my-pg, service: db, plan: small}, {name: my-pg, service: db, plan: large}]",
+		"instances: [{name: my-pg, service: db, plan: small, bindings: [{name: my-pg}]}]",
+		"targets: [{name: a, url: http://a}, {name: b, url: http://b}]\ninstances: [{name: my-pg, service: db, plan: small}]",
+		"targets: [{name: a, url: http://a}, {name: a, url: http://b}]",
+		"targets: [{name: a}]",
+	} {
+		if _, err := readManifest(writeManifest(t, bad)); err == nil {
+			t.Errorf("manifest should have been rejected:\n%s", bad)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	defer useBroker(t, b)()
+
+	manifest := `
+seed: ci
+targets:
+  - name: mock
+    url: ` + b.URL() + `
+    profile: cf
+instances:
+  - name: my-pg
+    service: db
+    plan: small
+    parameters: { size: 10G }
+    labels: { env: prod }
+    bindings:
+      - name: app1
+  - name: my-cache
+    service: cache
+    plan: tiny
+`
+	store := &api.Store{}
+	actions, ops := planFor(t, manifest, store, true)
+	if got := verbs(actions); got != "provision my-pg, provision my-cache, bind app1" {
+		t.Errorf("expected to provision my-pg and my-cache, and bind app1, but would %s", got)
+	}
+	if len(ops) != 0 {
+		t.Errorf("expected nothing to prune from an empty store, got %d ops", len(ops))
+	}
+	if id := api.DerivedID(b.URL(), "ci", "my-pg"); actions[0].Instance != id {
+		t.Errorf("expected my-pg to get the derived ID %s, not %s", id, actions[0].Instance)
+	}
+	for _, a := range actions {
+		if err := apply(store, a, 5*time.Second); err != nil {
+			t.Fatalf("unable to %s %s: %s", a.Verb, a.Name, err)
+		}
+	}
+	b.Expect(osbtest.Provision).
+		Field("context.platform", "cloudfoundry").
+		Field("context.instance_name", "my-pg").
+		Field("parameters.size", "10G").
+		Times(1)
+	b.Expect(osbtest.Bind).Field("context.binding_name", "app1").Times(1)
+
+	pg := store.Resolve(c.URL, "my-pg")
+	if _, plan, err := store.GetInstanceDetails(c.URL, pg); err != nil || plan != "db-small" {
+		t.Errorf("expected my-pg to be recorded as db-small, got %s (%v)", plan, err)
+	}
+	if creds, err := store.GetBindingCredentials(c.URL, store.Resolve(c.URL, "app1")); err != nil || creds["hostname"] != "db.mock" {
+		t.Errorf("expected app1 to be recorded with its credentials, got %v (%v)", creds, err)
+	}
+
+	// applying it again does nothing
+	if actions, ops := planFor(t, manifest, store, true); len(actions) != 0 || len(ops) != 0 {
+		t.Errorf("expected nothing to do the second time around, but would %s (and prune %d)", verbs(actions), len(ops))
+	}
+
+	// changes become updates
+	manifest = strings.Replace(manifest, "plan: small\n    parameters: { size: 10G }", "plan: large\n    parameters: { size: 20G }", 1)
+	actions, _ = planFor(t, manifest, store, true)
+	if got := verbs(actions); got != "update my-pg" || len(actions[0].Changes) != 2 {
+		t.Fatalf("expected to update the plan and parameters of my-pg, but would %s", got)
+	}
+	if err := apply(store, actions[0], 5*time.Second); err != nil {
+		t.Fatalf("unable to update my-pg: %s", err)
+	}
+	if _, plan, _ := store.GetInstanceDetails(c.URL, pg); plan != "db-large" {
+		t.Errorf("expected my-pg to be recorded as db-large, not %s", plan)
+	}
+	b.Expect(osbtest.Update).Field("plan_id", "db-large").Field("parameters.size", "20G").Times(1)
+
+	// and what's left out gets pruned, if asked
+	manifest = manifest[:strings.Index(manifest, "    bindings:")]
+	actions, ops = planFor(t, manifest, store, false)
+	if len(actions) != 0 || len(ops) != 0 {
+		t.Errorf("expected nothing to do without pruning, but would %s (and prune %d)", verbs(actions), len(ops))
+	}
+	_, ops = planFor(t, manifest, store, true)
+	cache := store.Resolve(c.URL, "my-cache")
+	if len(ops) != 2 || ops[0].Verb != "unbind" || ops[1].Verb != "deprovision" || ops[1].Instance != cache {
+		t.Errorf("expected to unbind app1 and then deprovision my-cache, got %s", opIDs(ops))
+	}
+}
+
+func TestApplyBlocked(t *testing.T) {
+	b := osbtest.New(t)
+	defer b.Close()
+	b.Service("db").Plan("small").Plan("large").
+		Service("fixed").NotPlanUpdateable().Plan("small").Plan("large")
+	defer useBroker(t, b)()
+
+	url := strings.TrimSuffix(b.URL(), "/")
+	store := &api.Store{}
+	store.AddInstance(url, api.DerivedID(url, "", "my-pg"), "db", "db-small")
+	store.AddInstance(url, api.DerivedID(url, "", "my-fixed"), "fixed", "fixed-small")
+
+	actions, _ := planFor(t, `
+instances:
+  - { name: my-pg,    service: db,    plan: large }
+  - { name: my-fixed, service: fixed, plan: large }
+`, store, false)
+	if got := verbs(actions); got != "update my-pg, update my-fixed" {
+		t.Fatalf("expected to update my-pg and my-fixed, but would %s", got)
+	}
+	if actions[0].Blocked != "" {
+		t.Errorf("my-pg is plan_updateable, and should not be blocked (%s)", actions[0].Blocked)
+	}
+	if !strings.Contains(actions[1].Blocked, "not plan_updateable") {
+		t.Errorf("my-fixed is not plan_updateable, and should be blocked, but got '%s'", actions[1].Blocked)
+	}
+
+	// changing services is out of the question
+	m, err := readManifest(writeManifest(t, "instances: [{name: my-pg, service: fixed, plan: small}]"))
+	if err != nil {
+		t.Fatalf("unable to read manifest: %s", err)
+	}
+	cache := &api.CatalogCache{Catalogs: make(map[string]*api.Catalog)}
+	if _, _, err := planApply(m, store, cache, false); err == nil {
+		t.Errorf("changing the service of my-pg should fail")
+	}
+
+	b.Expect(osbtest.Update).Never()
+}
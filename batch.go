@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+// A batchLine is one line of a batch file, as written by a human.
+// Instances and bindings can be referred to by ID, by their alias
+// in ~/.osbrc, or by a name given to them earlier in the same batch.
+// Services and plans can be given by name or ID.
+type batchLine struct {
+	Ref        string                 `json:"ref"`
+	Verb       string                 `json:"verb"`
+	Instance   string                 `json:"instance"`
+	Binding    string                 `json:"binding"`
+	Service    string                 `json:"service"`
+	Plan       string                 `json:"plan"`
+	Name       string                 `json:"name"`
+	Labels     map[string]string      `json:"labels"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Context    map[string]interface{} `json:"context"`
+}
+
+// A batchPlan holds everything we learned about the jobs while
+// reading them, that we will need again when recording the results.
+type batchPlan struct {
+	Jobs   []api.Job
+	Names  map[string]string
+	Labels map[string]map[string]string
+}
+
+func readBatch(in io.Reader, store *api.Store, c *api.Client, catalog func() *api.Catalog) (*batchPlan, error) {
+	p := &batchPlan{
+		Names:  make(map[string]string),
+		Labels: make(map[string]map[string]string),
+	}
+
+	// names (and the service / plan of instances) introduced by
+	// earlier lines, so that later lines can build on them.
+	names := make(map[string]string)
+	plans := make(map[string][2]string)
+	parent := make(map[string]string)
+
+	resolve := func(ref string) string {
+		if id, ok := names[ref]; ok {
+			return id
+		}
+		return store.Resolve(c.URL, ref)
+	}
+
+	lines := bufio.NewScanner(in)
+	lines.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for lines.Scan() {
+		n++
+		s := strings.TrimSpace(lines.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+
+		var l batchLine
+		if err := json.Unmarshal([]byte(s), &l); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if l.Ref == "" {
+			l.Ref = fmt.Sprintf("%d", n)
+		}
+
+		job := api.Job{
+			Ref:        l.Ref,
+			Verb:       l.Verb,
+			InstanceID: resolve(l.Instance),
+			Parameters: l.Parameters,
+			Context:    l.Context,
+		}
+
+		switch l.Verb {
+		case "provision", "bind":
+		case "update", "unbind", "deprovision":
+			if l.Name != "" {
+				return nil, fmt.Errorf("line %d: only provision and bind jobs can name things", n)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unrecognized verb '%s' (try provision, update, bind, unbind, or deprovision)", n, l.Verb)
+		}
+
+		if l.Verb == "unbind" {
+			if l.Binding == "" {
+				return nil, fmt.Errorf("line %d: unbind jobs need a binding", n)
+			}
+			job.BindingID = resolve(l.Binding)
+			if job.InstanceID == "" {
+				job.InstanceID = parent[job.BindingID]
+			}
+			if job.InstanceID == "" {
+				job.InstanceID, _, _, _ = store.GetBindingDetails(c.URL, job.BindingID)
+			}
+		}
+		if job.InstanceID == "" && l.Verb != "provision" {
+			return nil, fmt.Errorf("line %d: %s jobs need an instance", n, l.Verb)
+		}
+
+		if l.Verb == "provision" {
+			if job.InstanceID == "" && opt.Seed != "" && l.Name != "" {
+				job.InstanceID = api.DerivedID(c.URL, opt.Seed, l.Name)
+			}
+			if job.InstanceID == "" {
+				job.InstanceID = api.RandomID()
+			}
+		}
+		if l.Verb == "bind" {
+			job.BindingID = resolve(l.Binding)
+			if job.BindingID == "" && opt.Seed != "" && l.Name != "" {
+				job.BindingID = api.DerivedID(c.URL, opt.Seed, l.Name)
+			}
+			if job.BindingID == "" {
+				job.BindingID = api.RandomID()
+			}
+		}
+
+		if l.Service == "" || l.Plan == "" {
+			if sp, ok := plans[job.InstanceID]; ok {
+				job.ServiceID, job.PlanID = sp[0], sp[1]
+			} else {
+				job.ServiceID, job.PlanID, _ = store.GetInstanceDetails(c.URL, job.InstanceID)
+			}
+		}
+		if l.Service != "" || l.Plan != "" || job.ServiceID == "" {
+			service, plan := l.Service, l.Plan
+			if service == "" {
+				service = job.ServiceID
+			}
+			if plan == "" {
+				plan = job.PlanID
+			}
+			if service == "" || plan == "" {
+				return nil, fmt.Errorf("line %d: instance '%s' not found in local ~/.osbrc; the service and plan must be given", n, job.InstanceID)
+			}
+			sid, pid, err := catalog().FindPlan(service, plan)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			job.ServiceID, job.PlanID = sid, pid
+		}
+		if l.Verb == "provision" || l.Verb == "update" {
+			plans[job.InstanceID] = [2]string{job.ServiceID, job.PlanID}
+		}
+
+		if l.Name != "" {
+			id := job.InstanceID
+			if l.Verb == "bind" {
+				id = job.BindingID
+			}
+			if other, ok := names[l.Name]; ok && other != id {
+				return nil, fmt.Errorf("line %d: alias '%s' is already used earlier in the batch", n, l.Name)
+			}
+			if err := store.CheckAlias(c.URL, id, l.Name); err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			names[l.Name] = id
+			p.Names[l.Ref] = l.Name
+		}
+		if l.Verb == "bind" {
+			parent[job.BindingID] = job.InstanceID
+		}
+
+		if job.Context == nil {
+			fields := map[string]interface{}{"instance_name": store.Alias(c.URL, job.InstanceID)}
+			if l.Verb == "provision" {
+				fields["instance_name"] = l.Name
+			}
+			if l.Verb == "bind" {
+				for name, id := range names {
+					if id == job.InstanceID {
+						fields["instance_name"] = name
+					}
+				}
+				fields["binding_name"] = l.Name
+			}
+			ctx, err := profileContext(opt.Profile, fields)
+			if err != nil {
+				return nil, err
+			}
+			job.Context = ctx
+		}
+
+		if l.Labels != nil {
+			p.Labels[l.Ref] = l.Labels
+		}
+		p.Jobs = append(p.Jobs, job)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// record updates ~/.osbrc to reflect the outcome of a batch job.
+func (p *batchPlan) record(store *api.Store, url string, r api.JobResult) {
+	if !r.Succeeded() {
+		return
+	}
+
+	switch r.Verb {
+	case "provision":
+		store.AddInstance(url, r.InstanceID, r.ServiceID, r.PlanID)
+		store.LabelInstance(url, r.InstanceID, p.Labels[r.Ref])
+		store.SetInstanceParameters(url, r.InstanceID, r.Parameters)
+		store.SetInstanceOperation(url, r.InstanceID, r.Verb, r.Operation)
+		if name := p.Names[r.Ref]; name != "" {
+			if err := store.NameInstance(url, r.InstanceID, name); err != nil {
+				fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+			}
+		}
+
+	case "update":
+		store.SetInstancePlan(url, r.InstanceID, r.PlanID)
+		if r.Parameters != nil {
+			store.SetInstanceParameters(url, r.InstanceID, r.Parameters)
+		}
+		store.SetInstanceOperation(url, r.InstanceID, r.Verb, r.Operation)
+
+	case "bind":
+		var creds map[string]interface{}
+		if r.Binding != nil {
+			creds = r.Binding.Credentials
+		}
+		store.AddBinding(url, r.InstanceID, r.BindingID, creds)
+		store.LabelBinding(url, r.InstanceID, r.BindingID, p.Labels[r.Ref])
+		store.SetBindingParameters(url, r.InstanceID, r.BindingID, r.Parameters)
+		store.SetBindingOperation(url, r.InstanceID, r.BindingID, r.Verb, r.Operation)
+		if r.Binding != nil {
+			store.SetBindingExtras(url, r.InstanceID, r.BindingID, r.Binding.SyslogDrainURL, r.Binding.VolumeMounts)
+		}
+		if name := p.Names[r.Ref]; name != "" {
+			if err := store.NameBinding(url, r.InstanceID, r.BindingID, name); err != nil {
+				fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+			}
+		}
+
+	case "unbind":
+		store.RemoveBinding(url, r.InstanceID, r.BindingID)
+
+	case "deprovision":
+		store.RemoveInstance(url, r.InstanceID)
+	}
+}
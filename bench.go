@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-table"
+
+	"github.com/jhunt/osb/api"
+)
+
+// stopOnInterrupt returns a channel that is closed the first time we
+// are interrupted (or terminated), so that a long-running command can
+// stop what it is doing and clean up after itself.  Any more signals
+// are just acknowledged; the cleanup has to happen regardless.
+func stopOnInterrupt() (<-chan struct{}, func()) {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		first := true
+		for range signals {
+			if first {
+				fmt.Fprintf(os.Stderr, "@Y{interrupted; finishing what's in flight, and cleaning up...}\n")
+				close(stop)
+				first = false
+			} else {
+				fmt.Fprintf(os.Stderr, "@Y{still cleaning up; hang on...}\n")
+			}
+		}
+	}()
+	return stop, func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+func millis(f float64) string {
+	return strconv.FormatFloat(f, 'f', 1, 64)
+}
+
+func printBench(w io.Writer, r *api.BenchReport) {
+	fmt.Fprintf(w, "broker   @C{%s}\n", r.Broker)
+	if r.Rate > 0 {
+		fmt.Fprintf(w, "workload @M{%s}, %g/s, at most %d at once\n\n", r.Workload, r.Rate, r.Parallel)
+	} else {
+		fmt.Fprintf(w, "workload @M{%s}, %d at once\n\n", r.Workload, r.Parallel)
+	}
+
+	t := table.NewTable("Endpoint", "Requests", "Req/s", "Errors", "p50 (ms)", "p90 (ms)", "p99 (ms)", "Max (ms)")
+	for _, e := range r.Endpoints {
+		errors := "0"
+		if e.Errors > 0 {
+			errors = fmt.Sprintf("@R{%d (%.1f%%)}", e.Errors, e.ErrorRate*100)
+		}
+		t.Row(nil, e.Name, e.Requests, strconv.FormatFloat(e.Throughput, 'f', 2, 64), errors,
+			millis(e.P50), millis(e.P90), millis(e.P99), millis(e.Max))
+	}
+	t.Output(w)
+
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(w, "\n")
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(w, "@Y{!} %s\n", warning)
+		}
+	}
+
+	fmt.Fprintf(w, "\n")
+	if r.Interrupted {
+		fmt.Fprintf(w, "@Y{interrupted} after ")
+	}
+	fmt.Fprintf(w, "%d cycles in %s (%s, with setup and cleanup)\n", r.Cycles, ms(r.Elapsed), ms(r.Took))
+	if len(r.Leftovers) > 0 {
+		fmt.Fprintf(w, "@R{%d left behind}; clean these up by hand:\n", len(r.Leftovers))
+		for _, path := range r.Leftovers {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+	}
+}
+
+func csvBench(w io.Writer, r *api.BenchReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"endpoint", "requests", "throughput", "errors", "error_rate", "p50_ms", "p90_ms", "p99_ms", "max_ms"})
+	for _, e := range r.Endpoints {
+		out.Write([]string{
+			e.Name,
+			strconv.Itoa(e.Requests),
+			strconv.FormatFloat(e.Throughput, 'f', 3, 64),
+			strconv.Itoa(e.Errors),
+			strconv.FormatFloat(e.ErrorRate, 'f', 4, 64),
+			strconv.FormatFloat(e.P50, 'f', 3, 64),
+			strconv.FormatFloat(e.P90, 'f', 3, 64),
+			strconv.FormatFloat(e.P99, 'f', 3, 64),
+			strconv.FormatFloat(e.Max, 'f', 3, 64),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+// The shell completion scripts don't know anything about osb; they
+// hand the words on the command line to the hidden `__complete`
+// command, which works out what could come next from the options
+// in `opt` (so that new commands and flags are picked up for free),
+// and from what's in ~/.osbrc and the catalog cache.
+//
+// Candidates are printed one per line, as VALUE<tab>DESCRIPTION.
+// If the shell should fall back to completing file names, directory
+// names, or commands instead, a single :file, :dir or :command line
+// is printed.
+
+type completion struct {
+	Value       string
+	Description string
+}
+
+type completionFlag struct {
+	Shorts string
+	Longs  []string
+	Value  bool
+}
+
+type completionCommand struct {
+	Names []string
+	Flags []completionFlag
+}
+
+var commandSummaries = map[string]string{
+	"list":          "List known instance and binding details",
+	"env":           "Dump the environment variables that osb cares about",
+	"catalog":       "Retrieve the service catalog from the service broker",
+	"provision":     "Provision a new instance of a service/plan",
+	"deprovision":   "Remove a provisioned instance",
+	"bind":          "Bind a provisioned instance, to get credentials",
+	"unbind":        "Unbind an instance, releasing bound credentials",
+	"creds":         "Export the credentials of a binding",
+	"exec":          "Run a command with a binding's credentials",
+	"vcap":          "Generate VCAP_SERVICES from bindings",
+	"render":        "Render a configuration file template with credentials",
+	"sync":          "Check ~/.osbrc against what the broker knows about",
+	"adopt":         "Record an existing instance",
+	"adopt-binding": "Record an existing binding",
+	"import":        "Adopt instances and bindings in bulk, from a file",
+	"forget":        "Remove instances or bindings from ~/.osbrc",
+	"history":       "Show the journal of past lifecycle operations",
+	"teardown":      "Unbind and deprovision everything in ~/.osbrc",
+	"apply":         "Converge brokers with a manifest of instances and bindings",
+	"batch":         "Run lots of lifecycle operations at once, from a file",
+	"test":          "Check a broker against the OSB spec, end to end",
+	"stress":        "Hit one instance with lots of concurrent requests",
+	"bench":         "Measure how fast (and how reliably) a broker responds",
+	"run":           "Run a scenario of requests, with assertions, from a file",
+	"mock":          "Run an in-memory service broker, for testing against",
+	"proxy":         "Watch (and check) what a platform says to a broker",
+	"completion":    "Generate shell completion scripts",
+}
+
+// completeFlagValues says what the value of a flag looks like, keyed
+// by the command and the first long name of the flag.  Global flags
+// have an empty command.  Anything not listed here takes a value
+// that we can't guess at, and completes to nothing.
+var completeFlagValues = map[string]string{
+	" --data":     ":file",
+	" --endpoint": "broker",
+	" --profile":  "=cloudfoundry kubernetes",
+	" --record":   ":file",
+	" --replay":   ":file",
+
+	"list --broker":  "broker",
+	"list --service": "service",
+	"list --plan":    "plan",
+	"list --sort":    "=broker id name instance binding service plan created",
+	"list --format":  "=table wide yaml json csv tsv",
+
+	"bind --service":        "service",
+	"bind --plan":           "plan",
+	"unbind --service":      "service",
+	"unbind --plan":         "plan",
+	"deprovision --service": "service",
+	"deprovision --plan":    "plan",
+
+	"adopt --service":             "service",
+	"adopt --plan":                "plan",
+	"adopt-binding --instance":    "instance",
+	"adopt-binding --credentials": ":file",
+	"import --format":             "=yaml json csv tsv",
+
+	"teardown --broker":  "broker",
+	"teardown --service": "service",
+	"teardown --plan":    "plan",
+
+	"creds --format": "=env dotenv json yaml k8s-secret servicebinding",
+	"creds --dir":    ":dir",
+
+	"render --binding":  "binding",
+	"render --template": ":file",
+	"render --out":      ":file",
+
+	"test --suite":      "=lifecycle negative",
+	"test --format":     "=summary json junit",
+	"test --junit":      ":file",
+	"stress --workload": "=binds provision update-bind",
+	"bench --workload":  "=catalog provision bind",
+	"bench --format":    "=table json csv",
+	"run --junit":       ":file",
+	"mock --catalog":    ":file",
+	"proxy --chaos":     ":file",
+
+	"history --broker": "broker",
+	"history --verb":   "=provision update deprovision bind unbind",
+}
+
+// completeArgs says what each positional argument of a command looks
+// like.  A trailing "..." means that the last one can be repeated.
+var completeArgs = map[string][]string{
+	"provision":   {"service/plan"},
+	"deprovision": {"instance"},
+	"bind":        {"instance"},
+	"unbind":      {"binding"},
+	"creds":       {"binding"},
+	"exec":        {"binding"},
+	"vcap":        {"binding", "..."},
+	"forget":      {"id", "..."},
+	"history":     {"id"},
+	"import":      {":file"},
+	"apply":       {":file"},
+	"batch":       {":file"},
+	"test":        {"service/plan", "..."},
+	"stress":      {"service/plan"},
+	"bench":       {"service/plan"},
+	"run":         {":file"},
+	"completion":  {"=bash zsh fish"},
+}
+
+var tagSplitter = regexp.MustCompile(" *, *")
+
+func completionFlags(t reflect.Type) []completionFlag {
+	ff := make([]completionFlag, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("cli")
+		if !ok || field.Type.Kind() == reflect.Struct {
+			continue
+		}
+
+		f := completionFlag{Value: field.Type.Kind() != reflect.Bool}
+		for _, name := range tagSplitter.Split(tag, -1) {
+			if strings.HasPrefix(name, "--") {
+				f.Longs = append(f.Longs, name[2:])
+			} else if strings.HasPrefix(name, "-") {
+				f.Shorts += name[1:]
+			}
+		}
+		ff = append(ff, f)
+	}
+	return ff
+}
+
+func completionCommands() []completionCommand {
+	t := reflect.TypeOf(opt)
+	cc := make([]completionCommand, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("cli")
+		if !ok || field.Type.Kind() != reflect.Struct {
+			continue
+		}
+
+		c := completionCommand{Flags: completionFlags(field.Type)}
+		for _, name := range tagSplitter.Split(tag, -1) {
+			c.Names = append(c.Names, strings.TrimSuffix(name, "!"))
+		}
+		if strings.HasPrefix(c.Names[0], "__") {
+			continue
+		}
+		cc = append(cc, c)
+	}
+	return cc
+}
+
+func findFlag(ff []completionFlag, word string) (completionFlag, bool) {
+	for _, f := range ff {
+		if strings.HasPrefix(word, "--") {
+			for _, long := range f.Longs {
+				if word[2:] == long {
+					return f, true
+				}
+			}
+		} else if len(word) == 2 && strings.Contains(f.Shorts, word[1:]) {
+			return f, true
+		}
+	}
+	return completionFlag{}, false
+}
+
+// complete works out the candidates for the last of the given words,
+// which are everything on the command line after `osb`.
+func complete(words []string) []completion {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur, words := words[len(words)-1], words[:len(words)-1]
+
+	global := completionFlags(reflect.TypeOf(opt))
+	commands := completionCommands()
+
+	var cmd *completionCommand
+	name := ""
+	nargs := 0
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if w == "--" {
+			if name == "exec" {
+				if i == len(words)-1 {
+					return []completion{{Value: ":command"}}
+				}
+				return []completion{{Value: ":file"}}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(w, "-") && len(w) > 1 {
+			f, ok := findFlag(global, w)
+			if !ok && cmd != nil {
+				f, ok = findFlag(cmd.Flags, w)
+			}
+			if !ok || !f.Value {
+				continue
+			}
+			if i+1 == len(words) {
+				return completeFlagValue(name, f, cur)
+			}
+			// remember the store / broker we're talking about
+			i++
+			switch {
+			case len(f.Longs) > 0 && f.Longs[0] == "data":
+				opt.Data = words[i]
+			case len(f.Longs) > 0 && f.Longs[0] == "endpoint":
+				opt.Endpoint = words[i]
+			}
+			continue
+		}
+
+		if cmd == nil {
+			for j := range commands {
+				for _, n := range commands[j].Names {
+					if n == w {
+						cmd, name = &commands[j], commands[j].Names[0]
+					}
+				}
+			}
+			continue
+		}
+		nargs++
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		flags := global
+		if cmd != nil {
+			flags = append(flags[:len(flags):len(flags)], cmd.Flags...)
+		}
+		cc := make([]completion, 0)
+		for _, f := range flags {
+			for _, long := range f.Longs {
+				cc = append(cc, completion{Value: "--" + long})
+			}
+			if len(f.Longs) == 0 {
+				for _, short := range f.Shorts {
+					cc = append(cc, completion{Value: "-" + string(short)})
+				}
+			}
+		}
+		return filterCompletions(cc, cur)
+	}
+
+	if cmd == nil {
+		cc := make([]completion, 0)
+		for _, c := range commands {
+			cc = append(cc, completion{Value: c.Names[0], Description: commandSummaries[c.Names[0]]})
+		}
+		return filterCompletions(cc, cur)
+	}
+
+	kinds := completeArgs[name]
+	if len(kinds) == 0 {
+		return nil
+	}
+	if kinds[len(kinds)-1] == "..." && nargs >= len(kinds)-2 {
+		nargs = len(kinds) - 2
+	} else if nargs >= len(kinds) {
+		return nil
+	}
+	return completeKind(kinds[nargs], cur)
+}
+
+func completeFlagValue(command string, f completionFlag, cur string) []completion {
+	if len(f.Longs) == 0 {
+		return nil
+	}
+	kind, ok := completeFlagValues[command+" --"+f.Longs[0]]
+	if !ok {
+		kind, ok = completeFlagValues[" --"+f.Longs[0]]
+	}
+	if !ok {
+		return nil
+	}
+
+	// render --binding takes an optional NAME= prefix
+	if command == "render" && strings.Contains(cur, "=") {
+		n := strings.Index(cur, "=")
+		cc := completeKind(kind, cur[n+1:])
+		for i := range cc {
+			cc[i].Value = cur[:n+1] + cc[i].Value
+		}
+		return cc
+	}
+	return completeKind(kind, cur)
+}
+
+func completeKind(kind, cur string) []completion {
+	if strings.HasPrefix(kind, ":") {
+		return []completion{{Value: kind}}
+	}
+
+	cc := make([]completion, 0)
+	if strings.HasPrefix(kind, "=") {
+		for _, v := range strings.Split(kind[1:], " ") {
+			cc = append(cc, completion{Value: v})
+		}
+		return filterCompletions(cc, cur)
+	}
+
+	store, err := api.ReadStore(opt.Data)
+	if err != nil {
+		return nil
+	}
+	cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+	if err != nil {
+		cache = &api.CatalogCache{}
+	}
+	endpoint := strings.TrimSuffix(opt.Endpoint, "/")
+
+	switch kind {
+	case "broker":
+		for _, b := range store.Data {
+			cc = append(cc, completion{Value: strings.TrimSuffix(b.Broker, "/")})
+		}
+
+	case "instance", "binding", "id":
+		for _, l := range listings(store, cache, listFilter{}) {
+			if endpoint != "" && l.Broker != endpoint {
+				continue
+			}
+			if kind != "id" && l.Kind != kind {
+				continue
+			}
+			desc := l.Service + "/" + l.Plan
+			if l.Kind == "binding" {
+				desc = "binding of " + l.Instance + " (" + desc + ")"
+			}
+			cc = append(cc, completion{Value: l.ID, Description: desc})
+			if l.Name != "" {
+				cc = append(cc, completion{Value: l.Name, Description: l.ID + ", " + desc})
+			}
+		}
+
+	case "service", "plan", "service/plan":
+		for url, cat := range cache.Catalogs {
+			if endpoint != "" && url != endpoint {
+				continue
+			}
+			for _, s := range cat.Services {
+				if kind == "service" {
+					cc = append(cc, completion{Value: s.Name, Description: s.Description})
+					continue
+				}
+				for _, p := range s.Plans {
+					if kind == "plan" {
+						cc = append(cc, completion{Value: p.Name, Description: s.Name + ": " + p.Description})
+					} else {
+						cc = append(cc, completion{Value: s.Name + "/" + p.Name, Description: p.Description})
+					}
+				}
+			}
+		}
+	}
+	return filterCompletions(cc, cur)
+}
+
+func filterCompletions(cc []completion, prefix string) []completion {
+	seen := make(map[string]bool)
+	keep := make([]completion, 0, len(cc))
+	for _, c := range cc {
+		if !strings.HasPrefix(c.Value, prefix) || seen[c.Value] {
+			continue
+		}
+		seen[c.Value] = true
+		keep = append(keep, c)
+	}
+	sort.SliceStable(keep, func(i, j int) bool { return keep[i].Value < keep[j].Value })
+	return keep
+}
+
+func printCompletions(out io.Writer, cc []completion) {
+	for _, c := range cc {
+		d := strings.Join(strings.Fields(c.Description), " ")
+		if d == "" {
+			fmt.Fprintf(out, "%s\n", c.Value)
+		} else {
+			fmt.Fprintf(out, "%s\t%s\n", c.Value, d)
+		}
+	}
+}
+
+const bashCompletion = `# bash completion for osb
+#
+#   source <(osb completion bash)
+#
+_osb() {
+	local line="${COMP_LINE:0:COMP_POINT}"
+	local -a words out
+	read -r -a words <<< "$line"
+	[[ $line == *[[:space:]] ]] && words+=("")
+	local cur="${words[${#words[@]}-1]}"
+
+	local IFS=$'\n'
+	out=($("${words[0]}" __complete "${words[@]:1}" 2>/dev/null))
+	case "${out[0]}" in
+	:file)    compopt -o filenames 2>/dev/null; COMPREPLY=($(compgen -f -- "$cur")) ;;
+	:dir)     compopt -o filenames 2>/dev/null; COMPREPLY=($(compgen -d -- "$cur")) ;;
+	:command) COMPREPLY=($(compgen -c -- "$cur")) ;;
+	*)        COMPREPLY=($(printf '%s\n' "${out[@]}" | cut -f1)) ;;
+	esac
+
+	# bash only replaces what comes after a = or :
+	if [[ $cur == *[=:]* ]]; then
+		local prefix="${cur%"${cur##*[=:]}"}"
+		COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+	fi
+}
+complete -F _osb osb
+`
+
+const zshCompletion = `#compdef osb
+#
+#   source <(osb completion zsh)
+#
+# or save it as _osb somewhere in your $fpath.
+#
+_osb() {
+	local -a out values
+	local line v d
+	out=("${(@f)$(${words[1]} __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	case "${out[1]}" in
+	:file)    _files; return ;;
+	:dir)     _files -/; return ;;
+	:command) _command_names -e; return ;;
+	esac
+
+	for line in "${out[@]}"; do
+		[[ -z $line ]] && continue
+		v="${line%%$'\t'*}"
+		d=""
+		[[ $line == *$'\t'* ]] && d="${line#*$'\t'}"
+		v="${v//:/\\:}"
+		if [[ -n $d ]]; then values+=("$v:$d"); else values+=("$v"); fi
+	done
+	_describe -t values osb values
+}
+if [[ $funcstack[1] == _osb ]]; then
+	_osb "$@"
+else
+	compdef _osb osb
+fi
+`
+
+const fishCompletion = `# fish completion for osb
+#
+#   osb completion fish | source
+#
+# or save it as ~/.config/fish/completions/osb.fish
+#
+function __osb_complete
+	set -l words (commandline -opc)
+	set -l cur (commandline -ct)
+	set -l osb $words[1]
+	set -e words[1]
+	set -l out ($osb __complete $words "$cur" 2>/dev/null)
+	switch "$out[1]"
+	case :file
+		__fish_complete_path "$cur"
+	case :dir
+		__fish_complete_directories "$cur"
+	case :command
+		__fish_complete_command
+	case '*'
+		printf '%s\n' $out
+	end
+end
+complete -c osb -f -a '(__osb_complete)'
+`
+
+func completionScript(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion, nil
+	case "zsh":
+		return zshCompletion, nil
+	case "fish":
+		return fishCompletion, nil
+	}
+	return "", fmt.Errorf("unsupported shell '%s' (try bash, zsh, or fish)", shell)
+}
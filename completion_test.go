@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+func values(cc []completion) string {
+	l := make([]string, len(cc))
+	for i, c := range cc {
+		l[i] = c.Value
+	}
+	return strings.Join(l, " ")
+}
+
+func TestComplete(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	defer useBroker(t, b)()
+
+	store := newListStore(t, c)
+	if err := store.Write(opt.Data); err != nil {
+		t.Fatalf("unable to write store: %s", err)
+	}
+	fetchCatalog(c)
+
+	tests := []struct {
+		words  []string
+		expect string
+	}{
+		{[]string{"prov"}, "provision"},
+		{[]string{"provision", ""}, "cache/tiny db/large db/small"},
+		{[]string{"provision", "db/"}, "db/large db/small"},
+		{[]string{"provision", "db/small", ""}, ""},
+		{[]string{"deprovision", ""}, "i-1 i-2"},
+		{[]string{"unbind", ""}, "b-1"},
+		{[]string{"forget", "i-1", ""}, "b-1 i-1 i-2"},
+		{[]string{"list", "--plan", ""}, "large small tiny"},
+		{[]string{"list", "--format", "c"}, "csv"},
+		{[]string{"list", "--sort", "name", "--format", "t"}, "table tsv"},
+		{[]string{"list", "--bro"}, "--broker"},
+		{[]string{"--end"}, "--endpoint"},
+		{[]string{"list", "--endpoint", ""}, strings.TrimSuffix(c.URL, "/")},
+		{[]string{"import", ""}, ":file"},
+		{[]string{"exec", "b-1", "--", ""}, ":command"},
+		{[]string{"exec", "b-1", "--", "env", ""}, ":file"},
+		{[]string{"completion", ""}, "bash fish zsh"},
+	}
+	for _, test := range tests {
+		if got := values(complete(test.words)); got != test.expect {
+			t.Errorf("completing [%s]: expected [%s], got [%s]", strings.Join(test.words, " "), test.expect, got)
+		}
+	}
+
+	// other brokers' instances aren't offered
+	opt.Endpoint = "http://elsewhere"
+	if got := values(complete([]string{"deprovision", ""})); got != "" {
+		t.Errorf("expected no instances for another broker, got [%s]", got)
+	}
+
+	// a broken catalog cache just means no catalog completions
+	ioutil.WriteFile(api.CatalogCachePath(opt.Data), []byte("{"), 0666)
+	opt.Endpoint = c.URL
+	if got := values(complete([]string{"provision", ""})); got != "" {
+		t.Errorf("expected no plans without a catalog cache, got [%s]", got)
+	}
+	if got := values(complete([]string{"deprovision", ""})); got != "i-1 i-2" {
+		t.Errorf("expected instances without a catalog cache, got [%s]", got)
+	}
+}
+
+func TestPrintCompletions(t *testing.T) {
+	var out bytes.Buffer
+	printCompletions(&out, []completion{
+		{Value: "db/small"},
+		{Value: "db/large", Description: "A large\n  database"},
+	})
+	if expect := "db/small\ndb/large\tA large database\n"; out.String() != expect {
+		t.Errorf("expected\n%s\ngot\n%s", expect, out.String())
+	}
+}
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		script, err := completionScript(shell)
+		if err != nil || !strings.Contains(script, "__complete") {
+			t.Errorf("expected a %s completion script that calls `osb __complete`, got %v", shell, err)
+		}
+	}
+	if _, err := completionScript("tcsh"); err == nil {
+		t.Errorf("an unsupported shell should fail")
+	}
+}
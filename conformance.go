@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+func ms(n int64) string {
+	return (time.Duration(n) * time.Millisecond).String()
+}
+
+func printTestStep(w io.Writer, indent string, s *api.TestStep) {
+	switch s.Result {
+	case api.TestPassed:
+		fmt.Fprintf(w, "%s%-24s @G{PASS}  %s\n", indent, s.Name, ms(s.Took))
+	case api.TestSkipped:
+		fmt.Fprintf(w, "%s%-24s @Y{SKIP}  %s\n", indent, s.Name, s.Reason)
+	case api.TestFailed:
+		fmt.Fprintf(w, "%s%-24s @R{FAIL}  %s\n", indent, s.Name, ms(s.Took))
+		for _, f := range s.Failures {
+			fmt.Fprintf(w, "%s    @R{-} %s\n", indent, f)
+		}
+		if n := len(s.Exchanges); n > 0 && s.Observed == nil {
+			fmt.Fprintf(w, "%s    > %s\n", indent, s.Exchanges[n-1])
+		}
+	}
+	if s.Observed != nil {
+		fmt.Fprintf(w, "%s    > %s\n", indent, s.Observed)
+	}
+	for _, warning := range s.Warnings {
+		fmt.Fprintf(w, "%s    @Y{!} %s\n", indent, warning)
+	}
+}
+
+func printConformance(w io.Writer, r *api.ConformanceReport) {
+	fmt.Fprintf(w, "broker @C{%s}\n\n", r.Broker)
+	printTestStep(w, "", r.Catalog)
+	for _, t := range r.Cases {
+		fmt.Fprintf(w, "\n@M{%s}  (instance %s)\n", t.Name(), t.InstanceID)
+		for _, s := range t.Steps {
+			printTestStep(w, "  ", s)
+		}
+	}
+	if t := r.Negative; t != nil {
+		fmt.Fprintf(w, "\n@M{negative tests}  (using %s)\n", t.Name())
+		for _, s := range t.Steps {
+			printTestStep(w, "  ", s)
+		}
+	}
+
+	fmt.Fprintf(w, "\n")
+	if r.Result == api.TestPassed {
+		fmt.Fprintf(w, "@G{%d passed}, %d failed, @Y{%d skipped} in %s\n", r.Passed, r.Failed, r.Skipped, ms(r.Took))
+	} else {
+		fmt.Fprintf(w, "%d passed, @R{%d failed}, @Y{%d skipped} in %s\n", r.Passed, r.Failed, r.Skipped, ms(r.Took))
+	}
+}
+
+// The JUnit XML format isn't really written down anywhere, but this
+// is the shape that Jenkins, GitLab, GitHub Actions et al. all read.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func seconds(n int64) string {
+	return fmt.Sprintf("%.3f", float64(n)/1000)
+}
+
+func (s *junitSuite) add(step *api.TestStep) {
+	c := junitCase{
+		Name:      step.Name,
+		Classname: s.Name,
+		Time:      seconds(step.Took),
+		SystemOut: strings.Join(step.Warnings, "\n"),
+	}
+
+	s.Tests++
+	switch step.Result {
+	case api.TestFailed:
+		s.Failures++
+		l := make([]string, 0)
+		l = append(l, step.Failures...)
+		for _, x := range step.Exchanges {
+			l = append(l, x.String())
+		}
+		c.Failure = &junitMessage{Message: step.Failures[0], Body: strings.Join(l, "\n")}
+	case api.TestSkipped:
+		s.Skipped++
+		c.Skipped = &junitMessage{Message: step.Reason}
+	}
+	s.Cases = append(s.Cases, c)
+}
+
+func junitConformance(w io.Writer, r *api.ConformanceReport) error {
+	out := junitSuites{
+		Name: "osb test " + r.Broker,
+		Time: seconds(r.Took),
+	}
+
+	catalog := junitSuite{Name: "catalog", Time: seconds(r.Catalog.Took)}
+	catalog.add(r.Catalog)
+	out.Suites = append(out.Suites, catalog)
+
+	for _, t := range r.Cases {
+		suite := junitSuite{Name: t.Name(), Time: seconds(t.Took)}
+		for _, s := range t.Steps {
+			suite.add(s)
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+	if t := r.Negative; t != nil {
+		suite := junitSuite{Name: "negative", Time: seconds(t.Took)}
+		for _, s := range t.Steps {
+			suite.add(s)
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+
+	for _, s := range out.Suites {
+		out.Tests += s.Tests
+		out.Failures += s.Failures
+		out.Skipped += s.Skipped
+	}
+
+	b, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header+string(b)+"\n")
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestConformanceOutput(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	b.On(osbtest.FetchInstance).Status(200).JSON(map[string]interface{}{"plan_id": 42})
+
+	report, err := c.Conform(api.ConformanceSpec{
+		Suite:    "lifecycle",
+		Plans:    []string{"db/small", "cache"},
+		Interval: 20 * time.Millisecond,
+		MaxWait:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unable to run conformance tests: %s", err)
+	}
+
+	var out bytes.Buffer
+	printConformance(&out, report)
+	for _, s := range []string{"db/small", "cache/tiny", "fetch instance", "FAIL", "SKIP", "failed"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected the report to mention '%s':\n%s", s, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := junitConformance(&out, report); err != nil {
+		t.Fatalf("unable to write JUnit XML: %s", err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(out.Bytes(), &suites); err != nil {
+		t.Fatalf("JUnit output isn't valid XML: %s\n%s", err, out.String())
+	}
+	if len(suites.Suites) != 3 || suites.Suites[1].Name != "db/small" {
+		t.Fatalf("expected suites for the catalog, db/small and cache/tiny, got %d", len(suites.Suites))
+	}
+	if suites.Failures != report.Failed || suites.Skipped != report.Skipped || suites.Tests != report.Passed+report.Failed+report.Skipped {
+		t.Errorf("expected %d tests, %d failures and %d skipped, got %d, %d and %d",
+			report.Passed+report.Failed+report.Skipped, report.Failed, report.Skipped,
+			suites.Tests, suites.Failures, suites.Skipped)
+	}
+	failed := 0
+	for _, c := range suites.Suites[1].Cases {
+		if c.Failure != nil {
+			failed++
+			if c.Name != "fetch instance" || !strings.Contains(c.Failure.Body, "GET /v2/service_instances/") {
+				t.Errorf("expected fetch instance to fail, with the exchange, got %s: %s", c.Name, c.Failure.Body)
+			}
+		}
+	}
+	if failed != 1 {
+		t.Errorf("expected one failure for db/small, got %d", failed)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jhunt/osb/api"
+)
+
+// credentials looks up the credentials for a binding in the store,
+// optionally refreshing them from the broker first (and updating
+// the store) if the service is bindings_retrievable.
+func credentials(c *api.Client, store *api.Store, bid string, refresh bool) map[string]interface{} {
+	if !refresh {
+		creds, err := store.GetBindingCredentials(c.URL, bid)
+		bail(err)
+		return creds
+	}
+
+	connecting()
+	instance, service, _, err := store.GetBindingDetails(c.URL, bid)
+	bail(err)
+
+	if _, retrievable := fetchCatalog(c).Retrievable(service); !retrievable {
+		fmt.Fprintf(os.Stderr, "@Y{bindings of service %s are not retrievable; using the credentials from ~/.osbrc}\n", service)
+		creds, err := store.GetBindingCredentials(c.URL, bid)
+		bail(err)
+		return creds
+	}
+
+	b, err := c.GetBinding(instance, bid)
+	bail(err)
+
+	store.SetBindingCredentials(c.URL, instance, bid, b.Credentials)
+	if err := store.Write(opt.Data); err != nil {
+		fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+	}
+	return b.Credentials
+}
+
+// envify flattens credentials into a map of environment variable
+// names (with the given prefix) to their values.
+func envify(creds map[string]interface{}, prefix, sep string) map[string]string {
+	env := make(map[string]string)
+	for k, v := range api.Flatten(creds, sep) {
+		env[api.EnvName(prefix+k)] = v
+	}
+	return env
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatCredentials(out io.Writer, creds map[string]interface{}, format, prefix, sep string) error {
+	switch format {
+	case "", "env":
+		env := envify(creds, prefix, sep)
+		for _, k := range sortedKeys(env) {
+			fmt.Fprintf(out, "export %s=%s\n", k, shellQuote(env[k]))
+		}
+		return nil
+
+	case "dotenv":
+		env := envify(creds, prefix, sep)
+		for _, k := range sortedKeys(env) {
+			fmt.Fprintf(out, "%s=%s\n", k, dotenvQuote(env[k]))
+		}
+		return nil
+
+	case "json":
+		b, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", string(b))
+		return nil
+
+	case "yaml":
+		b, err := yaml.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s", string(b))
+		return nil
+	}
+
+	return fmt.Errorf("unrecognized credentials format '%s' (try env, dotenv, json, or yaml)", format)
+}
+
+// credentialField pulls a single value out of the credentials, by
+// its flattened key.  Dotted paths (i.e. `db.host`) work too.
+func credentialField(creds map[string]interface{}, field, sep string) (string, error) {
+	flat := api.Flatten(creds, sep)
+	if v, ok := flat[field]; ok {
+		return v, nil
+	}
+	if v, ok := flat[strings.Replace(field, ".", sep, -1)]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("no such credential field '%s'", field)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func dotenvQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// bindingType works out the servicebinding.io type and provider
+// for a binding, from the cached catalog (if there is one).
+func bindingType(c *api.Client, store *api.Store, bid string) (string, string) {
+	_, service, _, err := store.GetBindingDetails(c.URL, bid)
+	bail(err)
+
+	cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+	bail(err)
+	if cat := cache.Get(c.URL); cat != nil {
+		return cat.BindingType(service)
+	}
+	return service, ""
+}
+
+type k8sSecret struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string            `yaml:"name"`
+		Namespace string            `yaml:"namespace,omitempty"`
+		Labels    map[string]string `yaml:"labels,omitempty"`
+	} `yaml:"metadata"`
+	Type string            `yaml:"type"`
+	Data map[string]string `yaml:"data"`
+}
+
+var notSecretKeySafe = regexp.MustCompile(`[^-._a-zA-Z0-9]+`)
+
+// k8sSecretFor builds a Kubernetes Secret out of the credentials,
+// with the servicebinding.io `type` and `provider` entries, so that
+// it can be projected into workloads as-is.
+func k8sSecretFor(creds map[string]interface{}, name, namespace, typ, provider, sep string) k8sSecret {
+	var s k8sSecret
+	s.APIVersion = "v1"
+	s.Kind = "Secret"
+	s.Metadata.Name = name
+	s.Metadata.Namespace = namespace
+	s.Metadata.Labels = map[string]string{"app.kubernetes.io/managed-by": "osb"}
+	s.Type = "servicebinding.io/" + typ
+	s.Data = make(map[string]string)
+
+	flat := api.Flatten(creds, sep)
+	flat["type"] = typ
+	if provider != "" {
+		flat["provider"] = provider
+	}
+	for k, v := range flat {
+		s.Data[notSecretKeySafe.ReplaceAllString(k, "_")] = base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+func TestFormatCredentials(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	creds := bind(t, c, store, "i-1", "b-1")
+
+	var out bytes.Buffer
+	if err := formatCredentials(&out, creds, "env", "db_", "_"); err != nil {
+		t.Fatalf("unable to format credentials as env: %s", err)
+	}
+	for _, line := range []string{
+		"export DB_HOSTNAME='db.mock'\n",
+		"export DB_PORT='5432'\n",
+		"export DB_PASSWORD='" + creds["password"].(string) + "'\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected env credentials to include %s, got\n%s", line, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := formatCredentials(&out, creds, "json", "", "_"); err != nil {
+		t.Fatalf("unable to format credentials as json: %s", err)
+	}
+	var back map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &back); err != nil || back["uri"] != creds["uri"] {
+		t.Errorf("expected json credentials to round-trip, got %v (%v)", back, err)
+	}
+
+	if err := formatCredentials(&out, creds, "toml", "", "_"); err == nil {
+		t.Errorf("an unknown credentials format should fail")
+	}
+}
+
+func TestCredentialQuoting(t *testing.T) {
+	creds := map[string]interface{}{
+		"password": `it's "$HOME" \ ` + "`id`\nand more",
+	}
+
+	// the shell gets back exactly what the broker sent
+	var out bytes.Buffer
+	if err := formatCredentials(&out, creds, "env", "", "_"); err != nil {
+		t.Fatalf("unable to format credentials as env: %s", err)
+	}
+	got, err := exec.Command("sh", "-c", out.String()+`printf %s "$PASSWORD"`).Output()
+	if err != nil {
+		t.Fatalf("unable to source env credentials: %s\n%s", err, out.String())
+	}
+	if string(got) != creds["password"] {
+		t.Errorf("expected the shell to see\n%s\nbut it saw\n%s", creds["password"], got)
+	}
+
+	out.Reset()
+	if err := formatCredentials(&out, creds, "dotenv", "", "_"); err != nil {
+		t.Fatalf("unable to format credentials as dotenv: %s", err)
+	}
+	if expect := `PASSWORD="it's \"$HOME\" \\ ` + "`id`" + `\nand more"` + "\n"; out.String() != expect {
+		t.Errorf("expected dotenv credentials\n%s\ngot\n%s", expect, out.String())
+	}
+}
+
+func TestCredentialField(t *testing.T) {
+	creds := map[string]interface{}{
+		"uri": "mock://db",
+		"db":  map[string]interface{}{"host": "db.mock", "port": 5432.0},
+	}
+
+	tests := map[string]string{
+		"uri":     "mock://db",
+		"db_host": "db.mock",
+		"db.host": "db.mock",
+		"db.port": "5432",
+	}
+	for field, expect := range tests {
+		if got, err := credentialField(creds, field, "_"); err != nil || got != expect {
+			t.Errorf("credential field %s: expected %s, got %s (%v)", field, expect, got, err)
+		}
+	}
+	if _, err := credentialField(creds, "db.user", "_"); err == nil {
+		t.Errorf("looking up a missing credential field should fail")
+	}
+
+	env := envify(creds, "my-", "_")
+	if env["MY_DB_HOST"] != "db.mock" || env["MY_URI"] != "mock://db" {
+		t.Errorf("expected prefixed environment variables, got %v", env)
+	}
+}
+
+func TestK8sSecret(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	defer useBroker(t, b)()
+
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	creds := bind(t, c, store, "i-1", "b-1")
+	fetchCatalog(c)
+
+	typ, provider := bindingType(c, store, "b-1")
+	if typ != "db" || provider != "" {
+		t.Errorf("expected untagged db bindings to be of type db, with no provider, but got %s / '%s'", typ, provider)
+	}
+
+	s := k8sSecretFor(creds, "my-db", "apps", typ, provider, "_")
+	if s.Kind != "Secret" || s.Type != "servicebinding.io/db" || s.Metadata.Name != "my-db" || s.Metadata.Namespace != "apps" {
+		t.Errorf("expected a servicebinding.io/db Secret named apps/my-db, got %+v", s)
+	}
+	for k, v := range map[string]string{
+		"type":     "db",
+		"hostname": "db.mock",
+		"password": creds["password"].(string),
+	} {
+		got, err := base64.StdEncoding.DecodeString(s.Data[k])
+		if err != nil || string(got) != v {
+			t.Errorf("expected secret data %s to be '%s', got '%s' (%v)", k, v, got, err)
+		}
+	}
+	if _, ok := s.Data["provider"]; ok {
+		t.Errorf("a secret without a provider should not have a provider entry")
+	}
+
+	// keys have to be valid Secret keys
+	s = k8sSecretFor(map[string]interface{}{"db": map[string]interface{}{"host name": "x"}}, "x", "", "x", "", "/")
+	if _, ok := s.Data["db_host_name"]; !ok {
+		t.Errorf("expected db/host name to become db_host_name, got %v", s.Data)
+	}
+}
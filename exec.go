@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	fmt "github.com/jhunt/go-ansi"
+)
+
+// run executes a child process with the given extra environment,
+// passing through stdin / stdout / stderr and any signals that
+// we receive, and returns the exit code that osb should exit with.
+func run(command []string, env map[string]string) int {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for _, k := range sortedKeys(env) {
+		cmd.Env = append(cmd.Env, k+"="+env[k])
+	}
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
+		return 127
+	}
+
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err := cmd.Wait()
+	signal.Stop(signals)
+	close(signals)
+
+	if err == nil {
+		return 0
+	}
+	if exit, ok := err.(*exec.ExitError); ok {
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return 128 + int(ws.Signal())
+			}
+			return ws.ExitStatus()
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "@R{!!! %s}\n", err)
+	return 1
+}
+
+// tempBindingRoot creates a private, temporary directory to serve as
+// the $SERVICE_BINDING_ROOT for a child process.  The caller is
+// responsible for removing it once the child exits.
+func tempBindingRoot() (string, error) {
+	dir, err := ioutil.TempDir("", "osb-bindings-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return filepath.Clean(dir), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func TestRun(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	creds := bind(t, c, store, "i-1", "b-1")
+
+	dir, err := ioutil.TempDir("", "osb-exec")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	// the child sees the credentials in its environment
+	env := envify(creds, "db_", "_")
+	if rc := run([]string{"sh", "-c", `printf %s "$DB_PASSWORD@$DB_HOSTNAME" > ` + out}, env); rc != 0 {
+		t.Fatalf("expected the child to exit 0, but it exited %d", rc)
+	}
+	got, _ := ioutil.ReadFile(out)
+	if expect := creds["password"].(string) + "@db.mock"; string(got) != expect {
+		t.Errorf("expected the child to see %s, but it saw %s", expect, got)
+	}
+
+	// and osb exits the way the child did
+	if rc := run([]string{"sh", "-c", "exit 3"}, nil); rc != 3 {
+		t.Errorf("expected to exit 3, like the child, but got %d", rc)
+	}
+	if rc := run([]string{"sh", "-c", "kill -TERM $$"}, nil); rc != 128+15 {
+		t.Errorf("expected to exit 143 for a child killed by SIGTERM, but got %d", rc)
+	}
+	if rc := run([]string{filepath.Join(dir, "no-such-command")}, nil); rc != 127 {
+		t.Errorf("expected to exit 127 for a missing command, but got %d", rc)
+	}
+}
+
+func TestRefreshCredentials(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	defer useBroker(t, b)()
+
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	creds := bind(t, c, store, "i-1", "b-1")
+	store.SetBindingCredentials(c.URL, "i-1", "b-1", map[string]interface{}{"password": "stale"})
+
+	if got := credentials(c, store, "b-1", false); got["password"] != "stale" {
+		t.Errorf("without --refresh, the credentials should come from the store, not %v", got)
+	}
+
+	got := credentials(c, store, "b-1", true)
+	if got["password"] != creds["password"] {
+		t.Errorf("with --refresh, the credentials should come from the broker, not %v", got)
+	}
+	saved, err := api.ReadStore(opt.Data)
+	if err != nil {
+		t.Fatalf("unable to read the store back: %s", err)
+	}
+	if got, _ := saved.GetBindingCredentials(c.URL, "b-1"); got["password"] != creds["password"] {
+		t.Errorf("refreshed credentials should be saved in the store, not %v", got)
+	}
+
+	// only the refresh went to the broker
+	b.Expect(osbtest.FetchBinding).Times(1)
+}
+
+func TestTempBindingRoot(t *testing.T) {
+	dir, err := tempBindingRoot()
+	if err != nil {
+		t.Fatalf("unable to create a binding root: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	st, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("unable to stat the binding root: %s", err)
+	}
+	if !st.IsDir() || st.Mode().Perm() != 0700 {
+		t.Errorf("the binding root should be a private directory, but it is %s", st.Mode())
+	}
+}
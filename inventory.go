@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+	"gopkg.in/yaml.v2"
+)
+
+// readInventory parses an inventory of instances and bindings to
+// import, in the same shape that `osb list` emits via --format json,
+// yaml or csv, so that one store can be imported into another.
+func readInventory(path, format string) ([]listing, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		case ".tsv":
+			format = "tsv"
+		default:
+			format = "yaml"
+		}
+	}
+
+	var ll []listing
+	switch format {
+	case "json", "yaml", "yml":
+		// JSON is a subset of YAML, for our purposes
+		if err := yaml.Unmarshal(b, &ll); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+	case "csv", "tsv":
+		ll, err = readInventoryCSV(bytes.NewReader(b), format == "tsv")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+	default:
+		return nil, fmt.Errorf("unrecognized inventory format '%s' (try json, yaml, csv, or tsv)", format)
+	}
+
+	for i := range ll {
+		if ll[i].Kind == "" {
+			if ll[i].Binding != "" {
+				ll[i].Kind = "binding"
+			} else {
+				ll[i].Kind = "instance"
+			}
+		}
+		if ll[i].ID == "" {
+			if ll[i].Kind == "binding" {
+				ll[i].ID = ll[i].Binding
+			} else {
+				ll[i].ID = ll[i].Instance
+			}
+		}
+		if ll[i].Kind == "instance" && ll[i].Instance == "" {
+			ll[i].Instance = ll[i].ID
+		}
+		if ll[i].ID == "" {
+			return nil, fmt.Errorf("%s: record #%d has no id", path, i+1)
+		}
+		if ll[i].Kind != "instance" && ll[i].Kind != "binding" {
+			return nil, fmt.Errorf("%s: record #%d is of unrecognized kind '%s'", path, i+1, ll[i].Kind)
+		}
+	}
+	return ll, nil
+}
+
+func readInventoryCSV(in io.Reader, tabs bool) ([]listing, error) {
+	r := csv.NewReader(in)
+	if tabs {
+		r.Comma = '\t'
+	}
+	r.FieldsPerRecord = -1
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := make(map[string]int)
+	for i, h := range rows[0] {
+		header[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := header["id"]; !ok {
+		if _, ok := header["instance"]; !ok {
+			return nil, fmt.Errorf("missing either an `id` or `instance` column in the header")
+		}
+	}
+
+	ll := make([]listing, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		col := func(name string) string {
+			if i, ok := header[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		l := listing{
+			Kind:      col("kind"),
+			ID:        col("id"),
+			Name:      col("name"),
+			Broker:    col("broker"),
+			Instance:  col("instance"),
+			Binding:   col("binding"),
+			Service:   col("service"),
+			Plan:      col("plan"),
+			ServiceID: col("service_id"),
+			PlanID:    col("plan_id"),
+		}
+
+		if s := col("labels"); s != "" {
+			if l.Labels, err = parseLabels(strings.Split(s, ",")); err != nil {
+				return nil, fmt.Errorf("row %d: %s", n+2, err)
+			}
+		}
+		if s := col("credentials"); s != "" {
+			if err := json.Unmarshal([]byte(s), &l.Credentials); err != nil {
+				return nil, fmt.Errorf("row %d: invalid credentials: %s", n+2, err)
+			}
+		}
+		ll = append(ll, l)
+	}
+	return ll, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadInventory(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := newListStore(t, c)
+	cache := catalogs(t, c)
+
+	dir, err := ioutil.TempDir("", "osb-inventory")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// whatever `osb list` puts out, `osb import` takes back in
+	for format, file := range map[string]string{
+		"json": "inventory.json",
+		"yaml": "inventory.yml",
+		"csv":  "inventory.csv",
+		"tsv":  "inventory.tsv",
+	} {
+		var out bytes.Buffer
+		if err := outputListings(&out, listings(store, cache, listFilter{}), format, true); err != nil {
+			t.Fatalf("unable to output %s: %s", format, err)
+		}
+		path := filepath.Join(dir, file)
+		if err := ioutil.WriteFile(path, out.Bytes(), 0666); err != nil {
+			t.Fatalf("unable to write %s: %s", path, err)
+		}
+
+		ll, err := readInventory(path, "")
+		if err != nil {
+			t.Errorf("unable to read back %s inventory: %s", format, err)
+			continue
+		}
+		if got := ids(ll); got != "i-1 b-1 i-2" {
+			t.Errorf("expected [i-1 b-1 i-2] back from %s inventory, got [%s]", format, got)
+			continue
+		}
+		if ll[1].Kind != "binding" || ll[1].Instance != "i-1" || ll[1].Credentials["username"] == nil {
+			t.Errorf("expected b-1 back from %s inventory, with its instance and credentials, got %+v", format, ll[1])
+		}
+		if ll[0].ServiceID != "db" || ll[0].PlanID != "db-small" || ll[0].Labels["env"] != "prod" {
+			t.Errorf("expected i-1 back from %s inventory, with its service, plan and labels, got %+v", format, ll[0])
+		}
+	}
+}
+
+func TestReadInventoryMinimal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-inventory")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// kinds and ids are worked out from the instance and binding columns
+	path := filepath.Join(dir, "inventory.csv")
+	ioutil.WriteFile(path, []byte("instance,binding,service,plan\ni-1,,db,small\ni-1,b-1,,\n"), 0666)
+	ll, err := readInventory(path, "")
+	if err != nil {
+		t.Fatalf("unable to read inventory: %s", err)
+	}
+	if len(ll) != 2 || ll[0].Kind != "instance" || ll[0].ID != "i-1" || ll[1].Kind != "binding" || ll[1].ID != "b-1" {
+		t.Errorf("expected instance i-1 and binding b-1, got %+v", ll)
+	}
+
+	ioutil.WriteFile(path, []byte("service,plan\ndb,small\n"), 0666)
+	if _, err := readInventory(path, ""); err == nil {
+		t.Errorf("an inventory without ids should fail")
+	}
+
+	path = filepath.Join(dir, "inventory.yml")
+	ioutil.WriteFile(path, []byte("- kind: volume\n  id: v-1\n"), 0666)
+	if _, err := readInventory(path, ""); err == nil {
+		t.Errorf("an inventory of unknown kinds should fail")
+	}
+	if _, err := readInventory(path, "xml"); err == nil {
+		t.Errorf("an unknown inventory format should fail")
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-table"
+	"gopkg.in/yaml.v2"
+
+	"github.com/jhunt/osb/api"
+)
+
+// a listing is a single instance or binding record from
+// the store, flattened out so that it can be filtered,
+// sorted and formatted without caring about nesting.
+type listing struct {
+	Kind     string `json:"kind"     yaml:"kind"`
+	ID       string `json:"id"       yaml:"id"`
+	Broker   string `json:"broker"   yaml:"broker"`
+	Instance string `json:"instance" yaml:"instance"`
+	Binding  string `json:"binding"  yaml:"binding,omitempty"`
+
+	Name         string `json:"name,omitempty"          yaml:"name,omitempty"`
+	InstanceName string `json:"instance_name,omitempty" yaml:"instance_name,omitempty"`
+
+	ServiceID string `json:"service_id" yaml:"service_id"`
+	PlanID    string `json:"plan_id"    yaml:"plan_id"`
+	Service   string `json:"service"    yaml:"service"`
+	Plan      string `json:"plan"       yaml:"plan"`
+
+	Labels      map[string]string      `json:"labels,omitempty"      yaml:"labels,omitempty"`
+	Created     time.Time              `json:"created"               yaml:"created,omitempty"`
+	Credentials map[string]interface{} `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
+
+type listFilter struct {
+	Broker    string
+	Service   string
+	Plan      string
+	Labels    map[string]string
+	OlderThan time.Duration
+}
+
+func (f listFilter) matches(l listing) bool {
+	if f.Broker != "" && !strings.Contains(l.Broker, f.Broker) {
+		return false
+	}
+	if f.Service != "" && f.Service != l.ServiceID && f.Service != l.Service {
+		return false
+	}
+	if f.Plan != "" && f.Plan != l.PlanID && f.Plan != l.Plan {
+		return false
+	}
+	for k, v := range f.Labels {
+		if have, ok := l.Labels[k]; !ok || (v != "" && have != v) {
+			return false
+		}
+	}
+	if f.OlderThan > 0 && (l.Created.IsZero() || time.Since(l.Created) < f.OlderThan) {
+		return false
+	}
+	return true
+}
+
+func listings(store *api.Store, cache *api.CatalogCache, f listFilter) []listing {
+	ll := make([]listing, 0)
+	for _, broker := range store.Data {
+		url := strings.TrimSuffix(broker.Broker, "/")
+		for _, instance := range broker.Instances {
+			service, plan := instance.ServiceID, instance.PlanID
+			if cat := cache.Get(url); cat != nil {
+				service, plan = cat.Names(instance.ServiceID, instance.PlanID)
+			}
+
+			inst := listing{
+				Kind:      "instance",
+				ID:        instance.ID,
+				Broker:    url,
+				Instance:  instance.ID,
+				Name:      instance.Name,
+				ServiceID: instance.ServiceID,
+				PlanID:    instance.PlanID,
+				Service:   service,
+				Plan:      plan,
+				Labels:    instance.Labels,
+				Created:   instance.CreatedAt,
+
+				InstanceName: instance.Name,
+			}
+			if f.matches(inst) {
+				ll = append(ll, inst)
+			}
+
+			for _, binding := range instance.Bindings {
+				l := inst
+				l.Kind = "binding"
+				l.ID = binding.ID
+				l.Binding = binding.ID
+				l.Name = binding.Name
+				l.Created = binding.CreatedAt
+				l.Credentials = binding.Credentials
+				if len(binding.Labels) > 0 {
+					l.Labels = make(map[string]string)
+					for k, v := range instance.Labels {
+						l.Labels[k] = v
+					}
+					for k, v := range binding.Labels {
+						l.Labels[k] = v
+					}
+				}
+				if f.matches(l) {
+					ll = append(ll, l)
+				}
+			}
+		}
+	}
+	return ll
+}
+
+func sortListings(ll []listing, by string, reverse bool) error {
+	var key func(l listing) string
+	switch by {
+	case "":
+		if reverse {
+			for i, j := 0, len(ll)-1; i < j; i, j = i+1, j-1 {
+				ll[i], ll[j] = ll[j], ll[i]
+			}
+		}
+		return nil
+	case "broker":
+		key = func(l listing) string { return l.Broker }
+	case "id":
+		key = func(l listing) string { return l.ID }
+	case "name", "alias":
+		key = func(l listing) string { return l.Name }
+	case "instance":
+		key = func(l listing) string { return l.Instance }
+	case "binding":
+		key = func(l listing) string { return l.Binding }
+	case "service":
+		key = func(l listing) string { return l.Service }
+	case "plan":
+		key = func(l listing) string { return l.Plan }
+	case "created", "age":
+		key = func(l listing) string { return l.Created.UTC().Format(time.RFC3339Nano) }
+	default:
+		return fmt.Errorf("unable to sort by '%s' (try broker, id, name, instance, binding, service, plan, or created)", by)
+	}
+
+	sort.SliceStable(ll, func(i, j int) bool {
+		if reverse {
+			return key(ll[i]) > key(ll[j])
+		}
+		return key(ll[i]) < key(ll[j])
+	})
+	return nil
+}
+
+func outputListings(out io.Writer, ll []listing, format string, creds bool) error {
+	if !creds {
+		for i := range ll {
+			ll[i].Credentials = nil
+		}
+	}
+
+	if strings.Contains(format, "{{") {
+		tpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return err
+		}
+		for _, l := range ll {
+			if err := tpl.Execute(out, l); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "\n")
+		}
+		return nil
+	}
+
+	switch format {
+	case "", "table":
+		return tabulateListings(out, ll, false, creds)
+
+	case "wide":
+		return tabulateListings(out, ll, true, creds)
+
+	case "yaml":
+		b, err := yaml.Marshal(ll)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s", string(b))
+		return nil
+
+	case "json":
+		b, err := json.Marshal(ll)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", string(b))
+		return nil
+
+	case "csv", "tsv":
+		w := csv.NewWriter(out)
+		if format == "tsv" {
+			w.Comma = '\t'
+		}
+		header := []string{"kind", "id", "name", "broker", "instance", "binding", "service", "plan", "service_id", "plan_id", "labels", "created"}
+		if creds {
+			header = append(header, "credentials")
+		}
+		w.Write(header)
+		for _, l := range ll {
+			row := []string{l.Kind, l.ID, l.Name, l.Broker, l.Instance, l.Binding, l.Service, l.Plan, l.ServiceID, l.PlanID, labelString(l.Labels, ","), timeString(l.Created)}
+			if creds {
+				c := ""
+				if l.Credentials != nil {
+					b, err := json.Marshal(l.Credentials)
+					if err != nil {
+						return err
+					}
+					c = string(b)
+				}
+				row = append(row, c)
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	return fmt.Errorf("unrecognized output format '%s' (try table, wide, yaml, json, csv, tsv, or a {{template}})", format)
+}
+
+func tabulateListings(out io.Writer, ll []listing, wide, creds bool) error {
+	// instances only get their own row if none
+	// of their bindings made it into the listing
+	bound := make(map[string]bool)
+	for _, l := range ll {
+		if l.Kind == "binding" {
+			bound[l.Broker+" "+l.Instance] = true
+		}
+	}
+
+	header := []string{"Broker", "Instance", "Service", "Plan", "Binding"}
+	if wide {
+		header = []string{"Broker", "Instance", "Service", "(ID)", "Plan", "(ID)", "Binding", "Labels", "Created"}
+	}
+	if creds {
+		header = append(header, "Credentials")
+	}
+	t := table.NewTable(header...)
+
+	var prev listing
+	for _, l := range ll {
+		if l.Kind == "instance" && bound[l.Broker+" "+l.Instance] {
+			continue
+		}
+
+		bname, inst, service, serviceID, plan, planID := l.Broker, l.Instance, l.Service, l.ServiceID, l.Plan, l.PlanID
+		if l.Broker == prev.Broker {
+			bname = ""
+			if l.Instance == prev.Instance {
+				inst, service, serviceID, plan, planID = "", "", "", "", ""
+			}
+		}
+		prev = l
+
+		if inst != "" && l.InstanceName != "" {
+			inst = fmt.Sprintf("%s (%s)", l.InstanceName, inst)
+		}
+		bid := "-"
+		if l.Kind == "binding" {
+			bid = l.Binding
+			if l.Name != "" {
+				bid = fmt.Sprintf("%s (%s)", l.Name, bid)
+			}
+		}
+
+		row := []interface{}{bname, inst, service, plan, bid}
+		if wide {
+			row = []interface{}{bname, inst, service, serviceID, plan, planID, bid, labelString(l.Labels, "\n"), timeString(l.Created)}
+		}
+		if creds {
+			if l.Kind != "binding" {
+				row = append(row, "-")
+			} else if b, err := json.MarshalIndent(l.Credentials, "", "  "); err != nil {
+				row = append(row, fmt.Sprintf("error: %s", err))
+			} else {
+				row = append(row, string(b))
+			}
+		}
+		t.Row(nil, row...)
+	}
+	t.Output(out)
+	return nil
+}
+
+func labelString(labels map[string]string, sep string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	l := make([]string, len(keys))
+	for i, k := range keys {
+		l[i] = k + "=" + labels[k]
+	}
+	return strings.Join(l, sep)
+}
+
+func timeString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseLabels(in []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, s := range in {
+		l := strings.SplitN(s, "=", 2)
+		if l[0] == "" {
+			return nil, fmt.Errorf("invalid label '%s' (should be key=value)", s)
+		}
+		if len(l) == 1 {
+			labels[l[0]] = ""
+		} else {
+			labels[l[0]] = l[1]
+		}
+	}
+	return labels, nil
+}
+
+// parseAge is like time.ParseDuration, but also understands
+// days (as in `7d`), which are far more useful for ages.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age '%s'", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
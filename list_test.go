@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+)
+
+func newListStore(t *testing.T, c *api.Client) *api.Store {
+	t.Helper()
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	provision(t, c, store, "i-2", "cache", "cache-tiny")
+	bind(t, c, store, "i-1", "b-1")
+	store.LabelInstance(c.URL, "i-1", map[string]string{"env": "prod"})
+	store.LabelBinding(c.URL, "i-1", "b-1", map[string]string{"role": "app"})
+	return store
+}
+
+func ids(ll []listing) string {
+	l := make([]string, len(ll))
+	for i := range ll {
+		l[i] = ll[i].ID
+	}
+	return strings.Join(l, " ")
+}
+
+func TestListings(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := newListStore(t, c)
+	cache := catalogs(t, c)
+
+	tests := []struct {
+		name   string
+		filter listFilter
+		expect string
+	}{
+		{"everything", listFilter{}, "i-1 b-1 i-2"},
+		{"by broker", listFilter{Broker: strings.TrimPrefix(c.URL, "http://")}, "i-1 b-1 i-2"},
+		{"by other broker", listFilter{Broker: "elsewhere"}, ""},
+		{"by service id", listFilter{Service: "cache"}, "i-2"},
+		{"by plan name", listFilter{Plan: "small"}, "i-1 b-1"},
+		{"by plan id", listFilter{Plan: "db-small"}, "i-1 b-1"},
+		{"by label", listFilter{Labels: map[string]string{"env": "prod"}}, "i-1 b-1"},
+		{"by bare label", listFilter{Labels: map[string]string{"env": ""}}, "i-1 b-1"},
+		{"by binding label", listFilter{Labels: map[string]string{"role": "app"}}, "b-1"},
+		{"by mismatched label", listFilter{Labels: map[string]string{"env": "dev"}}, ""},
+		{"by age", listFilter{OlderThan: time.Hour}, ""},
+	}
+	for _, test := range tests {
+		if got := ids(listings(store, cache, test.filter)); got != test.expect {
+			t.Errorf("listing %s: expected [%s], got [%s]", test.name, test.expect, got)
+		}
+	}
+
+	// without a catalog, only the IDs are known
+	if got := ids(listings(store, nil, listFilter{Plan: "small"})); got != "" {
+		t.Errorf("listing by plan name without a catalog should find nothing, got [%s]", got)
+	}
+
+	for _, l := range listings(store, cache, listFilter{}) {
+		if l.Kind == "binding" && l.Labels["env"] != "prod" {
+			t.Errorf("binding %s should have its instance's labels, but has %v", l.ID, l.Labels)
+		}
+		if l.ID == "i-1" && (l.Service != "db" || l.Plan != "small") {
+			t.Errorf("i-1 should be db/small, but is %s/%s", l.Service, l.Plan)
+		}
+	}
+}
+
+func TestSortListings(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := newListStore(t, c)
+	cache := catalogs(t, c)
+
+	tests := []struct {
+		by      string
+		reverse bool
+		expect  string
+	}{
+		{"", false, "i-1 b-1 i-2"},
+		{"", true, "i-2 b-1 i-1"},
+		{"id", false, "b-1 i-1 i-2"},
+		{"service", false, "i-2 i-1 b-1"},
+		{"service", true, "i-1 b-1 i-2"},
+		{"binding", true, "b-1 i-1 i-2"},
+	}
+	for _, test := range tests {
+		ll := listings(store, cache, listFilter{})
+		if err := sortListings(ll, test.by, test.reverse); err != nil {
+			t.Errorf("unable to sort by '%s': %s", test.by, err)
+			continue
+		}
+		if got := ids(ll); got != test.expect {
+			t.Errorf("sorting by '%s' (reverse %v): expected [%s], got [%s]", test.by, test.reverse, test.expect, got)
+		}
+	}
+
+	if err := sortListings(listings(store, cache, listFilter{}), "color", false); err == nil {
+		t.Errorf("sorting by an unknown key should fail")
+	}
+}
+
+func TestOutputListings(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := newListStore(t, c)
+	cache := catalogs(t, c)
+
+	var out bytes.Buffer
+	if err := outputListings(&out, listings(store, cache, listFilter{}), "json", false); err != nil {
+		t.Fatalf("unable to output json: %s", err)
+	}
+	var ll []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &ll); err != nil {
+		t.Fatalf("json output isn't valid json: %s\n%s", err, out.String())
+	}
+	if len(ll) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(ll))
+	}
+	for _, l := range ll {
+		if _, ok := l["credentials"]; ok {
+			t.Errorf("%s should not have credentials without --creds", l["id"])
+		}
+	}
+
+	out.Reset()
+	if err := outputListings(&out, listings(store, cache, listFilter{}), "json", true); err != nil {
+		t.Fatalf("unable to output json: %s", err)
+	}
+	if !strings.Contains(out.String(), `"credentials":`) {
+		t.Errorf("json output should have credentials with --creds:\n%s", out.String())
+	}
+
+	for _, format := range []string{"csv", "tsv"} {
+		out.Reset()
+		if err := outputListings(&out, listings(store, cache, listFilter{}), format, false); err != nil {
+			t.Fatalf("unable to output %s: %s", format, err)
+		}
+		r := csv.NewReader(&out)
+		if format == "tsv" {
+			r.Comma = '\t'
+		}
+		rows, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s output isn't valid %s: %s", format, format, err)
+		}
+		if len(rows) != 4 || rows[0][0] != "kind" {
+			t.Fatalf("expected a header and 3 rows of %s, got %v", format, rows)
+		}
+		if rows[2][0] != "binding" || rows[2][1] != "b-1" || rows[2][10] != "env=prod,role=app" {
+			t.Errorf("expected the b-1 binding, with merged labels, on the second row of %s, got %v", format, rows[2])
+		}
+	}
+
+	out.Reset()
+	if err := outputListings(&out, listings(store, cache, listFilter{}), "{{.Kind}} {{.ID}} {{.Plan}}", false); err != nil {
+		t.Fatalf("unable to output a template: %s", err)
+	}
+	if expect := "instance i-1 small\nbinding b-1 small\ninstance i-2 tiny\n"; out.String() != expect {
+		t.Errorf("expected template output\n%s\ngot\n%s", expect, out.String())
+	}
+
+	out.Reset()
+	if err := outputListings(&out, listings(store, cache, listFilter{}), "yaml", false); err != nil {
+		t.Fatalf("unable to output yaml: %s", err)
+	}
+	if !strings.Contains(out.String(), "- kind: binding\n  id: b-1\n") {
+		t.Errorf("yaml output is missing the b-1 binding:\n%s", out.String())
+	}
+
+	if err := outputListings(&out, listings(store, cache, listFilter{}), "xml", false); err == nil {
+		t.Errorf("an unknown format should fail")
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"env=prod", "team", "note=a=b"})
+	if err != nil {
+		t.Fatalf("unable to parse labels: %s", err)
+	}
+	if labels["env"] != "prod" || labels["note"] != "a=b" {
+		t.Errorf("labels parsed wrong: %v", labels)
+	}
+	if v, ok := labels["team"]; !ok || v != "" {
+		t.Errorf("a bare label should match any value, but got %v", labels)
+	}
+
+	if _, err := parseLabels([]string{"=prod"}); err == nil {
+		t.Errorf("a label without a key should fail")
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := map[string]time.Duration{
+		"7d":   7 * 24 * time.Hour,
+		"1.5d": 36 * time.Hour,
+		"90m":  90 * time.Minute,
+	}
+	for s, expect := range tests {
+		if d, err := parseAge(s); err != nil || d != expect {
+			t.Errorf("parseAge(%s): expected %s, got %s (%v)", s, expect, d, err)
+		}
+	}
+	if _, err := parseAge("xd"); err == nil {
+		t.Errorf("parseAge(xd) should fail")
+	}
+}
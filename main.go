@@ -2,19 +2,29 @@ package main
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	fmt "github.com/jhunt/go-ansi"
 	"github.com/jhunt/go-cli"
 	env "github.com/jhunt/go-envirotron"
 	"github.com/jhunt/go-table"
+	"gopkg.in/yaml.v2"
 
 	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/mock"
+	"github.com/jhunt/osb/proxy"
 )
 
 var Version = "(development version)"
 
+var journal *api.Journal
+
 var opt struct {
 	Help  bool `cli:"-h, --help"`
 	Trace bool `cli:"-T, --trace" env:"OSB_TRACE"`
@@ -28,22 +38,47 @@ var opt struct {
 	Password   string `cli:"-P, --password" env:"OSB_PASSWORD"`
 	SkipVerify bool   `cli:"-k, --skip-verify" env:"OSB_SKIP_VERIFY"`
 	Timeout    int    `cli:"-t, --timeout" env:"OSB_TIMEOUT"`
+	Profile    string `cli:"--profile" env:"OSB_PROFILE"`
+	CFOrg      string `cli:"--cf-org" env:"OSB_CF_ORG"`
+	CFSpace    string `cli:"--cf-space" env:"OSB_CF_SPACE"`
+	Namespace  string `cli:"--k8s-namespace" env:"OSB_K8S_NAMESPACE"`
+	Cluster    string `cli:"--k8s-cluster" env:"OSB_K8S_CLUSTER"`
+	Seed       string `cli:"--seed" env:"OSB_SEED"`
+
+	Record string `cli:"--record" env:"OSB_RECORD"`
+	Replay string `cli:"--replay" env:"OSB_REPLAY"`
+	Match  string `cli:"--match"`
+	Strict bool   `cli:"--strict"`
 
 	JSON bool `cli:"--json"`
 
-	List struct{} `cli:"list, ls"`
-	Env  struct{} `cli:"env"`
+	List struct {
+		Broker      string   `cli:"-b, --broker"`
+		Service     string   `cli:"-s, --service"`
+		Plan        string   `cli:"-p, --plan"`
+		Labels      []string `cli:"-l, --label"`
+		OlderThan   string   `cli:"--older-than"`
+		Sort        string   `cli:"--sort"`
+		Reverse     bool     `cli:"-r, --reverse"`
+		Credentials bool     `cli:"--credentials, --no-credentials"`
+		Format      string   `cli:"-o, --format"`
+	} `cli:"list, ls"`
+	Env struct{} `cli:"env"`
 
 	Catalog struct{} `cli:"catalog"`
 
 	Provision struct {
-		ID string `cli:"-i, --instance, --id"`
+		ID     string   `cli:"-i, --instance, --id"`
+		Name   string   `cli:"-n, --name"`
+		Labels []string `cli:"-l, --label"`
 	} `cli:"provision, prov, create"`
 
 	Bind struct {
-		Service string `cli:"-s, --service"`
-		Plan    string `cli:"-p, --plan"`
-		ID      string `cli:"-i, --binding, --id"`
+		Service string   `cli:"-s, --service"`
+		Plan    string   `cli:"-p, --plan"`
+		ID      string   `cli:"-i, --binding, --id"`
+		Name    string   `cli:"-n, --name"`
+		Labels  []string `cli:"-l, --label"`
 	} `cli:"bind"`
 
 	Unbind struct {
@@ -52,6 +87,142 @@ var opt struct {
 		ID      string `cli:"-i, --binding, --id"`
 	} `cli:"unbind"`
 
+	Sync struct {
+		Apply bool `cli:"--apply"`
+	} `cli:"sync"`
+
+	Adopt struct {
+		Service string   `cli:"-s, --service"`
+		Plan    string   `cli:"-p, --plan"`
+		Labels  []string `cli:"-l, --label"`
+	} `cli:"adopt"`
+
+	AdoptBinding struct {
+		Instance    string   `cli:"-i, --instance"`
+		Credentials string   `cli:"-c, --credentials"`
+		Labels      []string `cli:"-l, --label"`
+	} `cli:"adopt-binding"`
+
+	Forget struct{} `cli:"forget"`
+
+	Import struct {
+		Format string `cli:"-f, --format"`
+	} `cli:"import"`
+
+	Teardown struct {
+		Broker    string   `cli:"-b, --broker"`
+		Service   string   `cli:"-s, --service"`
+		Plan      string   `cli:"-p, --plan"`
+		Labels    []string `cli:"-l, --label"`
+		OlderThan string   `cli:"--older-than"`
+		Parallel  int      `cli:"-j, --parallel"`
+		MaxWait   string   `cli:"-w, --max-wait"`
+		DryRun    bool     `cli:"-n, --dry-run"`
+		Yes       bool     `cli:"-y, --yes"`
+	} `cli:"teardown"`
+
+	Creds struct {
+		Format    string `cli:"-o, --format"`
+		Env       bool   `cli:"--env"`
+		Dotenv    bool   `cli:"--dotenv"`
+		YAML      bool   `cli:"--yaml"`
+		Field     string `cli:"-f, --field"`
+		Prefix    string `cli:"--prefix"`
+		Separator string `cli:"--separator"`
+		Refresh   bool   `cli:"-r, --refresh"`
+		Name      string `cli:"--name"`
+		Namespace string `cli:"--namespace"`
+		Dir       string `cli:"-d, --dir"`
+	} `cli:"creds"`
+
+	Exec struct {
+		Env         bool   `cli:"--env, --no-env"`
+		VCAP        bool   `cli:"--vcap"`
+		BindingRoot bool   `cli:"--binding-root"`
+		Prefix      string `cli:"--prefix"`
+		Separator   string `cli:"--separator"`
+		Refresh     bool   `cli:"-r, --refresh"`
+	} `cli:"exec"`
+
+	VCAP struct {
+		Pretty bool `cli:"-p, --pretty"`
+		Env    bool `cli:"--env"`
+	} `cli:"vcap"`
+
+	Render struct {
+		Bindings []string `cli:"-b, --binding"`
+		Template string   `cli:"-f, --template"`
+		Out      string   `cli:"-o, --out"`
+		Refresh  bool     `cli:"-r, --refresh"`
+	} `cli:"render"`
+
+	Apply struct {
+		Prune   bool   `cli:"--prune"`
+		MaxWait string `cli:"-w, --max-wait"`
+		DryRun  bool   `cli:"-n, --dry-run"`
+		Yes     bool   `cli:"-y, --yes"`
+	} `cli:"apply"`
+
+	Batch struct {
+		Parallel int    `cli:"-j, --parallel"`
+		MaxWait  string `cli:"-w, --max-wait"`
+		Retries  int    `cli:"--retries"`
+	} `cli:"batch"`
+
+	Test struct {
+		Suite      string `cli:"--suite"`
+		Format     string `cli:"-o, --format"`
+		JUnit      string `cli:"--junit"`
+		Parameters string `cli:"--params"`
+		MaxWait    string `cli:"-w, --max-wait"`
+	} `cli:"test"`
+
+	Run struct {
+		Vars    []string `cli:"--var"`
+		JUnit   string   `cli:"--junit"`
+		MaxWait string   `cli:"-w, --max-wait"`
+	} `cli:"run"`
+
+	Stress struct {
+		Workload   string `cli:"--workload"`
+		Parallel   int    `cli:"-j, --parallel"`
+		Parameters string `cli:"--params"`
+		MaxWait    string `cli:"-w, --max-wait"`
+	} `cli:"stress"`
+
+	Bench struct {
+		Workload   string  `cli:"--workload"`
+		Duration   string  `cli:"-d, --duration"`
+		Rate       float64 `cli:"-r, --rate"`
+		Parallel   int     `cli:"-j, --parallel"`
+		Poll       string  `cli:"--poll"`
+		Parameters string  `cli:"--params"`
+		MaxWait    string  `cli:"-w, --max-wait"`
+		Format     string  `cli:"-o, --format"`
+	} `cli:"bench"`
+
+	Mock struct {
+		Catalog string `cli:"-c, --catalog"`
+		Listen  string `cli:"-l, --listen"`
+	} `cli:"mock"`
+
+	Proxy struct {
+		Listen   string `cli:"-l, --listen"`
+		Upstream string `cli:"-u, --upstream"`
+		Save     bool   `cli:"--save"`
+		Quiet    bool   `cli:"-q, --quiet"`
+		Chaos    string `cli:"--chaos"`
+	} `cli:"proxy"`
+
+	Completion struct{} `cli:"completion"`
+	Complete   struct{} `cli:"__complete!"`
+
+	History struct {
+		Broker string `cli:"-b, --broker"`
+		Verb   string `cli:"--verb"`
+		Limit  int    `cli:"-n, --limit"`
+	} `cli:"history"`
+
 	Deprovision struct {
 		Service string `cli:"-s, --service"`
 		Plan    string `cli:"-p, --plan"`
@@ -67,6 +238,38 @@ func bail(e error) {
 
 func main() {
 	opt.Timeout = 5
+	opt.List.Labels = []string{}
+	opt.List.Credentials = true
+	opt.Provision.Labels = []string{}
+	opt.Bind.Labels = []string{}
+	opt.Adopt.Labels = []string{}
+	opt.AdoptBinding.Labels = []string{}
+	opt.Teardown.Labels = []string{}
+	opt.Creds.Separator = "_"
+	opt.Exec.Env = true
+	opt.Render.Bindings = []string{}
+	opt.Exec.Separator = "_"
+	opt.Teardown.Parallel = 4
+	opt.Teardown.MaxWait = "30m"
+	opt.Apply.MaxWait = "30m"
+	opt.Batch.Parallel = 4
+	opt.Batch.MaxWait = "30m"
+	opt.Batch.Retries = 5
+	opt.Test.Format = "summary"
+	opt.Test.MaxWait = "30m"
+	opt.Run.Vars = []string{}
+	opt.Run.MaxWait = "30m"
+	opt.Stress.Workload = api.StressBinds
+	opt.Stress.Parallel = 10
+	opt.Stress.MaxWait = "30m"
+	opt.Bench.Workload = api.BenchCatalog
+	opt.Bench.Duration = "1m"
+	opt.Bench.Parallel = 10
+	opt.Bench.Poll = "1s"
+	opt.Bench.MaxWait = "30m"
+	opt.Bench.Format = "table"
+	opt.Mock.Listen = ":3000"
+	opt.Proxy.Listen = ":8080"
 	env.Override(&opt)
 	command, args, err := cli.Parse(&opt)
 	bail(err)
@@ -81,7 +284,8 @@ func main() {
 		fmt.Printf("\n")
 		fmt.Printf("  --data             Path to the OSB data file, for storing instance and\n")
 		fmt.Printf("                     binding information required by future bind, unbind,\n")
-		fmt.Printf("                     and deprovision requests.\n")
+		fmt.Printf("                     and deprovision requests.  Every lifecycle operation\n")
+		fmt.Printf("                     is also journaled to a @W{.journal} file alongside it.\n")
 		fmt.Printf("\n")
 		fmt.Printf("  -e, --endpoint     The URL to the backend service broker to interact with.\n")
 		fmt.Printf("                     Can also be specified via the @W{OSB_URL} variable.\n")
@@ -98,6 +302,45 @@ func main() {
 		fmt.Printf("  -t, --timeout      Timeout (in seconds) for HTTP requests.\n")
 		fmt.Printf("                     Can also be specified via @W{OSB_TIMEOUT}.\n")
 		fmt.Printf("\n")
+		fmt.Printf("  --profile          The platform profile (@W{cloudfoundry} or @W{kubernetes})\n")
+		fmt.Printf("                     to send @W{context} for, in provision and bind requests.\n")
+		fmt.Printf("                     Can also be specified via @W{OSB_PROFILE}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --cf-org           The org and space GUIDs to put in the @W{cloudfoundry}\n")
+		fmt.Printf("  --cf-space         @W{context}.  Without them, osb makes up its own (the\n")
+		fmt.Printf("                     same ones, every time).\n")
+		fmt.Printf("                     Can also be specified via @W{OSB_CF_ORG} and @W{OSB_CF_SPACE}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --k8s-namespace    The namespace and cluster ID to put in the @W{kubernetes}\n")
+		fmt.Printf("  --k8s-cluster      @W{context}.  The namespace defaults to @W{default}, and\n")
+		fmt.Printf("                     osb makes up a cluster ID (the same one, every time).\n")
+		fmt.Printf("                     Can also be specified via @W{OSB_K8S_NAMESPACE} and\n")
+		fmt.Printf("                     @W{OSB_K8S_CLUSTER}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --seed             Derive the IDs of new instances and bindings from\n")
+		fmt.Printf("                     their @W{--name}, the broker URL, and this seed, so\n")
+		fmt.Printf("                     that re-running a provision or bind is idempotent.\n")
+		fmt.Printf("                     Can also be specified via @W{OSB_SEED}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --record           Record every HTTP exchange with the broker to the\n")
+		fmt.Printf("                     given cassette file (adding to it, if it exists),\n")
+		fmt.Printf("                     with Authorization headers and credentials redacted.\n")
+		fmt.Printf("                     Can also be specified via @W{OSB_RECORD}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --replay           Answer requests from a cassette file made by @W{--record},\n")
+		fmt.Printf("                     instead of talking to the broker.\n")
+		fmt.Printf("                     Can also be specified via @W{OSB_REPLAY}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --match            Which parts of a request have to match a recording\n")
+		fmt.Printf("                     for it to be replayed, as a comma-separated list of\n")
+		fmt.Printf("                     @W{method}, @W{host}, @W{path}, @W{query} and @W{body}.\n")
+		fmt.Printf("                     Defaults to @W{method,path}.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  --strict           Replay each recording once, in the order they were\n")
+		fmt.Printf("                     recorded, failing any request that doesn't match.\n")
+		fmt.Printf("                     Otherwise, recordings can be replayed in any order,\n")
+		fmt.Printf("                     and more than once.\n")
+		fmt.Printf("\n")
 		fmt.Printf("  --json             Emit JSON responses, and nothing else.\n")
 		fmt.Printf("                     Useful for scripting!\n")
 		fmt.Printf("\n")
@@ -111,6 +354,31 @@ func main() {
 		fmt.Printf("\n")
 		fmt.Printf("  bind           Bind a provisioned instance, to get credentials.\n")
 		fmt.Printf("  unbind         Unbind an instance, releasing bound credentials.\n")
+		fmt.Printf("  creds          Export the credentials of a binding.\n")
+		fmt.Printf("  exec           Run a command with a binding's credentials.\n")
+		fmt.Printf("  vcap           Generate VCAP_SERVICES from bindings in ~/.osbrc.\n")
+		fmt.Printf("  render         Render a configuration file template with credentials.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  sync           Check ~/.osbrc against what the broker knows about.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  adopt          Record an existing instance in ~/.osbrc.\n")
+		fmt.Printf("  adopt-binding  Record an existing binding in ~/.osbrc.\n")
+		fmt.Printf("  import         Adopt instances and bindings in bulk, from a file.\n")
+		fmt.Printf("  forget         Remove instances or bindings from ~/.osbrc.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  history        Show the journal of past lifecycle operations.\n")
+		fmt.Printf("  teardown       Unbind and deprovision everything in ~/.osbrc.\n")
+		fmt.Printf("  apply          Converge brokers with a manifest of instances and bindings.\n")
+		fmt.Printf("  batch          Run lots of lifecycle operations at once, from a file.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  test           Check a broker against the OSB spec, end to end.\n")
+		fmt.Printf("  stress         Hit one instance with lots of concurrent requests.\n")
+		fmt.Printf("  bench          Measure how fast (and how reliably) a broker responds.\n")
+		fmt.Printf("  run            Run a scenario of requests, with assertions, from a file.\n")
+		fmt.Printf("  mock           Run an in-memory service broker, for testing against.\n")
+		fmt.Printf("  proxy          Watch (and check) what a platform says to a broker.\n")
+		fmt.Printf("\n")
+		fmt.Printf("  completion     Generate shell completion scripts.\n")
 		fmt.Printf("\n")
 		os.Exit(0)
 	}
@@ -125,6 +393,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	if command == "__complete" {
+		printCompletions(os.Stdout, complete(args))
+		os.Exit(0)
+	}
+
+	journal = &api.Journal{Path: api.JournalPath(opt.Data)}
+	cassette, err := loadCassette()
+	bail(err)
+	clients.cassette = cassette
+
 	c := &api.Client{
 		URL:        opt.Endpoint,
 		Username:   opt.Username,
@@ -132,18 +410,66 @@ func main() {
 		SkipVerify: opt.SkipVerify,
 		Timeout:    opt.Timeout,
 		Trace:      opt.Trace,
+		Journal:    journal,
+		Cassette:   cassette,
 	}
 
 	store, err := api.ReadStore(opt.Data)
 	bail(err)
 
+	// aliases are good anywhere an instance or binding ID is
+	switch command {
+	case "bind", "unbind", "deprovision", "creds", "vcap", "forget", "history":
+		for i := range args {
+			args[i] = store.Resolve(c.URL, args[i])
+		}
+	case "exec":
+		if len(args) > 0 {
+			args[0] = store.Resolve(c.URL, args[0])
+		}
+	}
+	opt.Unbind.ID = store.Resolve(c.URL, opt.Unbind.ID)
+	opt.AdoptBinding.Instance = store.Resolve(c.URL, opt.AdoptBinding.Instance)
+
 	switch command {
 	default:
 		bail(fmt.Errorf("%s: not implemented", command))
 
 	case "list":
 		if opt.Help {
-			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s}\n\n", os.Args[0], command)
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}]\n\n", os.Args[0], command)
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -b, --broker       Only list records for brokers whose URL contains\n")
+			fmt.Printf("                     the given string.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -s, --service      Only list records for the given service (name or ID).\n")
+			fmt.Printf("  -p, --plan         Only list records for the given plan (name or ID).\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --label        Only list records with the given @W{key=value} label.\n")
+			fmt.Printf("                     A bare @W{key} matches any value.  Can be given more\n")
+			fmt.Printf("                     than once; all labels must match.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --older-than       Only list records created more than this long ago,\n")
+			fmt.Printf("                     i.e. @W{90m}, @W{24h}, or @W{7d}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --sort             Sort by @W{broker}, @W{id}, @W{name}, @W{instance}, @W{binding},\n")
+			fmt.Printf("                     @W{service}, @W{plan}, or @W{created}.  Defaults to the order\n")
+			fmt.Printf("                     in ~/.osbrc.\n")
+			fmt.Printf("  -r, --reverse      Reverse the sort order.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --no-credentials   Leave binding credentials out of the output.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -o, --format       How to format the output: @W{table} (the default),\n")
+			fmt.Printf("                     @W{wide}, @W{yaml}, @W{json}, @W{csv}, @W{tsv}, or a Go template\n")
+			fmt.Printf("                     like @W{'{{.ID}}'}, which is rendered once per record.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --json             Print all of ~/.osbrc as JSON, as-is.  The options\n")
+			fmt.Printf("                     above don't apply; use @W{--format json} for the\n")
+			fmt.Printf("                     (filtered, sorted) records.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Service and plan IDs are shown by name if the broker catalog has\n")
+			fmt.Printf("been cached by a previous @C{catalog}, @C{provision}, or @C{bind}.\n")
+			fmt.Printf("\n")
 			os.Exit(0)
 		}
 
@@ -152,33 +478,28 @@ func main() {
 			os.Exit(0)
 		}
 
-		t := table.NewTable("Broker", "Instance", "Service", "Plan", "Binding", "Credentials")
-		for _, broker := range store.Data {
-			bname := broker.Broker
-			for _, instance := range broker.Instances {
-				if instance.Bindings == nil || len(instance.Bindings) == 0 {
-					t.Row(nil, bname, instance.ID, instance.ServiceID, instance.PlanID, "-", "-")
-					bname = ""
+		labels, err := parseLabels(opt.List.Labels)
+		bail(err)
 
-				} else {
-					inst := instance.ID
-					service := instance.ServiceID
-					plan := instance.PlanID
-					for _, binding := range instance.Bindings {
-						b, err := json.MarshalIndent(binding.Credentials, "", "  ")
-						if err != nil {
-							t.Row(nil, bname, inst, service, plan, binding.ID, fmt.Sprintf("error: %s", err))
-						} else {
-							t.Row(nil, bname, inst, service, plan, binding.ID, string(b))
-						}
-						inst = ""
-						service = ""
-						plan = ""
-					}
-				}
-			}
+		var age time.Duration
+		if opt.List.OlderThan != "" {
+			age, err = parseAge(opt.List.OlderThan)
+			bail(err)
 		}
-		t.Output(os.Stdout)
+
+		cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+		bail(err)
+
+		ll := listings(store, cache, listFilter{
+			Broker:    opt.List.Broker,
+			Service:   opt.List.Service,
+			Plan:      opt.List.Plan,
+			Labels:    labels,
+			OlderThan: age,
+		})
+		bail(sortListings(ll, opt.List.Sort, opt.List.Reverse))
+
+		bail(outputListings(os.Stdout, ll, opt.List.Format, opt.List.Credentials))
 		os.Exit(0)
 
 	case "env":
@@ -190,6 +511,10 @@ func main() {
 			Password   string `json:"OSB_PASSWORD"`
 			SkipVerify bool   `json:"OSB_SKIP_VERIFY"`
 			Timeout    int    `json:"OSB_TIMEOUT"`
+			Profile    string `json:"OSB_PROFILE"`
+			Seed       string `json:"OSB_SEED"`
+			Record     string `json:"OSB_RECORD"`
+			Replay     string `json:"OSB_REPLAY"`
 		}{
 			Trace:      opt.Trace,
 			Data:       opt.Data,
@@ -198,6 +523,10 @@ func main() {
 			Password:   opt.Password,
 			SkipVerify: opt.SkipVerify,
 			Timeout:    opt.Timeout,
+			Profile:    opt.Profile,
+			Seed:       opt.Seed,
+			Record:     opt.Record,
+			Replay:     opt.Replay,
 		}
 
 		if opt.JSON {
@@ -218,6 +547,10 @@ func main() {
 		fmt.Printf("export OSB_PASSWORD=\"%s\"\n", e.Password)
 		fmt.Printf("export OSB_TIMEOUT=%d\n", e.Timeout)
 		fmt.Printf("export OSB_DATA=\"%s\"\n", e.Data)
+		fmt.Printf("export OSB_PROFILE=\"%s\"\n", e.Profile)
+		fmt.Printf("export OSB_SEED=\"%s\"\n", e.Seed)
+		fmt.Printf("export OSB_RECORD=\"%s\"\n", e.Record)
+		fmt.Printf("export OSB_REPLAY=\"%s\"\n", e.Replay)
 		fmt.Printf("export OSB_TRACE=%s\n", booly(e.Trace))
 		fmt.Printf("export OSB_SKIP_VERIFY=%s\n", booly(e.SkipVerify))
 
@@ -228,8 +561,7 @@ func main() {
 		}
 
 		connecting()
-		catalog, err := c.GetCatalog()
-		bail(err)
+		catalog := fetchCatalog(c)
 
 		if opt.JSON {
 			jsonify(catalog)
@@ -268,12 +600,25 @@ func main() {
 			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [--id ID] @M{SERVICE}/@M{PLAN}\n\n", os.Args[0], command)
 			fmt.Printf("Options:\n\n")
 			fmt.Printf("  -i, --id       The ID to use for the newly-provisioned service instance.\n")
-			fmt.Printf("                 If not specified, will be a random UUID.\n")
+			fmt.Printf("                 If not specified, will be a random UUID, unless\n")
+			fmt.Printf("                 @W{--seed} and @W{--name} are given.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -n, --name     A local alias for the new instance, which can be used\n")
+			fmt.Printf("                 in place of its ID in other commands.  It is also sent\n")
+			fmt.Printf("                 to the broker as @W{instance_name}, if the @W{--profile}\n")
+			fmt.Printf("                 supports it.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --label    A @W{key=value} label to record alongside the instance\n")
+			fmt.Printf("                 in ~/.osbrc, for use with @C{list --label}.  Can be\n")
+			fmt.Printf("                 given more than once.\n")
 			fmt.Printf("\n")
 			os.Exit(0)
 		}
 
 		provisioning(args)
+		labels, err := parseLabels(opt.Provision.Labels)
+		bail(err)
+
 		l := strings.SplitN(args[0], "/", 2)
 		if len(l) != 2 {
 			fmt.Fprintf(os.Stderr, "USAGE: @G{%s} [@W{options}] @C{%s} [--id ID] @M{SERVICE}/@M{PLAN}\n", os.Args[0], command)
@@ -281,19 +626,32 @@ func main() {
 			os.Exit(1)
 		}
 
-		catalog, err := c.GetCatalog()
+		if opt.Provision.ID == "" && opt.Seed != "" && opt.Provision.Name != "" {
+			opt.Provision.ID = api.DerivedID(c.URL, opt.Seed, opt.Provision.Name)
+		}
+		bail(store.CheckAlias(c.URL, opt.Provision.ID, opt.Provision.Name))
+		context, err := profileContext(opt.Profile, map[string]interface{}{
+			"instance_name": opt.Provision.Name,
+		})
 		bail(err)
 
+		catalog := fetchCatalog(c)
 		service, plan, err := catalog.FindPlan(l[0], l[1])
 		bail(err)
 
 		stat, err := c.Provision(opt.Provision.ID, api.ProvisionSpec{
 			ServiceID: service,
 			PlanID:    plan,
+			Context:   context,
 		})
 		bail(err)
 
 		store.AddInstance(c.URL, stat.InstanceID, service, plan)
+		store.LabelInstance(c.URL, stat.InstanceID, labels)
+		if err := store.NameInstance(c.URL, stat.InstanceID, opt.Provision.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+		}
+		store.SetInstanceOperation(c.URL, stat.InstanceID, "provision", stat.Operation)
 		if err := store.Write(opt.Data); err != nil {
 			fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
 		}
@@ -304,6 +662,9 @@ func main() {
 		}
 
 		fmt.Printf("instance: @G{%s}\n", stat.InstanceID)
+		if opt.Provision.Name != "" {
+			fmt.Printf("alias:    @G{%s}\n", opt.Provision.Name)
+		}
 		fmt.Printf("status:   @M{%s}\n", stat.Status)
 		if stat.DashboardURL != "" {
 			fmt.Printf("dashboard: @C{%s}\n", stat.DashboardURL)
@@ -326,17 +687,28 @@ func main() {
 			fmt.Printf("                 instance details are not found in ~/.osbrc.\n")
 			fmt.Printf("\n")
 			fmt.Printf("  -i, --id       The ID to use for the new service instance binding.\n")
-			fmt.Printf("                 If not specified, will be a random UUID.\n")
+			fmt.Printf("                 If not specified, will be a random UUID, unless\n")
+			fmt.Printf("                 @W{--seed} and @W{--name} are given.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -n, --name     A local alias for the new binding, which can be used\n")
+			fmt.Printf("                 in place of its ID in other commands.  It is also sent\n")
+			fmt.Printf("                 to the broker as @W{binding_name}, if the @W{--profile}\n")
+			fmt.Printf("                 supports it.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --label    A @W{key=value} label to record alongside the binding\n")
+			fmt.Printf("                 in ~/.osbrc, for use with @C{list --label}.  Can be\n")
+			fmt.Printf("                 given more than once.\n")
 			fmt.Printf("\n")
 			os.Exit(0)
 		}
 
 		binding(args)
+		labels, err := parseLabels(opt.Bind.Labels)
+		bail(err)
 
 		service, plan, _ := store.GetInstanceDetails(c.URL, args[0])
 		if service == "" || plan == "" {
-			catalog, err := c.GetCatalog()
-			bail(err)
+			catalog := fetchCatalog(c)
 
 			if service == "" {
 				service = opt.Bind.Service
@@ -361,15 +733,32 @@ func main() {
 			plan = p
 		}
 
+		if opt.Bind.ID == "" && opt.Seed != "" && opt.Bind.Name != "" {
+			opt.Bind.ID = api.DerivedID(c.URL, opt.Seed, opt.Bind.Name)
+		}
+		bail(store.CheckAlias(c.URL, opt.Bind.ID, opt.Bind.Name))
+		context, err := profileContext(opt.Profile, map[string]interface{}{
+			"instance_name": store.Alias(c.URL, args[0]),
+			"binding_name":  opt.Bind.Name,
+		})
+		bail(err)
+
 		stat, err := c.Bind(api.BindSpec{
 			InstanceID: args[0],
 			BindingID:  opt.Bind.ID,
 			ServiceID:  service,
 			PlanID:     plan,
+			Context:    context,
 		})
 		bail(err)
 
 		store.AddBinding(c.URL, stat.InstanceID, stat.BindingID, stat.Credentials)
+		store.LabelBinding(c.URL, stat.InstanceID, stat.BindingID, labels)
+		store.SetBindingOperation(c.URL, stat.InstanceID, stat.BindingID, "bind", stat.Operation)
+		store.SetBindingExtras(c.URL, stat.InstanceID, stat.BindingID, stat.SyslogDrainURL, stat.VolumeMounts)
+		if err := store.NameBinding(c.URL, stat.InstanceID, stat.BindingID, opt.Bind.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+		}
 		if err := store.Write(opt.Data); err != nil {
 			fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
 		}
@@ -381,6 +770,9 @@ func main() {
 
 		fmt.Printf("instance: @G{%s}\n", stat.InstanceID)
 		fmt.Printf("binding:  @G{%s}\n", stat.BindingID)
+		if opt.Bind.Name != "" {
+			fmt.Printf("alias:    @G{%s}\n", opt.Bind.Name)
+		}
 		fmt.Printf("status:   @C{%s}\n", stat.Status)
 		os.Exit(0)
 
@@ -416,8 +808,7 @@ func main() {
 			}
 		}
 		if service == "" || plan == "" {
-			catalog, err := c.GetCatalog()
-			bail(err)
+			catalog := fetchCatalog(c)
 
 			if service == "" {
 				service = opt.Unbind.Service
@@ -465,67 +856,1846 @@ func main() {
 		fmt.Printf("status:   @C{%s}\n", stat.Status)
 		os.Exit(0)
 
-	case "deprovision":
+	case "sync":
 		if opt.Help {
-			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s}\n\n", os.Args[0], command)
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [--apply]\n\n", os.Args[0], command)
+			fmt.Printf("Checks every instance and binding in ~/.osbrc for the current\n")
+			fmt.Printf("broker, reporting on records that have gone missing, whose last\n")
+			fmt.Printf("operation failed, whose plan has drifted, or whose credentials\n")
+			fmt.Printf("have changed.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Services that are not @W{instances_retrievable} / @W{bindings_retrievable}\n")
+			fmt.Printf("can only be checked via their last_operation endpoints, so the\n")
+			fmt.Printf("coverage for those records is only partial.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --apply        Prune missing and failed records from ~/.osbrc, and\n")
+			fmt.Printf("                 update any drifted plans or changed credentials.\n")
+			fmt.Printf("\n")
 			os.Exit(0)
 		}
 
-		deprovisioning(args)
-
-		instance := args[0]
-		service, plan, _ := store.GetInstanceDetails(c.URL, instance)
-		if service == "" || plan == "" {
-			catalog, err := c.GetCatalog()
-			bail(err)
+		connecting()
+		catalog := fetchCatalog(c)
+		report, err := c.Sync(store, catalog)
+		bail(err)
 
-			if service == "" {
-				service = opt.Deprovision.Service
-				if service == "" {
-					fmt.Fprintf(os.Stderr, "@R{instance '%s' not found in local ~/.osbrc}\n", instance)
-					fmt.Fprintf(os.Stderr, "You must specify the --service flag to the deprovision operation.\n")
-					os.Exit(1)
+		n := 0
+		if opt.Sync.Apply {
+			n = report.Apply(store)
+			if n > 0 {
+				if err := store.Write(opt.Data); err != nil {
+					fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
 				}
 			}
-			if plan == "" {
-				plan = opt.Deprovision.Plan
-				if plan == "" {
-					fmt.Fprintf(os.Stderr, "@R{instance '%s' not found in local ~/.osbrc}\n", instance)
-					fmt.Fprintf(os.Stderr, "You must specify the --plan flag to the deprovision operation.\n")
-					os.Exit(1)
+		}
+
+		if opt.JSON {
+			jsonify(report)
+			os.Exit(0)
+		}
+
+		t := table.NewTable("Instance", "Binding", "State", "Detail", "Action")
+		for _, f := range report.Findings {
+			bid := "-"
+			if f.BindingID != "" {
+				bid = f.BindingID
+			}
+
+			state := fmt.Sprintf("@G{%s}", f.State)
+			switch f.State {
+			case api.SyncMissing, api.SyncFailed:
+				state = fmt.Sprintf("@R{%s}", f.State)
+			case api.SyncDrifted, api.SyncChanged, api.SyncPending, api.SyncUnknown:
+				state = fmt.Sprintf("@Y{%s}", f.State)
+			}
+			if f.Partial {
+				state += fmt.Sprintf(" @W{*}")
+			}
+
+			action := "-"
+			if f.Action != "" {
+				if opt.Sync.Apply {
+					action = f.Action
+				} else {
+					action = "(would " + f.Action + ")"
 				}
 			}
+			t.Row(nil, f.InstanceID, bid, state, f.Detail, action)
+		}
+		t.Output(os.Stdout)
 
-			s, p, err := catalog.FindPlan(service, plan)
-			bail(err)
-			service = s
-			plan = p
+		if report.Partial {
+			fmt.Printf("\n@W{*} only checked via last_operation; this broker does not let us\n")
+			fmt.Printf("  retrieve these records directly, so coverage is @Y{partial}.\n")
 		}
-		stat, err := c.Deprovision(api.DeprovisionSpec{
-			InstanceID: args[0],
-			ServiceID:  service,
-			PlanID:     plan,
-		})
+		if opt.Sync.Apply {
+			fmt.Printf("\n%d change(s) applied to ~/.osbrc\n", n)
+		}
+		os.Exit(0)
+
+	case "adopt":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} --service @M{SERVICE} --plan @M{PLAN} @M{INSTANCE}\n\n", os.Args[0], command)
+			fmt.Printf("Records an existing service instance, provisioned by some other\n")
+			fmt.Printf("platform, in ~/.osbrc.  If the broker lets us retrieve the instance,\n")
+			fmt.Printf("it will be checked before it is saved.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -s, --service  The name or ID of the service that the instance\n")
+			fmt.Printf("                 was provisioned from.  This is required.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -p, --plan     The name or ID of the plan that the instance\n")
+			fmt.Printf("                 was provisioned from.  This is required.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --label    A @W{key=value} label to record alongside the instance.\n")
+			fmt.Printf("                 Can be given more than once.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		if len(args) != 1 || opt.Adopt.Service == "" || opt.Adopt.Plan == "" {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{adopt} --service SERVICE --plan PLAN INSTANCE-ID\n", os.Args[0])
+			os.Exit(1)
+		}
+		labels, err := parseLabels(opt.Adopt.Labels)
 		bail(err)
 
-		store.RemoveInstance(c.URL, args[0])
-		if err := store.Write(opt.Data); err != nil {
-			fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+		catalog := fetchCatalog(c)
+		service, plan, err := c.Adopt(store, catalog, args[0], opt.Adopt.Service, opt.Adopt.Plan)
+		bail(err)
+
+		store.LabelInstance(c.URL, args[0], labels)
+		bail(store.Write(opt.Data))
+
+		if opt.JSON {
+			jsonify(struct {
+				InstanceID string `json:"instance_id"`
+				ServiceID  string `json:"service_id"`
+				PlanID     string `json:"plan_id"`
+			}{args[0], service, plan})
+			os.Exit(0)
 		}
 
 		fmt.Printf("instance: @G{%s}\n", args[0])
-		fmt.Printf("status:   @M{%s}\n", stat.Status)
-		if stat.Operation != "" {
-			fmt.Printf("operation: @C{%s}\n", stat.Operation)
+		fmt.Printf("service:  @C{%s}\n", service)
+		fmt.Printf("plan:     @C{%s}\n", plan)
+		fmt.Printf("status:   @M{adopted}\n")
+		os.Exit(0)
+
+	case "adopt-binding":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} --instance @M{INSTANCE} @M{BINDING}\n\n", os.Args[0], command)
+			fmt.Printf("Records an existing service binding, created by some other platform,\n")
+			fmt.Printf("in ~/.osbrc.  The instance must already be known; see @C{adopt}.\n")
+			fmt.Printf("If the broker lets us retrieve the binding, its credentials will be\n")
+			fmt.Printf("fetched before it is saved.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -i, --instance     The ID of the service instance this binding\n")
+			fmt.Printf("                     belongs to.  This is required.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -c, --credentials  Path to a JSON or YAML file of credentials to\n")
+			fmt.Printf("                     record, for bindings that cannot be retrieved.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --label        A @W{key=value} label to record alongside the\n")
+			fmt.Printf("                     binding.  Can be given more than once.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		if len(args) != 1 || opt.AdoptBinding.Instance == "" {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{adopt-binding} --instance INSTANCE-ID BINDING-ID\n", os.Args[0])
+			os.Exit(1)
+		}
+		labels, err := parseLabels(opt.AdoptBinding.Labels)
+		bail(err)
+
+		var creds map[string]interface{}
+		if opt.AdoptBinding.Credentials != "" {
+			b, err := ioutil.ReadFile(opt.AdoptBinding.Credentials)
+			bail(err)
+			bail(yaml.Unmarshal(b, &creds))
+		}
+
+		catalog := fetchCatalog(c)
+		creds, err = c.AdoptBinding(store, catalog, opt.AdoptBinding.Instance, args[0], creds)
+		bail(err)
+
+		store.LabelBinding(c.URL, opt.AdoptBinding.Instance, args[0], labels)
+		bail(store.Write(opt.Data))
+
+		if opt.JSON {
+			jsonify(struct {
+				InstanceID  string                 `json:"instance_id"`
+				BindingID   string                 `json:"binding_id"`
+				Credentials map[string]interface{} `json:"credentials"`
+			}{opt.AdoptBinding.Instance, args[0], creds})
+			os.Exit(0)
 		}
+
+		fmt.Printf("instance: @G{%s}\n", opt.AdoptBinding.Instance)
+		fmt.Printf("binding:  @G{%s}\n", args[0])
+		fmt.Printf("status:   @C{adopted}\n")
 		os.Exit(0)
-	}
-}
 
-func jsonify(x interface{}) {
-	b, err := json.Marshal(x)
-	bail(err)
-	fmt.Printf("%s\n", string(b))
+	case "import":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [--format FORMAT] @M{FILE}\n\n", os.Args[0], command)
+			fmt.Printf("Adopts instances and bindings in bulk, from an inventory file.\n")
+			fmt.Printf("The inventory is a list of records in the same shape that\n")
+			fmt.Printf("@C{list --format} emits, i.e.:\n")
+			fmt.Printf("\n")
+			fmt.Printf("  - kind:     instance\n")
+			fmt.Printf("    id:       @M{INSTANCE}\n")
+			fmt.Printf("    service:  postgres\n")
+			fmt.Printf("    plan:     small\n")
+			fmt.Printf("\n")
+			fmt.Printf("  - kind:     binding\n")
+			fmt.Printf("    id:       @M{BINDING}\n")
+			fmt.Printf("    instance: @M{INSTANCE}\n")
+			fmt.Printf("\n")
+			fmt.Printf("CSV and TSV files need a header row naming the columns (@W{kind},\n")
+			fmt.Printf("@W{id}, @W{instance}, @W{service}, @W{plan}, @W{labels}, @W{credentials}, etc.)\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -f, --format   The format of the inventory file: @W{yaml}, @W{json}, @W{csv},\n")
+			fmt.Printf("                 or @W{tsv}.  Defaults to CSV or TSV for .csv and .tsv\n")
+			fmt.Printf("                 files, and YAML / JSON for everything else.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		if len(args) != 1 {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{import} FILE\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		ll, err := readInventory(args[0], opt.Import.Format)
+		bail(err)
+
+		catalog := fetchCatalog(c)
+		url := strings.TrimSuffix(c.URL, "/")
+		t := table.NewTable("Kind", "ID", "Status")
+		imported, skipped, failed := 0, 0, 0
+		for _, kind := range []string{"instance", "binding"} {
+			for _, l := range ll {
+				if l.Kind != kind {
+					continue
+				}
+				if l.Broker != "" && strings.TrimSuffix(l.Broker, "/") != url {
+					t.Row(nil, l.Kind, l.ID, fmt.Sprintf("@Y{skipped} (belongs to %s)", l.Broker))
+					skipped++
+					continue
+				}
+
+				if kind == "instance" {
+					if _, _, err := store.GetInstanceDetails(c.URL, l.ID); err == nil {
+						t.Row(nil, l.Kind, l.ID, fmt.Sprintf("@Y{skipped} (already known)"))
+						skipped++
+						continue
+					}
+
+					service, plan := l.ServiceID, l.PlanID
+					if service == "" {
+						service = l.Service
+					}
+					if plan == "" {
+						plan = l.Plan
+					}
+					if _, _, err := c.Adopt(store, catalog, l.ID, service, plan); err != nil {
+						t.Row(nil, l.Kind, l.ID, fmt.Sprintf("@R{failed}: %s", err))
+						failed++
+						continue
+					}
+					store.LabelInstance(c.URL, l.ID, l.Labels)
+					if err := store.NameInstance(c.URL, l.ID, l.Name); err != nil {
+						fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+					}
+
+				} else {
+					if _, _, _, err := store.GetBindingDetails(c.URL, l.ID); err == nil {
+						t.Row(nil, l.Kind, l.ID, fmt.Sprintf("@Y{skipped} (already known)"))
+						skipped++
+						continue
+					}
+
+					if _, err := c.AdoptBinding(store, catalog, l.Instance, l.ID, l.Credentials); err != nil {
+						t.Row(nil, l.Kind, l.ID, fmt.Sprintf("@R{failed}: %s", err))
+						failed++
+						continue
+					}
+					store.LabelBinding(c.URL, l.Instance, l.ID, l.Labels)
+					if err := store.NameBinding(c.URL, l.Instance, l.ID, l.Name); err != nil {
+						fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+					}
+				}
+
+				t.Row(nil, l.Kind, l.ID, fmt.Sprintf("@G{adopted}"))
+				imported++
+			}
+		}
+
+		if imported > 0 {
+			bail(store.Write(opt.Data))
+		}
+
+		if !opt.JSON {
+			t.Output(os.Stdout)
+			fmt.Printf("\n%d adopted, %d skipped, %d failed\n", imported, skipped, failed)
+		} else {
+			jsonify(struct {
+				Adopted int `json:"adopted"`
+				Skipped int `json:"skipped"`
+				Failed  int `json:"failed"`
+			}{imported, skipped, failed})
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "forget":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} @M{INSTANCE}|@M{BINDING} ...\n\n", os.Args[0], command)
+			fmt.Printf("Removes instances (and all of their bindings) or bindings from\n")
+			fmt.Printf("~/.osbrc, @Y{without} telling the broker anything about it.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) == 0 {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{forget} ID [ID ...]\n", os.Args[0])
+			os.Exit(1)
+		}
+		if opt.Endpoint == "" {
+			fmt.Fprintf(os.Stderr, "@Y{missing required --endpoint flag or $OSB_URL environment variable}\n")
+			os.Exit(1)
+		}
+
+		for _, id := range args {
+			kind, err := store.Forget(c.URL, id)
+			bail(err)
+			if !opt.JSON {
+				fmt.Printf("%s @G{%s} forgotten\n", kind, id)
+			}
+		}
+		bail(store.Write(opt.Data))
+		os.Exit(0)
+
+	case "history":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] [@M{INSTANCE}|@M{BINDING}]\n\n", os.Args[0], command)
+			fmt.Printf("Shows the journal of provision, bind, unbind and deprovision\n")
+			fmt.Printf("operations that osb has performed, oldest first.  If an instance\n")
+			fmt.Printf("or binding ID is given, only operations against it are shown.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -b, --broker   Only show operations against brokers whose URL\n")
+			fmt.Printf("                 contains the given string.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --verb         Only show operations of the given kind, i.e.\n")
+			fmt.Printf("                 @W{provision}, @W{bind}, @W{unbind}, or @W{deprovision}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -n, --limit    Only show the most recent N operations.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) > 1 {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{history} [ID]\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		journal, err := api.ReadJournal(c.Journal.Path)
+		bail(err)
+
+		ee := make([]api.JournalEntry, 0)
+		for _, e := range journal {
+			if len(args) == 1 && e.InstanceID != args[0] && e.BindingID != args[0] {
+				continue
+			}
+			if opt.History.Broker != "" && !strings.Contains(e.Target, opt.History.Broker) {
+				continue
+			}
+			if opt.History.Verb != "" && e.Verb != opt.History.Verb {
+				continue
+			}
+			ee = append(ee, e)
+		}
+		if opt.History.Limit > 0 && len(ee) > opt.History.Limit {
+			ee = ee[len(ee)-opt.History.Limit:]
+		}
+
+		if opt.JSON {
+			jsonify(ee)
+			os.Exit(0)
+		}
+
+		t := table.NewTable("When", "Who", "Broker", "Verb", "Instance", "Binding", "HTTP", "Status", "Took", "Request ID")
+		for _, e := range ee {
+			bid := e.BindingID
+			if bid == "" {
+				bid = "-"
+			}
+			code := "-"
+			if e.StatusCode != 0 {
+				code = fmt.Sprintf("%d", e.StatusCode)
+			}
+			status := e.Status
+			if e.Error != "" {
+				status = fmt.Sprintf("@R{%s}\n%s", e.Status, e.Error)
+			} else if e.Operation != "" {
+				status = fmt.Sprintf("%s\n(operation @C{%s})", e.Status, e.Operation)
+			}
+			took := time.Duration(e.Duration) * time.Millisecond
+			t.Row(nil, e.Timestamp.Local().Format("2006-01-02 15:04:05"), e.User, e.Target, e.Verb, e.InstanceID, bid, code, status, took.String(), e.RequestIdentity)
+		}
+		t.Output(os.Stdout)
+		os.Exit(0)
+
+	case "teardown":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}]\n\n", os.Args[0], command)
+			fmt.Printf("Unbinds every binding and then deprovisions every instance recorded\n")
+			fmt.Printf("in ~/.osbrc, waiting for asynchronous operations to finish.  Records\n")
+			fmt.Printf("are only removed from ~/.osbrc once the broker confirms that they\n")
+			fmt.Printf("are gone.  Instances are only deprovisioned if all of their bindings\n")
+			fmt.Printf("were successfully unbound first.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Only records for the current broker (@W{--endpoint} / @W{OSB_URL}) are torn\n")
+			fmt.Printf("down, since its credentials are the only ones osb has; records for\n")
+			fmt.Printf("other brokers are left alone.  To tear those down too, run teardown\n")
+			fmt.Printf("again, against each of them.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -b, --broker       Only tear down records for brokers whose URL\n")
+			fmt.Printf("                     contains the given string.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -s, --service      Only tear down records for the given service.\n")
+			fmt.Printf("  -p, --plan         Only tear down records for the given plan.\n")
+			fmt.Printf("  -l, --label        Only tear down records with the given label.\n")
+			fmt.Printf("  --older-than       Only tear down records created more than this\n")
+			fmt.Printf("                     long ago, i.e. @W{90m}, @W{24h}, or @W{7d}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -j, --parallel     How many operations to run at once.  Defaults to @W{4}.\n")
+			fmt.Printf("  -w, --max-wait     How long to wait for each asynchronous operation\n")
+			fmt.Printf("                     to finish.  Defaults to @W{30m}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -n, --dry-run      Show what would be torn down, and stop.\n")
+			fmt.Printf("  -y, --yes          Don't ask for confirmation before tearing down.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		labels, err := parseLabels(opt.Teardown.Labels)
+		bail(err)
+
+		var age time.Duration
+		if opt.Teardown.OlderThan != "" {
+			age, err = parseAge(opt.Teardown.OlderThan)
+			bail(err)
+		}
+		wait, err := parseAge(opt.Teardown.MaxWait)
+		bail(err)
+
+		cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+		bail(err)
+
+		unbinds, deprovs := planTeardown(store, cache, listFilter{
+			Broker:    opt.Teardown.Broker,
+			Service:   opt.Teardown.Service,
+			Plan:      opt.Teardown.Plan,
+			Labels:    labels,
+			OlderThan: age,
+		})
+
+		// our credentials are for the --endpoint broker, and
+		// must not be sent to any of the others.
+		unbinds, elsewhere := onBroker(unbinds, opt.Endpoint)
+		deprovs, more := onBroker(deprovs, opt.Endpoint)
+		if n := elsewhere + more; n > 0 {
+			fmt.Fprintf(os.Stderr, "@Y{leaving %d record(s) for other brokers alone;} run teardown against each of them to tear those down.\n", n)
+		}
+		if len(unbinds)+len(deprovs) == 0 {
+			if !opt.JSON {
+				fmt.Printf("nothing to tear down.\n")
+			} else {
+				jsonify([]interface{}{})
+			}
+			os.Exit(0)
+		}
+
+		if !opt.JSON || opt.Teardown.DryRun {
+			t := table.NewTable("Operation", "Broker", "Instance", "Binding", "Service", "Plan")
+			for _, op := range append(unbinds, deprovs...) {
+				bid := op.Binding
+				if bid == "" {
+					bid = "-"
+				}
+				service, plan := op.ServiceID, op.PlanID
+				if cat := cache.Get(op.Broker); cat != nil {
+					service, plan = cat.Names(service, plan)
+				}
+				t.Row(nil, op.Verb, op.Broker, op.Instance, bid, service, plan)
+			}
+			if opt.JSON {
+				jsonify(append(unbinds, deprovs...))
+			} else {
+				t.Output(os.Stdout)
+				fmt.Printf("\n")
+			}
+		}
+		if opt.Teardown.DryRun {
+			os.Exit(0)
+		}
+		if !opt.Teardown.Yes && !confirm(fmt.Sprintf("Unbind @Y{%d} binding(s) and deprovision @Y{%d} instance(s)?", len(unbinds), len(deprovs))) {
+			fmt.Fprintf(os.Stderr, "@R{aborted.}\n")
+			os.Exit(1)
+		}
+
+		failed := make(map[string]bool)
+		done := func(op *teardownOp) {
+			if op.Error != "" {
+				failed[op.Broker+" "+op.Instance] = true
+				if !opt.JSON {
+					fmt.Printf("@R{%-11s} %s %s @R{failed}: %s\n", op.Verb, op.Instance, op.Binding, op.Error)
+				}
+				return
+			}
+
+			if op.Verb == "unbind" {
+				store.RemoveBinding(op.Broker, op.Instance, op.Binding)
+			} else {
+				store.RemoveInstance(op.Broker, op.Instance)
+			}
+			if err := store.Write(opt.Data); err != nil {
+				fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+			}
+			if !opt.JSON {
+				fmt.Printf("@G{%-11s} %s %s @G{%s}\n", op.Verb, op.Instance, op.Binding, op.Status)
+			}
+		}
+
+		runTeardown(unbinds, opt.Teardown.Parallel, wait, done)
+
+		ready := make([]*teardownOp, 0, len(deprovs))
+		for _, op := range deprovs {
+			if failed[op.Broker+" "+op.Instance] {
+				op.Status = "skipped"
+				op.Error = "not all bindings could be unbound"
+				if !opt.JSON {
+					fmt.Printf("@Y{%-11s} %s @Y{skipped}: %s\n", op.Verb, op.Instance, op.Error)
+				}
+				continue
+			}
+			ready = append(ready, op)
+		}
+		runTeardown(ready, opt.Teardown.Parallel, wait, done)
+
+		n := map[string]int{}
+		for _, op := range append(unbinds, deprovs...) {
+			switch {
+			case op.Status == "skipped":
+				n["skipped"]++
+			case op.Error != "":
+				n["failed"]++
+			default:
+				n[op.Verb]++
+			}
+		}
+
+		if opt.JSON {
+			jsonify(append(unbinds, deprovs...))
+		} else {
+			fmt.Printf("\n%d unbound, %d deprovisioned, %d failed, %d skipped\n", n["unbind"], n["deprovision"], n["failed"], n["skipped"])
+		}
+		if n["failed"]+n["skipped"] > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "creds":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] @M{BINDING}\n\n", os.Args[0], command)
+			fmt.Printf("Prints the credentials of a binding, from ~/.osbrc.  Nested\n")
+			fmt.Printf("credentials are flattened for the @W{env} and @W{dotenv} formats, so\n")
+			fmt.Printf("that @W{{\"db\":{\"host\":\"h\"}}} becomes @W{DB_HOST=h}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -o, --format   How to format the credentials: @W{env} (the default),\n")
+			fmt.Printf("                 @W{dotenv}, @W{json}, @W{yaml}, @W{k8s-secret}, or @W{servicebinding}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("                 @W{k8s-secret} emits a Kubernetes Secret manifest, with\n")
+			fmt.Printf("                 base64-encoded values.  Pipe it to @W{kubectl apply -f -}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("                 @W{servicebinding} writes the credentials out as files,\n")
+			fmt.Printf("                 one per key, in @W{DIR/NAME}, per servicebinding.io.\n")
+			fmt.Printf("\n")
+			fmt.Printf("                 Both add the servicebinding.io @W{type} and @W{provider}\n")
+			fmt.Printf("                 entries, from the tags and name of the service in\n")
+			fmt.Printf("                 the cached catalog.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --env          Shorthand for @W{--format env}; @W{export} lines suitable\n")
+			fmt.Printf("                 for @W{eval}-ing in a shell.\n")
+			fmt.Printf("  --dotenv       Shorthand for @W{--format dotenv}; a @W{.env} file.\n")
+			fmt.Printf("  --yaml         Shorthand for @W{--format yaml}.\n")
+			fmt.Printf("  --json         Shorthand for @W{--format json}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -f, --field    Print just the value of a single (flattened) field,\n")
+			fmt.Printf("                 like @W{uri} or @W{db_host}.  Dotted paths like @W{db.host}\n")
+			fmt.Printf("                 work too.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --prefix       A prefix for environment variable names.\n")
+			fmt.Printf("  --separator    What to join nested keys with.  Defaults to @W{_}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -r, --refresh  Fetch the credentials from the broker (and update\n")
+			fmt.Printf("                 ~/.osbrc), if the broker allows bindings to be\n")
+			fmt.Printf("                 retrieved.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --name         The name of the Secret, or of the servicebinding.io\n")
+			fmt.Printf("                 directory.  Defaults to the binding ID.\n")
+			fmt.Printf("  --namespace    The namespace of the Secret.\n")
+			fmt.Printf("  -d, --dir      Where to write servicebinding.io directories.\n")
+			fmt.Printf("                 Defaults to @W{$SERVICE_BINDING_ROOT}, or @W{.} if unset.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) != 1 {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{creds} BINDING-ID\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		creds := credentials(c, store, args[0], opt.Creds.Refresh)
+		if opt.Creds.Field != "" {
+			v, err := credentialField(creds, opt.Creds.Field, opt.Creds.Separator)
+			bail(err)
+			fmt.Printf("%s\n", v)
+			os.Exit(0)
+		}
+
+		format := opt.Creds.Format
+		switch {
+		case opt.JSON:
+			format = "json"
+		case opt.Creds.YAML:
+			format = "yaml"
+		case opt.Creds.Dotenv:
+			format = "dotenv"
+		case opt.Creds.Env:
+			format = "env"
+		}
+
+		name := opt.Creds.Name
+		if name == "" {
+			name = args[0]
+		}
+		switch format {
+		case "k8s-secret", "secret":
+			typ, provider := bindingType(c, store, args[0])
+			b, err := yaml.Marshal(k8sSecretFor(creds, name, opt.Creds.Namespace, typ, provider, opt.Creds.Separator))
+			bail(err)
+			fmt.Printf("---\n%s", string(b))
+
+		case "servicebinding":
+			dir := opt.Creds.Dir
+			if dir == "" {
+				dir = os.Getenv("SERVICE_BINDING_ROOT")
+			}
+			if dir == "" {
+				dir = "."
+			}
+			typ, provider := bindingType(c, store, args[0])
+			bail(api.WriteServiceBinding(filepath.Join(dir, name), typ, provider, creds, opt.Creds.Separator))
+			fmt.Fprintf(os.Stderr, "wrote binding @G{%s} to @C{%s}\n", args[0], filepath.Join(dir, name))
+
+		default:
+			bail(formatCredentials(os.Stdout, creds, format, opt.Creds.Prefix, opt.Creds.Separator))
+		}
+		os.Exit(0)
+
+	case "exec":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] @M{BINDING} -- @M{COMMAND} [@M{ARGS...}]\n\n", os.Args[0], command)
+			fmt.Printf("Runs a command with the credentials of a binding injected into\n")
+			fmt.Printf("its environment, and exits with the command's exit code.  The\n")
+			fmt.Printf("credentials are never printed.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --no-env         Don't set flattened, upper-cased environment\n")
+			fmt.Printf("                   variables (like @W{DB_HOST}) for each credential.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --prefix         A prefix for environment variable names.\n")
+			fmt.Printf("  --separator      What to join nested keys with.  Defaults to @W{_}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --vcap           Set @W{VCAP_SERVICES}, as Cloud Foundry would.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --binding-root   Project the binding into a temporary directory,\n")
+			fmt.Printf("                   per servicebinding.io, and set @W{SERVICE_BINDING_ROOT}.\n")
+			fmt.Printf("                   The directory is removed when the command exits.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -r, --refresh    Fetch the credentials from the broker first, if\n")
+			fmt.Printf("                   the broker allows bindings to be retrieved.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) < 2 {
+			fmt.Printf("USAGE: @Y{%s} [@W{options}] @C{exec} BINDING-ID -- COMMAND [ARGS...]\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		// the whole point of exec is to hand the credentials to the
+		// command without showing them, so --trace can't show them either
+		if opt.Exec.Refresh && c.Trace {
+			fmt.Fprintf(os.Stderr, "@Y{not tracing the refresh, to keep the credentials out of the terminal}\n")
+			c.Trace = false
+		}
+		creds := credentials(c, store, args[0], opt.Exec.Refresh)
+		env := make(map[string]string)
+		if opt.Exec.Env {
+			env = envify(creds, opt.Exec.Prefix, opt.Exec.Separator)
+		}
+
+		cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+		bail(err)
+
+		if opt.Exec.VCAP {
+			vcap, err := store.VCAP(c.URL, cache, args[0])
+			bail(err)
+			b, err := vcap.JSON()
+			bail(err)
+			env["VCAP_SERVICES"] = string(b)
+		}
+
+		root := ""
+		if opt.Exec.BindingRoot {
+			root, err = tempBindingRoot()
+			bail(err)
+
+			typ, provider := bindingType(c, store, args[0])
+			if err := api.WriteServiceBinding(filepath.Join(root, args[0]), typ, provider, creds, opt.Exec.Separator); err != nil {
+				os.RemoveAll(root)
+				bail(err)
+			}
+			env["SERVICE_BINDING_ROOT"] = root
+		}
+
+		rc := run(args[1:], env)
+		if root != "" {
+			os.RemoveAll(root)
+		}
+		os.Exit(rc)
+
+	case "vcap":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] [@M{BINDING}...]\n\n", os.Args[0], command)
+			fmt.Printf("Generates a Cloud Foundry @W{VCAP_SERVICES} document from the given\n")
+			fmt.Printf("bindings in ~/.osbrc, or from all of the bindings for the current\n")
+			fmt.Printf("broker if none are given.  Service labels, plan names and tags are\n")
+			fmt.Printf("taken from the cached catalog, if there is one.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -p, --pretty   Indent the JSON, for human consumption.\n")
+			fmt.Printf("  --env          Emit an @W{export VCAP_SERVICES=...} line, suitable\n")
+			fmt.Printf("                 for @W{eval}-ing in a shell.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+		bail(err)
+
+		vcap, err := store.VCAP(c.URL, cache, args...)
+		bail(err)
+
+		b, err := vcap.JSON()
+		bail(err)
+		if opt.VCAP.Env {
+			fmt.Printf("export VCAP_SERVICES=%s\n", shellQuote(string(b)))
+			os.Exit(0)
+		}
+		if opt.VCAP.Pretty {
+			b, err = json.MarshalIndent(vcap, "", "  ")
+			bail(err)
+		}
+		fmt.Printf("%s\n", string(b))
+		os.Exit(0)
+
+	case "render":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} @W{--binding} @M{[NAME=]BINDING} @W{--template} @M{FILE} [@W{options}]\n\n", os.Args[0], command)
+			fmt.Printf("Renders a Go text/template with the credentials of one or more\n")
+			fmt.Printf("bindings from ~/.osbrc, to build configuration files.\n")
+			fmt.Printf("\n")
+			fmt.Printf("The credentials of the first binding are available as @W{.Credentials};\n")
+			fmt.Printf("every binding is also available by name (or by ID, if it was not\n")
+			fmt.Printf("given one) as @W{.Bindings.NAME}, with @W{.ID}, @W{.Instance}, @W{.Service},\n")
+			fmt.Printf("@W{.Plan}, @W{.Tags} and @W{.Credentials}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Besides the standard template functions, you can use:\n\n")
+			fmt.Printf("  @W{url} SCHEME USER PASS HOST PORT PATH   Build a properly-escaped URL.\n")
+			fmt.Printf("  @W{hostport} HOST PORT                    Join a host and port.\n")
+			fmt.Printf("  @W{b64enc} / @W{b64dec}                       Base64 encode / decode.\n")
+			fmt.Printf("  @W{json} / @W{prettyjson}                     Marshal a value as JSON.\n")
+			fmt.Printf("  @W{default} VALUE                         Use VALUE if the input is empty.\n")
+			fmt.Printf("  @W{required} MESSAGE                      Fail with MESSAGE if it is empty.\n")
+			fmt.Printf("  @W{env} NAME                              Look up an environment variable.\n")
+			fmt.Printf("  @W{upper}, @W{lower}, @W{join}, @W{replace}, @W{trimprefix}, @W{trimsuffix},\n")
+			fmt.Printf("  @W{queryescape}, @W{pathescape}\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -b, --binding    A binding to render, optionally named, as in\n")
+			fmt.Printf("                   @W{--binding db=BINDING}.  Can be given more than once.\n")
+			fmt.Printf("  -f, --template   The template file to render.\n")
+			fmt.Printf("  -o, --out        Where to write the result.  The file is replaced\n")
+			fmt.Printf("                   atomically, and is only readable by you (0600).\n")
+			fmt.Printf("                   Defaults to standard output.\n")
+			fmt.Printf("  -r, --refresh    Retrieve the credentials from the broker first,\n")
+			fmt.Printf("                   if its bindings are retrievable.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(opt.Render.Bindings) == 0 || opt.Render.Template == "" || len(args) != 0 {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} render --binding [NAME=]BINDING --template FILE [--out FILE]\n", os.Args[0])
+			os.Exit(1)
+		}
+
+		data := renderData{Bindings: make(map[string]renderBinding)}
+		for i, b := range opt.Render.Bindings {
+			name, bid := b, b
+			if n := strings.Index(b, "="); n >= 0 {
+				name, bid = b[:n], b[n+1:]
+			}
+			if _, dup := data.Bindings[name]; dup {
+				bail(fmt.Errorf("binding name '%s' given more than once", name))
+			}
+			data.Bindings[name] = renderBindingFor(c, store, bid, opt.Render.Refresh)
+			if i == 0 {
+				data.Credentials = data.Bindings[name].Credentials
+			}
+		}
+
+		out, err := render(opt.Render.Template, data)
+		bail(err)
+
+		if opt.Render.Out == "" || opt.Render.Out == "-" {
+			os.Stdout.Write(out)
+			os.Exit(0)
+		}
+		bail(writeAtomically(opt.Render.Out, out))
+		os.Exit(0)
+
+	case "apply":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] @M{MANIFEST}\n\n", os.Args[0], command)
+			fmt.Printf("Provisions, updates and binds whatever it takes to make the brokers\n")
+			fmt.Printf("named in @M{MANIFEST} match it, waiting for asynchronous operations\n")
+			fmt.Printf("to finish.  A plan of what is going to change is shown first.\n")
+			fmt.Printf("\n")
+			fmt.Printf("The manifest is YAML, and looks like this:\n")
+			fmt.Printf("\n")
+			fmt.Printf("  seed: my-test-env\n")
+			fmt.Printf("  targets:\n")
+			fmt.Printf("    - name:     local\n")
+			fmt.Printf("      url:      http://localhost:3000\n")
+			fmt.Printf("      username: admin\n")
+			fmt.Printf("      password: $BROKER_PASSWORD\n")
+			fmt.Printf("      profile:  cloudfoundry\n")
+			fmt.Printf("  instances:\n")
+			fmt.Printf("    - name:    pg\n")
+			fmt.Printf("      target:  local\n")
+			fmt.Printf("      service: postgres\n")
+			fmt.Printf("      plan:    small\n")
+			fmt.Printf("      parameters: { extensions: [ pgcrypto ] }\n")
+			fmt.Printf("      bindings:\n")
+			fmt.Printf("        - name: app1\n")
+			fmt.Printf("\n")
+			fmt.Printf("Instances and bindings are known by their @W{name}, which becomes their\n")
+			fmt.Printf("alias in ~/.osbrc.  Unless given an explicit @W{id}, their IDs are\n")
+			fmt.Printf("derived from their name, the target URL, and the @W{seed}, so that\n")
+			fmt.Printf("applying the same manifest again is harmless.  If there are no\n")
+			fmt.Printf("targets, the current @W{--endpoint} is used.  $VARIABLES are\n")
+			fmt.Printf("expanded from the environment.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Plan changes for services that aren't @W{plan_updateable} are shown\n")
+			fmt.Printf("in the plan (marked @R{!}), but skipped.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --prune            Also unbind and deprovision anything in ~/.osbrc\n")
+			fmt.Printf("                     for the manifest's targets that isn't in it.\n")
+			fmt.Printf("  -w, --max-wait     How long to wait for each asynchronous operation\n")
+			fmt.Printf("                     to finish.  Defaults to @W{30m}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -n, --dry-run      Show the plan, and stop.\n")
+			fmt.Printf("  -y, --yes          Don't ask for confirmation before applying.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} [@W{options}] @C{apply} MANIFEST\n", os.Args[0])
+			os.Exit(1)
+		}
+		wait, err := parseAge(opt.Apply.MaxWait)
+		bail(err)
+
+		m, err := readManifest(args[0])
+		bail(err)
+
+		path := api.CatalogCachePath(opt.Data)
+		cache, err := api.ReadCatalogCache(path)
+		bail(err)
+		actions, prunes, err := planApply(m, store, cache, opt.Apply.Prune)
+		bail(err)
+		if err := cache.Write(path); err != nil {
+			fmt.Fprintf(os.Stderr, "@Y{!!! unable to cache catalog: %s}\n", err)
+		}
+
+		targets := make(map[string]*applyTarget)
+		for i := range m.Targets {
+			targets[m.Targets[i].URL] = &m.Targets[i]
+		}
+
+		if len(actions)+len(prunes) == 0 {
+			if !opt.JSON {
+				fmt.Printf("nothing to do; everything is up to date.\n")
+			} else {
+				jsonify([]interface{}{})
+			}
+			os.Exit(0)
+		}
+
+		if !opt.JSON {
+			named := func(name, id string) string {
+				if name == "" {
+					return id
+				}
+				return fmt.Sprintf("%s (%s)", name, id)
+			}
+			t := table.NewTable("", "Action", "Target", "Instance", "Binding", "Service", "Plan", "Changes")
+			for _, a := range actions {
+				mark, inst, bid := fmt.Sprintf("@G{+}"), named(a.Name, a.Instance), "-"
+				if a.Verb == "update" {
+					mark = fmt.Sprintf("@Y{~}")
+				}
+				if a.Blocked != "" {
+					mark = fmt.Sprintf("@R{!}")
+				}
+				if a.Verb == "bind" {
+					inst, bid = named(a.instance.Name, a.Instance), named(a.Name, a.Binding)
+				}
+				t.Row(nil, mark, a.Verb, a.Target, inst, bid, a.Service, a.Plan, strings.Join(a.Changes, "\n"))
+			}
+			for _, op := range prunes {
+				inst, bid := store.Alias(op.Broker, op.Instance), "-"
+				inst = named(inst, op.Instance)
+				if op.Binding != "" {
+					bid = named(store.Alias(op.Broker, op.Binding), op.Binding)
+				}
+				service, plan := op.ServiceID, op.PlanID
+				if cat := cache.Get(op.Broker); cat != nil {
+					service, plan = cat.Names(service, plan)
+				}
+				t.Row(nil, fmt.Sprintf("@R{-}"), op.Verb, targets[op.Broker].Name, inst, bid, service, plan, "")
+			}
+			t.Output(os.Stdout)
+			fmt.Printf("\n")
+			blocked := false
+			for _, a := range actions {
+				if a.Blocked != "" {
+					fmt.Printf("@R{!!! %s can't be updated:} %s\n", a.Name, a.Blocked)
+					blocked = true
+				}
+			}
+			if blocked {
+				fmt.Printf("\n")
+			}
+		}
+
+		if opt.Apply.DryRun {
+			if opt.JSON {
+				jsonify(struct {
+					Actions []*applyAction `json:"actions"`
+					Prune   []*teardownOp  `json:"prune"`
+				}{actions, prunes})
+			}
+			os.Exit(0)
+		}
+		if !opt.Apply.Yes && !confirm(fmt.Sprintf("Apply @Y{%d} change(s)?", len(actions)+len(prunes))) {
+			fmt.Fprintf(os.Stderr, "@R{aborted.}\n")
+			os.Exit(1)
+		}
+
+		n := map[string]int{}
+		failed := make(map[string]bool)
+		for _, a := range actions {
+			if a.Blocked != "" {
+				a.Status = "skipped"
+				a.Error = a.Blocked
+				n["skipped"]++
+				if !opt.JSON {
+					fmt.Printf("@Y{%-11s} %s %s @Y{skipped}: %s\n", a.Verb, a.Instance, a.Binding, a.Error)
+				}
+				continue
+			}
+			if failed[a.target.URL+" "+a.Instance] {
+				a.Status = "skipped"
+				a.Error = "the instance could not be provisioned"
+				n["skipped"]++
+				if !opt.JSON {
+					fmt.Printf("@Y{%-11s} %s %s @Y{skipped}: %s\n", a.Verb, a.Instance, a.Binding, a.Error)
+				}
+				continue
+			}
+
+			start := time.Now()
+			err := apply(store, a, wait)
+			a.Took = int64(time.Since(start) / time.Millisecond)
+			if err := store.Write(opt.Data); err != nil {
+				fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+			}
+			if err != nil {
+				a.Status = "failed"
+				a.Error = err.Error()
+				n["failed"]++
+				if a.Verb == "provision" {
+					failed[a.target.URL+" "+a.Instance] = true
+				}
+				if !opt.JSON {
+					fmt.Printf("@R{%-11s} %s %s @R{failed}: %s\n", a.Verb, a.Instance, a.Binding, a.Error)
+				}
+				continue
+			}
+			n[a.Verb]++
+			if !opt.JSON {
+				fmt.Printf("@G{%-11s} %s %s @G{%s}\n", a.Verb, a.Instance, a.Binding, a.Status)
+			}
+		}
+
+		for _, op := range prunes {
+			if op.Verb == "deprovision" && failed[op.Broker+" "+op.Instance] {
+				op.Status = "skipped"
+				op.Error = "not all bindings could be unbound"
+				n["skipped"]++
+				if !opt.JSON {
+					fmt.Printf("@Y{%-11s} %s @Y{skipped}: %s\n", op.Verb, op.Instance, op.Error)
+				}
+				continue
+			}
+
+			start := time.Now()
+			err := teardown(targets[op.Broker].client, op, wait)
+			op.Took = int64(time.Since(start) / time.Millisecond)
+			if err != nil {
+				op.Status = "failed"
+				op.Error = err.Error()
+				n["failed"]++
+				failed[op.Broker+" "+op.Instance] = true
+				if !opt.JSON {
+					fmt.Printf("@R{%-11s} %s %s @R{failed}: %s\n", op.Verb, op.Instance, op.Binding, op.Error)
+				}
+				continue
+			}
+
+			if op.Verb == "unbind" {
+				store.RemoveBinding(op.Broker, op.Instance, op.Binding)
+			} else {
+				store.RemoveInstance(op.Broker, op.Instance)
+			}
+			if err := store.Write(opt.Data); err != nil {
+				fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+			}
+			n[op.Verb]++
+			if !opt.JSON {
+				fmt.Printf("@G{%-11s} %s %s @G{%s}\n", op.Verb, op.Instance, op.Binding, op.Status)
+			}
+		}
+
+		if opt.JSON {
+			jsonify(struct {
+				Actions []*applyAction `json:"actions"`
+				Prune   []*teardownOp  `json:"prune"`
+			}{actions, prunes})
+		} else {
+			fmt.Printf("\n%d provisioned, %d updated, %d bound, %d unbound, %d deprovisioned, %d failed, %d skipped\n",
+				n["provision"], n["update"], n["bind"], n["unbind"], n["deprovision"], n["failed"], n["skipped"])
+		}
+		if n["failed"]+n["skipped"] > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "batch":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] [@M{FILE}]\n\n", os.Args[0], command)
+			fmt.Printf("Runs a batch of provision, update, bind, unbind and deprovision jobs\n")
+			fmt.Printf("against the broker, several at a time, waiting for asynchronous\n")
+			fmt.Printf("operations to finish.  Jobs are read from @M{FILE} (or standard input,\n")
+			fmt.Printf("if @M{FILE} is missing or @W{-}), one JSON object per line:\n")
+			fmt.Printf("\n")
+			fmt.Printf("  {\"verb\":\"provision\",\"service\":\"postgres\",\"plan\":\"small\",\"name\":\"pg1\"}\n")
+			fmt.Printf("  {\"verb\":\"bind\",\"instance\":\"pg1\",\"name\":\"app1\"}\n")
+			fmt.Printf("  {\"verb\":\"unbind\",\"binding\":\"app1\"}\n")
+			fmt.Printf("  {\"verb\":\"deprovision\",\"instance\":\"pg1\"}\n")
+			fmt.Printf("\n")
+			fmt.Printf("Jobs can also have a @W{ref} (handed back in the results), @W{labels},\n")
+			fmt.Printf("@W{parameters}, and a @W{context}.  Instances and bindings can be given by\n")
+			fmt.Printf("ID, by alias, or by a @W{name} given to them earlier in the batch.\n")
+			fmt.Printf("Jobs against the same instance run in order; if the instance can't\n")
+			fmt.Printf("be provisioned, the rest of its jobs are skipped.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Results are printed as they come in, one JSON object per line, with\n")
+			fmt.Printf("a final @W{summary} line.  ~/.osbrc is kept up to date as jobs finish.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -j, --parallel     How many jobs to run at once.  Defaults to @W{4}.\n")
+			fmt.Printf("  -w, --max-wait     How long to wait for each asynchronous operation\n")
+			fmt.Printf("                     to finish.  Defaults to @W{30m}.\n")
+			fmt.Printf("  --retries          How many times to retry a job that the broker\n")
+			fmt.Printf("                     refuses with a @W{ConcurrencyError}, backing off a\n")
+			fmt.Printf("                     bit more each time.  Defaults to @W{5}.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} [@W{options}] @C{batch} [FILE]\n", os.Args[0])
+			os.Exit(1)
+		}
+		connecting()
+		wait, err := parseAge(opt.Batch.MaxWait)
+		bail(err)
+
+		in := os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			bail(err)
+			defer f.Close()
+			in = f
+		}
+
+		var catalog *api.Catalog
+		plan, err := readBatch(in, store, c, func() *api.Catalog {
+			if catalog == nil {
+				catalog = fetchCatalog(c)
+			}
+			return catalog
+		})
+		bail(err)
+
+		c.AcceptsIncomplete = true
+		b := &api.Batch{
+			Client:   c,
+			Parallel: opt.Batch.Parallel,
+			MaxWait:  wait,
+			Retries:  opt.Batch.Retries,
+		}
+
+		jobs := make(chan api.Job)
+		go func() {
+			for _, job := range plan.Jobs {
+				jobs <- job
+			}
+			close(jobs)
+		}()
+
+		summary := b.Run(jobs, func(r api.JobResult) {
+			plan.record(store, c.URL, r)
+			if err := store.Write(opt.Data); err != nil {
+				fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+			}
+			jsonify(r)
+		})
+		jsonify(struct {
+			Summary api.BatchSummary `json:"summary"`
+		}{summary})
+
+		if summary.Failed+summary.Skipped > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "test":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] [@M{SERVICE}[/@M{PLAN}] ...]\n\n", os.Args[0], command)
+			fmt.Printf("Runs the whole OSB lifecycle against the broker, for each of the\n")
+			fmt.Printf("given service plans (or every plan in the catalog): catalog,\n")
+			fmt.Printf("provision (sync, then async if the broker insists), last_operation\n")
+			fmt.Printf("polling, fetch, update, bind, fetch binding, unbind and deprovision.\n")
+			fmt.Printf("Every response is checked against what the spec requires.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Then, requests that brokers have to reject are sent, checking for\n")
+			fmt.Printf("the right error: a missing or wrong @W{X-Broker-API-Version} (412),\n")
+			fmt.Printf("bad credentials (401), unknown service and plan IDs and malformed\n")
+			fmt.Printf("JSON (400), duplicate provisions (409), deprovisioning something that\n")
+			fmt.Printf("isn't there (410), binding a non-bindable plan, and synchronous\n")
+			fmt.Printf("requests for asynchronous-only plans (422 @W{AsyncRequired}).\n")
+			fmt.Printf("\n")
+			fmt.Printf("Test instances and bindings are always unbound and deprovisioned,\n")
+			fmt.Printf("even if an earlier step fails.  They are not recorded in ~/.osbrc.\n")
+			fmt.Printf("Exits non-zero if anything fails.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --suite            Only run the @W{lifecycle} tests, or the @W{negative}\n")
+			fmt.Printf("                     ones.  By default, both are run.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -o, --format       How to report the results: @W{summary} (the default),\n")
+			fmt.Printf("                     @W{json}, or @W{junit} (XML, for CI systems).\n")
+			fmt.Printf("  --junit            Also write JUnit XML results to the given file.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --params           A JSON object of parameters to send with each\n")
+			fmt.Printf("                     provision request.\n")
+			fmt.Printf("  -w, --max-wait     How long to wait for each asynchronous operation\n")
+			fmt.Printf("                     to finish.  Defaults to @W{30m}.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		wait, err := parseAge(opt.Test.MaxWait)
+		bail(err)
+		if opt.JSON {
+			opt.Test.Format = "json"
+		}
+		switch opt.Test.Format {
+		case "summary", "json", "junit":
+		default:
+			bail(fmt.Errorf("unrecognized format '%s' (try summary, json, or junit)", opt.Test.Format))
+		}
+
+		var params map[string]interface{}
+		if opt.Test.Parameters != "" {
+			bail(json.Unmarshal([]byte(opt.Test.Parameters), &params))
+		}
+
+		report, err := c.Conform(api.ConformanceSpec{
+			Suite:      opt.Test.Suite,
+			Plans:      args,
+			Parameters: params,
+			MaxWait:    wait,
+		})
+		bail(err)
+
+		if opt.Test.JUnit != "" {
+			f, err := os.Create(opt.Test.JUnit)
+			bail(err)
+			bail(junitConformance(f, report))
+			bail(f.Close())
+		}
+
+		switch opt.Test.Format {
+		case "json":
+			jsonify(report)
+		case "junit":
+			bail(junitConformance(os.Stdout, report))
+		default:
+			printConformance(os.Stdout, report)
+		}
+
+		if report.Result != api.TestPassed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "stress":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] @M{SERVICE}/@M{PLAN}\n\n", os.Args[0], command)
+			fmt.Printf("Sends lots of requests for the same service instance to the broker,\n")
+			fmt.Printf("all at once, to see if it copes.  Refusing some of them with a 422\n")
+			fmt.Printf("@W{ConcurrencyError} is fine; falling over with 5xx errors, or losing\n")
+			fmt.Printf("track of what exists, is not.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Afterwards, every instance and binding that might exist is checked:\n")
+			fmt.Printf("those the broker said it made should be there, and the rest should\n")
+			fmt.Printf("not, according to both a GET (if the service allows that) and the\n")
+			fmt.Printf("response to unbinding or deprovisioning it, which also cleans up.\n")
+			fmt.Printf("Exits non-zero if there were any 5xx errors, or inconsistencies.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --workload         What to do at once, which is one of:\n")
+			fmt.Printf("                       @W{binds}        bind one instance, over and over\n")
+			fmt.Printf("                       @W{provision}    provision and deprovision one ID\n")
+			fmt.Printf("                       @W{update-bind}  update and bind one instance\n")
+			fmt.Printf("                     Defaults to @W{binds}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -j, --parallel     How many requests to send at once.  Defaults to @W{10}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --params           A JSON object of parameters to send with each\n")
+			fmt.Printf("                     provision and bind request.\n")
+			fmt.Printf("  -w, --max-wait     How long to wait for each asynchronous operation\n")
+			fmt.Printf("                     to finish.  Defaults to @W{30m}.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		if len(args) != 1 || !strings.Contains(args[0], "/") {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} stress [--workload %s] SERVICE/PLAN\n", os.Args[0], strings.Join(api.StressWorkloads, "|"))
+			os.Exit(1)
+		}
+		wait, err := parseAge(opt.Stress.MaxWait)
+		bail(err)
+
+		var params map[string]interface{}
+		if opt.Stress.Parameters != "" {
+			bail(json.Unmarshal([]byte(opt.Stress.Parameters), &params))
+		}
+
+		l := strings.SplitN(args[0], "/", 2)
+		catalog := fetchCatalog(c)
+		service, plan, err := catalog.FindPlan(l[0], l[1])
+		bail(err)
+
+		report, err := c.Stress(catalog, api.StressSpec{
+			Workload:   opt.Stress.Workload,
+			ServiceID:  service,
+			PlanID:     plan,
+			Parallel:   opt.Stress.Parallel,
+			Parameters: params,
+			MaxWait:    wait,
+		})
+		bail(err)
+
+		if opt.JSON {
+			jsonify(report)
+		} else {
+			printStress(os.Stdout, report)
+		}
+
+		if report.Failed() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "bench":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] [@M{SERVICE}/@M{PLAN}]\n\n", os.Args[0], command)
+			fmt.Printf("Puts load on the broker, by running the same cycle of requests over\n")
+			fmt.Printf("and over, for a while, and then reports the throughput, error rate,\n")
+			fmt.Printf("and latency (p50, p90, p99 and max) of each endpoint.  Asynchronous\n")
+			fmt.Printf("operations also get a @W{(done)} row, timed from the first request to\n")
+			fmt.Printf("the operation finishing.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Everything provisioned or bound is cleaned up afterwards, even if\n")
+			fmt.Printf("you interrupt the benchmark with Ctrl-C; anything that can't be is\n")
+			fmt.Printf("listed, so that you can clean it up by hand.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --workload         What to do, over and over, which is one of:\n")
+			fmt.Printf("                       @W{catalog}      fetch the catalog\n")
+			fmt.Printf("                       @W{provision}    provision, then deprovision\n")
+			fmt.Printf("                       @W{bind}         bind, then unbind (one instance)\n")
+			fmt.Printf("                     Defaults to @W{catalog}.  The others need a\n")
+			fmt.Printf("                     @M{SERVICE}/@M{PLAN} to work with.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -d, --duration     How long to keep it up.  Defaults to @W{1m}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -r, --rate         How many cycles to start every second.  By default,\n")
+			fmt.Printf("                     they are started as fast as they finish.\n")
+			fmt.Printf("  -j, --parallel     How many cycles to run at once.  Defaults to @W{10}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --poll             How often to poll for asynchronous operations to\n")
+			fmt.Printf("                     finish.  Defaults to @W{1s}.\n")
+			fmt.Printf("  -w, --max-wait     How long to wait for each asynchronous operation\n")
+			fmt.Printf("                     to finish.  Defaults to @W{30m}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --params           A JSON object of parameters to send with each\n")
+			fmt.Printf("                     provision and bind request.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -o, --format       How to report the results: @W{table}, @W{json}\n")
+			fmt.Printf("                     or @W{csv}.  Defaults to @W{table}.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		connecting()
+		if opt.JSON {
+			opt.Bench.Format = "json"
+		}
+		switch opt.Bench.Format {
+		case "table", "json", "csv":
+		default:
+			bail(fmt.Errorf("unrecognized format '%s' (try table, json, or csv)", opt.Bench.Format))
+		}
+		if len(args) > 1 || (opt.Bench.Workload != api.BenchCatalog && (len(args) != 1 || !strings.Contains(args[0], "/"))) {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} bench [--workload %s] [SERVICE/PLAN]\n", os.Args[0], strings.Join(api.BenchWorkloads, "|"))
+			os.Exit(1)
+		}
+
+		duration, err := parseAge(opt.Bench.Duration)
+		bail(err)
+		poll, err := parseAge(opt.Bench.Poll)
+		bail(err)
+		wait, err := parseAge(opt.Bench.MaxWait)
+		bail(err)
+
+		var params map[string]interface{}
+		if opt.Bench.Parameters != "" {
+			bail(json.Unmarshal([]byte(opt.Bench.Parameters), &params))
+		}
+
+		catalog := fetchCatalog(c)
+		var service, plan string
+		if len(args) == 1 {
+			l := strings.SplitN(args[0], "/", 2)
+			service, plan, err = catalog.FindPlan(l[0], l[1])
+			bail(err)
+		}
+
+		stop, done := stopOnInterrupt()
+		report, err := c.Bench(catalog, api.BenchSpec{
+			Workload:   opt.Bench.Workload,
+			ServiceID:  service,
+			PlanID:     plan,
+			Parameters: params,
+			Duration:   duration,
+			Parallel:   opt.Bench.Parallel,
+			Rate:       opt.Bench.Rate,
+			Interval:   poll,
+			MaxWait:    wait,
+			Stop:       stop,
+		})
+		done()
+		bail(err)
+
+		switch opt.Bench.Format {
+		case "json":
+			jsonify(report)
+		case "csv":
+			bail(csvBench(os.Stdout, report))
+		default:
+			printBench(os.Stdout, report)
+		}
+
+		if len(report.Leftovers) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "run":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}] @M{SCENARIO.yml}\n\n", os.Args[0], command)
+			fmt.Printf("Runs a scenario: a list of steps that each make a request of the\n")
+			fmt.Printf("broker, and check what it says back.  Steps are one of @W{catalog},\n")
+			fmt.Printf("@W{provision}, @W{update}, @W{deprovision}, @W{bind}, @W{unbind}, @W{wait} (for the last\n")
+			fmt.Printf("asynchronous operation to finish), or @W{request} (for anything else):\n")
+			fmt.Printf("\n")
+			fmt.Printf("  name: small databases have the right port\n")
+			fmt.Printf("  steps:\n")
+			fmt.Printf("    - provision: { service: db, plan: small }\n")
+			fmt.Printf("    - wait: {}\n")
+			fmt.Printf("    - bind: {}\n")
+			fmt.Printf("      expect:\n")
+			fmt.Printf("        status:     201\n")
+			fmt.Printf("        osb_status: bound\n")
+			fmt.Printf("        json:       { $.credentials.port: 5432 }\n")
+			fmt.Printf("        within:     5s\n")
+			fmt.Printf("      capture:\n")
+			fmt.Printf("        user: $.credentials.username\n")
+			fmt.Printf("    - request:\n")
+			fmt.Printf("        method: GET\n")
+			fmt.Printf("        path:   /v2/service_instances/${instance}\n")
+			fmt.Printf("    - deprovision: {}\n")
+			fmt.Printf("      always: true\n")
+			fmt.Printf("\n")
+			fmt.Printf("Without an @W{expect}, any 2xx response will do (so will a 410 Gone, for\n")
+			fmt.Printf("@W{unbind} and @W{deprovision}; for @W{wait}, the operation has to have\n")
+			fmt.Printf("succeeded).  @W{${instance}}, @W{${binding}} and @W{${operation}}\n")
+			fmt.Printf("are set by lifecycle steps; other variables come from @W{vars}, @W{--var},\n")
+			fmt.Printf("and @W{capture}, which takes values from responses, by JSONPath.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Once a step fails, the rest are skipped, except for those marked\n")
+			fmt.Printf("@W{always}, which are for cleaning up.  Exits non-zero if anything\n")
+			fmt.Printf("fails.  Scenario instances are not recorded in ~/.osbrc.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  --var              Set a variable, as @W{name=value}, overriding the\n")
+			fmt.Printf("                     scenario's own @W{vars}.  Can be given more than once.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --junit            Also write JUnit XML results to the given file.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -w, --max-wait     How long @W{wait} steps wait for operations to finish,\n")
+			fmt.Printf("                     unless they say otherwise.  Defaults to @W{30m}.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} run SCENARIO.yml\n", os.Args[0])
+			os.Exit(1)
+		}
+		connecting()
+
+		scenario, err := api.ReadScenario(args[0])
+		bail(err)
+		vars, err := parseVars(opt.Run.Vars)
+		bail(err)
+		wait, err := parseAge(opt.Run.MaxWait)
+		bail(err)
+
+		report := c.RunScenario(scenario, api.ScenarioSpec{
+			Vars:    vars,
+			MaxWait: wait,
+		})
+
+		if opt.Run.JUnit != "" {
+			f, err := os.Create(opt.Run.JUnit)
+			bail(err)
+			bail(junitScenario(f, report))
+			bail(f.Close())
+		}
+
+		if opt.JSON {
+			jsonify(report)
+		} else {
+			printScenario(os.Stdout, report)
+		}
+
+		if report.Result != api.TestPassed {
+			os.Exit(1)
+		}
+		os.Exit(0)
+
+	case "mock":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}]\n\n", os.Args[0], command)
+			fmt.Printf("Runs a pretend OSB service broker, that keeps its instances and\n")
+			fmt.Printf("bindings in memory, for developing and testing platforms (and\n")
+			fmt.Printf("scripts) against, without a real broker, or any real services.\n")
+			fmt.Printf("\n")
+			fmt.Printf("The broker serves the catalog it is given, and follows the spec:\n")
+			fmt.Printf("duplicate provisions and binds are 200 OK if they match, and 409\n")
+			fmt.Printf("Conflict if they don't; things that are already gone are 410 Gone;\n")
+			fmt.Printf("plan changes and @W{maintenance_info} are checked against the catalog.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Each plan in the catalog can say how the broker should behave, via a\n")
+			fmt.Printf("@W{mock} key (which clients never see):\n")
+			fmt.Printf("\n")
+			fmt.Printf("  plans:\n")
+			fmt.Printf("    - name: small\n")
+			fmt.Printf("      mock:\n")
+			fmt.Printf("        async:    true   # provision, update and deprovision are async\n")
+			fmt.Printf("        bindings: async  # and so are bind and unbind\n")
+			fmt.Printf("        duration: 10s    # how long async operations take\n")
+			fmt.Printf("\n")
+			fmt.Printf("HTTP Basic Auth credentials are taken from @W{--username} and @W{--password}\n")
+			fmt.Printf("(or @W{OSB_USERNAME} and @W{OSB_PASSWORD}); without them, anyone can connect.\n")
+			fmt.Printf("\n")
+			fmt.Printf("The current state of the broker can be seen with @W{GET /admin/state},\n")
+			fmt.Printf("and thrown away with @W{DELETE /admin/state}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -c, --catalog      A YAML (or JSON) file with the catalog to serve.\n")
+			fmt.Printf("                     By default, a single @W{mockdb} service is served,\n")
+			fmt.Printf("                     with sync, async and non-bindable plans.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -l, --listen       The address to listen on.  Defaults to @W{:3000}.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		catalog, err := mock.ReadCatalog(opt.Mock.Catalog)
+		bail(err)
+
+		broker := mock.New(catalog, opt.Username, opt.Password)
+		broker.Log = os.Stderr
+		if opt.Username == "" && opt.Password == "" {
+			fmt.Fprintf(os.Stderr, "@Y{!!! no --username or --password given; HTTP Basic Auth is disabled}\n")
+		}
+		for _, s := range catalog.Services {
+			for _, p := range s.Plans {
+				fmt.Fprintf(os.Stderr, "serving @M{%s}/@M{%s}\n", s.Name, p.Name)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "listening on @C{%s}\n", opt.Mock.Listen)
+		bail(http.ListenAndServe(opt.Mock.Listen, broker))
+		os.Exit(0)
+
+	case "proxy":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} [@W{options}]\n\n", os.Args[0], command)
+			fmt.Printf("Sits between a platform (like Cloud Foundry, or the Kubernetes\n")
+			fmt.Printf("Service Catalog) and a service broker, forwarding everything from\n")
+			fmt.Printf("the one to the other, untouched, so that you can see what they say\n")
+			fmt.Printf("to each other.  Point the platform at the proxy instead of the broker.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Each exchange is logged to standard error, colored like @W{--trace}\n")
+			fmt.Printf("(except that the Authorization header is redacted), and checked\n")
+			fmt.Printf("against the OSB spec: required headers, fields and query parameters\n")
+			fmt.Printf("in requests, and status codes and fields in responses.  Violations\n")
+			fmt.Printf("are flagged with @R{!!!} as they happen.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Options:\n\n")
+			fmt.Printf("  -l, --listen       The address to listen on.  Defaults to @W{:8080}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -u, --upstream     The URL of the broker to forward to.  Defaults\n")
+			fmt.Printf("                     to the current broker (@W{--endpoint} / @W{OSB_URL}).\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --save             Save the instances and bindings that the platform\n")
+			fmt.Printf("                     creates (and forget the ones it deletes) in the\n")
+			fmt.Printf("                     osb store, so that osb can manage them later.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  -q, --quiet        Only log exchanges that violate the spec, or that\n")
+			fmt.Printf("                     chaos rules applied to.\n")
+			fmt.Printf("\n")
+			fmt.Printf("  --chaos            A YAML file of rules for injecting faults into\n")
+			fmt.Printf("                     the exchanges, to see how the platform copes\n")
+			fmt.Printf("                     with a misbehaving broker (see below).\n")
+			fmt.Printf("\n")
+			fmt.Printf("Chaos rules look like this:\n")
+			fmt.Printf("\n")
+			fmt.Printf("  rules:\n")
+			fmt.Printf("    - name:        flaky binds\n")
+			fmt.Printf("      endpoints:   [bind, unbind]   # any endpoint, by default\n")
+			fmt.Printf("      service:     mockdb           # any service, by default\n")
+			fmt.Printf("      plan:        large            # any plan, by default\n")
+			fmt.Printf("      probability: 0.25             # 1 (always), by default\n")
+			fmt.Printf("      latency:     2s               # extra delay, before responding\n")
+			fmt.Printf("      fault:       503\n")
+			fmt.Printf("\n")
+			fmt.Printf("The first rule that matches a request (and wins its roll of the\n")
+			fmt.Printf("dice) applies to it.  Services and plans can be given by name (as\n")
+			fmt.Printf("seen in the last catalog fetched through the proxy) or ID.  The\n")
+			fmt.Printf("endpoints are @W{catalog}, @W{provision}, @W{fetch instance}, @W{update},\n")
+			fmt.Printf("@W{deprovision}, @W{last_operation}, @W{bind}, @W{fetch binding}, @W{unbind} and\n")
+			fmt.Printf("@W{binding last_operation}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("Faults are one of:\n")
+			fmt.Printf("\n")
+			fmt.Printf("  @W{500}, @W{503}, @W{408}, ...  respond with that HTTP status, instead of the broker.\n")
+			fmt.Printf("  @W{drop}                hang up on the platform, after the broker responds.\n")
+			fmt.Printf("  @W{truncate}            cut the broker's response off halfway.\n")
+			fmt.Printf("  @W{stuck}               accept the request (with a 202 Accepted), without\n")
+			fmt.Printf("                      telling the broker, and then never finish it.\n")
+			fmt.Printf("  @W{failed}              say that the last operation failed.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if opt.Proxy.Upstream == "" {
+			opt.Proxy.Upstream = c.URL
+		}
+		if opt.Proxy.Upstream == "" {
+			fmt.Fprintf(os.Stderr, "@R{!!! no upstream broker given; try} @W{--upstream URL}\n")
+			os.Exit(1)
+		}
+
+		p, err := proxy.New(opt.Proxy.Upstream, opt.SkipVerify, time.Duration(opt.Timeout)*time.Second)
+		bail(err)
+		p.Log = os.Stderr
+		p.Quiet = opt.Proxy.Quiet
+		if opt.Proxy.Save {
+			p.Store = store
+			p.StorePath = opt.Data
+		}
+		if opt.Proxy.Chaos != "" {
+			p.Chaos, err = proxy.ReadChaos(opt.Proxy.Chaos)
+			bail(err)
+			for _, rule := range p.Chaos.Rules {
+				fmt.Fprintf(os.Stderr, "chaos rule @Y{%s}\n", rule)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "proxying @C{%s} to @C{%s}\n", opt.Proxy.Listen, p.Upstream)
+		bail(http.ListenAndServe(opt.Proxy.Listen, p))
+		os.Exit(0)
+
+	case "completion":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s} @M{SHELL}\n\n", os.Args[0], command)
+			fmt.Printf("Prints a script that sets up tab completion of osb commands, flags,\n")
+			fmt.Printf("instance and binding IDs (from ~/.osbrc), and service and plan names\n")
+			fmt.Printf("(from the cached catalog) for @M{SHELL}, which must be one of @W{bash},\n")
+			fmt.Printf("@W{zsh}, or @W{fish}.\n")
+			fmt.Printf("\n")
+			fmt.Printf("To enable completion for the current session:\n\n")
+			fmt.Printf("  bash:  @W{source <(osb completion bash)}\n")
+			fmt.Printf("  zsh:   @W{source <(osb completion zsh)}\n")
+			fmt.Printf("  fish:  @W{osb completion fish | source}\n")
+			fmt.Printf("\n")
+			fmt.Printf("Put the same in your ~/.bashrc, ~/.zshrc, or ~/.config/fish/config.fish\n")
+			fmt.Printf("to make it permanent.\n")
+			fmt.Printf("\n")
+			os.Exit(0)
+		}
+
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "USAGE: @Y{%s} completion bash|zsh|fish\n", os.Args[0])
+			os.Exit(1)
+		}
+		script, err := completionScript(args[0])
+		bail(err)
+		fmt.Printf("%s", script)
+		os.Exit(0)
+
+	case "deprovision":
+		if opt.Help {
+			fmt.Printf("USAGE: @G{%s} [@W{options}] @C{%s}\n\n", os.Args[0], command)
+			os.Exit(0)
+		}
+
+		deprovisioning(args)
+
+		instance := args[0]
+		service, plan, _ := store.GetInstanceDetails(c.URL, instance)
+		if service == "" || plan == "" {
+			catalog := fetchCatalog(c)
+
+			if service == "" {
+				service = opt.Deprovision.Service
+				if service == "" {
+					fmt.Fprintf(os.Stderr, "@R{instance '%s' not found in local ~/.osbrc}\n", instance)
+					fmt.Fprintf(os.Stderr, "You must specify the --service flag to the deprovision operation.\n")
+					os.Exit(1)
+				}
+			}
+			if plan == "" {
+				plan = opt.Deprovision.Plan
+				if plan == "" {
+					fmt.Fprintf(os.Stderr, "@R{instance '%s' not found in local ~/.osbrc}\n", instance)
+					fmt.Fprintf(os.Stderr, "You must specify the --plan flag to the deprovision operation.\n")
+					os.Exit(1)
+				}
+			}
+
+			s, p, err := catalog.FindPlan(service, plan)
+			bail(err)
+			service = s
+			plan = p
+		}
+		stat, err := c.Deprovision(api.DeprovisionSpec{
+			InstanceID: args[0],
+			ServiceID:  service,
+			PlanID:     plan,
+		})
+		bail(err)
+
+		store.RemoveInstance(c.URL, args[0])
+		if err := store.Write(opt.Data); err != nil {
+			fmt.Fprintf(os.Stderr, "@Y{!!! %s}\n", err)
+		}
+
+		fmt.Printf("instance: @G{%s}\n", args[0])
+		fmt.Printf("status:   @M{%s}\n", stat.Status)
+		if stat.Operation != "" {
+			fmt.Printf("operation: @C{%s}\n", stat.Operation)
+		}
+		os.Exit(0)
+	}
+}
+
+func jsonify(x interface{}) {
+	b, err := json.Marshal(x)
+	bail(err)
+	fmt.Printf("%s\n", string(b))
+}
+
+// profileContext is api.ProfileContext, with the org, space, namespace
+// and cluster from the command-line (if any).
+func profileContext(profile string, fields map[string]interface{}) (map[string]interface{}, error) {
+	for k, v := range map[string]string{
+		"organization_guid": opt.CFOrg,
+		"space_guid":        opt.CFSpace,
+		"namespace":         opt.Namespace,
+		"clusterid":         opt.Cluster,
+	} {
+		if v != "" {
+			fields[k] = v
+		}
+	}
+	return api.ProfileContext(profile, fields)
+}
+
+func fetchCatalog(c *api.Client) *api.Catalog {
+	catalog, err := c.GetCatalog()
+	bail(err)
+
+	path := api.CatalogCachePath(opt.Data)
+	cache, err := api.ReadCatalogCache(path)
+	if err == nil {
+		cache.Set(c.URL, catalog)
+		err = cache.Write(path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "@Y{!!! unable to cache catalog: %s}\n", err)
+	}
+	return catalog
+}
+
+var clients = struct {
+	sync.Mutex
+	by       map[string]*api.Client
+	cassette *api.Cassette
+}{by: make(map[string]*api.Client)}
+
+// clientFor returns a client for talking to the given broker, using
+// the same credentials and settings as the global client, that can
+// wait for asynchronous operations.  Since those credentials are for
+// the --endpoint broker, it should only ever be given that URL.
+func clientFor(url string) *api.Client {
+	clients.Lock()
+	defer clients.Unlock()
+
+	url = strings.TrimSuffix(url, "/")
+	if c, ok := clients.by[url]; ok {
+		return c
+	}
+
+	c := &api.Client{
+		URL:        url,
+		Username:   opt.Username,
+		Password:   opt.Password,
+		SkipVerify: opt.SkipVerify,
+		Timeout:    opt.Timeout,
+		Trace:      opt.Trace,
+		Journal:    journal,
+		Cassette:   clients.cassette,
+
+		AcceptsIncomplete: true,
+	}
+	clients.by[url] = c
+	return c
+}
+
+// loadCassette sets up recording or replaying HTTP exchanges, if
+// --record or --replay were given.
+func loadCassette() (*api.Cassette, error) {
+	if opt.Record != "" && opt.Replay != "" {
+		return nil, fmt.Errorf("--record and --replay cannot be used together")
+	}
+	if opt.Record != "" {
+		return api.RecordCassette(opt.Record)
+	}
+	if opt.Replay == "" {
+		return nil, nil
+	}
+
+	cassette, err := api.LoadCassette(opt.Replay)
+	if err != nil {
+		return nil, err
+	}
+	cassette.Strict = opt.Strict
+	if opt.Match != "" {
+		for _, m := range strings.Split(opt.Match, ",") {
+			m = strings.TrimSpace(m)
+			ok := false
+			for _, known := range api.CassetteMatches {
+				ok = ok || m == known
+			}
+			if !ok {
+				return nil, fmt.Errorf("unrecognized --match '%s' (try %s)", m, strings.Join(api.CassetteMatches, ", "))
+			}
+			cassette.Match = append(cassette.Match, m)
+		}
+	}
+	return cassette, nil
 }
 
 func connecting() {
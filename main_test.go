@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func newBroker(t *testing.T) (*osbtest.Broker, *api.Client) {
+	b := osbtest.New(t)
+	b.Service("db").
+		Plan("small").
+		Plan("large").Async(100 * time.Millisecond).AsyncBindings().
+		Service("cache").
+		Plan("tiny")
+
+	c := b.Client()
+	c.AcceptsIncomplete = true
+	return b, c
+}
+
+// provision creates an instance on the broker, and records it in
+// the store, the way `osb provision` would.
+func provision(t *testing.T, c *api.Client, store *api.Store, id, service, plan string) {
+	t.Helper()
+	stat, err := c.Provision(id, api.ProvisionSpec{ServiceID: service, PlanID: plan})
+	if err != nil {
+		t.Fatalf("unable to provision %s: %s", id, err)
+	}
+	if stat.Status == "provisioning" {
+		wait(t, c, api.LastOperationSpec{InstanceID: id, Operation: stat.Operation})
+	}
+	store.AddInstance(c.URL, id, service, plan)
+}
+
+// bind binds to an instance on the broker, and records the binding
+// (and its credentials) in the store, the way `osb bind` would.
+func bind(t *testing.T, c *api.Client, store *api.Store, id, bid string) map[string]interface{} {
+	t.Helper()
+	service, plan, err := store.GetInstanceDetails(c.URL, id)
+	if err != nil {
+		t.Fatalf("unable to bind %s: %s", id, err)
+	}
+	out, err := c.Bind(api.BindSpec{InstanceID: id, BindingID: bid, ServiceID: service, PlanID: plan})
+	if err != nil {
+		t.Fatalf("unable to bind %s: %s", id, err)
+	}
+	creds := out.Credentials
+	if out.Status == "binding" {
+		wait(t, c, api.LastOperationSpec{InstanceID: id, BindingID: bid, Operation: out.Operation})
+		b, err := c.GetBinding(id, bid)
+		if err != nil {
+			t.Fatalf("unable to retrieve binding %s: %s", bid, err)
+		}
+		creds = b.Credentials
+	}
+	store.AddBinding(c.URL, id, bid, creds)
+	return creds
+}
+
+func wait(t *testing.T, c *api.Client, spec api.LastOperationSpec) {
+	t.Helper()
+	op, err := c.WaitFor(spec, 10*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to wait for %s: %s", spec.InstanceID, err)
+	}
+	if op.State != api.Succeeded {
+		t.Fatalf("operation on %s %s: %s", spec.InstanceID, op.State, op.Description)
+	}
+}
+
+// catalogs caches the broker's catalog, so that service and plan
+// names can be looked up.
+func catalogs(t *testing.T, c *api.Client) *api.CatalogCache {
+	t.Helper()
+	cat, err := c.GetCatalog()
+	if err != nil {
+		t.Fatalf("unable to retrieve catalog: %s", err)
+	}
+	cache := &api.CatalogCache{Catalogs: make(map[string]*api.Catalog)}
+	cache.Set(c.URL, cat)
+	return cache
+}
+
+// useBroker points the global options at the broker, with a
+// temporary ~/.osbrc, for testing code that reads them.  The
+// returned func puts everything back.
+func useBroker(t *testing.T, b *osbtest.Broker) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "osb-test")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+
+	saved := opt
+	opt.Endpoint = b.URL()
+	opt.Username, opt.Password = osbtest.Username, osbtest.Password
+	opt.Data = filepath.Join(dir, "osbrc")
+	return func() {
+		opt = saved
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,675 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/jhunt/osb/api"
+)
+
+// A Broker is an in-memory OSB service broker, for developing and
+// testing platforms (and osb itself) against.  It serves the v2 API,
+// plus a small admin API, under /admin, for inspecting and resetting
+// its state:
+//
+//	GET    /admin/state   everything the broker knows about
+//	DELETE /admin/state   forget all instances and bindings
+//
+// Nothing is ever really provisioned; instances and bindings are
+// just records in memory, that go away when the broker does.
+type Broker struct {
+	Catalog  *Catalog
+	Username string
+	Password string
+
+	// if set, a line is logged here for every request.
+	Log io.Writer
+
+	lock      sync.Mutex
+	instances map[string]*instance
+	gone      map[string]bool
+	ops       int
+}
+
+type operation struct {
+	ID          string    `json:"id"`
+	Verb        string    `json:"verb"`
+	State       string    `json:"state"`
+	Description string    `json:"description,omitempty"`
+	Started     time.Time `json:"started"`
+	Until       time.Time `json:"until"`
+}
+
+type instance struct {
+	ID               string                 `json:"id"`
+	ServiceID        string                 `json:"service_id"`
+	PlanID           string                 `json:"plan_id"`
+	OrganizationGUID string                 `json:"organization_guid,omitempty"`
+	SpaceGUID        string                 `json:"space_guid,omitempty"`
+	Context          map[string]interface{} `json:"context,omitempty"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+	MaintenanceInfo  *api.MaintenanceInfo   `json:"maintenance_info,omitempty"`
+	DashboardURL     string                 `json:"dashboard_url"`
+	Created          time.Time              `json:"created"`
+	LastOperation    *operation             `json:"last_operation,omitempty"`
+	Bindings         map[string]*binding    `json:"bindings"`
+}
+
+type binding struct {
+	ID            string                 `json:"id"`
+	Context       map[string]interface{} `json:"context,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Credentials   map[string]interface{} `json:"credentials"`
+	Created       time.Time              `json:"created"`
+	LastOperation *operation             `json:"last_operation,omitempty"`
+}
+
+type errorBody struct {
+	Error       string `json:"error,omitempty"`
+	Description string `json:"description"`
+}
+
+var empty = struct{}{}
+
+func New(cat *Catalog, username, password string) *Broker {
+	b := &Broker{
+		Catalog:  cat,
+		Username: username,
+		Password: password,
+	}
+	b.Reset()
+	return b
+}
+
+// Reset forgets every instance and binding.
+func (b *Broker) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.instances = make(map[string]*instance)
+	b.gone = make(map[string]bool)
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &recorder{ResponseWriter: w, status: 200}
+	b.serve(rec, r)
+	if b.Log != nil {
+		fmt.Fprintf(b.Log, "%s %s %s => %d (%s)\n", start.Format("15:04:05"), r.Method, r.URL.RequestURI(), rec.status, time.Since(start))
+	}
+}
+
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	b, _ := json.Marshal(v)
+	w.Write(b)
+}
+
+func fail(w http.ResponseWriter, status int, code, f string, args ...interface{}) {
+	respond(w, status, errorBody{Error: code, Description: fmt.Sprintf(f, args...)})
+}
+
+func decode(w http.ResponseWriter, r *http.Request, out interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(out); err != nil {
+		fail(w, 400, "", "malformed request body: %s", err)
+		return false
+	}
+	return true
+}
+
+func acceptsIncomplete(r *http.Request) bool {
+	return r.URL.Query().Get("accepts_incomplete") == "true"
+}
+
+func (b *Broker) serve(w http.ResponseWriter, r *http.Request) {
+	if b.Username != "" || b.Password != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != b.Username || password != b.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="osb mock"`)
+			fail(w, 401, "", "not authorized")
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "admin" {
+		b.admin(w, r, parts[1:])
+		return
+	}
+
+	v := r.Header.Get("X-Broker-API-Version")
+	if !strings.HasPrefix(v, "2.") {
+		fail(w, 412, "", "unsupported X-Broker-API-Version '%s'; this broker speaks 2.x", v)
+		return
+	}
+	if len(parts) < 2 || parts[0] != "v2" {
+		fail(w, 404, "", "no such endpoint")
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.settle(time.Now())
+
+	route := r.Method + " " + parts[1]
+	switch {
+	case len(parts) == 2 && route == "GET catalog":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b.Catalog.raw)
+
+	case len(parts) == 3 && route == "PUT service_instances":
+		b.provision(w, r, parts[2])
+	case len(parts) == 3 && route == "GET service_instances":
+		b.fetchInstance(w, r, parts[2])
+	case len(parts) == 3 && route == "PATCH service_instances":
+		b.update(w, r, parts[2])
+	case len(parts) == 3 && route == "DELETE service_instances":
+		b.deprovision(w, r, parts[2])
+	case len(parts) == 4 && route == "GET service_instances" && parts[3] == "last_operation":
+		b.lastOperation(w, parts[2], "")
+
+	case len(parts) == 5 && route == "PUT service_instances" && parts[3] == "service_bindings":
+		b.bind(w, r, parts[2], parts[4])
+	case len(parts) == 5 && route == "GET service_instances" && parts[3] == "service_bindings":
+		b.fetchBinding(w, r, parts[2], parts[4])
+	case len(parts) == 5 && route == "DELETE service_instances" && parts[3] == "service_bindings":
+		b.unbind(w, r, parts[2], parts[4])
+	case len(parts) == 6 && route == "GET service_instances" && parts[3] == "service_bindings" && parts[5] == "last_operation":
+		b.lastOperation(w, parts[2], parts[4])
+
+	default:
+		fail(w, 404, "", "no such endpoint")
+	}
+}
+
+// settle finishes every asynchronous operation whose time is up.
+func (b *Broker) settle(now time.Time) {
+	for id, inst := range b.instances {
+		for bid, bind := range inst.Bindings {
+			if op := bind.LastOperation; op != nil && op.State == api.InProgress && !now.Before(op.Until) {
+				op.State = api.Succeeded
+				if op.Verb == "unbind" {
+					delete(inst.Bindings, bid)
+					b.gone[bid] = true
+				}
+			}
+		}
+		if op := inst.LastOperation; op != nil && op.State == api.InProgress && !now.Before(op.Until) {
+			op.State = api.Succeeded
+			if op.Verb == "deprovision" {
+				delete(b.instances, id)
+				b.gone[id] = true
+			}
+		}
+	}
+}
+
+func (b *Broker) operation(verb string, d time.Duration) *operation {
+	b.ops++
+	now := time.Now()
+	return &operation{
+		ID:      fmt.Sprintf("%s-%d", verb, b.ops),
+		Verb:    verb,
+		State:   api.InProgress,
+		Started: now,
+		Until:   now.Add(d),
+	}
+}
+
+func busy(op *operation) bool {
+	return op != nil && op.State == api.InProgress
+}
+
+// lookup finds a service and plan in the catalog, by ID.
+func (b *Broker) lookup(service, plan string) (int, int, bool) {
+	for i, s := range b.Catalog.Services {
+		if s.ID != service {
+			continue
+		}
+		for j, p := range s.Plans {
+			if p.ID == plan {
+				return i, j, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+func sameMaps(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (b *Broker) provision(w http.ResponseWriter, r *http.Request, id string) {
+	var spec api.ProvisionSpec
+	if !decode(w, r, &spec) {
+		return
+	}
+	i, j, ok := b.lookup(spec.ServiceID, spec.PlanID)
+	if !ok {
+		fail(w, 400, "", "no such service / plan '%s' / '%s' in the catalog", spec.ServiceID, spec.PlanID)
+		return
+	}
+	plan := b.Catalog.Services[i].Plans[j]
+	how := b.Catalog.Behavior[plan.ID]
+
+	if inst, ok := b.instances[id]; ok {
+		if inst.ServiceID != spec.ServiceID || inst.PlanID != spec.PlanID ||
+			inst.OrganizationGUID != spec.OrganizationGUID || inst.SpaceGUID != spec.SpaceGUID ||
+			!sameMaps(inst.Parameters, spec.Parameters) {
+			fail(w, 409, "", "service instance '%s' already exists, with different attributes", id)
+			return
+		}
+		if op := inst.LastOperation; busy(op) && op.Verb == "provision" {
+			respond(w, 202, api.ProvisionStatus{DashboardURL: inst.DashboardURL, Operation: op.ID})
+			return
+		}
+		respond(w, 200, map[string]interface{}{"dashboard_url": inst.DashboardURL})
+		return
+	}
+
+	if how.Async && !acceptsIncomplete(r) {
+		fail(w, 422, "AsyncRequired", "this plan can only be provisioned asynchronously")
+		return
+	}
+	if spec.MaintenanceInfo != nil && (plan.MaintenanceInfo == nil || spec.MaintenanceInfo.Version != plan.MaintenanceInfo.Version) {
+		fail(w, 422, "MaintenanceInfoConflict", "maintenance_info.version does not match the catalog")
+		return
+	}
+
+	inst := &instance{
+		ID:               id,
+		ServiceID:        spec.ServiceID,
+		PlanID:           spec.PlanID,
+		OrganizationGUID: spec.OrganizationGUID,
+		SpaceGUID:        spec.SpaceGUID,
+		Context:          spec.Context,
+		Parameters:       spec.Parameters,
+		MaintenanceInfo:  plan.MaintenanceInfo,
+		DashboardURL:     "http://dashboard.mock/instances/" + id,
+		Created:          time.Now(),
+		Bindings:         make(map[string]*binding),
+	}
+	b.instances[id] = inst
+	delete(b.gone, id)
+
+	if how.Async {
+		inst.LastOperation = b.operation("provision", how.Duration)
+		respond(w, 202, api.ProvisionStatus{DashboardURL: inst.DashboardURL, Operation: inst.LastOperation.ID})
+		return
+	}
+	respond(w, 201, map[string]interface{}{"dashboard_url": inst.DashboardURL})
+}
+
+func (b *Broker) fetchInstance(w http.ResponseWriter, r *http.Request, id string) {
+	inst, ok := b.instances[id]
+	if !ok || (busy(inst.LastOperation) && inst.LastOperation.Verb == "provision") {
+		fail(w, 404, "", "service instance '%s' not found", id)
+		return
+	}
+	i, _, _ := b.lookup(inst.ServiceID, inst.PlanID)
+	if !b.Catalog.Services[i].InstancesRetrievable {
+		fail(w, 400, "", "service instances of this service are not retrievable")
+		return
+	}
+	if busy(inst.LastOperation) {
+		fail(w, 422, "ConcurrencyError", "service instance '%s' is being %s", id, inst.LastOperation.Verb+"d")
+		return
+	}
+
+	respond(w, 200, api.Instance{
+		ServiceID:       inst.ServiceID,
+		PlanID:          inst.PlanID,
+		DashboardURL:    inst.DashboardURL,
+		Parameters:      inst.Parameters,
+		MaintenanceInfo: inst.MaintenanceInfo,
+	})
+}
+
+func (b *Broker) update(w http.ResponseWriter, r *http.Request, id string) {
+	var spec api.UpdateSpec
+	if !decode(w, r, &spec) {
+		return
+	}
+	inst, ok := b.instances[id]
+	if !ok {
+		fail(w, 404, "", "service instance '%s' not found", id)
+		return
+	}
+	if busy(inst.LastOperation) {
+		fail(w, 422, "ConcurrencyError", "another operation is in progress on service instance '%s'", id)
+		return
+	}
+	if spec.ServiceID != inst.ServiceID {
+		fail(w, 400, "", "service instance '%s' is not an instance of service '%s'", id, spec.ServiceID)
+		return
+	}
+
+	planID := spec.PlanID
+	if planID == "" {
+		planID = inst.PlanID
+	}
+	i, j, ok := b.lookup(spec.ServiceID, planID)
+	if !ok {
+		fail(w, 400, "", "no such plan '%s' in the catalog", planID)
+		return
+	}
+	plan := b.Catalog.Services[i].Plans[j]
+	if planID != inst.PlanID && !b.Catalog.Services[i].PlanUpdateable {
+		fail(w, 422, "", "this service does not support changing plans")
+		return
+	}
+	how := b.Catalog.Behavior[plan.ID]
+	if how.Async && !acceptsIncomplete(r) {
+		fail(w, 422, "AsyncRequired", "this plan can only be updated asynchronously")
+		return
+	}
+	if spec.MaintenanceInfo != nil && (plan.MaintenanceInfo == nil || spec.MaintenanceInfo.Version != plan.MaintenanceInfo.Version) {
+		fail(w, 422, "MaintenanceInfoConflict", "maintenance_info.version does not match the catalog")
+		return
+	}
+
+	if planID != inst.PlanID || spec.MaintenanceInfo != nil {
+		inst.MaintenanceInfo = plan.MaintenanceInfo
+	}
+	inst.PlanID = planID
+	if spec.Parameters != nil {
+		inst.Parameters = spec.Parameters
+	}
+	if spec.Context != nil {
+		inst.Context = spec.Context
+	}
+
+	if how.Async {
+		inst.LastOperation = b.operation("update", how.Duration)
+		respond(w, 202, api.UpdateStatus{DashboardURL: inst.DashboardURL, Operation: inst.LastOperation.ID})
+		return
+	}
+	inst.LastOperation = nil
+	respond(w, 200, empty)
+}
+
+func requireIDs(w http.ResponseWriter, r *http.Request) bool {
+	q := r.URL.Query()
+	if q.Get("service_id") == "" || q.Get("plan_id") == "" {
+		fail(w, 400, "", "service_id and plan_id are required query parameters")
+		return false
+	}
+	return true
+}
+
+func (b *Broker) deprovision(w http.ResponseWriter, r *http.Request, id string) {
+	if !requireIDs(w, r) {
+		return
+	}
+	inst, ok := b.instances[id]
+	if !ok {
+		fail(w, 410, "", "service instance '%s' does not exist", id)
+		return
+	}
+	if op := inst.LastOperation; busy(op) {
+		if op.Verb == "deprovision" {
+			respond(w, 202, api.DeprovisionStatus{Operation: op.ID})
+			return
+		}
+		fail(w, 422, "ConcurrencyError", "another operation is in progress on service instance '%s'", id)
+		return
+	}
+
+	how := b.Catalog.Behavior[inst.PlanID]
+	if how.Async && !acceptsIncomplete(r) {
+		fail(w, 422, "AsyncRequired", "this plan can only be deprovisioned asynchronously")
+		return
+	}
+	if how.Async {
+		inst.LastOperation = b.operation("deprovision", how.Duration)
+		respond(w, 202, api.DeprovisionStatus{Operation: inst.LastOperation.ID})
+		return
+	}
+
+	delete(b.instances, id)
+	b.gone[id] = true
+	respond(w, 200, empty)
+}
+
+func (b *Broker) lastOperation(w http.ResponseWriter, id, bid string) {
+	var op *operation
+	inst, ok := b.instances[id]
+	if ok && bid == "" {
+		op = inst.LastOperation
+	} else if ok {
+		bind, found := inst.Bindings[bid]
+		if found {
+			op = bind.LastOperation
+		}
+		ok = found
+	}
+
+	if !ok {
+		if b.gone[id] || (bid != "" && b.gone[bid]) {
+			fail(w, 410, "", "gone")
+			return
+		}
+		fail(w, 404, "", "not found")
+		return
+	}
+
+	if op == nil {
+		respond(w, 200, api.LastOperation{State: api.Succeeded})
+		return
+	}
+	respond(w, 200, api.LastOperation{State: op.State, Description: op.Description})
+}
+
+func (b *Broker) bind(w http.ResponseWriter, r *http.Request, id, bid string) {
+	var spec api.BindSpec
+	if !decode(w, r, &spec) {
+		return
+	}
+	inst, ok := b.instances[id]
+	if !ok {
+		fail(w, 404, "", "service instance '%s' not found", id)
+		return
+	}
+	if busy(inst.LastOperation) {
+		fail(w, 422, "ConcurrencyError", "another operation is in progress on service instance '%s'", id)
+		return
+	}
+	if spec.ServiceID != inst.ServiceID || spec.PlanID != inst.PlanID {
+		fail(w, 400, "", "service instance '%s' is not an instance of service / plan '%s' / '%s'", id, spec.ServiceID, spec.PlanID)
+		return
+	}
+
+	i, j, _ := b.lookup(inst.ServiceID, inst.PlanID)
+	svc, plan := b.Catalog.Services[i], b.Catalog.Services[i].Plans[j]
+	bindable := svc.Bindable
+	if plan.MaybeBindable != nil {
+		bindable = *plan.MaybeBindable
+	}
+	if !bindable {
+		fail(w, 400, "", "plan '%s' is not bindable", plan.Name)
+		return
+	}
+
+	if bind, ok := inst.Bindings[bid]; ok {
+		if !sameMaps(bind.Parameters, spec.Parameters) {
+			fail(w, 409, "", "service binding '%s' already exists, with different attributes", bid)
+			return
+		}
+		if op := bind.LastOperation; busy(op) && op.Verb == "bind" {
+			respond(w, 202, api.BindStatus{Operation: op.ID})
+			return
+		}
+		respond(w, 200, map[string]interface{}{"credentials": bind.Credentials})
+		return
+	}
+
+	how := b.Catalog.Behavior[plan.ID]
+	if how.AsyncBindings && !acceptsIncomplete(r) {
+		fail(w, 422, "AsyncRequired", "this plan can only be bound asynchronously")
+		return
+	}
+
+	bind := &binding{
+		ID:          bid,
+		Context:     spec.Context,
+		Parameters:  spec.Parameters,
+		Credentials: credentials(svc.Name, id, bid),
+		Created:     time.Now(),
+	}
+	inst.Bindings[bid] = bind
+	delete(b.gone, bid)
+
+	if how.AsyncBindings {
+		bind.LastOperation = b.operation("bind", how.Duration)
+		respond(w, 202, map[string]interface{}{"operation": bind.LastOperation.ID})
+		return
+	}
+	respond(w, 201, map[string]interface{}{"credentials": bind.Credentials})
+}
+
+func credentials(service, id, bid string) map[string]interface{} {
+	username := "u" + strings.Replace(bid, "-", "", -1)
+	if len(username) > 16 {
+		username = username[:16]
+	}
+	password := strings.Replace(uuid.NewRandom().String(), "-", "", -1)
+	host := service + ".mock"
+	db := "db" + strings.Replace(id, "-", "", -1)
+	if len(db) > 16 {
+		db = db[:16]
+	}
+
+	return map[string]interface{}{
+		"uri":      fmt.Sprintf("mock://%s:%s@%s:5432/%s", username, password, host, db),
+		"hostname": host,
+		"port":     5432,
+		"name":     db,
+		"username": username,
+		"password": password,
+	}
+}
+
+func (b *Broker) fetchBinding(w http.ResponseWriter, r *http.Request, id, bid string) {
+	inst, ok := b.instances[id]
+	if !ok {
+		fail(w, 404, "", "service instance '%s' not found", id)
+		return
+	}
+	bind, ok := inst.Bindings[bid]
+	if !ok || (busy(bind.LastOperation) && bind.LastOperation.Verb == "bind") {
+		fail(w, 404, "", "service binding '%s' not found", bid)
+		return
+	}
+	i, _, _ := b.lookup(inst.ServiceID, inst.PlanID)
+	if !b.Catalog.Services[i].BindingsRetrievable {
+		fail(w, 400, "", "service bindings of this service are not retrievable")
+		return
+	}
+
+	respond(w, 200, map[string]interface{}{
+		"credentials": bind.Credentials,
+		"parameters":  bind.Parameters,
+	})
+}
+
+func (b *Broker) unbind(w http.ResponseWriter, r *http.Request, id, bid string) {
+	if !requireIDs(w, r) {
+		return
+	}
+	inst, ok := b.instances[id]
+	var bind *binding
+	if ok {
+		bind, ok = inst.Bindings[bid]
+	}
+	if !ok {
+		fail(w, 410, "", "service binding '%s' does not exist", bid)
+		return
+	}
+	if op := bind.LastOperation; busy(op) {
+		if op.Verb == "unbind" {
+			respond(w, 202, api.UnbindStatus{Operation: op.ID})
+			return
+		}
+		fail(w, 422, "ConcurrencyError", "service binding '%s' is still being bound", bid)
+		return
+	}
+
+	how := b.Catalog.Behavior[inst.PlanID]
+	if how.AsyncBindings && !acceptsIncomplete(r) {
+		fail(w, 422, "AsyncRequired", "this plan can only be unbound asynchronously")
+		return
+	}
+	if how.AsyncBindings {
+		bind.LastOperation = b.operation("unbind", how.Duration)
+		respond(w, 202, api.UnbindStatus{Operation: bind.LastOperation.ID})
+		return
+	}
+
+	delete(inst.Bindings, bid)
+	b.gone[bid] = true
+	respond(w, 200, empty)
+}
+
+// State is a snapshot of everything the broker knows about.
+type State struct {
+	Instances []*instance `json:"instances"`
+}
+
+func (b *Broker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.settle(time.Now())
+
+	s := State{Instances: make([]*instance, 0, len(b.instances))}
+	for _, inst := range b.instances {
+		s.Instances = append(s.Instances, inst)
+	}
+	sort.Slice(s.Instances, func(i, j int) bool {
+		return s.Instances[i].Created.Before(s.Instances[j].Created)
+	})
+	return s
+}
+
+func (b *Broker) admin(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) != 1 || parts[0] != "state" {
+		fail(w, 404, "", "no such endpoint")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		s := b.State()
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		respond(w, 200, s)
+
+	case "DELETE":
+		b.Reset()
+		respond(w, 200, empty)
+
+	default:
+		fail(w, 405, "", "method not allowed")
+	}
+}
@@ -0,0 +1,268 @@
+package mock_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/mock"
+)
+
+type reply struct {
+	Status int
+	Body   map[string]interface{}
+}
+
+// newMock serves the default catalog, with the large plan sped up
+// enough to wait for.
+func newMock(t *testing.T) (*mock.Broker, *httptest.Server) {
+	cat, err := mock.ReadCatalog("")
+	if err != nil {
+		t.Fatalf("unable to read the default catalog: %s", err)
+	}
+	how := cat.Behavior["mock-db-large"]
+	how.Duration = 100 * time.Millisecond
+	cat.Behavior["mock-db-large"] = how
+
+	b := mock.New(cat, "user", "pass")
+	return b, httptest.NewServer(b)
+}
+
+func send(t *testing.T, srv *httptest.Server, method, path string, body interface{}, header ...string) reply {
+	t.Helper()
+	var in bytes.Buffer
+	if body != nil {
+		if s, ok := body.(string); ok {
+			in.WriteString(s)
+		} else {
+			json.NewEncoder(&in).Encode(body)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &in)
+	if err != nil {
+		t.Fatalf("unable to build %s %s request: %s", method, path, err)
+	}
+	req.SetBasicAuth("user", "pass")
+	req.Header.Set("X-Broker-API-Version", "2.14")
+	for i := 0; i+1 < len(header); i += 2 {
+		if header[i+1] == "" {
+			req.Header.Del(header[i])
+		} else {
+			req.Header.Set(header[i], header[i+1])
+		}
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unable to %s %s: %s", method, path, err)
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+
+	r := reply{Status: res.StatusCode}
+	json.Unmarshal(b, &r.Body)
+	return r
+}
+
+func expect(t *testing.T, what string, r reply, status int, code string) {
+	t.Helper()
+	if r.Status != status {
+		t.Errorf("%s: expected a %d, got %d %v", what, status, r.Status, r.Body)
+		return
+	}
+	if got, _ := r.Body["error"].(string); got != code {
+		t.Errorf("%s: expected error '%s', got '%s'", what, code, got)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	_, srv := newMock(t)
+	defer srv.Close()
+
+	r := send(t, srv, "GET", "/v2/catalog", nil)
+	expect(t, "fetching the catalog", r, 200, "")
+	services, _ := r.Body["services"].([]interface{})
+	if len(services) != 1 {
+		t.Fatalf("expected one service in the catalog, got %v", r.Body)
+	}
+	for _, p := range services[0].(map[string]interface{})["plans"].([]interface{}) {
+		if _, ok := p.(map[string]interface{})["mock"]; ok {
+			t.Errorf("the mock behavior of plans should not be shown to clients, got %v", p)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	_, srv := newMock(t)
+	defer srv.Close()
+
+	r := send(t, srv, "GET", "/v2/catalog", nil, "Authorization", "")
+	expect(t, "without credentials", r, 401, "")
+	req, _ := http.NewRequest("GET", srv.URL+"/v2/catalog", nil)
+	req.SetBasicAuth("user", "wrong")
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != 401 {
+		t.Errorf("with the wrong password: expected a 401, got %v (%v)", res, err)
+	} else if res.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("a 401 should say how to authenticate")
+	}
+
+	expect(t, "without an API version", send(t, srv, "GET", "/v2/catalog", nil, "X-Broker-API-Version", ""), 412, "")
+	expect(t, "with a v1 API version", send(t, srv, "GET", "/v2/catalog", nil, "X-Broker-API-Version", "1.0"), 412, "")
+	expect(t, "on an unknown endpoint", send(t, srv, "GET", "/v2/things", nil), 404, "")
+}
+
+func TestLifecycle(t *testing.T) {
+	b, srv := newMock(t)
+	defer srv.Close()
+
+	small := map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-small"}
+	expect(t, "provisioning", send(t, srv, "PUT", "/v2/service_instances/i-1", small), 201, "")
+	expect(t, "provisioning again", send(t, srv, "PUT", "/v2/service_instances/i-1", small), 200, "")
+	expect(t, "provisioning differently", send(t, srv, "PUT", "/v2/service_instances/i-1",
+		map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-large"}), 409, "")
+	expect(t, "provisioning an unknown plan", send(t, srv, "PUT", "/v2/service_instances/i-2",
+		map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-huge"}), 400, "")
+	expect(t, "provisioning with a malformed body", send(t, srv, "PUT", "/v2/service_instances/i-2", "{"), 400, "")
+
+	r := send(t, srv, "GET", "/v2/service_instances/i-1", nil)
+	expect(t, "fetching the instance", r, 200, "")
+	if r.Body["plan_id"] != "mock-db-small" {
+		t.Errorf("expected i-1 to be a mock-db-small, got %v", r.Body)
+	}
+
+	r = send(t, srv, "PUT", "/v2/service_instances/i-1/service_bindings/b-1", small)
+	expect(t, "binding", r, 201, "")
+	creds, _ := r.Body["credentials"].(map[string]interface{})
+	if creds["hostname"] != "mockdb.mock" || creds["port"] != float64(5432) || creds["password"] == "" {
+		t.Errorf("expected credentials for mockdb.mock:5432, got %v", creds)
+	}
+	r = send(t, srv, "GET", "/v2/service_instances/i-1/service_bindings/b-1", nil)
+	expect(t, "fetching the binding", r, 200, "")
+	if got, _ := r.Body["credentials"].(map[string]interface{}); got["password"] != creds["password"] {
+		t.Errorf("expected the same credentials back, got %v", got)
+	}
+
+	if n := len(b.State().Instances); n != 1 {
+		t.Errorf("expected the broker to know about one instance, not %d", n)
+	}
+
+	expect(t, "deprovisioning without ids", send(t, srv, "DELETE", "/v2/service_instances/i-1", nil), 400, "")
+	ids := "?service_id=mock-db&plan_id=mock-db-small"
+	expect(t, "unbinding", send(t, srv, "DELETE", "/v2/service_instances/i-1/service_bindings/b-1"+ids, nil), 200, "")
+	expect(t, "unbinding again", send(t, srv, "DELETE", "/v2/service_instances/i-1/service_bindings/b-1"+ids, nil), 410, "")
+	expect(t, "deprovisioning", send(t, srv, "DELETE", "/v2/service_instances/i-1"+ids, nil), 200, "")
+	expect(t, "deprovisioning again", send(t, srv, "DELETE", "/v2/service_instances/i-1"+ids, nil), 410, "")
+	expect(t, "polling a deprovisioned instance", send(t, srv, "GET", "/v2/service_instances/i-1/last_operation", nil), 410, "")
+	expect(t, "polling an unknown instance", send(t, srv, "GET", "/v2/service_instances/i-9/last_operation", nil), 404, "")
+}
+
+func TestNotBindable(t *testing.T) {
+	_, srv := newMock(t)
+	defer srv.Close()
+
+	archive := map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-archive"}
+	expect(t, "provisioning", send(t, srv, "PUT", "/v2/service_instances/i-1", archive), 201, "")
+	expect(t, "binding", send(t, srv, "PUT", "/v2/service_instances/i-1/service_bindings/b-1", archive), 400, "")
+}
+
+func TestAsync(t *testing.T) {
+	b, srv := newMock(t)
+	defer srv.Close()
+
+	large := map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-large"}
+	expect(t, "provisioning synchronously", send(t, srv, "PUT", "/v2/service_instances/i-1", large), 422, "AsyncRequired")
+
+	r := send(t, srv, "PUT", "/v2/service_instances/i-1?accepts_incomplete=true", large)
+	expect(t, "provisioning", r, 202, "")
+	if r.Body["operation"] == "" {
+		t.Errorf("an async provision should say which operation to poll for")
+	}
+	expect(t, "provisioning again", send(t, srv, "PUT", "/v2/service_instances/i-1?accepts_incomplete=true", large), 202, "")
+	expect(t, "fetching the instance", send(t, srv, "GET", "/v2/service_instances/i-1", nil), 404, "")
+	expect(t, "binding too soon", send(t, srv, "PUT", "/v2/service_instances/i-1/service_bindings/b-1?accepts_incomplete=true", large), 422, "ConcurrencyError")
+	expect(t, "updating too soon", send(t, srv, "PATCH", "/v2/service_instances/i-1?accepts_incomplete=true", large), 422, "ConcurrencyError")
+	ids := "?accepts_incomplete=true&service_id=mock-db&plan_id=mock-db-large"
+	expect(t, "deprovisioning too soon", send(t, srv, "DELETE", "/v2/service_instances/i-1"+ids, nil), 422, "ConcurrencyError")
+
+	r = send(t, srv, "GET", "/v2/service_instances/i-1/last_operation", nil)
+	if r.Status != 200 || r.Body["state"] != "in progress" {
+		t.Errorf("expected the provision to be in progress, got %d %v", r.Status, r.Body)
+	}
+	time.Sleep(150 * time.Millisecond)
+	r = send(t, srv, "GET", "/v2/service_instances/i-1/last_operation", nil)
+	if r.Status != 200 || r.Body["state"] != "succeeded" {
+		t.Errorf("expected the provision to have succeeded, got %d %v", r.Status, r.Body)
+	}
+
+	expect(t, "binding synchronously", send(t, srv, "PUT", "/v2/service_instances/i-1/service_bindings/b-1", large), 422, "AsyncRequired")
+	expect(t, "binding", send(t, srv, "PUT", "/v2/service_instances/i-1/service_bindings/b-1?accepts_incomplete=true", large), 202, "")
+	expect(t, "fetching the binding", send(t, srv, "GET", "/v2/service_instances/i-1/service_bindings/b-1", nil), 404, "")
+	expect(t, "unbinding too soon", send(t, srv, "DELETE", "/v2/service_instances/i-1/service_bindings/b-1"+ids, nil), 422, "ConcurrencyError")
+	time.Sleep(150 * time.Millisecond)
+	expect(t, "fetching the binding", send(t, srv, "GET", "/v2/service_instances/i-1/service_bindings/b-1", nil), 200, "")
+
+	expect(t, "unbinding", send(t, srv, "DELETE", "/v2/service_instances/i-1/service_bindings/b-1"+ids, nil), 202, "")
+	expect(t, "deprovisioning", send(t, srv, "DELETE", "/v2/service_instances/i-1"+ids, nil), 202, "")
+	time.Sleep(150 * time.Millisecond)
+	r = send(t, srv, "GET", "/v2/service_instances/i-1/last_operation", nil)
+	if r.Status != 410 {
+		t.Errorf("expected the deprovisioned instance to be gone, got %d %v", r.Status, r.Body)
+	}
+	if n := len(b.State().Instances); n != 0 {
+		t.Errorf("expected the broker to have forgotten everything, but it knows about %d instances", n)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	_, srv := newMock(t)
+	defer srv.Close()
+
+	small := map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-small"}
+	expect(t, "provisioning", send(t, srv, "PUT", "/v2/service_instances/i-1", small), 201, "")
+	expect(t, "updating an unknown instance", send(t, srv, "PATCH", "/v2/service_instances/i-9", small), 404, "")
+	expect(t, "updating to an async plan", send(t, srv, "PATCH", "/v2/service_instances/i-1",
+		map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-large"}), 422, "AsyncRequired")
+	expect(t, "updating to a different maintenance version", send(t, srv, "PATCH", "/v2/service_instances/i-1",
+		map[string]interface{}{"service_id": "mock-db", "maintenance_info": map[string]string{"version": "2.0.0"}}), 422, "MaintenanceInfoConflict")
+	expect(t, "updating parameters", send(t, srv, "PATCH", "/v2/service_instances/i-1",
+		map[string]interface{}{"service_id": "mock-db", "parameters": map[string]string{"size": "10G"}}), 200, "")
+
+	r := send(t, srv, "GET", "/v2/service_instances/i-1", nil)
+	if params, _ := r.Body["parameters"].(map[string]interface{}); params["size"] != "10G" || r.Body["plan_id"] != "mock-db-small" {
+		t.Errorf("expected i-1 to still be small, with new parameters, got %v", r.Body)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	_, srv := newMock(t)
+	defer srv.Close()
+
+	small := map[string]interface{}{"service_id": "mock-db", "plan_id": "mock-db-small"}
+	send(t, srv, "PUT", "/v2/service_instances/i-1", small)
+	send(t, srv, "PUT", "/v2/service_instances/i-1/service_bindings/b-1", small)
+
+	// the admin API doesn't care about API versions
+	r := send(t, srv, "GET", "/admin/state", nil, "X-Broker-API-Version", "")
+	expect(t, "fetching the state", r, 200, "")
+	instances, _ := r.Body["instances"].([]interface{})
+	if len(instances) != 1 || !strings.Contains(fmtJSON(instances), `"b-1"`) {
+		t.Errorf("expected the state to have i-1, bound to b-1, got %v", r.Body)
+	}
+
+	expect(t, "resetting the state", send(t, srv, "DELETE", "/admin/state", nil), 200, "")
+	r = send(t, srv, "GET", "/admin/state", nil)
+	if instances, _ := r.Body["instances"].([]interface{}); len(instances) != 0 {
+		t.Errorf("expected the state to be empty after a reset, got %v", r.Body)
+	}
+	expect(t, "posting the state", send(t, srv, "POST", "/admin/state", nil), 405, "")
+}
+
+func fmtJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,176 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/jhunt/osb/api"
+)
+
+// Behavior says how the mock broker handles requests for a plan.
+// It comes from the `mock` key of each plan in the catalog, which
+// is not part of the OSB spec, and is never shown to clients:
+//
+//	plans:
+//	  - name: small
+//	    mock:
+//	      async:    true   # provision, update and deprovision are async
+//	      bindings: async  # ... and so are bind and unbind
+//	      duration: 10s    # how long async operations take
+//
+// Async operations refuse to run without `accepts_incomplete=true`,
+// with a 422 AsyncRequired error, just like real async-only brokers.
+type Behavior struct {
+	Async         bool
+	AsyncBindings bool
+	Duration      time.Duration
+}
+
+type behavior struct {
+	Async    bool   `json:"async"`
+	Bindings string `json:"bindings"`
+	Duration string `json:"duration"`
+}
+
+// A Catalog is the service catalog the mock broker advertises, plus
+// the behavior of each of its plans, keyed by plan ID.
+type Catalog struct {
+	api.Catalog
+	Behavior map[string]Behavior
+
+	// what we tell clients; the catalog as given, minus the
+	// `mock` keys, so that nothing is lost in translation.
+	raw []byte
+}
+
+// DefaultCatalog is used when the mock broker isn't given a catalog.
+// It has one service with a synchronous plan, an asynchronous plan,
+// and a plan that can't be bound.
+const DefaultCatalog = `---
+services:
+  - id:          mock-db
+    name:        mockdb
+    description: A pretend database, for testing OSB platforms
+    tags:        [mock, database]
+    bindable:    true
+    plan_updateable:       true
+    instances_retrievable: true
+    bindings_retrievable:  true
+    plans:
+      - id:          mock-db-small
+        name:        small
+        description: A small, synchronous database
+        maintenance_info: { version: 1.0.0 }
+      - id:          mock-db-large
+        name:        large
+        description: A large database, that takes a while to provision
+        maintenance_info: { version: 1.0.0 }
+        mock: { async: true, bindings: async, duration: 5s }
+      - id:          mock-db-archive
+        name:        archive
+        description: Cold storage, which can't be bound to
+        bindable:    false
+`
+
+// ReadCatalog reads a catalog (as YAML, or JSON) from a file.  With
+// an empty path, the DefaultCatalog is used instead.
+func ReadCatalog(path string) (*Catalog, error) {
+	b := []byte(DefaultCatalog)
+	if path != "" {
+		var err error
+		if b, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return ParseCatalog(b)
+}
+
+func ParseCatalog(b []byte) (*Catalog, error) {
+	// YAML is a superset of JSON, but decodes nested maps into types
+	// that encoding/json can't handle, so we go via JSON to get at the
+	// api.Catalog types, and their json tags.
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(api.JSONable(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var cat Catalog
+	if err := json.Unmarshal(b, &cat.Catalog); err != nil {
+		return nil, err
+	}
+	if cat.raw, err = json.Marshal(withoutBehavior(api.JSONable(raw))); err != nil {
+		return nil, err
+	}
+
+	var extra struct {
+		Services []struct {
+			Plans []struct {
+				ID   string   `json:"id"`
+				Mock behavior `json:"mock"`
+			} `json:"plans"`
+		} `json:"services"`
+	}
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return nil, err
+	}
+
+	cat.Behavior = make(map[string]Behavior)
+	for _, s := range extra.Services {
+		for _, p := range s.Plans {
+			var d time.Duration
+			if p.Mock.Duration != "" {
+				if d, err = time.ParseDuration(p.Mock.Duration); err != nil {
+					return nil, fmt.Errorf("plan %s: invalid duration '%s'", p.ID, p.Mock.Duration)
+				}
+			}
+			switch p.Mock.Bindings {
+			case "", "sync", "async":
+			default:
+				return nil, fmt.Errorf("plan %s: bindings should be either sync or async, not '%s'", p.ID, p.Mock.Bindings)
+			}
+			cat.Behavior[p.ID] = Behavior{
+				Async:         p.Mock.Async,
+				AsyncBindings: p.Mock.Bindings == "async",
+				Duration:      d,
+			}
+		}
+	}
+
+	if len(cat.Services) == 0 {
+		return nil, fmt.Errorf("the catalog has no services in it")
+	}
+	for i, s := range cat.Services {
+		if s.ID == "" || s.Name == "" {
+			return nil, fmt.Errorf("services[%d] is missing its id or name", i)
+		}
+		for j, p := range s.Plans {
+			if p.ID == "" || p.Name == "" {
+				return nil, fmt.Errorf("service %s: plans[%d] is missing its id or name", s.Name, j)
+			}
+		}
+	}
+	return &cat, nil
+}
+
+func withoutBehavior(v interface{}) interface{} {
+	m, _ := v.(map[string]interface{})
+	services, _ := m["services"].([]interface{})
+	for _, s := range services {
+		s, _ := s.(map[string]interface{})
+		plans, _ := s["plans"].([]interface{})
+		for _, p := range plans {
+			if p, ok := p.(map[string]interface{}); ok {
+				delete(p, "mock")
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,55 @@
+package mock_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/mock"
+)
+
+func TestDefaultCatalog(t *testing.T) {
+	cat, err := mock.ReadCatalog("")
+	if err != nil {
+		t.Fatalf("unable to read the default catalog: %s", err)
+	}
+	if len(cat.Services) != 1 || len(cat.Services[0].Plans) != 3 {
+		t.Fatalf("expected one service, with three plans, got %+v", cat.Services)
+	}
+	if how := cat.Behavior["mock-db-small"]; how.Async || how.AsyncBindings {
+		t.Errorf("mock-db-small should be synchronous, got %+v", how)
+	}
+	if how := cat.Behavior["mock-db-large"]; !how.Async || !how.AsyncBindings || how.Duration != 5*time.Second {
+		t.Errorf("mock-db-large should be asynchronous, for 5s, got %+v", how)
+	}
+}
+
+func TestParseCatalog(t *testing.T) {
+	cat, err := mock.ParseCatalog([]byte(`{"services":[{"id":"s","name":"svc","plans":[
+		{"id":"p","name":"plan","mock":{"async":true,"bindings":"sync","duration":"250ms"}}]}]}`))
+	if err != nil {
+		t.Fatalf("unable to parse a JSON catalog: %s", err)
+	}
+	if how := cat.Behavior["p"]; !how.Async || how.AsyncBindings || how.Duration != 250*time.Millisecond {
+		t.Errorf("expected p to be async, with sync bindings, for 250ms, got %+v", how)
+	}
+
+	tests := []struct {
+		name    string
+		catalog string
+		err     string
+	}{
+		{"no services", "services: []", "no services"},
+		{"a nameless service", "services: [{id: s}]", "missing its id or name"},
+		{"an id-less plan", "services: [{id: s, name: svc, plans: [{name: p}]}]", "missing its id or name"},
+		{"a bad duration", "services: [{id: s, name: svc, plans: [{id: p, name: p, mock: {duration: soon}}]}]", "invalid duration"},
+		{"bad bindings", "services: [{id: s, name: svc, plans: [{id: p, name: p, mock: {bindings: maybe}}]}]", "either sync or async"},
+		{"not yaml", "services: [", ""},
+	}
+	for _, test := range tests {
+		_, err := mock.ParseCatalog([]byte(test.catalog))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("a catalog with %s should fail with '%s', got %v", test.name, test.err, err)
+		}
+	}
+}
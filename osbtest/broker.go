@@ -0,0 +1,153 @@
+// Package osbtest provides a fake OSB service broker, for testing
+// code that talks to brokers through an api.Client, without needing
+// a real broker to talk to.
+//
+// The fake broker is an `osb mock` broker (see package mock), running
+// on an httptest server, so out of the box it does what a real broker
+// would do.  On top of that, tests can script the responses to any
+// endpoint, and check the requests that the broker received:
+//
+//	func TestProvision(t *testing.T) {
+//	  b := osbtest.New(t)
+//	  defer b.Close()
+//	  b.Service("db").Plan("small")
+//	  b.On(osbtest.Provision).Status(500).Error("", "out of disk").Once()
+//
+//	  c := b.Client()
+//	  _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"})
+//	  if err == nil {
+//	    t.Errorf("provision should have failed")
+//	  }
+//	  b.Expect(osbtest.Provision).Field("plan_id", "db-small").Times(1)
+//	}
+//
+// Expectations are checked when the broker is closed.
+package osbtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/mock"
+)
+
+// T is the part of testing.T (and testing.B) that the fake broker
+// needs, to report problems.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+const (
+	Username = "osbtest"
+	Password = "sekrit"
+)
+
+// A Broker is a fake OSB service broker, listening on localhost.
+type Broker struct {
+	Server *httptest.Server
+
+	// The broker that handles everything that isn't scripted.  Its
+	// State() is everything that has been provisioned and bound.
+	Mock *mock.Broker
+
+	t    T
+	lock sync.Mutex
+
+	services []*Service
+	raw      string
+	dirty    bool
+	err      error
+
+	scripts      []*Response
+	requests     []*Request
+	expectations []*Expectation
+}
+
+// New starts a fake broker, serving the default `osb mock` catalog
+// until services are added with Service(), or a catalog is given
+// with Catalog().  Close it when the test is done with it, usually
+// with a defer.
+func New(t T) *Broker {
+	b := &Broker{
+		t:     t,
+		raw:   mock.DefaultCatalog,
+		dirty: true,
+	}
+	b.Mock = mock.New(nil, Username, Password)
+	b.Server = httptest.NewServer(b)
+	return b
+}
+
+// URL is where the broker is listening.
+func (b *Broker) URL() string {
+	return b.Server.URL
+}
+
+// Client returns a new api.Client, ready to talk to the broker.
+func (b *Broker) Client() *api.Client {
+	return &api.Client{
+		URL:      b.Server.URL,
+		Username: Username,
+		Password: Password,
+		Timeout:  30,
+	}
+}
+
+// Close checks the expectations of the test, and shuts the broker
+// down.
+func (b *Broker) Close() {
+	b.t.Helper()
+	b.Verify()
+	b.Server.Close()
+}
+
+// Reset forgets everything the broker has provisioned and bound,
+// every scripted response, and every request it has received.  The
+// catalog stays the same.
+func (b *Broker) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.Mock.Reset()
+	b.scripts = nil
+	b.requests = nil
+	b.expectations = nil
+}
+
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	b.lock.Lock()
+	if b.dirty {
+		b.dirty = false
+		cat, err := mock.ParseCatalog(b.catalog())
+		if err != nil {
+			b.err = err
+			b.t.Errorf("osbtest: invalid catalog: %s", err)
+		} else {
+			b.err = nil
+			b.Mock.Catalog = cat
+		}
+	}
+	req := newRequest(r, body)
+	b.requests = append(b.requests, req)
+	script := b.script(req)
+	err := b.err
+	b.lock.Unlock()
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid catalog: %s", err), 500)
+		return
+	}
+	if script != nil && script.serve(w) {
+		return
+	}
+	b.Mock.ServeHTTP(w, r)
+}
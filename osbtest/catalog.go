@@ -0,0 +1,220 @@
+package osbtest
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// A Service is a service in the catalog of a fake broker.  Its
+// methods all return the Service itself (or a new Plan, or a new
+// Service), so that whole catalogs can be built up in one go:
+//
+//	b.Service("db").Tags("sql").
+//	  Plan("small").
+//	  Plan("large").Async(time.Second).
+//	b.Service("cache").NotBindable().
+//	  Plan("shared")
+//
+// By default, services are bindable, plan-updateable, and both their
+// instances and bindings are retrievable.
+type Service struct {
+	b   *Broker
+	def service
+}
+
+// A Plan is a plan of a Service, in the catalog of a fake broker.
+// By default, plans are synchronous, free, and as bindable as the
+// service they belong to.
+type Plan struct {
+	s   *Service
+	def plan
+}
+
+type service struct {
+	ID                   string   `json:"id"`
+	Name                 string   `json:"name"`
+	Description          string   `json:"description"`
+	Tags                 []string `json:"tags,omitempty"`
+	Bindable             bool     `json:"bindable"`
+	InstancesRetrievable bool     `json:"instances_retrievable"`
+	BindingsRetrievable  bool     `json:"bindings_retrievable"`
+	PlanUpdateable       bool     `json:"plan_updateable"`
+	Plans                []*plan  `json:"plans"`
+}
+
+type plan struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Description     string            `json:"description"`
+	Free            *bool             `json:"free,omitempty"`
+	Bindable        *bool             `json:"bindable,omitempty"`
+	MaintenanceInfo map[string]string `json:"maintenance_info,omitempty"`
+	Mock            behavior          `json:"mock"`
+}
+
+// the `mock` key that the mock package reads plan behavior from.
+type behavior struct {
+	Async    bool   `json:"async"`
+	Bindings string `json:"bindings,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// Service adds a service to the catalog.  Its ID is the same as its
+// name, unless changed with ID().
+func (b *Broker) Service(name string) *Service {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	s := &Service{b: b, def: service{
+		ID:                   name,
+		Name:                 name,
+		Description:          "The " + name + " service",
+		Bindable:             true,
+		InstancesRetrievable: true,
+		BindingsRetrievable:  true,
+		PlanUpdateable:       true,
+	}}
+	b.services = append(b.services, s)
+	b.dirty = true
+	return s
+}
+
+// Catalog replaces the catalog of the broker with the given YAML (or
+// JSON), which can use everything that `osb mock` understands,
+// including the `mock` keys for plan behavior.
+func (b *Broker) Catalog(yaml string) *Broker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.services = nil
+	b.raw = yaml
+	b.dirty = true
+	return b
+}
+
+// catalog renders the catalog that has been built so far, in the
+// form that the mock package reads.
+func (b *Broker) catalog() []byte {
+	if len(b.services) == 0 {
+		return []byte(b.raw)
+	}
+
+	l := make([]service, len(b.services))
+	for i, s := range b.services {
+		l[i] = s.def
+	}
+	out, _ := json.Marshal(map[string]interface{}{"services": l})
+	return out
+}
+
+func (s *Service) change(fn func(*service)) *Service {
+	s.b.lock.Lock()
+	defer s.b.lock.Unlock()
+
+	fn(&s.def)
+	s.b.dirty = true
+	return s
+}
+
+// ID sets the ID of the service.  The IDs of plans that are added
+// after this are derived from it.
+func (s *Service) ID(id string) *Service {
+	return s.change(func(def *service) { def.ID = id })
+}
+
+func (s *Service) Description(description string) *Service {
+	return s.change(func(def *service) { def.Description = description })
+}
+
+func (s *Service) Tags(tags ...string) *Service {
+	return s.change(func(def *service) { def.Tags = append(def.Tags, tags...) })
+}
+
+func (s *Service) NotBindable() *Service {
+	return s.change(func(def *service) { def.Bindable = false })
+}
+
+func (s *Service) NotPlanUpdateable() *Service {
+	return s.change(func(def *service) { def.PlanUpdateable = false })
+}
+
+// Retrievable sets whether or not instances and bindings of the
+// service can be fetched from the broker.
+func (s *Service) Retrievable(instances, bindings bool) *Service {
+	return s.change(func(def *service) {
+		def.InstancesRetrievable = instances
+		def.BindingsRetrievable = bindings
+	})
+}
+
+// Plan adds a plan to the service.  Its ID is the service ID and the
+// plan name, joined by a hyphen, unless changed with ID().
+func (s *Service) Plan(name string) *Plan {
+	p := &Plan{s: s, def: plan{
+		Name:        name,
+		Description: "The " + name + " plan",
+	}}
+	s.change(func(def *service) {
+		p.def.ID = def.ID + "-" + name
+		def.Plans = append(def.Plans, &p.def)
+	})
+	return p
+}
+
+// Service adds another service to the catalog.
+func (s *Service) Service(name string) *Service {
+	return s.b.Service(name)
+}
+
+func (p *Plan) change(fn func(*plan)) *Plan {
+	p.s.change(func(*service) { fn(&p.def) })
+	return p
+}
+
+func (p *Plan) ID(id string) *Plan {
+	return p.change(func(def *plan) { def.ID = id })
+}
+
+func (p *Plan) Description(description string) *Plan {
+	return p.change(func(def *plan) { def.Description = description })
+}
+
+func (p *Plan) NotFree() *Plan {
+	free := false
+	return p.change(func(def *plan) { def.Free = &free })
+}
+
+// Bindable overrides the bindability of the service, for this plan.
+func (p *Plan) Bindable(bindable bool) *Plan {
+	return p.change(func(def *plan) { def.Bindable = &bindable })
+}
+
+func (p *Plan) MaintenanceInfo(version string) *Plan {
+	return p.change(func(def *plan) { def.MaintenanceInfo = map[string]string{"version": version} })
+}
+
+// Async makes provision, update and deprovision of the plan happen
+// asynchronously, taking the given time to finish.  Without
+// `accepts_incomplete`, the broker refuses them.
+func (p *Plan) Async(took time.Duration) *Plan {
+	return p.change(func(def *plan) {
+		def.Mock.Async = true
+		def.Mock.Duration = took.String()
+	})
+}
+
+// AsyncBindings makes bind and unbind happen asynchronously, taking
+// the same time as the other asynchronous operations of the plan.
+func (p *Plan) AsyncBindings() *Plan {
+	return p.change(func(def *plan) { def.Mock.Bindings = "async" })
+}
+
+// Plan adds another plan to the same service.
+func (p *Plan) Plan(name string) *Plan {
+	return p.s.Plan(name)
+}
+
+// Service adds another service to the catalog.
+func (p *Plan) Service(name string) *Service {
+	return p.s.b.Service(name)
+}
@@ -0,0 +1,229 @@
+package osbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/jhunt/osb/api"
+)
+
+// A Request is a request that the fake broker received.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+
+	// Which of the OSB endpoints (like Provision) the request was
+	// for, or "" if it wasn't for any of them.
+	Endpoint string
+}
+
+func newRequest(r *http.Request, body []byte) *Request {
+	req := &Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: api.CopyHeader(r.Header),
+		Body:   body,
+	}
+	for _, e := range endpoints {
+		if matches(e, req.Method, req.Path) {
+			req.Endpoint = e
+			break
+		}
+	}
+	return req
+}
+
+func (r *Request) String() string {
+	return r.Method + " " + r.Path
+}
+
+// JSON decodes the body of the request, returning nil if it isn't a
+// JSON object.
+func (r *Request) JSON() map[string]interface{} {
+	var m map[string]interface{}
+	json.Unmarshal(r.Body, &m)
+	return m
+}
+
+// Field looks up a field of the JSON body of the request, by its
+// path: "parameters.size" is the `size` key of the `parameters`
+// object.  The bool is false if there is no such field.
+func (r *Request) Field(path string) (interface{}, bool) {
+	var v interface{} = r.JSON()
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Requests returns every request the broker has received, in the
+// order they were received in.
+func (b *Broker) Requests() []*Request {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]*Request{}, b.requests...)
+}
+
+// Received returns the requests the broker has received for an
+// endpoint (see On for what endpoints look like).
+func (b *Broker) Received(endpoint string) []*Request {
+	l := make([]*Request, 0)
+	for _, r := range b.Requests() {
+		if matches(endpoint, r.Method, r.Path) {
+			l = append(l, r)
+		}
+	}
+	return l
+}
+
+// An Expectation is a check on the requests that the broker receives
+// for an endpoint, which is made when the broker is closed (or when
+// Verify is called).  The checks narrow down which requests count:
+//
+//	b.Expect(osbtest.Bind).
+//	  Header("X-Broker-API-Version", "2.14").
+//	  Query("accepts_incomplete", "true").
+//	  Field("parameters.role", "ro").
+//	  Times(2)
+//
+// Without Times, at least one request has to match.
+type Expectation struct {
+	b        *Broker
+	endpoint string
+	checks   []check
+	times    int
+}
+
+type check struct {
+	what string
+	fn   func(*Request) bool
+}
+
+// Expect sets up an expectation about the requests for an endpoint.
+func (b *Broker) Expect(endpoint string) *Expectation {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e := &Expectation{b: b, endpoint: endpoint, times: -1}
+	b.expectations = append(b.expectations, e)
+	return e
+}
+
+func (e *Expectation) add(what string, fn func(*Request) bool) *Expectation {
+	e.b.lock.Lock()
+	defer e.b.lock.Unlock()
+	e.checks = append(e.checks, check{what: what, fn: fn})
+	return e
+}
+
+func (e *Expectation) Header(name, value string) *Expectation {
+	return e.add(fmt.Sprintf("header %s: %s", name, value), func(r *Request) bool {
+		return r.Header.Get(name) == value
+	})
+}
+
+// Query checks for a query string parameter.  An empty value checks
+// that the parameter was not given at all.
+func (e *Expectation) Query(name, value string) *Expectation {
+	return e.add(fmt.Sprintf("query %s=%s", name, value), func(r *Request) bool {
+		return r.Query.Get(name) == value
+	})
+}
+
+// Field checks a field of the JSON body of the request (see
+// Request.Field) against a value, which is compared as JSON, so that
+// Field("port", 5432) works, even though JSON numbers are floats.
+func (e *Expectation) Field(path string, value interface{}) *Expectation {
+	var want interface{}
+	b, _ := json.Marshal(value)
+	json.Unmarshal(b, &want)
+
+	return e.add(fmt.Sprintf("field %s = %s", path, b), func(r *Request) bool {
+		got, ok := r.Field(path)
+		return ok && reflect.DeepEqual(got, want)
+	})
+}
+
+// Match checks requests with an arbitrary function.
+func (e *Expectation) Match(what string, fn func(*Request) bool) *Expectation {
+	return e.add(what, fn)
+}
+
+// Times expects exactly n requests to match.
+func (e *Expectation) Times(n int) *Expectation {
+	e.b.lock.Lock()
+	defer e.b.lock.Unlock()
+	e.times = n
+	return e
+}
+
+// Never expects no requests to match.
+func (e *Expectation) Never() *Expectation {
+	return e.Times(0)
+}
+
+func (e *Expectation) String() string {
+	s := e.endpoint
+	for _, c := range e.checks {
+		s += ", " + c.what
+	}
+	return s
+}
+
+func (e *Expectation) count(requests []*Request) int {
+	n := 0
+	for _, r := range requests {
+		if !matches(e.endpoint, r.Method, r.Path) {
+			continue
+		}
+		ok := true
+		for _, c := range e.checks {
+			if !c.fn(r) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Verify checks every expectation, reporting the ones that haven't
+// been met as test errors.  It returns false if there were any.
+// Expectations are only checked once; Verify forgets them after.
+func (b *Broker) Verify() bool {
+	b.t.Helper()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ok := true
+	for _, e := range b.expectations {
+		n := e.count(b.requests)
+		switch {
+		case e.times < 0 && n == 0:
+			b.t.Errorf("osbtest: expected a request for %s, but got none", e)
+			ok = false
+		case e.times >= 0 && n != e.times:
+			b.t.Errorf("osbtest: expected %d request(s) for %s, but got %d", e.times, e, n)
+			ok = false
+		}
+	}
+	b.expectations = nil
+	return ok
+}
@@ -0,0 +1,208 @@
+package osbtest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+// fakeT stands in for a testing.T, so that we can check what the fake
+// broker reports, without failing the test that is checking it.
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func provision(c *api.Client, id string) error {
+	_, err := c.Provision(id, api.ProvisionSpec{ServiceID: "mock-db", PlanID: "mock-db-small"})
+	return err
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		method   string
+		path     string
+		want     bool
+	}{
+		{Catalog, "GET", "/v2/catalog", true},
+		{Catalog, "PUT", "/v2/catalog", false},
+		{Provision, "PUT", "/v2/service_instances/i-1", true},
+		{Provision, "PUT", "/v2/service_instances/i-1/service_bindings/b-1", false},
+		{Bind, "PUT", "/v2/service_instances/i-1/service_bindings/b-1", true},
+		{LastOperation, "GET", "/v2/service_instances/i-1/last_operation", true},
+		{FetchInstance, "GET", "/v2/service_instances/i-1/last_operation", false},
+		{"* /v2/service_instances/i-1", "DELETE", "/v2/service_instances/i-1", true},
+		{"* /v2/service_instances/i-1", "DELETE", "/v2/service_instances/i-2", false},
+		{"bogus", "GET", "/v2/catalog", false},
+	}
+	for _, test := range tests {
+		if got := matches(test.endpoint, test.method, test.path); got != test.want {
+			t.Errorf("matches(%q, %q, %q) = %v, want %v", test.endpoint, test.method, test.path, got, test.want)
+		}
+	}
+}
+
+func TestOnScriptsResponses(t *testing.T) {
+	b := New(t)
+	defer b.Close()
+	b.On(Provision).Status(503).Error("", "out of disk").Once()
+	c := b.Client()
+
+	err := provision(c, "i-1")
+	if err == nil || !strings.Contains(err.Error(), "out of disk") {
+		t.Errorf("the first provision should have failed with the scripted error, but got %v", err)
+	}
+	if n := len(b.Mock.State().Instances); n != 0 {
+		t.Errorf("a scripted response shouldn't reach the mock broker, but it has %d instance(s)", n)
+	}
+
+	if err := provision(c, "i-1"); err != nil {
+		t.Errorf("once the scripted response was used up, the mock broker should have provisioned, but got %s", err)
+	}
+	if n := len(b.Mock.State().Instances); n != 1 {
+		t.Errorf("the mock broker should have 1 instance, but it has %d", n)
+	}
+}
+
+func TestOnInOrder(t *testing.T) {
+	b := New(t)
+	defer b.Close()
+	b.On(Provision).Status(500).Error("", "first").Once()
+	b.On(Provision).Status(500).Error("", "second").Once()
+	c := b.Client()
+
+	for _, want := range []string{"first", "second"} {
+		if err := provision(c, "i-1"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the %s scripted error, but got %v", want, err)
+		}
+	}
+}
+
+func TestTimes(t *testing.T) {
+	b := New(t)
+	defer b.Close()
+	b.On(Provision).Status(422).Error("ConcurrencyError", "busy").Times(2)
+	c := b.Client()
+
+	for i := 1; i <= 2; i++ {
+		if err := provision(c, "i-1"); !api.IsConcurrencyError(err) {
+			t.Errorf("provision #%d should have hit a ConcurrencyError, but got %v", i, err)
+		}
+	}
+	if err := provision(c, "i-1"); err != nil {
+		t.Errorf("provision #3 should have gone through to the mock broker, but got %s", err)
+	}
+}
+
+func TestPassThrough(t *testing.T) {
+	b := New(t)
+	defer b.Close()
+	b.On(Catalog).Header("X-Scripted", "yes")
+
+	req, _ := http.NewRequest("GET", b.URL()+"/v2/catalog", nil)
+	req.SetBasicAuth(Username, Password)
+	req.Header.Set("X-Broker-API-Version", "2.14")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unable to fetch the catalog: %s", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 200 {
+		t.Errorf("a response with no status should be handled by the mock broker, but got HTTP %d", res.StatusCode)
+	}
+	if res.Header.Get("X-Scripted") != "yes" {
+		t.Errorf("the scripted header should have been set on the response")
+	}
+}
+
+func TestExpect(t *testing.T) {
+	b := New(t)
+	defer b.Close()
+	c := b.Client()
+	if err := provision(c, "i-1"); err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+
+	b.Expect(Provision).Field("plan_id", "mock-db-small").Times(1)
+	b.Expect(Provision).Header("X-Broker-API-Version", api.DefaultAPIVersion)
+	b.Expect(Provision).Query("accepts_incomplete", "")
+	b.Expect(Provision).Field("plan_id", "mock-db-large").Never()
+	b.Expect(Bind).Never()
+	if !b.Verify() {
+		t.Errorf("all of the expectations should have been met")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ft := &fakeT{}
+	b := New(ft)
+	defer b.Server.Close()
+
+	if err := provision(b.Client(), "i-1"); err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+
+	b.Expect(Bind)
+	b.Expect(Provision).Times(2)
+	b.Expect(Provision).Match("anything", func(*Request) bool { return true })
+	if b.Verify() {
+		t.Errorf("Verify should have failed")
+	}
+	if len(ft.errors) != 2 {
+		t.Fatalf("expected 2 errors, but got %d: %v", len(ft.errors), ft.errors)
+	}
+	if !strings.Contains(ft.errors[0], "expected a request for "+Bind) {
+		t.Errorf("unexpected error for the unmet bind expectation: %s", ft.errors[0])
+	}
+	if !strings.Contains(ft.errors[1], "expected 2 request(s)") || !strings.Contains(ft.errors[1], "got 1") {
+		t.Errorf("unexpected error for the unmet provision expectation: %s", ft.errors[1])
+	}
+
+	// expectations are only checked once
+	if !b.Verify() || len(ft.errors) != 2 {
+		t.Errorf("Verify should have forgotten the expectations it already checked")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	b := New(t)
+	defer b.Close()
+	c := b.Client()
+	_, err := c.Provision("i-1", api.ProvisionSpec{
+		ServiceID:  "mock-db",
+		PlanID:     "mock-db-small",
+		Parameters: map[string]interface{}{"size": 5},
+	})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+
+	l := b.Received(Provision)
+	if len(l) != 1 {
+		t.Fatalf("expected 1 provision request, but got %d", len(l))
+	}
+	if l[0].Endpoint != Provision || l[0].String() != "PUT /v2/service_instances/i-1" {
+		t.Errorf("unexpected request %s (for %q)", l[0], l[0].Endpoint)
+	}
+	if v, ok := l[0].Field("parameters.size"); !ok || v != float64(5) {
+		t.Errorf("expected parameters.size to be 5, but got %v", v)
+	}
+	if _, ok := l[0].Field("parameters.nope"); ok {
+		t.Errorf("parameters.nope shouldn't be there")
+	}
+
+	b.Reset()
+	if n := len(b.Requests()); n != 0 {
+		t.Errorf("Reset should have forgotten every request, but there are %d", n)
+	}
+}
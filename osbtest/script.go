@@ -0,0 +1,186 @@
+package osbtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The endpoints of the OSB API, for scripting responses and checking
+// requests.  Any "METHOD /path" works just as well; a * in the path
+// matches any one part of it, and a * method matches any method.
+const (
+	Catalog              = "GET /v2/catalog"
+	Provision            = "PUT /v2/service_instances/*"
+	FetchInstance        = "GET /v2/service_instances/*"
+	Update               = "PATCH /v2/service_instances/*"
+	Deprovision          = "DELETE /v2/service_instances/*"
+	LastOperation        = "GET /v2/service_instances/*/last_operation"
+	Bind                 = "PUT /v2/service_instances/*/service_bindings/*"
+	FetchBinding         = "GET /v2/service_instances/*/service_bindings/*"
+	Unbind               = "DELETE /v2/service_instances/*/service_bindings/*"
+	BindingLastOperation = "GET /v2/service_instances/*/service_bindings/*/last_operation"
+)
+
+var endpoints = []string{
+	Catalog, Provision, FetchInstance, Update, Deprovision, LastOperation,
+	Bind, FetchBinding, Unbind, BindingLastOperation,
+}
+
+func matches(endpoint, method, path string) bool {
+	l := strings.SplitN(endpoint, " ", 2)
+	if len(l) != 2 || (l[0] != "*" && l[0] != method) {
+		return false
+	}
+
+	want := strings.Split(strings.Trim(l[1], "/"), "/")
+	got := strings.Split(strings.Trim(path, "/"), "/")
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if want[i] != "*" && want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// A Response is a scripted response to requests for an endpoint.
+// Its methods all return the Response itself, so that it can be
+// scripted in one go:
+//
+//	b.On(osbtest.LastOperation).Status(200).JSON(map[string]string{
+//	  "state": "in progress",
+//	}).RetryAfter(time.Second).Times(3)
+//
+// A Response without a Status is a pass-through: after its Delay,
+// and with its headers set, the request is handled as usual.
+type Response struct {
+	b        *Broker
+	endpoint string
+
+	status    int
+	body      []byte
+	headers   http.Header
+	delay     time.Duration
+	remaining int
+}
+
+// On scripts the response to requests for an endpoint.  Responses
+// are used in the order they were scripted, until they run out (see
+// Times); requests that no response is left for are handled by the
+// mock broker, as usual.
+func (b *Broker) On(endpoint string) *Response {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	r := &Response{
+		b:         b,
+		endpoint:  endpoint,
+		headers:   make(http.Header),
+		remaining: -1,
+	}
+	b.scripts = append(b.scripts, r)
+	return r
+}
+
+// script finds the response to send to a request, if there is one.
+// The broker must be locked.
+func (b *Broker) script(req *Request) *Response {
+	for _, r := range b.scripts {
+		if r.remaining != 0 && matches(r.endpoint, req.Method, req.Path) {
+			if r.remaining > 0 {
+				r.remaining--
+			}
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *Response) change(fn func()) *Response {
+	r.b.lock.Lock()
+	defer r.b.lock.Unlock()
+	fn()
+	return r
+}
+
+func (r *Response) Status(code int) *Response {
+	return r.change(func() { r.status = code })
+}
+
+func (r *Response) Body(body string) *Response {
+	return r.change(func() { r.body = []byte(body) })
+}
+
+// JSON sets the body of the response to the JSON encoding of v.
+func (r *Response) JSON(v interface{}) *Response {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("osbtest: unable to encode response as JSON: %s", err))
+	}
+	return r.change(func() {
+		r.body = b
+		r.headers.Set("Content-Type", "application/json")
+	})
+}
+
+// Error sets the body of the response to an OSB error, with the
+// given error code (like "ConcurrencyError", or "" for none) and
+// description.
+func (r *Response) Error(code, description string) *Response {
+	e := map[string]string{"description": description}
+	if code != "" {
+		e["error"] = code
+	}
+	return r.JSON(e)
+}
+
+func (r *Response) Header(name, value string) *Response {
+	return r.change(func() { r.headers.Add(name, value) })
+}
+
+// RetryAfter sets the Retry-After header, in whole seconds.
+func (r *Response) RetryAfter(d time.Duration) *Response {
+	return r.change(func() { r.headers.Set("Retry-After", fmt.Sprintf("%d", int(d/time.Second))) })
+}
+
+// Delay holds the response back for a while, to simulate a slow
+// broker (or to trigger client timeouts).
+func (r *Response) Delay(d time.Duration) *Response {
+	return r.change(func() { r.delay = d })
+}
+
+// Times limits the response to the next n requests.  By default,
+// scripted responses are used forever.
+func (r *Response) Times(n int) *Response {
+	return r.change(func() { r.remaining = n })
+}
+
+func (r *Response) Once() *Response {
+	return r.Times(1)
+}
+
+// serve sends the scripted response, returning false if the request
+// should still be handled by the mock broker.
+func (r *Response) serve(w http.ResponseWriter) bool {
+	r.b.lock.Lock()
+	status, body, delay := r.status, r.body, r.delay
+	for k, l := range r.headers {
+		for _, v := range l {
+			w.Header().Add(k, v)
+		}
+	}
+	r.b.lock.Unlock()
+
+	time.Sleep(delay)
+	if status == 0 {
+		return false
+	}
+	w.WriteHeader(status)
+	w.Write(body)
+	return true
+}
@@ -0,0 +1,434 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The faults that a chaos rule can inject, other than responding with
+// an HTTP error (which is given as just the status code).
+const (
+	// Forward the request, but hang up instead of responding, so the
+	// broker does the work but the platform never hears about it.
+	DropConnection = "drop"
+
+	// Forward the request, but cut the response body off halfway.
+	TruncateJSON = "truncate"
+
+	// Accept the request (with a 202), without forwarding it, and
+	// then report that the operation is in progress, forever.
+	NeverComplete = "stuck"
+
+	// Respond to last_operation requests with a failed state.
+	FailOperation = "failed"
+)
+
+// A Rule says which requests to mess with (and how).  A rule with no
+// endpoints matches every endpoint; one with no service or plan
+// matches any service and plan.
+type Rule struct {
+	Name        string   `yaml:"name"`
+	Endpoints   []string `yaml:"endpoints"`
+	Service     string   `yaml:"service"`
+	Plan        string   `yaml:"plan"`
+	Probability *float64 `yaml:"probability"`
+	Latency     string   `yaml:"latency"`
+	Fault       string   `yaml:"fault"`
+
+	latency time.Duration
+	status  int
+}
+
+// Chaos is a list of rules; the first one that matches a request (and
+// wins its roll of the dice) applies to it.
+type Chaos struct {
+	Rules []*Rule `yaml:"rules"`
+
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+// ReadChaos reads chaos rules from a YAML file.
+func ReadChaos(path string) (*Chaos, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	chaos, err := ParseChaos(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return chaos, nil
+}
+
+// ParseChaos parses (and checks) chaos rules, like these:
+//
+//	rules:
+//	  - name:        slow provisions
+//	    endpoints:   [provision]
+//	    service:     mockdb
+//	    plan:        large
+//	    probability: 0.25
+//	    latency:     5s
+//
+//	  - name:        flaky binds
+//	    endpoints:   [bind, unbind]
+//	    probability: 0.1
+//	    fault:       503
+func ParseChaos(b []byte) (*Chaos, error) {
+	var chaos Chaos
+	if err := yaml.UnmarshalStrict(b, &chaos); err != nil {
+		return nil, err
+	}
+	if len(chaos.Rules) == 0 {
+		return nil, fmt.Errorf("no chaos rules found")
+	}
+
+	for i, r := range chaos.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule #%d", i+1)
+		}
+		for _, e := range r.Endpoints {
+			if !known(e) {
+				return nil, fmt.Errorf("%s: unrecognized endpoint '%s'", r.Name, e)
+			}
+		}
+		if r.Probability != nil && (*r.Probability < 0 || *r.Probability > 1) {
+			return nil, fmt.Errorf("%s: probability should be between 0 and 1", r.Name)
+		}
+		if r.Latency != "" {
+			d, err := time.ParseDuration(r.Latency)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid latency '%s'", r.Name, r.Latency)
+			}
+			r.latency = d
+		}
+
+		switch r.Fault {
+		case "":
+			if r.latency == 0 {
+				return nil, fmt.Errorf("%s: neither a fault nor any latency to inject", r.Name)
+			}
+		case DropConnection, TruncateJSON:
+		case NeverComplete:
+			if err := r.only(Provision, Update, Deprovision, Bind, Unbind); err != nil {
+				return nil, err
+			}
+		case FailOperation:
+			if err := r.only(LastOperation, BindingLastOperation); err != nil {
+				return nil, err
+			}
+		default:
+			n, err := strconv.Atoi(r.Fault)
+			if err != nil || n < 100 || n > 599 {
+				return nil, fmt.Errorf("%s: unrecognized fault '%s' (try an HTTP status, %s, %s, %s, or %s)",
+					r.Name, r.Fault, DropConnection, TruncateJSON, NeverComplete, FailOperation)
+			}
+			r.status = n
+		}
+	}
+
+	chaos.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &chaos, nil
+}
+
+func known(endpoint string) bool {
+	for _, e := range Endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// only restricts a rule to some endpoints, for faults that only make
+// sense for them.  Rules for any endpoint get restricted to them;
+// rules for others are an error.
+func (r *Rule) only(endpoints ...string) error {
+	if len(r.Endpoints) == 0 {
+		r.Endpoints = endpoints
+		return nil
+	}
+	for _, e := range r.Endpoints {
+		ok := false
+		for _, o := range endpoints {
+			ok = ok || o == e
+		}
+		if !ok {
+			return fmt.Errorf("%s: a '%s' fault doesn't make sense for %s requests", r.Name, r.Fault, e)
+		}
+	}
+	return nil
+}
+
+// match returns true if the rule is for the endpoint, service and
+// plan; names are a match for the IDs they go with.
+func (r *Rule) match(endpoint, service, plan string, names map[string]string) bool {
+	if len(r.Endpoints) > 0 {
+		ok := false
+		for _, e := range r.Endpoints {
+			ok = ok || e == endpoint
+		}
+		if !ok {
+			return false
+		}
+	}
+	if r.Service != "" && r.Service != service && (service == "" || r.Service != names[service]) {
+		return false
+	}
+	if r.Plan != "" && r.Plan != plan && (plan == "" || r.Plan != names[plan]) {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) String() string {
+	var s string
+	if r.latency > 0 {
+		s = fmt.Sprintf("+%s", r.latency)
+	}
+	if r.Fault != "" {
+		if s != "" {
+			s += ", "
+		}
+		s += r.Fault
+	}
+	return fmt.Sprintf("%s (%s)", r.Name, s)
+}
+
+// pick returns the rule that applies to a request, if any.
+func (c *Chaos) pick(endpoint, service, plan string, names map[string]string) *Rule {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, r := range c.Rules {
+		if !r.match(endpoint, service, plan, names) {
+			continue
+		}
+		if r.Probability == nil || c.rand.Float64() < *r.Probability {
+			return r
+		}
+	}
+	return nil
+}
+
+// chaos picks the chaos rule (if any) that applies to a request.  The
+// service and plan come from the request itself, or from whatever was
+// asked for when the instance was provisioned.
+func (p *Proxy) chaos(x *Exchange) *Rule {
+	if p.Chaos == nil {
+		return nil
+	}
+
+	in := object(x.Request)
+	service, _ := in["service_id"].(string)
+	plan, _ := in["plan_id"].(string)
+	if service == "" {
+		service = x.URL.Query().Get("service_id")
+	}
+	if plan == "" {
+		plan = x.URL.Query().Get("plan_id")
+	}
+
+	p.lock.Lock()
+	if seen, ok := p.seen[x.Instance]; ok && x.Instance != "" {
+		if service == "" {
+			service = seen[0]
+		}
+		if plan == "" {
+			plan = seen[1]
+		}
+	}
+	_, knownService := p.names[service]
+	_, knownPlan := p.names[plan]
+	fetch := !p.catalogued && p.Chaos.named() && (!knownService && service != "" || !knownPlan && plan != "")
+	p.lock.Unlock()
+
+	// rules can name services and plans, but requests only have their
+	// IDs; if the platform hasn't fetched the catalog through us yet,
+	// we have to go and get it ourselves.
+	if fetch {
+		p.fetchCatalog(x)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.Chaos.pick(x.Endpoint, service, plan, p.names)
+}
+
+// named returns true if any of the rules match on service or plan,
+// which might be given by name.
+func (c *Chaos) named() bool {
+	for _, r := range c.Rules {
+		if r.Service != "" || r.Plan != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchCatalog gets the catalog from the broker, with the credentials
+// (and API version) of the platform request that needed it, so that
+// we can learn the names of its services and plans.
+func (p *Proxy) fetchCatalog(x *Exchange) {
+	u := *p.Upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/v2/catalog"
+	u.RawQuery = ""
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return
+	}
+	for _, h := range []string{"Authorization", "X-Broker-API-Version"} {
+		if v := x.RequestHeader.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	res, err := p.ua.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil || res.StatusCode != 200 {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.learnCatalog(b)
+}
+
+// inject responds to a request on the broker's behalf, if the chaos
+// rule (or an earlier one) says to, and returns true if it did.
+func (p *Proxy) inject(x *Exchange, rule *Rule) bool {
+	key := x.Instance
+	if x.Binding != "" {
+		key += "/" + x.Binding
+	}
+
+	p.lock.Lock()
+	stuck, isStuck := p.stuck[key]
+	if isStuck && (x.Endpoint == Deprovision || x.Endpoint == Unbind) {
+		delete(p.stuck, key)
+	}
+	p.lock.Unlock()
+	if isStuck {
+		switch x.Endpoint {
+		case LastOperation, BindingLastOperation:
+			x.Chaos = stuck.rule
+			respond(x, 200, map[string]string{
+				"state":       "in progress",
+				"description": fmt.Sprintf("this will never finish (%s)", stuck.rule.Name),
+			})
+			return true
+
+		case Deprovision, Unbind:
+			// the platform has given up on it.  if the broker never
+			// heard about it, there's nothing for it to delete, so we
+			// answer for it; otherwise, it's the broker's to handle.
+			if stuck.endpoint == Provision || stuck.endpoint == Bind {
+				x.Chaos = stuck.rule
+				respond(x, 200, map[string]string{})
+				return true
+			}
+		}
+	}
+
+	if rule == nil {
+		return false
+	}
+	x.Chaos = rule
+	switch {
+	case rule.status != 0:
+		respond(x, rule.status, map[string]string{
+			"description": fmt.Sprintf("injected by osb proxy (%s)", rule.Name),
+		})
+		return true
+
+	case rule.Fault == NeverComplete && x.URL.Query().Get("accepts_incomplete") == "true":
+		p.lock.Lock()
+		p.stuck[key] = stalled{rule: rule, endpoint: x.Endpoint}
+		p.lock.Unlock()
+		respond(x, 202, map[string]string{"operation": "osb-chaos-stuck"})
+		return true
+
+	case rule.Fault == FailOperation:
+		respond(x, 200, map[string]string{
+			"state":       "failed",
+			"description": fmt.Sprintf("injected by osb proxy (%s)", rule.Name),
+		})
+		return true
+	}
+	return false
+}
+
+// stalled remembers which (chaos) rule made an operation never finish,
+// and what that operation was.
+type stalled struct {
+	rule     *Rule
+	endpoint string
+}
+
+func respond(x *Exchange, status int, v interface{}) {
+	x.Status = status
+	x.ResponseHeader = http.Header{"Content-Type": []string{"application/json"}}
+	x.Response, _ = json.Marshal(v)
+}
+
+// learn remembers the names of services and plans from the catalog,
+// and the services and plans of instances, so that chaos rules can
+// match on them.
+func (p *Proxy) learn(x *Exchange) {
+	switch x.Endpoint {
+	case Catalog:
+		if x.Status == 200 {
+			p.learnCatalog(x.Response)
+		}
+
+	case Provision, Update:
+		in := object(x.Request)
+		seen := p.seen[x.Instance]
+		if service, _ := in["service_id"].(string); service != "" {
+			seen[0] = service
+		}
+		if plan, _ := in["plan_id"].(string); plan != "" {
+			seen[1] = plan
+		}
+		p.seen[x.Instance] = seen
+	}
+}
+
+// learnCatalog remembers the names of the services and plans in a
+// catalog, by ID.
+func (p *Proxy) learnCatalog(b []byte) {
+	var catalog struct {
+		Services []struct {
+			ID    string `json:"id"`
+			Name  string `json:"name"`
+			Plans []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"plans"`
+		} `json:"services"`
+	}
+	if json.Unmarshal(b, &catalog) != nil {
+		return
+	}
+	for _, s := range catalog.Services {
+		p.names[s.ID] = s.Name
+		for _, plan := range s.Plans {
+			p.names[plan.ID] = plan.Name
+		}
+	}
+	p.catalogued = true
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	chaos, err := ParseChaos([]byte(`
+rules:
+  - name:        slow provisions
+    endpoints:   [provision]
+    service:     mockdb
+    plan:        large
+    probability: 0.25
+    latency:     5s
+
+  - endpoints:   [bind, unbind]
+    fault:       503
+
+  - fault:       stuck
+
+  - fault:       failed
+    latency:     100ms
+`))
+	if err != nil {
+		t.Fatalf("unable to parse chaos rules: %s", err)
+	}
+	if len(chaos.Rules) != 4 {
+		t.Fatalf("expected 4 rules, but got %d", len(chaos.Rules))
+	}
+
+	r := chaos.Rules[0]
+	if r.Name != "slow provisions" || r.latency != 5*time.Second || *r.Probability != 0.25 || r.Fault != "" {
+		t.Errorf("rule #1 wasn't parsed properly: %+v", r)
+	}
+	if r := chaos.Rules[1]; r.Name != "rule #2" || r.status != 503 {
+		t.Errorf("rule #2 should be named for its position, and respond 503: %+v", r)
+	}
+	if r := chaos.Rules[2]; strings.Join(r.Endpoints, ",") != "provision,update,deprovision,bind,unbind" {
+		t.Errorf("a stuck rule should be limited to the asynchronous endpoints, but it is for %v", r.Endpoints)
+	}
+	if r := chaos.Rules[3]; strings.Join(r.Endpoints, ",") != "last_operation,binding last_operation" || r.latency != 100*time.Millisecond {
+		t.Errorf("a failed rule should be limited to last_operation, but it is for %v", r.Endpoints)
+	}
+	if s := chaos.Rules[3].String(); s != "rule #4 (+100ms, failed)" {
+		t.Errorf("unexpected String() for rule #4: %s", s)
+	}
+}
+
+func TestParseChaosErrors(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string
+	}{
+		{`rules: []`, "no chaos rules"},
+		{`rules: [{name: x, fault: 500, bogus: true}]`, "bogus"},
+		{`rules: [{name: x, endpoints: [provison], fault: 500}]`, "unrecognized endpoint 'provison'"},
+		{`rules: [{name: x, probability: 2, fault: 500}]`, "between 0 and 1"},
+		{`rules: [{name: x, latency: soon}]`, "invalid latency 'soon'"},
+		{`rules: [{name: x}]`, "neither a fault nor any latency"},
+		{`rules: [{name: x, fault: explode}]`, "unrecognized fault 'explode'"},
+		{`rules: [{name: x, fault: 99}]`, "unrecognized fault '99'"},
+		{`rules: [{name: x, endpoints: [catalog], fault: stuck}]`, "doesn't make sense for catalog"},
+		{`rules: [{name: x, endpoints: [provision], fault: failed}]`, "doesn't make sense for provision"},
+	}
+	for _, test := range tests {
+		_, err := ParseChaos([]byte(test.yaml))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("ParseChaos(%s) should have failed with %q, but got %v", test.yaml, test.want, err)
+		}
+	}
+}
+
+func TestChaosPick(t *testing.T) {
+	chaos, err := ParseChaos([]byte(`
+rules:
+  - name: never
+    probability: 0
+    fault: 500
+  - name: large
+    endpoints: [provision]
+    service: mockdb
+    plan: large
+    fault: 503
+  - name: binds
+    endpoints: [bind]
+    fault: drop
+`))
+	if err != nil {
+		t.Fatalf("unable to parse chaos rules: %s", err)
+	}
+	names := map[string]string{"mock-db": "mockdb", "mock-db-large": "large"}
+
+	tests := []struct {
+		endpoint, service, plan string
+		want                    string
+	}{
+		{Provision, "mock-db", "mock-db-large", "large"},
+		{Provision, "mockdb", "large", "large"},
+		{Provision, "mock-db", "mock-db-small", ""},
+		{Provision, "", "", ""},
+		{Update, "mock-db", "mock-db-large", ""},
+		{Bind, "", "", "binds"},
+		{Catalog, "", "", ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if r := chaos.pick(test.endpoint, test.service, test.plan, names); r != nil {
+			got = r.Name
+		}
+		if got != test.want {
+			t.Errorf("pick(%s, %s, %s) = %q, want %q", test.endpoint, test.service, test.plan, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+// Package proxy sits between a platform and an OSB service broker,
+// forwarding requests (and responses) untouched, while logging them
+// and checking them against the OSB specification.  It can also keep
+// track of the instances and bindings that go through it, in an osb
+// store, so that they can be managed with osb later.
+//
+// Given Chaos rules, it also injects faults (latency, errors, dropped
+// connections, and operations that fail or never finish), to see how
+// well platforms cope with a misbehaving broker.
+package proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+// A Proxy forwards everything it receives to the Upstream broker.
+type Proxy struct {
+	Upstream *url.URL
+
+	// If set, every exchange is written to Log, colored like the
+	// output of --trace, along with any violations of the spec.
+	// Quiet leaves out exchanges that don't violate anything (and
+	// that no chaos rule applied to).
+	Log   io.Writer
+	Quiet bool
+
+	// If set, instances and bindings that the broker creates (or
+	// deletes) are added to (or removed from) the Store, which is
+	// written back to StorePath after each change.
+	Store     *api.Store
+	StorePath string
+
+	// If set, faults are injected into the exchanges that match the
+	// Chaos rules.
+	Chaos *Chaos
+
+	// Violations counts the violations seen so far.
+	Violations int
+
+	ua     *http.Client
+	lock   sync.Mutex
+	output sync.Mutex // for writing to Log, and saving the Store
+
+	names      map[string]string    // service / plan names, by ID
+	catalogued bool                 // have we seen the catalog yet?
+	seen       map[string][2]string // service / plan IDs, by instance
+	stuck      map[string]stalled   // instances / bindings that never finish
+}
+
+// An Exchange is one request (and its response) that went through
+// the proxy.
+type Exchange struct {
+	Endpoint string
+	Instance string
+	Binding  string
+
+	Method         string
+	URL            *url.URL
+	RequestHeader  http.Header
+	Request        []byte
+	Status         int
+	ResponseHeader http.Header
+	Response       []byte
+	Err            error
+	Took           time.Duration
+
+	Violations []string
+
+	// Chaos is the chaos rule that was applied, if any.
+	Chaos *Rule
+}
+
+// New returns a Proxy for the upstream broker at the given URL.
+func New(upstream string, skipVerify bool, timeout time.Duration) (*Proxy, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("upstream broker URL '%s' should be http:// or https://", upstream)
+	}
+
+	return &Proxy{
+		Upstream: u,
+		ua: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
+			},
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		names: make(map[string]string),
+		seen:  make(map[string][2]string),
+		stuck: make(map[string]stalled),
+	}, nil
+}
+
+// hop-by-hop headers only mean something to one connection, and
+// aren't to be passed on.
+var hopByHop = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	x, err := p.receive(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	rule := p.chaos(x)
+	if rule != nil && rule.latency > 0 {
+		time.Sleep(rule.latency)
+	}
+	if !p.inject(x, rule) {
+		p.forward(x)
+	}
+	if rule != nil && rule.Fault == TruncateJSON && x.Err == nil {
+		x.Response = x.Response[:len(x.Response)/2]
+	}
+	p.done(x)
+
+	if rule != nil && rule.Fault == DropConnection {
+		panic(http.ErrAbortHandler)
+	}
+
+	if x.Err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(502)
+		b, _ := json.Marshal(map[string]string{"description": fmt.Sprintf("unable to reach the broker: %s", x.Err)})
+		w.Write(b)
+		return
+	}
+	for k, l := range x.ResponseHeader {
+		for _, v := range l {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(x.Status)
+	w.Write(x.Response)
+}
+
+// receive reads (and classifies) a request from the platform.
+func (p *Proxy) receive(r *http.Request) (*Exchange, error) {
+	x := &Exchange{
+		Method:        r.Method,
+		URL:           r.URL,
+		RequestHeader: r.Header,
+	}
+	x.Endpoint, x.Instance, x.Binding = Classify(r.Method, r.URL.Path)
+
+	b, err := ioutil.ReadAll(r.Body)
+	x.Request = b
+	return x, err
+}
+
+// forward sends a request on to the broker, and reads its response.
+func (p *Proxy) forward(x *Exchange) {
+	u := *p.Upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + x.URL.Path
+	u.RawQuery = x.URL.RawQuery
+	req, err := http.NewRequest(x.Method, u.String(), bytes.NewBuffer(x.Request))
+	if err != nil {
+		x.Err = err
+		return
+	}
+	for k, l := range x.RequestHeader {
+		for _, v := range l {
+			req.Header.Add(k, v)
+		}
+	}
+	for _, h := range hopByHop {
+		req.Header.Del(h)
+	}
+
+	start := time.Now()
+	res, err := p.ua.Do(req)
+	if err != nil {
+		x.Err = err
+		x.Took = time.Since(start)
+		return
+	}
+	defer res.Body.Close()
+
+	x.Response, x.Err = ioutil.ReadAll(res.Body)
+	x.Took = time.Since(start)
+	x.Status = res.StatusCode
+	x.ResponseHeader = res.Header
+	for _, h := range hopByHop {
+		x.ResponseHeader.Del(h)
+	}
+	x.ResponseHeader.Del("Content-Length")
+}
+
+// done checks a finished exchange, logs it, and saves whatever it
+// tells us about instances and bindings.
+func (p *Proxy) done(x *Exchange) {
+	if x.Err == nil && (x.Chaos == nil || x.Chaos.Fault == "") {
+		x.Violations = Check(x)
+	}
+
+	p.lock.Lock()
+	if x.Err == nil {
+		p.learn(x)
+	}
+	p.Violations += len(x.Violations)
+	p.lock.Unlock()
+
+	var trace string
+	if p.Log != nil && (!p.Quiet || len(x.Violations) > 0 || x.Err != nil || x.Chaos != nil) {
+		trace = p.trace(x)
+	}
+
+	// a slow terminal (or disk) only holds up other exchanges that
+	// have something to log or save, not the ones still in flight
+	p.output.Lock()
+	defer p.output.Unlock()
+	if trace != "" {
+		io.WriteString(p.Log, trace)
+	}
+	// what a fault says happened didn't happen, as far as the broker
+	// is concerned, so it has no business in the store
+	if p.Store != nil && x.Err == nil && (x.Chaos == nil || x.Chaos.Fault == "") {
+		if p.save(x) {
+			if err := p.Store.Write(p.StorePath); err != nil && p.Log != nil {
+				fmt.Fprintf(p.Log, "@Y{failed to update the store:} @R{%s}\n\n", err)
+			}
+		}
+	}
+}
+
+// trace formats an exchange the way --trace does, except that any
+// credentials in the Authorization header are left out.
+func (p *Proxy) trace(x *Exchange) string {
+	var b strings.Builder
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "%s %s HTTP/1.1\r\n", x.Method, x.URL.RequestURI())
+	headers(&req, x.RequestHeader)
+	req.Write(x.Request)
+	b.WriteString(fmt.Sprintf("@M{%s}\n\n", req.String()))
+	if x.Chaos != nil {
+		b.WriteString(fmt.Sprintf("@Y{~~~ chaos: %s}\n\n", x.Chaos))
+	}
+
+	if x.Err != nil {
+		b.WriteString(fmt.Sprintf("@Y{request failed:} @R{%s}\n\n", x.Err))
+		return b.String()
+	}
+
+	var res bytes.Buffer
+	fmt.Fprintf(&res, "HTTP/1.1 %d %s\r\n", x.Status, http.StatusText(x.Status))
+	headers(&res, x.ResponseHeader)
+	res.Write(x.Response)
+	switch x.Status / 100 {
+	case 1:
+		b.WriteString(fmt.Sprintf("@W{%s}\n\n", res.String()))
+	case 2:
+		b.WriteString(fmt.Sprintf("@G{%s}\n\n", res.String()))
+	case 3:
+		b.WriteString(fmt.Sprintf("@C{%s}\n\n", res.String()))
+	default:
+		b.WriteString(fmt.Sprintf("@R{%s}\n\n", res.String()))
+	}
+
+	for _, v := range x.Violations {
+		b.WriteString(fmt.Sprintf("@R{!!! %s %s:} @Y{%s}\n", x.Method, x.URL.Path, v))
+	}
+	if len(x.Violations) > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func headers(out *bytes.Buffer, h http.Header) {
+	h = api.CopyHeader(h)
+	if h.Get("Authorization") != "" {
+		h.Set("Authorization", "REDACTED")
+	}
+	h.Write(out)
+	out.WriteString("\r\n")
+}
+
+// save updates the store with what the exchange says about instances
+// and bindings, and returns true if anything changed.
+func (p *Proxy) save(x *Exchange) bool {
+	url := p.Upstream.String()
+	in := object(x.Request)
+	out := object(x.Response)
+	ok := x.Status == 200 || x.Status == 201 || x.Status == 202
+	op, _ := out["operation"].(string)
+
+	// everything but a provision is about an instance we should
+	// already know about; the store would quietly ignore the rest
+	if x.Endpoint != Provision {
+		if _, _, err := p.Store.GetInstanceDetails(url, x.Instance); err != nil {
+			return false
+		}
+	}
+
+	switch x.Endpoint {
+	case Provision:
+		if !ok {
+			return false
+		}
+		service, _ := in["service_id"].(string)
+		plan, _ := in["plan_id"].(string)
+		p.Store.AddInstance(url, x.Instance, service, plan)
+		if params, is := in["parameters"].(map[string]interface{}); is {
+			p.Store.SetInstanceParameters(url, x.Instance, params)
+		}
+		p.Store.SetInstanceOperation(url, x.Instance, "provision", op)
+		return true
+
+	case Update:
+		if !ok {
+			return false
+		}
+		if plan, _ := in["plan_id"].(string); plan != "" {
+			p.Store.SetInstancePlan(url, x.Instance, plan)
+		}
+		p.Store.SetInstanceOperation(url, x.Instance, "update", op)
+		return true
+
+	case Deprovision:
+		if x.Status == 200 || x.Status == 410 {
+			p.Store.RemoveInstance(url, x.Instance)
+			return true
+		}
+		if x.Status == 202 {
+			p.Store.SetInstanceOperation(url, x.Instance, "deprovision", op)
+			return true
+		}
+
+	case LastOperation:
+		verb, _ := p.Store.GetInstanceOperation(url, x.Instance)
+		switch {
+		case x.Status == 410, x.Status == 200 && out["state"] == "succeeded" && verb == "deprovision":
+			p.Store.RemoveInstance(url, x.Instance)
+			return true
+		case x.Status == 200 && out["state"] == "succeeded" && verb != "":
+			p.Store.SetInstanceOperation(url, x.Instance, "", "")
+			return true
+		}
+
+	case Bind:
+		if !ok {
+			return false
+		}
+		creds, _ := out["credentials"].(map[string]interface{})
+		p.Store.AddBinding(url, x.Instance, x.Binding, creds)
+		if params, is := in["parameters"].(map[string]interface{}); is {
+			p.Store.SetBindingParameters(url, x.Instance, x.Binding, params)
+		}
+		p.Store.SetBindingOperation(url, x.Instance, x.Binding, "bind", op)
+		return true
+
+	case FetchBinding:
+		if creds, is := out["credentials"].(map[string]interface{}); x.Status == 200 && is {
+			p.Store.AddBinding(url, x.Instance, x.Binding, creds)
+			return true
+		}
+
+	case Unbind:
+		if x.Status == 200 || x.Status == 410 {
+			p.Store.RemoveBinding(url, x.Instance, x.Binding)
+			return true
+		}
+		if x.Status == 202 {
+			p.Store.SetBindingOperation(url, x.Instance, x.Binding, "unbind", op)
+			return true
+		}
+
+	case BindingLastOperation:
+		verb, _ := p.Store.GetBindingOperation(url, x.Instance, x.Binding)
+		switch {
+		case x.Status == 410, x.Status == 200 && out["state"] == "succeeded" && verb == "unbind":
+			p.Store.RemoveBinding(url, x.Instance, x.Binding)
+			return true
+		case x.Status == 200 && out["state"] == "succeeded" && verb != "":
+			p.Store.SetBindingOperation(url, x.Instance, x.Binding, "", "")
+			return true
+		}
+	}
+	return false
+}
+
+func object(b []byte) map[string]interface{} {
+	var m map[string]interface{}
+	json.Unmarshal(b, &m)
+	return m
+}
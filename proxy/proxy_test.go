@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+// newProxy starts a proxy in front of a fake broker, and returns a
+// client that talks to the broker through it, and a function that
+// shuts them both down.
+func newProxy(t *testing.T) (*osbtest.Broker, *Proxy, *api.Client, func()) {
+	b := osbtest.New(t)
+	b.Service("db").
+		Plan("small").
+		Plan("large").Async(100 * time.Millisecond).AsyncBindings()
+
+	p, err := New(b.URL(), false, 10*time.Second)
+	if err != nil {
+		t.Fatalf("unable to create the proxy: %s", err)
+	}
+	server := httptest.NewServer(p)
+
+	c := b.Client()
+	c.URL = server.URL
+	c.AcceptsIncomplete = true
+	return b, p, c, func() {
+		server.Close()
+		b.Close()
+	}
+}
+
+func wait(t *testing.T, c *api.Client, last api.LastOperationSpec) *api.LastOperation {
+	op, err := c.WaitFor(last, 20*time.Millisecond, 5*time.Second)
+	if err != nil {
+		t.Fatalf("unable to wait for %s: %s", last.Operation, err)
+	}
+	return op
+}
+
+func TestProxySave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, p, c, done := newProxy(t)
+	defer done()
+	p.Store = &api.Store{}
+	p.StorePath = filepath.Join(dir, "osbrc")
+	url := b.URL()
+
+	stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	if verb, op := p.Store.GetInstanceOperation(url, "i-1"); verb != "provision" || op != stat.Operation {
+		t.Errorf("the store should have the pending provision, but has %s '%s'", verb, op)
+	}
+
+	last := api.LastOperationSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-large", Operation: stat.Operation}
+	wait(t, c, last)
+	if verb, op := p.Store.GetInstanceOperation(url, "i-1"); verb != "" || op != "" {
+		t.Errorf("the store should have forgotten the finished provision, but has %s '%s'", verb, op)
+	}
+
+	// some brokers say a deprovision succeeded, instead of (410) gone
+	del, err := c.Deprovision(api.DeprovisionSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to deprovision: %s", err)
+	}
+	if verb, _ := p.Store.GetInstanceOperation(url, "i-1"); verb != "deprovision" {
+		t.Errorf("the store should have the pending deprovision, but has %s", verb)
+	}
+	b.On(osbtest.LastOperation).Status(200).JSON(map[string]string{"state": "succeeded"}).Once()
+	last.Operation = del.Operation
+	wait(t, c, last)
+	if _, _, err := p.Store.GetInstanceDetails(url, "i-1"); err == nil {
+		t.Errorf("the store should have dropped the deprovisioned instance")
+	}
+
+	store, err := api.ReadStore(p.StorePath)
+	if err != nil {
+		t.Fatalf("unable to read the store back: %s", err)
+	}
+	if _, _, err := store.GetInstanceDetails(url, "i-1"); err == nil {
+		t.Errorf("the store file should have been written without the deprovisioned instance")
+	}
+}
+
+func TestProxySaveUnknownInstance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, p, c, done := newProxy(t)
+	defer done()
+	p.Store = &api.Store{}
+	p.StorePath = filepath.Join(dir, "osbrc")
+
+	// the store has never heard of i-1, so it has nowhere to put b-1
+	b.On(osbtest.Bind).Status(201).JSON(map[string]interface{}{
+		"credentials": map[string]string{"username": "ghost"},
+	}).Once()
+	if _, err := c.Bind(api.BindSpec{InstanceID: "i-1", BindingID: "b-1", ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to bind: %s", err)
+	}
+	if _, err := os.Stat(p.StorePath); !os.IsNotExist(err) {
+		t.Errorf("a binding for an unknown instance shouldn't have written the store (%v)", err)
+	}
+}
+
+func TestProxyStuck(t *testing.T) {
+	b, p, c, done := newProxy(t)
+	defer done()
+	chaos, err := ParseChaos([]byte(`
+rules:
+  - name:      never finish
+    endpoints: [provision]
+    service:   db
+    plan:      large
+    fault:     stuck
+`))
+	if err != nil {
+		t.Fatalf("unable to parse chaos rules: %s", err)
+	}
+	p.Chaos = chaos
+
+	dir, err := ioutil.TempDir("", "osb-proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p.Store = &api.Store{}
+	p.StorePath = filepath.Join(dir, "osbrc")
+
+	// the platform hasn't asked for the catalog, so the proxy has to
+	// fetch it, to know which plan is `large`
+	stat, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-large"})
+	if err != nil {
+		t.Fatalf("unable to provision: %s", err)
+	}
+	if stat.Operation != "osb-chaos-stuck" {
+		t.Fatalf("the provision should have been stuck, but got operation '%s'", stat.Operation)
+	}
+	if _, _, err := p.Store.GetInstanceDetails(b.URL(), "i-1"); err == nil {
+		t.Errorf("the stuck provision never reached the broker, and shouldn't have been saved")
+	}
+	op, err := c.LastOperation(api.LastOperationSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-large"})
+	if err != nil || op.State != api.InProgress {
+		t.Errorf("a stuck provision should stay in progress, but got %v (%v)", op, err)
+	}
+
+	// giving up on it unsticks it, without bothering the broker
+	del, err := c.Deprovision(api.DeprovisionSpec{InstanceID: "i-1", ServiceID: "db", PlanID: "db-large"})
+	if err != nil || del.Status != "deprovisioned" {
+		t.Errorf("the proxy should have answered the deprovision itself, but got %v (%v)", del, err)
+	}
+	if _, ok := p.stuck["i-1"]; ok {
+		t.Errorf("the deprovisioned instance should no longer be stuck")
+	}
+
+	if _, err := c.Provision("i-2", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Errorf("a small provision shouldn't be stuck, but got %s", err)
+	}
+
+	b.Expect(osbtest.Catalog).Times(1)
+	b.Expect(osbtest.Provision).Field("plan_id", "db-large").Never()
+	b.Expect(osbtest.Provision).Field("plan_id", "db-small").Times(1)
+	b.Expect(osbtest.Deprovision).Never()
+	b.Expect(osbtest.LastOperation).Never()
+}
+
+func TestProxyForwards(t *testing.T) {
+	b, p, c, done := newProxy(t)
+	defer done()
+
+	if _, err := c.GetCatalog(); err != nil {
+		t.Fatalf("unable to fetch the catalog through the proxy: %s", err)
+	}
+	if _, err := c.Provision("i-1", api.ProvisionSpec{ServiceID: "db", PlanID: "db-small"}); err != nil {
+		t.Fatalf("unable to provision through the proxy: %s", err)
+	}
+	if p.Violations != 0 {
+		t.Errorf("the client shouldn't have violated the spec, but the proxy saw %d violations", p.Violations)
+	}
+	if p.names["db-small"] != "small" {
+		t.Errorf("the proxy should have learned the plan names from the catalog")
+	}
+
+	b.Expect(osbtest.Provision).
+		Header("X-Broker-API-Version", api.DefaultAPIVersion).
+		Query("accepts_incomplete", "true").
+		Field("plan_id", "db-small").
+		Times(1)
+}
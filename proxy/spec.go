@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The endpoints of the OSB API, as classified by Classify.
+const (
+	Catalog              = "catalog"
+	Provision            = "provision"
+	FetchInstance        = "fetch instance"
+	Update               = "update"
+	Deprovision          = "deprovision"
+	LastOperation        = "last_operation"
+	Bind                 = "bind"
+	FetchBinding         = "fetch binding"
+	Unbind               = "unbind"
+	BindingLastOperation = "binding last_operation"
+)
+
+// Endpoints lists the endpoints of the OSB API, in the order that the
+// spec describes them.
+var Endpoints = []string{
+	Catalog, Provision, FetchInstance, Update, Deprovision, LastOperation,
+	Bind, FetchBinding, Unbind, BindingLastOperation,
+}
+
+// Classify works out which endpoint of the OSB API a request is for,
+// and which instance (and binding) it is about.  Requests that aren't
+// for any part of the API are classified as "".
+func Classify(method, path string) (endpoint, instance, binding string) {
+	l := strings.Split(strings.Trim(path, "/"), "/")
+	if len(l) < 2 || l[0] != "v2" {
+		return "", "", ""
+	}
+	if len(l) == 2 && l[1] == "catalog" && method == "GET" {
+		return Catalog, "", ""
+	}
+	if l[1] != "service_instances" || len(l) < 3 {
+		return "", "", ""
+	}
+
+	instance = l[2]
+	switch {
+	case len(l) == 3:
+		switch method {
+		case "PUT":
+			return Provision, instance, ""
+		case "GET":
+			return FetchInstance, instance, ""
+		case "PATCH":
+			return Update, instance, ""
+		case "DELETE":
+			return Deprovision, instance, ""
+		}
+	case len(l) == 4 && l[3] == "last_operation" && method == "GET":
+		return LastOperation, instance, ""
+	case len(l) >= 5 && l[3] == "service_bindings":
+		binding = l[4]
+		switch {
+		case len(l) == 6 && l[5] == "last_operation" && method == "GET":
+			return BindingLastOperation, instance, binding
+		case len(l) != 5:
+		case method == "PUT":
+			return Bind, instance, binding
+		case method == "GET":
+			return FetchBinding, instance, binding
+		case method == "DELETE":
+			return Unbind, instance, binding
+		}
+	}
+	return "", "", ""
+}
+
+// the status codes that each endpoint may respond with, on top of
+// 401, 403, 412 and any 5xx, which any endpoint may respond with.
+var statuses = map[string][]int{
+	Catalog:              {200},
+	Provision:            {200, 201, 202, 400, 409, 422},
+	FetchInstance:        {200, 404, 422},
+	Update:               {200, 202, 400, 422},
+	Deprovision:          {200, 202, 400, 410, 422},
+	LastOperation:        {200, 400, 404, 410},
+	Bind:                 {200, 201, 202, 400, 409, 422},
+	FetchBinding:         {200, 404, 422},
+	Unbind:               {200, 202, 400, 410, 422},
+	BindingLastOperation: {200, 400, 404, 410},
+}
+
+var apiVersion = regexp.MustCompile(`^\d+\.\d+$`)
+
+// Check returns everything about an exchange that goes against the OSB
+// spec: missing headers, fields or query parameters in the request,
+// and unexpected status codes or missing fields in the response.
+func Check(x *Exchange) []string {
+	var l []string
+	violate := func(f string, args ...interface{}) {
+		l = append(l, fmt.Sprintf(f, args...))
+	}
+
+	if x.Endpoint == "" {
+		violate("%s %s is not part of the OSB API", x.Method, x.URL.Path)
+		return l
+	}
+
+	// request headers
+	if v := x.RequestHeader.Get("X-Broker-API-Version"); v == "" {
+		violate("request is missing the X-Broker-API-Version header")
+	} else if !apiVersion.MatchString(v) {
+		violate("request X-Broker-API-Version header '%s' should look like 2.14", v)
+	}
+	if x.RequestHeader.Get("Authorization") == "" && x.Status != 401 {
+		violate("request has no Authorization header, but the broker let it through anyway")
+	}
+	if v := x.RequestHeader.Get("X-Broker-API-Originating-Identity"); v != "" {
+		parts := strings.SplitN(v, " ", 2)
+		var identity map[string]interface{}
+		if b, err := base64.StdEncoding.DecodeString(parts[len(parts)-1]); len(parts) != 2 || err != nil || json.Unmarshal(b, &identity) != nil {
+			violate("request X-Broker-API-Originating-Identity header should be a platform and a base64-encoded JSON object")
+		}
+	}
+
+	// request query / body
+	q := x.URL.Query()
+	in := map[string]interface{}{}
+	if len(x.Request) > 0 {
+		if err := json.Unmarshal(x.Request, &in); err != nil {
+			violate("request body is not a JSON object: %s", err)
+		}
+	}
+	required := func(fields ...string) {
+		for _, f := range fields {
+			if s, _ := in[f].(string); s == "" {
+				violate("request body is missing `%s`", f)
+			}
+		}
+	}
+	switch x.Endpoint {
+	case Provision, Bind:
+		required("service_id", "plan_id")
+	case Update:
+		required("service_id")
+	case Deprovision, Unbind:
+		for _, f := range []string{"service_id", "plan_id"} {
+			if q.Get(f) == "" {
+				violate("request is missing the `%s` query parameter", f)
+			}
+		}
+	}
+	for _, f := range []string{"parameters", "context"} {
+		if v, ok := in[f]; ok && v != nil {
+			if _, ok := v.(map[string]interface{}); !ok {
+				violate("request `%s` should be a JSON object", f)
+			}
+		}
+	}
+
+	// response status
+	known := x.Status == 401 || x.Status == 403 || x.Status == 412 || x.Status/100 == 5
+	for _, status := range statuses[x.Endpoint] {
+		known = known || status == x.Status
+	}
+	if !known {
+		violate("%s responded %d, which the spec doesn't allow", x.Endpoint, x.Status)
+	}
+	if x.Status == 202 && q.Get("accepts_incomplete") != "true" {
+		violate("%s responded 202 Accepted, but the request didn't have ?accepts_incomplete=true", x.Endpoint)
+	}
+
+	// response headers / body
+	if x.Status == 401 || x.Status == 403 || x.Status == 412 {
+		return l
+	}
+	if len(x.Response) > 0 && !strings.HasPrefix(x.ResponseHeader.Get("Content-Type"), "application/json") {
+		violate("response Content-Type should be application/json, not '%s'", x.ResponseHeader.Get("Content-Type"))
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(x.Response, &out); err != nil {
+		violate("response body is not a JSON object: %s", err)
+		return l
+	}
+
+	if x.Status >= 400 {
+		for _, f := range []string{"error", "description"} {
+			if v, ok := out[f]; ok {
+				if _, ok := v.(string); !ok {
+					violate("error response `%s` should be a string", f)
+				}
+			}
+		}
+		return l
+	}
+
+	if x.Status == 202 {
+		if v, ok := out["operation"]; ok {
+			if s, ok := v.(string); !ok || len(s) > 10000 {
+				violate("response `operation` should be a string, of at most 10000 characters")
+			}
+		}
+	}
+
+	switch x.Endpoint {
+	case Catalog:
+		checkCatalog(out, violate)
+
+	case LastOperation, BindingLastOperation:
+		if x.Status == 200 {
+			switch out["state"] {
+			case "in progress", "succeeded", "failed":
+			default:
+				violate("response `state` should be 'in progress', 'succeeded' or 'failed', not %v", out["state"])
+			}
+		}
+
+	case Bind, FetchBinding:
+		if v, ok := out["credentials"]; ok {
+			if _, ok := v.(map[string]interface{}); !ok {
+				violate("response `credentials` should be a JSON object")
+			}
+		}
+
+	case FetchInstance:
+		if x.Status == 200 {
+			for _, f := range []string{"service_id", "plan_id"} {
+				if v, ok := out[f]; ok {
+					if _, ok := v.(string); !ok {
+						violate("response `%s` should be a string", f)
+					}
+				}
+			}
+		}
+	}
+	return l
+}
+
+func checkCatalog(out map[string]interface{}, violate func(string, ...interface{})) {
+	services, ok := out["services"].([]interface{})
+	if !ok {
+		violate("catalog has no `services` list")
+		return
+	}
+
+	require := func(what string, m map[string]interface{}, fields ...string) {
+		for _, f := range fields {
+			if s, _ := m[f].(string); s == "" {
+				violate("%s is missing `%s`", what, f)
+			}
+		}
+	}
+	for i, v := range services {
+		s, ok := v.(map[string]interface{})
+		if !ok {
+			violate("catalog service #%d is not a JSON object", i+1)
+			continue
+		}
+		what := fmt.Sprintf("catalog service #%d", i+1)
+		if name, _ := s["name"].(string); name != "" {
+			what = fmt.Sprintf("catalog service '%s'", name)
+		}
+		require(what, s, "id", "name", "description")
+		if _, ok := s["bindable"].(bool); !ok {
+			violate("%s is missing `bindable`", what)
+		}
+
+		plans, _ := s["plans"].([]interface{})
+		if len(plans) == 0 {
+			violate("%s has no plans", what)
+		}
+		for j, v := range plans {
+			p, ok := v.(map[string]interface{})
+			if !ok {
+				violate("%s plan #%d is not a JSON object", what, j+1)
+				continue
+			}
+			plan := fmt.Sprintf("%s plan #%d", what, j+1)
+			if name, _ := p["name"].(string); name != "" {
+				plan = fmt.Sprintf("%s plan '%s'", what, name)
+			}
+			require(plan, p, "id", "name", "description")
+		}
+	}
+}
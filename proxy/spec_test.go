@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		method, path                string
+		endpoint, instance, binding string
+	}{
+		{"GET", "/v2/catalog", Catalog, "", ""},
+		{"GET", "/v2/catalog/", Catalog, "", ""},
+		{"POST", "/v2/catalog", "", "", ""},
+		{"PUT", "/v2/service_instances/i-1", Provision, "i-1", ""},
+		{"GET", "/v2/service_instances/i-1", FetchInstance, "i-1", ""},
+		{"PATCH", "/v2/service_instances/i-1", Update, "i-1", ""},
+		{"DELETE", "/v2/service_instances/i-1", Deprovision, "i-1", ""},
+		{"POST", "/v2/service_instances/i-1", "", "", ""},
+		{"GET", "/v2/service_instances/i-1/last_operation", LastOperation, "i-1", ""},
+		{"PUT", "/v2/service_instances/i-1/last_operation", "", "", ""},
+		{"PUT", "/v2/service_instances/i-1/service_bindings/b-1", Bind, "i-1", "b-1"},
+		{"GET", "/v2/service_instances/i-1/service_bindings/b-1", FetchBinding, "i-1", "b-1"},
+		{"DELETE", "/v2/service_instances/i-1/service_bindings/b-1", Unbind, "i-1", "b-1"},
+		{"GET", "/v2/service_instances/i-1/service_bindings/b-1/last_operation", BindingLastOperation, "i-1", "b-1"},
+		{"GET", "/v2/service_instances/i-1/service_bindings/b-1/other", "", "", ""},
+		{"GET", "/v2/service_instances", "", "", ""},
+		{"GET", "/v3/catalog", "", "", ""},
+		{"GET", "/", "", "", ""},
+	}
+	for _, test := range tests {
+		e, i, b := Classify(test.method, test.path)
+		if e != test.endpoint || i != test.instance || b != test.binding {
+			t.Errorf("Classify(%s %s) = %q, %q, %q, want %q, %q, %q",
+				test.method, test.path, e, i, b, test.endpoint, test.instance, test.binding)
+		}
+	}
+}
+
+// exchange builds an exchange that follows the spec, to be broken in
+// whatever way a test needs.
+func exchange(method, uri, request string, status int, response string) *Exchange {
+	u, _ := url.Parse(uri)
+	x := &Exchange{
+		Method: method,
+		URL:    u,
+		RequestHeader: http.Header{
+			"X-Broker-Api-Version": {"2.14"},
+			"Authorization":        {"Basic dTpw"},
+		},
+		Request:        []byte(request),
+		Status:         status,
+		ResponseHeader: http.Header{"Content-Type": {"application/json"}},
+		Response:       []byte(response),
+	}
+	x.Endpoint, x.Instance, x.Binding = Classify(method, u.Path)
+	return x
+}
+
+func TestCheck(t *testing.T) {
+	provision := `{"service_id":"s","plan_id":"p"}`
+	tests := []struct {
+		name string
+		x    *Exchange
+		want string // a violation that should be found, or "" for none
+	}{
+		{"catalog", exchange("GET", "/v2/catalog", "", 200,
+			`{"services":[{"id":"s","name":"db","description":"a db","bindable":true,"plans":[{"id":"p","name":"small","description":"small"}]}]}`), ""},
+		{"provision", exchange("PUT", "/v2/service_instances/i-1", provision, 201, `{}`), ""},
+		{"async provision", exchange("PUT", "/v2/service_instances/i-1?accepts_incomplete=true", provision, 202, `{"operation":"op-1"}`), ""},
+		{"deprovision", exchange("DELETE", "/v2/service_instances/i-1?service_id=s&plan_id=p", "", 410, `{}`), ""},
+		{"error", exchange("PUT", "/v2/service_instances/i-1", provision, 409, `{"description":"exists"}`), ""},
+		{"server error", exchange("PUT", "/v2/service_instances/i-1", provision, 503, `{}`), ""},
+		{"last operation", exchange("GET", "/v2/service_instances/i-1/last_operation", "", 200, `{"state":"in progress"}`), ""},
+
+		{"not OSB", exchange("GET", "/v2/other", "", 200, `{}`), "not part of the OSB API"},
+		{"bad catalog", exchange("GET", "/v2/catalog", "", 200, `{"services":[{"id":"s","name":"db","bindable":true,"plans":[]}]}`),
+			"catalog service 'db' is missing `description`"},
+		{"no plans", exchange("GET", "/v2/catalog", "", 200, `{"services":[{"id":"s","name":"db","description":"d","bindable":true}]}`),
+			"catalog service 'db' has no plans"},
+		{"missing plan_id", exchange("PUT", "/v2/service_instances/i-1", `{"service_id":"s"}`, 201, `{}`), "missing `plan_id`"},
+		{"bad parameters", exchange("PUT", "/v2/service_instances/i-1", `{"service_id":"s","plan_id":"p","parameters":[]}`, 201, `{}`),
+			"`parameters` should be a JSON object"},
+		{"missing query", exchange("DELETE", "/v2/service_instances/i-1?plan_id=p", "", 200, `{}`), "missing the `service_id` query parameter"},
+		{"bad status", exchange("PUT", "/v2/service_instances/i-1", provision, 204, `{}`), "provision responded 204"},
+		{"unasked-for 202", exchange("PUT", "/v2/service_instances/i-1", provision, 202, `{}`), "didn't have ?accepts_incomplete=true"},
+		{"bad state", exchange("GET", "/v2/service_instances/i-1/last_operation", "", 200, `{"state":"done"}`), "response `state` should be"},
+		{"bad credentials", exchange("PUT", "/v2/service_instances/i-1/service_bindings/b-1", provision, 201, `{"credentials":"sekrit"}`),
+			"`credentials` should be a JSON object"},
+		{"bad error", exchange("PUT", "/v2/service_instances/i-1", provision, 400, `{"description":42}`), "`description` should be a string"},
+		{"not JSON", exchange("PUT", "/v2/service_instances/i-1", provision, 201, `OK`), "response body is not a JSON object"},
+	}
+
+	for _, test := range tests {
+		l := Check(test.x)
+		if test.want == "" {
+			if len(l) != 0 {
+				t.Errorf("%s: expected no violations, but got:\n  %s", test.name, strings.Join(l, "\n  "))
+			}
+			continue
+		}
+		found := false
+		for _, v := range l {
+			found = found || strings.Contains(v, test.want)
+		}
+		if !found {
+			t.Errorf("%s: expected a violation about %q, but got:\n  %s", test.name, test.want, strings.Join(l, "\n  "))
+		}
+	}
+}
+
+func TestCheckRequestHeaders(t *testing.T) {
+	x := exchange("GET", "/v2/catalog", "", 200, `{"services":[]}`)
+	x.RequestHeader = http.Header{"X-Broker-Api-Version": {"2"}, "X-Broker-Api-Originating-Identity": {"cloudfoundry nope"}}
+	l := strings.Join(Check(x), "\n")
+	for _, want := range []string{
+		"header '2' should look like 2.14",
+		"no Authorization header",
+		"X-Broker-API-Originating-Identity header should be",
+	} {
+		if !strings.Contains(l, want) {
+			t.Errorf("expected a violation about %q, but got:\n%s", want, l)
+		}
+	}
+
+	x.Status = 401
+	x.RequestHeader.Del("X-Broker-Api-Version")
+	if l := strings.Join(Check(x), "\n"); strings.Contains(l, "Authorization") || !strings.Contains(l, "missing the X-Broker-API-Version") {
+		t.Errorf("a 401 should only be missing the API version, but got:\n%s", l)
+	}
+}
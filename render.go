@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+type renderBinding struct {
+	ID          string
+	Instance    string
+	Service     string
+	Plan        string
+	Tags        []string
+	Credentials map[string]interface{}
+}
+
+// renderData is what templates see as `.`; the credentials of the
+// first binding are available directly as `.Credentials`, and every
+// binding is available by name, as in `.Bindings.db.Credentials`.
+type renderData struct {
+	Credentials map[string]interface{}
+	Bindings    map[string]renderBinding
+}
+
+var renderFuncs = template.FuncMap{
+	"url":         renderURL,
+	"hostport":    renderHostPort,
+	"b64enc":      func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":      renderBase64Decode,
+	"json":        renderJSON,
+	"prettyjson":  renderPrettyJSON,
+	"default":     renderDefault,
+	"required":    renderRequired,
+	"env":         os.Getenv,
+	"upper":       strings.ToUpper,
+	"lower":       strings.ToLower,
+	"join":        strings.Join,
+	"replace":     func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trimsuffix":  func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"trimprefix":  func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"queryescape": url.QueryEscape,
+	"pathescape":  url.PathEscape,
+}
+
+// renderURL builds a URL out of its parts, taking care of escaping
+// the username, password and path, i.e.
+//
+//	{{ url "postgres" .Credentials.username .Credentials.password .Credentials.host .Credentials.port "db" }}
+//
+// Empty (or missing) parts are left out.
+func renderURL(scheme string, user, pass, host, port, path interface{}) string {
+	u := url.URL{
+		Scheme: scheme,
+		Host:   renderHostPort(host, port),
+		Path:   renderString(path),
+	}
+	if u.Path != "" && !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+	if s := renderString(user); s != "" {
+		if p := renderString(pass); p != "" {
+			u.User = url.UserPassword(s, p)
+		} else {
+			u.User = url.User(s)
+		}
+	}
+	return u.String()
+}
+
+func renderHostPort(host, port interface{}) string {
+	h, p := renderString(host), renderString(port)
+	if p == "" {
+		return h
+	}
+	return net.JoinHostPort(h, p)
+}
+
+func renderBase64Decode(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
+
+func renderJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func renderPrettyJSON(v interface{}) (string, error) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	return string(b), err
+}
+
+// renderDefault is meant to be used in pipelines, as in
+// `{{ .Credentials.port | default 5432 }}`.
+func renderDefault(def, v interface{}) interface{} {
+	if renderEmpty(v) {
+		return def
+	}
+	return v
+}
+
+func renderRequired(msg string, v interface{}) (interface{}, error) {
+	if renderEmpty(v) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return v, nil
+}
+
+func renderEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Map, reflect.Slice, reflect.Array:
+		return rv.Len() == 0
+	}
+	return false
+}
+
+func renderString(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v.(string)
+	case float64:
+		// JSON numbers; avoid 5.432e+03 for port numbers
+		return strconv.FormatFloat(v.(float64), 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func render(tpl string, data renderData) ([]byte, error) {
+	src, err := ioutil.ReadFile(tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := template.New(filepath.Base(tpl)).Funcs(renderFuncs).Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeAtomically writes a file by way of a temporary file in the
+// same directory, so that readers never see a half-written file,
+// and so that the file is never readable by anyone but its owner.
+func writeAtomically(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if err = f.Chmod(0600); err == nil {
+		if _, err = f.Write(b); err == nil {
+			err = f.Sync()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func renderBindingFor(c *api.Client, store *api.Store, bid string, refresh bool) renderBinding {
+	bid = store.Resolve(c.URL, bid)
+	instance, service, plan, err := store.GetBindingDetails(c.URL, bid)
+	bail(err)
+
+	b := renderBinding{
+		ID:          bid,
+		Instance:    instance,
+		Service:     service,
+		Plan:        plan,
+		Tags:        []string{},
+		Credentials: credentials(c, store, bid, refresh),
+	}
+
+	cache, err := api.ReadCatalogCache(api.CatalogCachePath(opt.Data))
+	bail(err)
+	if cat := cache.Get(c.URL); cat != nil {
+		b.Service, b.Plan = cat.Names(service, plan)
+		if t := cat.Tags(service); t != nil {
+			b.Tags = t
+		}
+	}
+	return b
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jhunt/osb/api"
+)
+
+func renderTemplate(t *testing.T, dir, src string, data renderData) (string, error) {
+	t.Helper()
+	path := filepath.Join(dir, "template")
+	if err := ioutil.WriteFile(path, []byte(src), 0666); err != nil {
+		t.Fatalf("unable to write template: %s", err)
+	}
+	out, err := render(path, data)
+	return string(out), err
+}
+
+func TestRender(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	defer useBroker(t, b)()
+
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	creds := bind(t, c, store, "i-1", "b-1")
+	fetchCatalog(c)
+
+	db := renderBindingFor(c, store, "b-1", false)
+	if db.Instance != "i-1" || db.Service != "db" || db.Plan != "small" {
+		t.Errorf("expected b-1 to be a binding of i-1, a small db, got %+v", db)
+	}
+	data := renderData{
+		Credentials: db.Credentials,
+		Bindings:    map[string]renderBinding{"db": db},
+	}
+
+	dir, err := ioutil.TempDir("", "osb-render")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		src, expect string
+	}{
+		{`{{ .Credentials.hostname }}:{{ .Credentials.port }}`, "db.mock:5432"},
+		{`{{ hostport .Credentials.hostname .Credentials.port }}`, "db.mock:5432"},
+		{`{{ .Bindings.db.Plan }} {{ .Bindings.db.ID }}`, "small b-1"},
+		{`{{ url "postgres" .Credentials.username .Credentials.password .Credentials.hostname .Credentials.port .Credentials.name }}`,
+			"postgres://" + creds["username"].(string) + ":" + creds["password"].(string) + "@db.mock:5432/" + creds["name"].(string)},
+		{`{{ .Credentials.missing | default "none" }}`, "none"},
+		{`{{ "s3cr3t" | b64enc }} {{ "czNjcjN0" | b64dec }}`, "czNjcjN0 s3cr3t"},
+		{`{{ "A/B" | lower | replace "/" "-" }}`, "a-b"},
+	}
+	for _, test := range tests {
+		got, err := renderTemplate(t, dir, test.src, data)
+		if err != nil {
+			t.Errorf("unable to render %s: %s", test.src, err)
+		} else if got != test.expect {
+			t.Errorf("rendering %s: expected '%s', got '%s'", test.src, test.expect, got)
+		}
+	}
+
+	if _, err := renderTemplate(t, dir, `{{ .Credentials.missing | required "need a missing" }}`, data); err == nil || !strings.Contains(err.Error(), "need a missing") {
+		t.Errorf("a missing required value should fail with its message, got %v", err)
+	}
+}
+
+func TestRenderString(t *testing.T) {
+	tests := []struct {
+		v      interface{}
+		expect string
+	}{
+		{nil, ""},
+		{"x", "x"},
+		{5432.0, "5432"},
+		{1.5, "1.5"},
+		{0.25, "0.25"},
+		{1e21, "1000000000000000000000"},
+		{true, "true"},
+	}
+	for _, test := range tests {
+		if got := renderString(test.v); got != test.expect {
+			t.Errorf("renderString(%v): expected '%s', got '%s'", test.v, test.expect, got)
+		}
+	}
+}
+
+func TestWriteAtomically(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osb-render")
+	if err != nil {
+		t.Fatalf("unable to create a temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config")
+	ioutil.WriteFile(path, []byte("old"), 0644)
+	if err := writeAtomically(path, []byte("new")); err != nil {
+		t.Fatalf("unable to write atomically: %s", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "new" {
+		t.Errorf("expected the file to be replaced, but it contains '%s'", b)
+	}
+	if st, _ := os.Stat(path); st.Mode().Perm() != 0600 {
+		t.Errorf("the file should only be readable by its owner, but is %s", st.Mode())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("no temporary files should be left behind, but there are %d files", len(files))
+	}
+}
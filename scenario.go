@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+func parseVars(l []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, kv := range l {
+		n := strings.Index(kv, "=")
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid --var '%s' (should be name=value)", kv)
+		}
+		vars[kv[:n]] = kv[n+1:]
+	}
+	return vars, nil
+}
+
+func printScenario(w io.Writer, r *api.ScenarioReport) {
+	fmt.Fprintf(w, "scenario @M{%s}\n", r.Name)
+	fmt.Fprintf(w, "broker   @C{%s}\n\n", r.Broker)
+	for _, s := range r.Steps {
+		printTestStep(w, "", s)
+	}
+
+	if len(r.Vars) > 0 {
+		fmt.Fprintf(w, "\nvariables:\n")
+		names := make([]string, 0, len(r.Vars))
+		for name := range r.Vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s = %s\n", name, r.Vars[name])
+		}
+	}
+
+	fmt.Fprintf(w, "\n")
+	if r.Result == api.TestPassed {
+		fmt.Fprintf(w, "@G{%d passed}, %d failed, @Y{%d skipped} in %s\n", r.Passed, r.Failed, r.Skipped, ms(r.Took))
+	} else {
+		fmt.Fprintf(w, "%d passed, @R{%d failed}, @Y{%d skipped} in %s\n", r.Passed, r.Failed, r.Skipped, ms(r.Took))
+	}
+}
+
+func junitScenario(w io.Writer, r *api.ScenarioReport) error {
+	suite := junitSuite{Name: r.Name, Time: seconds(r.Took)}
+	for _, s := range r.Steps {
+		suite.add(s)
+	}
+	out := junitSuites{
+		Name:     "osb run " + r.Name,
+		Time:     seconds(r.Took),
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitSuite{suite},
+	}
+
+	b, err := xml.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header+string(b)+"\n")
+	return err
+}
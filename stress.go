@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"sort"
+
+	fmt "github.com/jhunt/go-ansi"
+	"github.com/jhunt/go-table"
+
+	"github.com/jhunt/osb/api"
+)
+
+func tally(n int, color string) string {
+	if n == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("@"+color+"{%d}", n)
+}
+
+func printStress(w io.Writer, r *api.StressReport) {
+	fmt.Fprintf(w, "broker   @C{%s}\n", r.Broker)
+	fmt.Fprintf(w, "workload @M{%s}, %d at once, against instance %s\n\n", r.Workload, r.Parallel, r.InstanceID)
+
+	verbs := make([]string, 0, len(r.Verbs))
+	for verb := range r.Verbs {
+		verbs = append(verbs, verb)
+	}
+	sort.Strings(verbs)
+
+	t := table.NewTable("Request", "Sent", "OK", "Async Failed", "ConcurrencyError", "5xx", "4xx", "Network")
+	row := func(name string, n api.StressCount) {
+		t.Row(nil, name, n.Requests, tally(n.OK, "G"), tally(n.AsyncFailed, "R"), tally(n.ConcurrencyErrors, "Y"),
+			tally(n.ServerErrors, "R"), tally(n.ClientErrors, "Y"), tally(n.NetworkErrors, "R"))
+	}
+	for _, verb := range verbs {
+		row(verb, *r.Verbs[verb])
+	}
+	row("total", r.Total)
+	t.Output(w)
+
+	fmt.Fprintf(w, "\n")
+	t = table.NewTable("Kind", "ID", "Expected", "Found", "")
+	for _, o := range r.Objects {
+		if o.Consistent {
+			t.Row(nil, o.Kind, o.ID, o.Expected, o.Found, fmt.Sprintf("@G{ok}"))
+		} else {
+			t.Row(nil, o.Kind, o.ID, o.Expected, o.Found, fmt.Sprintf("@R{%s}", o.Problem))
+		}
+	}
+	t.Output(w)
+
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(w, "\n")
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(w, "@Y{!} %s\n", warning)
+		}
+	}
+
+	fmt.Fprintf(w, "\n")
+	if r.Failed() {
+		fmt.Fprintf(w, "@R{%d inconsistent}, @R{%d server errors}, %d concurrency errors in %s\n", r.Inconsistent, r.Total.ServerErrors, r.Total.ConcurrencyErrors, ms(r.Took))
+	} else {
+		fmt.Fprintf(w, "@G{0 inconsistent}, @G{0 server errors}, %d concurrency errors in %s\n", r.Total.ConcurrencyErrors, ms(r.Took))
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	fmt "github.com/jhunt/go-ansi"
+
+	"github.com/jhunt/osb/api"
+)
+
+type teardownOp struct {
+	Verb      string `json:"verb"`
+	Broker    string `json:"broker"`
+	Instance  string `json:"instance_id"`
+	Binding   string `json:"binding_id,omitempty"`
+	ServiceID string `json:"service_id"`
+	PlanID    string `json:"plan_id"`
+
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Took   int64  `json:"duration_ms"`
+}
+
+// planTeardown works out which bindings need to be unbound, and
+// which instances deprovisioned, to get rid of everything selected
+// by the filter.  Selecting an instance implicitly selects all of
+// its bindings, since they have to go first.
+func planTeardown(store *api.Store, cache *api.CatalogCache, f listFilter) ([]*teardownOp, []*teardownOp) {
+	unbinds := make([]*teardownOp, 0)
+	deprovs := make([]*teardownOp, 0)
+
+	ll := listings(store, cache, f)
+	doomed := make(map[string]bool)
+	for _, l := range ll {
+		if l.Kind == "instance" {
+			doomed[l.Broker+" "+l.Instance] = true
+			deprovs = append(deprovs, &teardownOp{
+				Verb:      "deprovision",
+				Broker:    l.Broker,
+				Instance:  l.Instance,
+				ServiceID: l.ServiceID,
+				PlanID:    l.PlanID,
+			})
+		}
+	}
+
+	for _, l := range listings(store, cache, listFilter{}) {
+		if l.Kind != "binding" {
+			continue
+		}
+		if !doomed[l.Broker+" "+l.Instance] && !f.matches(l) {
+			continue
+		}
+		unbinds = append(unbinds, &teardownOp{
+			Verb:      "unbind",
+			Broker:    l.Broker,
+			Instance:  l.Instance,
+			Binding:   l.Binding,
+			ServiceID: l.ServiceID,
+			PlanID:    l.PlanID,
+		})
+	}
+
+	return unbinds, deprovs
+}
+
+// onBroker returns just the operations for the given broker, and how
+// many others there were.
+func onBroker(ops []*teardownOp, url string) ([]*teardownOp, int) {
+	url = strings.TrimSuffix(url, "/")
+	keep := make([]*teardownOp, 0, len(ops))
+	for _, op := range ops {
+		if strings.TrimSuffix(op.Broker, "/") == url {
+			keep = append(keep, op)
+		}
+	}
+	return keep, len(ops) - len(keep)
+}
+
+// runTeardown performs each of the given operations, at most
+// `parallel` at a time, waiting for asynchronous operations to
+// finish.  The `done` callback is called (serially) as each
+// operation finishes, successfully or otherwise.
+func runTeardown(ops []*teardownOp, parallel int, wait time.Duration, done func(*teardownOp)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan *teardownOp)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range queue {
+				start := time.Now()
+				err := teardown(clientFor(op.Broker), op, wait)
+				op.Took = int64(time.Since(start) / time.Millisecond)
+				if err != nil {
+					op.Status = "failed"
+					op.Error = err.Error()
+				}
+
+				lock.Lock()
+				done(op)
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for _, op := range ops {
+		queue <- op
+	}
+	close(queue)
+	wg.Wait()
+}
+
+func teardown(c *api.Client, op *teardownOp, wait time.Duration) error {
+	last := api.LastOperationSpec{
+		InstanceID: op.Instance,
+		BindingID:  op.Binding,
+		ServiceID:  op.ServiceID,
+		PlanID:     op.PlanID,
+	}
+
+	if op.Verb == "unbind" {
+		stat, err := c.Unbind(api.UnbindSpec{
+			InstanceID: op.Instance,
+			BindingID:  op.Binding,
+			ServiceID:  op.ServiceID,
+			PlanID:     op.PlanID,
+		})
+		if api.IsGone(err) {
+			op.Status = "already gone"
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		op.Status = stat.Status
+		if stat.Status != "unbinding" {
+			return nil
+		}
+		last.Operation = stat.Operation
+
+	} else {
+		stat, err := c.Deprovision(api.DeprovisionSpec{
+			InstanceID: op.Instance,
+			ServiceID:  op.ServiceID,
+			PlanID:     op.PlanID,
+		})
+		if err != nil {
+			return err
+		}
+		op.Status = stat.Status
+		if stat.Status != "deprovisioning" {
+			return nil
+		}
+		last.Operation = stat.Operation
+	}
+
+	res, err := c.WaitFor(last, 0, wait)
+	if err != nil {
+		return err
+	}
+	switch res.State {
+	case api.Succeeded, api.Gone:
+		if op.Verb == "unbind" {
+			op.Status = "unbound"
+		} else {
+			op.Status = "deprovisioned"
+		}
+		return nil
+	}
+	if res.Description != "" {
+		return fmt.Errorf("%s %s: %s", op.Verb, res.State, res.Description)
+	}
+	return fmt.Errorf("%s %s", op.Verb, res.State)
+}
+
+// confirm asks the user a yes/no question on the terminal,
+// defaulting to no if they just hit enter (or if stdin is closed).
+func confirm(prompt string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jhunt/osb/api"
+	"github.com/jhunt/osb/osbtest"
+)
+
+func opIDs(ops []*teardownOp) string {
+	l := make([]string, len(ops))
+	for i, op := range ops {
+		l[i] = op.Instance
+		if op.Binding != "" {
+			l[i] = op.Binding
+		}
+	}
+	return strings.Join(l, " ")
+}
+
+func TestPlanTeardown(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	store := newListStore(t, c)
+	bind(t, c, store, "i-1", "b-2")
+	cache := catalogs(t, c)
+
+	tests := []struct {
+		name    string
+		filter  listFilter
+		unbinds string
+		deprovs string
+	}{
+		{"everything", listFilter{}, "b-1 b-2", "i-1 i-2"},
+		// bindings have to go before their instances can
+		{"an instance", listFilter{Plan: "small"}, "b-1 b-2", "i-1"},
+		{"an unbound instance", listFilter{Service: "cache"}, "", "i-2"},
+		{"a binding", listFilter{Labels: map[string]string{"role": "app"}}, "b-1", ""},
+		{"nothing", listFilter{Broker: "elsewhere"}, "", ""},
+	}
+	for _, test := range tests {
+		unbinds, deprovs := planTeardown(store, cache, test.filter)
+		if got := opIDs(unbinds); got != test.unbinds {
+			t.Errorf("tearing down %s: expected to unbind [%s], but would unbind [%s]", test.name, test.unbinds, got)
+		}
+		if got := opIDs(deprovs); got != test.deprovs {
+			t.Errorf("tearing down %s: expected to deprovision [%s], but would deprovision [%s]", test.name, test.deprovs, got)
+		}
+	}
+
+	// nothing was torn down in the planning
+	b.Expect(osbtest.Unbind).Never()
+	b.Expect(osbtest.Deprovision).Never()
+}
+
+func TestRunTeardown(t *testing.T) {
+	b, c := newBroker(t)
+	defer b.Close()
+	opt.Username, opt.Password = osbtest.Username, osbtest.Password
+	defer func() { opt.Username, opt.Password = "", "" }()
+
+	store := &api.Store{}
+	provision(t, c, store, "i-1", "db", "db-small")
+	provision(t, c, store, "i-2", "db", "db-large")
+	bind(t, c, store, "i-1", "b-1")
+	bind(t, c, store, "i-2", "b-2")
+
+	unbinds, deprovs := planTeardown(store, nil, listFilter{})
+	status := make(map[string]string)
+	done := func(op *teardownOp) {
+		if op.Error != "" {
+			t.Errorf("unable to %s %s: %s", op.Verb, opIDs([]*teardownOp{op}), op.Error)
+		}
+		status[opIDs([]*teardownOp{op})] = op.Status
+	}
+	runTeardown(unbinds, 2, 5*time.Second, done)
+	runTeardown(deprovs, 2, 5*time.Second, done)
+
+	// the asynchronous ones were waited for
+	if status["b-2"] != "unbound" || status["i-2"] != "deprovisioned" {
+		t.Errorf("expected async b-2 and i-2 to be unbound and deprovisioned, but they were %s and %s", status["b-2"], status["i-2"])
+	}
+	if n := len(b.Mock.State().Instances); n != 0 {
+		t.Errorf("expected everything to be torn down, but %d instances are left", n)
+	}
+
+	// every binding went before any instance did
+	unbound := false
+	for _, r := range b.Requests() {
+		switch r.Endpoint {
+		case osbtest.Unbind:
+			if unbound {
+				t.Errorf("%s came after an instance was deprovisioned", r)
+			}
+		case osbtest.Deprovision:
+			unbound = true
+		}
+	}
+
+	// and tearing down what's gone is fine
+	op := &teardownOp{Verb: "unbind", Broker: c.URL, Instance: "i-1", Binding: "b-1", ServiceID: "db", PlanID: "db-small"}
+	if err := teardown(c, op, time.Second); err != nil || op.Status != "already gone" {
+		t.Errorf("unbinding b-1 again should find it already gone, but got %s (%v)", op.Status, err)
+	}
+}
+
+func TestOnBroker(t *testing.T) {
+	ops := []*teardownOp{
+		{Broker: "http://a/", Instance: "i-1"},
+		{Broker: "http://b", Instance: "i-2"},
+		{Broker: "http://a", Instance: "i-3"},
+	}
+	keep, others := onBroker(ops, "http://a")
+	if opIDs(keep) != "i-1 i-3" || others != 1 {
+		t.Errorf("expected [i-1 i-3] on http://a, and one other, but got [%s] and %d", opIDs(keep), others)
+	}
+}